@@ -3,137 +3,1106 @@ package agent
 import (
 	"context"
 	"fmt"
-	"log"
-	"path/filepath"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
 	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"katalog/internal/config"
 	"katalog/internal/forwarder"
+	"katalog/internal/metrics"
 	"katalog/internal/models"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Package-level variables for the functions we want to make mockable.
 // These are initialized with the real implementations by default.
 var (
-	tailFileFunc  = forwarder.TailFile
-	writeLogsFunc = forwarder.WriteLogs
+	tailFileFunc    = forwarder.TailFile
+	readStreamFunc  = forwarder.ReadStream
+	readJournalFunc = forwarder.ReadJournal
+	writeLogsFunc   = forwarder.WriteLogsBuffered
+
+	// stdinReader is what a target with the path "-" reads.
+	stdinReader io.Reader = os.Stdin
+)
+
+// TargetField is the field carrying the owning target's name when
+// tag_target is enabled.
+const TargetField = "_target"
+
+// defaultMaxMultilineBytes caps multiline entries of targets that don't set
+// max_multiline_bytes.
+const defaultMaxMultilineBytes = 1 << 20
+
+// defaultRedaction replaces redact_patterns matches without a replacement.
+const defaultRedaction = "***"
+
+// defaultChannelBuffer is the capacity of the channel from the tailers to
+// the writer when channel_buffer is unset.
+const defaultChannelBuffer = 100
+
+// Bounds for the retry delay applied to paths that fail to open.
+var (
+	openBackoffBase = time.Second
+	openBackoffMax  = 5 * time.Minute
 )
 
 type Agent struct {
-	cfg        *config.Config
-	hostname   string
-	logCh      chan models.LogEntry
-	tracked    map[string]context.CancelFunc
-	wg         sync.WaitGroup
-	regexCache map[int]regexPair
+	cfg         *config.Config
+	hostname    string
+	logCh       chan models.LogEntry
+	tracked     map[string]trackedFile
+	wg          sync.WaitGroup
+	targetCache map[int]compiledTarget
+	fieldCache  map[int]map[string]string
+	// overlapWarned records paths already reported as matching several targets
+	overlapWarned map[string]bool
+	limitWarned   bool // max_tracked_files was hit in the last cycle
+	settling      map[string]settling
+	// drainTimeout bounds each tailer's read-to-EOF in drain_to_eof mode
+	drainTimeout time.Duration
+	// readyTimeout is how long a network output may fail before Ready does
+	readyTimeout time.Duration
+	// eofPoll is how often tailers without file events poll at EOF
+	eofPoll time.Duration
+
+	// Reported by Healthy and Ready
+	running, writerUp, discovered atomic.Bool
+
+	mu      sync.Mutex
+	backoff map[string]*openBackoff
+
+	taps []func(models.LogEntry)
+
+	outputs   []*output
+	localCopy *forwarder.LocalCopy
+
+	checkpoints *forwarder.CheckpointStore // nil unless checkpoint_file is set
+	checkpointh checkpointHealth           // reported by Ready
+	watcher     *watcher                   // nil in poll mode
+
+	reloads chan reloadRequest
 }
 
-type regexPair struct {
-	exclude   *regexp.Regexp
-	multiline *regexp.Regexp
+// trackedFile is a running tailer and the target it was started for.
+type trackedFile struct {
+	cancel context.CancelFunc
+	target string // the owning target's key
 }
 
-func New(cfg *config.Config, hostname string) (*Agent, error) {
-	// Pre-compile regexes to avoid compiling them in every loop cycle
-	cache := make(map[int]regexPair)
+// openBackoff tracks repeated open failures for a single path.
+type openBackoff struct {
+	failures int
+	retryAt  time.Time
+	exited   bool // the failed tailer has returned and must be untracked
+}
+
+type compiledTarget struct {
+	key        string // identifies the target across reloads
+	exclude    []*regexp.Regexp
+	include    []*regexp.Regexp
+	multiline  []*regexp.Regexp
+	processors []forwarder.Processor
+	// fileProcessors build processors for one file, e.g. to read its header
+	fileProcessors []func(path string) forwarder.Processor
+	source         *regexp.Regexp // source_pattern, matched against each path
+	redact         []forwarder.Redaction
+	reorder        time.Duration
+	settle         time.Duration
+	partial        time.Duration
+	mlTimeout      time.Duration
+	live           *forwarder.LivePatterns
+}
+
+// settling tracks a newly matched file whose size must stop changing for
+// its target's settle_time before it is opened.
+type settling struct {
+	size  int64
+	since time.Time
+}
+
+// compileTargets pre-compiles each target's regexes and processors and
+// resolves its static fields, keyed by target index, so nothing is compiled
+// per discover cycle.
+func compileTargets(cfg *config.Config) (map[int]compiledTarget, map[int]map[string]string, error) {
+	type derived struct {
+		re      *regexp.Regexp
+		counter *prometheus.CounterVec
+	}
+	derivedMetrics := make([]derived, 0, len(cfg.DerivedMetrics))
+	for _, d := range cfg.DerivedMetrics {
+		re, err := regexp.Compile(d.Pattern)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid derived_metrics pattern for '%s': %w", d.Name, err)
+		}
+		counter, err := metrics.Derived(d.Name, d.Help)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid derived_metrics name '%s': %w", d.Name, err)
+		}
+		derivedMetrics = append(derivedMetrics, derived{re, counter})
+	}
+
+	cache := make(map[int]compiledTarget)
+	fields := make(map[int]map[string]string)
+	keys := targetKeys(cfg)
 	for i, target := range cfg.Targets {
-		var pair regexPair
+		ct := compiledTarget{key: keys[i]}
+		if target.Journald != nil && !forwarder.JournalSupported {
+			return nil, nil, fmt.Errorf("target '%s' reads journald, which is only available on Linux", target.Name)
+		}
 		var err error
-		if target.ExcludePattern != "" {
-			if pair.exclude, err = regexp.Compile(target.ExcludePattern); err != nil {
-				return nil, fmt.Errorf("invalid exclude_pattern for target '%s': %w", target.Name, err)
+		if ct.exclude, err = compilePatterns(target.Name, "exclude_pattern", target.ExcludePattern, target.ExcludePatterns); err != nil {
+			return nil, nil, err
+		}
+		if ct.include, err = compilePatterns(target.Name, "include_pattern", target.IncludePattern, target.IncludePatterns); err != nil {
+			return nil, nil, err
+		}
+		if ct.multiline, err = compilePatterns(target.Name, "multiline_pattern", target.MultilinePattern, target.MultilinePatterns); err != nil {
+			return nil, nil, err
+		}
+		if target.MultilineTimeout != "" {
+			if ct.mlTimeout, err = time.ParseDuration(target.MultilineTimeout); err != nil {
+				return nil, nil, fmt.Errorf("invalid multiline_timeout for target '%s': %w", target.Name, err)
+			}
+		}
+		// Parsing and extraction come first so every later stage sees their
+		// fields, and the timestamp is taken from the line as read
+		if target.TimestampPattern != "" {
+			re, err := regexp.Compile(target.TimestampPattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid timestamp_pattern for target '%s': %w", target.Name, err)
+			}
+			if re.SubexpIndex("ts") < 0 {
+				return nil, nil, fmt.Errorf("invalid timestamp_pattern for target '%s': needs a capture group named ts", target.Name)
+			}
+			ct.processors = append(ct.processors, forwarder.ParseTimestamp(re, target.TimestampLayout))
+		}
+		for j, rule := range target.RedactPatterns {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid redact_patterns[%d] pattern for target '%s': %w", j, target.Name, err)
+			}
+			replacement := rule.Replacement
+			if replacement == "" {
+				replacement = defaultRedaction
 			}
+			ct.redact = append(ct.redact, forwarder.Redaction{Pattern: re, Replacement: replacement})
+		}
+		if target.SourcePattern != "" {
+			re, err := regexp.Compile(target.SourcePattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid source_pattern for target '%s': %w", target.Name, err)
+			}
+			if !slices.ContainsFunc(re.SubexpNames(), func(name string) bool { return name != "" }) {
+				return nil, nil, fmt.Errorf("invalid source_pattern for target '%s': needs a named capture group", target.Name)
+			}
+			ct.source = re
+		}
+		switch target.Parse {
+		case "json":
+			ct.processors = append(ct.processors, forwarder.ParseJSON(target.MessageKey, target.JSONNested == "encode"))
+		case "logfmt":
+			ct.processors = append(ct.processors, forwarder.ParseLogfmt(target.MessageKey))
+		case "auto":
+			// Each file gets its own format
+			ct.fileProcessors = append(ct.fileProcessors, func(path string) forwarder.Processor {
+				return forwarder.ParseAuto(path, target.MessageKey, target.JSONNested == "encode")
+			})
+		case "csv":
+			opts := forwarder.CSVOptions{Headers: target.CSVHeaders}
+			if target.CSVDelimiter != "" {
+				opts.Delimiter = []rune(target.CSVDelimiter)[0]
+			}
+			if !target.CSVHeaderFromFile {
+				ct.processors = append(ct.processors, forwarder.ParseCSV(opts))
+				break
+			}
+			// Each file has its own header
+			ct.fileProcessors = append(ct.fileProcessors, func(path string) forwarder.Processor {
+				opts := opts
+				opts.HeaderFile = path
+				return forwarder.ParseCSV(opts)
+			})
+		}
+		if target.FieldPattern != "" {
+			re, err := regexp.Compile(target.FieldPattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid field_pattern for target '%s': %w", target.Name, err)
+			}
+			if !slices.ContainsFunc(re.SubexpNames(), func(name string) bool { return name != "" }) {
+				return nil, nil, fmt.Errorf("invalid field_pattern for target '%s': needs a named capture group", target.Name)
+			}
+			ct.processors = append(ct.processors, forwarder.ExtractFields(re, target.FieldPatternReq))
+		}
+		if target.GrokPattern != "" {
+			re, err := forwarder.CompileGrok(target.GrokPattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid grok_pattern for target '%s': %w", target.Name, err)
+			}
+			if !slices.ContainsFunc(re.SubexpNames(), func(name string) bool { return name != "" }) {
+				return nil, nil, fmt.Errorf("invalid grok_pattern for target '%s': needs a %%{PATTERN:field} reference", target.Name)
+			}
+			ct.processors = append(ct.processors, forwarder.ParseGrok(re))
+		}
+		// Derived metrics count every entry, before any field processing
+		for _, d := range derivedMetrics {
+			ct.processors = append(ct.processors, forwarder.CountMatches(d.re, d.counter.WithLabelValues(target.Name).Inc))
+		}
+		// Field processors, in pipeline order: conditional fields, trace ID,
+		// rename, keep, drop
+		if len(target.ConditionalFields) > 0 {
+			rules := make([]forwarder.FieldRule, 0, len(target.ConditionalFields))
+			for j, rule := range target.ConditionalFields {
+				re, err := regexp.Compile(rule.Pattern)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid conditional_fields[%d] pattern for target '%s': %w", j, target.Name, err)
+				}
+				rules = append(rules, forwarder.FieldRule{Pattern: re, Fields: rule.Fields})
+			}
+			ct.processors = append(ct.processors, forwarder.ConditionalFields(rules, target.ConditionalMatch == "first"))
+		}
+		if target.TraceIDPattern != "" {
+			re, err := regexp.Compile(target.TraceIDPattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid trace_id_pattern for target '%s': %w", target.Name, err)
+			}
+			if re.NumSubexp() == 0 {
+				return nil, nil, fmt.Errorf("invalid trace_id_pattern for target '%s': needs a capture group", target.Name)
+			}
+			ct.processors = append(ct.processors, forwarder.ExtractTraceID(re))
+		}
+		if len(target.RenameFields) > 0 {
+			ct.processors = append(ct.processors, forwarder.RenameFields(target.RenameFields))
+		}
+		if len(target.KeepFields) > 0 {
+			ct.processors = append(ct.processors, forwarder.KeepFields(target.KeepFields))
+		}
+		if len(target.DropFields) > 0 {
+			ct.processors = append(ct.processors, forwarder.DropFields(target.DropFields))
+		}
+		if target.ReorderWindow != "" {
+			if ct.reorder, err = time.ParseDuration(target.ReorderWindow); err != nil {
+				return nil, nil, fmt.Errorf("invalid reorder_window for target '%s': %w", target.Name, err)
+			}
+		}
+		if target.SettleTime != "" {
+			if ct.settle, err = time.ParseDuration(target.SettleTime); err != nil {
+				return nil, nil, fmt.Errorf("invalid settle_time for target '%s': %w", target.Name, err)
+			}
+		}
+		if target.PartialLineTimeout != "" {
+			if ct.partial, err = time.ParseDuration(target.PartialLineTimeout); err != nil {
+				return nil, nil, fmt.Errorf("invalid partial_line_timeout for target '%s': %w", target.Name, err)
+			}
+		}
+		// Global fields, then the target's own, then the tag, each winning
+		// over the last. Always a fresh map, so tailers never share the
+		// config's maps
+		if n := len(cfg.Fields) + len(target.Fields); n > 0 || cfg.TagTarget {
+			fields[i] = make(map[string]string, n+1)
+			maps.Copy(fields[i], cfg.Fields)
+			maps.Copy(fields[i], target.Fields)
+			if cfg.TagTarget {
+				fields[i][TargetField] = target.Name
+			}
+		}
+		ct.live = forwarder.NewLivePatterns(forwarder.Patterns{Exclude: ct.exclude, Include: ct.include, Multiline: ct.multiline, Fields: fields[i]})
+		cache[i] = ct
+	}
+	return cache, fields, nil
+}
+
+// compilePatterns compiles the singular form of a pattern option (e.g.
+// exclude_pattern) of a target followed by its list form (exclude_patterns).
+func compilePatterns(target, key, single string, list []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	if single != "" {
+		re, err := regexp.Compile(single)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s for target '%s': %w", key, target, err)
+		}
+		res = append(res, re)
+	}
+	for j, pattern := range list {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %ss[%d] for target '%s': %w", key, j, target, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// bufferPolicies collects the per-target output buffering settings, keyed
+// by target name (the entries' sourcetype), on top of the output's own
+// defaults, which fall back to the global flush_interval and also apply to
+// the writer's default buffer, under "".
+func bufferPolicies(cfg *config.Config, out config.Output) (map[string]forwarder.BufferPolicy, error) {
+	base := forwarder.BufferPolicy{BatchSize: out.BatchSize, MaxBatchBytes: out.MaxBatchBytes}
+	flushInterval := out.FlushInterval
+	if flushInterval == "" {
+		flushInterval = cfg.FlushInterval
+	}
+	if flushInterval != "" {
+		d, err := time.ParseDuration(flushInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output flush_interval: %w", err)
+		}
+		base.FlushInterval = d
+	}
+	policies := make(map[string]forwarder.BufferPolicy)
+	if base != (forwarder.BufferPolicy{}) {
+		policies[""] = base
+	}
+	for _, target := range cfg.Targets {
+		p := base
+		if target.BatchSize != 0 {
+			p.BatchSize = target.BatchSize
+		}
+		if target.MaxBatchBytes != 0 {
+			p.MaxBatchBytes = target.MaxBatchBytes
 		}
-		if target.MultilinePattern != "" {
-			if pair.multiline, err = regexp.Compile(target.MultilinePattern); err != nil {
-				return nil, fmt.Errorf("invalid multiline_pattern for target '%s': %w", target.Name, err)
+		if target.FlushInterval != "" {
+			d, err := time.ParseDuration(target.FlushInterval)
+			if err != nil {
+				return nil, fmt.Errorf("invalid flush_interval for target '%s': %w", target.Name, err)
 			}
+			p.FlushInterval = d
+		}
+		if p != (forwarder.BufferPolicy{}) {
+			policies[target.Name] = p
+		}
+	}
+	return policies, nil
+}
+
+func New(cfg *config.Config, hostname string) (*Agent, error) {
+	cache, fields, err := compileTargets(cfg)
+	if err != nil {
+		return nil, err
+	}
+	priorities, err := syslogPriorities(cfg)
+	if err != nil {
+		return nil, err
+	}
+	drainTimeout, _ := time.ParseDuration(cfg.ShutdownTimeout)
+	readyTimeout, _ := time.ParseDuration(cfg.ReadyFailureTimeout)
+	eofPoll, _ := time.ParseDuration(cfg.EOFPollInterval)
+	channelBuffer := cfg.ChannelBuffer
+	if channelBuffer == 0 {
+		channelBuffer = defaultChannelBuffer
+	}
+
+	var outputs []*output
+	for _, outCfg := range cfg.ResolvedOutputs() {
+		o, err := openOutput(cfg, outCfg, priorities)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, o)
+	}
+
+	a := &Agent{
+		cfg:           cfg,
+		hostname:      hostname,
+		logCh:         make(chan models.LogEntry, channelBuffer),
+		tracked:       make(map[string]trackedFile),
+		targetCache:   cache,
+		fieldCache:    fields,
+		backoff:       make(map[string]*openBackoff),
+		drainTimeout:  drainTimeout,
+		readyTimeout:  readyTimeout,
+		eofPoll:       eofPoll,
+		overlapWarned: make(map[string]bool),
+		settling:      make(map[string]settling),
+		outputs:       outputs,
+		reloads:       make(chan reloadRequest),
+	}
+	if cfg.RejectTargetOverlap {
+		_, owners, overlaps := a.claimPaths()
+		if err := a.overlapError(owners, overlaps); err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+	}
+	if cfg.CheckpointFile != "" {
+		if a.checkpoints, err = forwarder.OpenCheckpointStore(cfg.CheckpointFile); err != nil {
+			closeOutputs(outputs)
+			return nil, fmt.Errorf("failed to load checkpoints: %w", err)
+		}
+	}
+	if lc := cfg.LocalCopy; lc != nil {
+		maxAge, _ := time.ParseDuration(lc.MaxAge)
+		a.localCopy, err = forwarder.NewLocalCopy(lc.Path, int64(lc.MaxSize)<<20, maxAge)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, fmt.Errorf("failed to open local copy: %w", err)
+		}
+		a.AddTap(a.localCopy.Publish)
+	}
+	if cfg.WatchMode == config.WatchInotify {
+		if a.watcher, err = newWatcher(); err != nil {
+			slog.Warn("Cannot watch files, falling back to polling", "error", err)
+		}
+	}
+	return a, nil
+}
+
+// Check runs the checks New makes of cfg that have no side effects: it
+// compiles every target's patterns and processors, resolves syslog
+// priorities and, with reject_target_overlap, rejects overlapping targets.
+// Nothing is opened or started. It returns how many existing files each
+// target currently claims, by target index.
+func Check(cfg *config.Config) ([]int, error) {
+	cache, fields, err := compileTargets(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := syslogPriorities(cfg); err != nil {
+		return nil, err
+	}
+	a := &Agent{cfg: cfg, targetCache: cache, fieldCache: fields}
+	paths, owners, overlaps := a.claimPaths()
+	if cfg.RejectTargetOverlap {
+		if err := a.overlapError(owners, overlaps); err != nil {
+			return nil, err
+		}
+	}
+	counts := make([]int, len(cfg.Targets))
+	for _, path := range paths {
+		// Literal paths are claimed before they exist
+		if _, err := os.Stat(path); err == nil || path == forwarder.StdinPath {
+			counts[owners[path]]++
+		}
+	}
+	// The journal is the one source of a journald target
+	for i, target := range cfg.Targets {
+		if target.Journald != nil {
+			counts[i] = 1
 		}
-		cache[i] = pair
 	}
+	return counts, nil
+}
 
-	return &Agent{
-		cfg:        cfg,
-		hostname:   hostname,
-		logCh:      make(chan models.LogEntry, 100),
-		tracked:    make(map[string]context.CancelFunc),
-		regexCache: cache,
-	}, nil
+// AddTap registers fn to observe every entry before it reaches the writer.
+// Taps run on the writer's path and must not block. Call before Run.
+func (a *Agent) AddTap(fn func(models.LogEntry)) {
+	a.taps = append(a.taps, fn)
 }
 
 func (a *Agent) Run(ctx context.Context) {
+	// Stops the background goroutines once a run in once mode is done
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	// Route entries through the taps when any are registered
+	writerCh := a.logCh
+	if len(a.taps) > 0 {
+		teeCh := make(chan models.LogEntry, cap(a.logCh))
+		go func() {
+			defer close(teeCh)
+			for entry := range a.logCh {
+				for _, tap := range a.taps {
+					tap(entry)
+				}
+				teeCh <- entry
+			}
+		}()
+		writerCh = teeCh
+	}
+
 	// Start the writer goroutine
 	var writerWg sync.WaitGroup
 	writerWg.Add(1)
+	a.writerUp.Store(true)
 	go func() {
 		defer writerWg.Done()
-		writeLogsFunc(a.logCh, a.cfg.OutputFormat) // Use the mockable function
+		defer a.writerUp.Store(false)
+		fanOut(writerCh, a.outputs)
 	}()
 
-	pollDur, _ := time.ParseDuration(a.cfg.PollInterval)
-	ticker := time.NewTicker(pollDur)
+	if a.checkpoints != nil {
+		interval, _ := time.ParseDuration(a.cfg.CheckpointInterval)
+		writerWg.Add(1)
+		go func() {
+			defer writerWg.Done()
+			a.saveCheckpoints(ctx, interval)
+		}()
+	}
+
+	if interval, _ := time.ParseDuration(a.cfg.StatsInterval); interval > 0 {
+		writerWg.Add(1)
+		go func() {
+			defer writerWg.Done()
+			logStats(ctx, metrics.NewSummarizer(prometheus.DefaultGatherer), interval)
+		}()
+	}
+
+	// Let rotations in flight at startup settle before the first discovery
+	if delay, _ := time.ParseDuration(a.cfg.StartupDelay); delay > 0 {
+		slog.Info("Waiting before the first discovery", "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	ticker := time.NewTicker(a.pollInterval())
 	defer ticker.Stop()
 
-	log.Println("Log collector started.")
+	// A nil rescan channel (poll mode) never fires
+	var rescan <-chan struct{}
+	if a.watcher != nil {
+		rescan = a.watcher.rescan
+		writerWg.Add(1)
+		go func() {
+			defer writerWg.Done()
+			a.watcher.run(ctx)
+		}()
+	}
+
+	slog.Info("Log collector started")
+	a.running.Store(true)
+	defer a.running.Store(false)
 
 	for {
 		a.discover(ctx)
+		a.discovered.Store(true)
+
+		if a.cfg.Mode == config.ModeOnce || a.stdinOnly() {
+			// Every tailer stops at EOF; those still reading when ctx is
+			// done stop there
+			a.wg.Wait()
+			slog.Info("All files read to EOF, cleaning up")
+			stop()
+			a.shutdown(&writerWg)
+			return
+		}
 
 		select {
 		case <-ticker.C:
 			continue
+		case <-rescan:
+			continue
+		case r := <-a.reloads:
+			r.done <- a.applyReload(r.cfg)
+			ticker.Reset(a.pollInterval())
+			continue
 		case <-ctx.Done():
-			log.Println("Shutdown signal received. Cleaning up...")
-			for _, cancel := range a.tracked {
-				cancel()
+			slog.Info("Shutdown signal received, cleaning up")
+			a.shutdown(&writerWg)
+			return
+		}
+	}
+}
+
+// stdinOnly reports whether standard input is all there is to read, so
+// that the agent stops once it is exhausted.
+func (a *Agent) stdinOnly() bool {
+	for _, target := range a.cfg.Targets {
+		if target.Journald != nil {
+			return false
+		}
+		for _, path := range target.Paths {
+			if path != forwarder.StdinPath {
+				return false
 			}
-			a.wg.Wait()
-			close(a.logCh)
-			writerWg.Wait()
-			log.Println("All collectors stopped. Exiting.")
+		}
+	}
+	return len(a.cfg.Targets) > 0
+}
+
+// shutdown stops every tailer, lets the writers deliver what was read and
+// closes the outputs. Once ShutdownTimeout has passed since it began, the
+// outputs give up the batches they are still retrying.
+func (a *Agent) shutdown(writerWg *sync.WaitGroup) {
+	if a.drainTimeout > 0 {
+		stop := time.AfterFunc(a.drainTimeout, func() {
+			for _, o := range a.outputs {
+				for _, conn := range o.conns {
+					if conn.stopRetries != nil {
+						conn.stopRetries()
+					}
+				}
+			}
+		})
+		defer stop.Stop()
+	}
+	for _, tf := range a.tracked {
+		tf.cancel()
+	}
+	a.wg.Wait()
+	close(a.logCh)
+	writerWg.Wait()
+	if a.checkpoints != nil {
+		if err := a.saveCheckpoint(context.Background()); err != nil {
+			slog.Error("Error saving checkpoints", "error", err)
+		}
+	}
+	closeOutputs(a.outputs)
+	if a.localCopy != nil {
+		if err := a.localCopy.Close(); err != nil {
+			slog.Error("Error closing local copy", "error", err)
+		}
+	}
+	slog.Info("All collectors stopped, exiting")
+}
+
+// checkpointSaveAttempts bounds the tries of one checkpoint save, each
+// checkpointRetryDelay apart. A save that still fails is tried again at the
+// next interval.
+const checkpointSaveAttempts = 3
+
+var checkpointRetryDelay = 200 * time.Millisecond
+
+// saveCheckpoints persists the read offsets every interval until ctx is
+// done. The final save happens once every tailer has stopped. Tailers only
+// update the offsets in memory, so ingestion never waits on a save, even
+// one that keeps failing.
+func (a *Agent) saveCheckpoints(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.saveCheckpoint(ctx); err != nil {
+				slog.Warn("Error saving checkpoints, durability is degraded until a save succeeds", "error", err)
+			}
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (a *Agent) discover(ctx context.Context) {
-	activeInThisCycle := make(map[string]bool)
+// saveCheckpoint saves the checkpoints, trying again a bounded number of
+// times, and records the outcome for Ready.
+func (a *Agent) saveCheckpoint(ctx context.Context) error {
+	var err error
+retry:
+	for attempt := 1; ; attempt++ {
+		if err = a.checkpoints.Save(); err == nil || attempt == checkpointSaveAttempts {
+			break
+		}
+		select {
+		case <-time.After(checkpointRetryDelay):
+		case <-ctx.Done():
+			break retry
+		}
+	}
+	if err != nil {
+		metrics.CheckpointErrors.Inc()
+	}
+	a.checkpointh.record(err, time.Now())
+	return err
+}
 
-	for i, target := range a.cfg.Targets {
-		regexes := a.regexCache[i]
+// logStats logs a summary line per group every interval until ctx is done.
+func logStats(ctx context.Context, s *metrics.Summarizer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			summaries, err := s.Next()
+			if err != nil {
+				slog.Error("Error gathering stats", "error", err)
+			}
+			for _, gs := range summaries {
+				slog.Info("Stats", "group", gs.Group, "interval", interval, "lines", gs.Lines, "bytes", gs.Bytes, "errors", gs.Errors)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// settled reports whether path may be opened: its size must have stayed
+// the same for settleTime, so files still being created or renamed into
+// place are left alone. A zero settleTime opens immediately.
+func (a *Agent) settled(path string, settleTime time.Duration, now time.Time) bool {
+	if settleTime <= 0 {
+		return true
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		delete(a.settling, path)
+		return false
+	}
+	s, ok := a.settling[path]
+	if !ok || s.size != fi.Size() {
+		a.settling[path] = settling{size: fi.Size(), since: now}
+		return false
+	}
+	if now.Sub(s.since) < settleTime {
+		return false
+	}
+	delete(a.settling, path)
+	return true
+}
 
+// onOpen returns the callback a tailer uses to report its open result.
+// Failures push the next attempt out exponentially and are logged once per
+// backoff window; a successful open clears the path's history.
+func (a *Agent) onOpen(path string) func(error) {
+	return func(err error) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if err == nil {
+			delete(a.backoff, path)
+			return
+		}
+		b, ok := a.backoff[path]
+		if !ok {
+			b = &openBackoff{}
+			a.backoff[path] = b
+		}
+		b.failures++
+		delay := openBackoffMax
+		if shift := b.failures - 1; shift < 16 {
+			delay = min(openBackoffBase<<shift, openBackoffMax)
+		}
+		b.retryAt = time.Now().Add(delay)
+		b.exited = true
+		slog.Warn("Failed to open file, retrying", "path", path, "attempt", b.failures, "retry_in", delay, "error", err)
+	}
+}
+
+// retryAllowed untracks paths whose tailer exited on an open failure and
+// reports whether path may be (re)started now.
+func (a *Agent) retryAllowed(path string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b, ok := a.backoff[path]
+	if !ok {
+		return true
+	}
+	if b.exited {
+		if tf, tracked := a.tracked[path]; tracked {
+			tf.cancel()
+			delete(a.tracked, path)
+		}
+		b.exited = false
+	}
+	return !now.Before(b.retryAt)
+}
+
+// claimPaths expands every target's globs, less its ignore_paths, and
+// assigns each matched path to the first target (in config order) that
+// matches it. A literal path is
+// claimed even before it exists, so its tailer can wait for it. Paths also
+// matched by later targets are reported in overlaps, keyed by path.
+func (a *Agent) claimPaths() (paths []string, owners map[string]int, overlaps map[string][]int) {
+	owners = make(map[string]int)
+	overlaps = make(map[string][]int)
+	for i, target := range a.cfg.Targets {
 		for _, pattern := range target.Paths {
-			matches, _ := filepath.Glob(pattern) // Error handling omitted for brevity in glob
+			matches, _ := glob(pattern) // Error handling omitted for brevity in glob
+			if len(matches) == 0 && isLiteral(pattern) {
+				matches = []string{pattern}
+			}
 			for _, path := range matches {
-				activeInThisCycle[path] = true
-				if _, ok := a.tracked[path]; !ok {
-					fileCtx, cancel := context.WithCancel(ctx)
-					a.tracked[path] = cancel
-					a.wg.Add(1)
-
-					opts := forwarder.TailOptions{
-						GroupName:      target.Name,
-						Hostname:       a.hostname,
-						ExcludeRegex:   regexes.exclude,
-						MultilineRegex: regexes.multiline,
-						CustomFields:   target.Fields,
-					}
-
-					go tailFileFunc(fileCtx, &a.wg, path, a.logCh, opts) // Use the mockable function
-					log.Printf("Started tracking: %s", path)
+				if ignored(target.IgnorePaths, path) {
+					continue
+				}
+				owner, claimed := owners[path]
+				if !claimed {
+					owners[path] = i
+					paths = append(paths, path)
+				} else if owner != i && !slices.Contains(overlaps[path], i) {
+					overlaps[path] = append(overlaps[path], i)
 				}
 			}
 		}
 	}
+	return paths, owners, overlaps
+}
+
+// overlapError describes paths claimed by more than one target.
+func (a *Agent) overlapError(owners map[string]int, overlaps map[string][]int) error {
+	if len(overlaps) == 0 {
+		return nil
+	}
+	var msgs []string
+	for path, others := range overlaps {
+		names := []string{a.cfg.Targets[owners[path]].Name}
+		for _, i := range others {
+			names = append(names, a.cfg.Targets[i].Name)
+		}
+		msgs = append(msgs, fmt.Sprintf("%s (targets: %s)", path, strings.Join(names, ", ")))
+	}
+	sort.Strings(msgs)
+	return fmt.Errorf("paths matched by multiple targets: %s", strings.Join(msgs, "; "))
+}
+
+// pathFields returns the named groups of target i's source_pattern that
+// matched path, or nil.
+func (a *Agent) pathFields(i int, path string) map[string]string {
+	re := a.targetCache[i].source
+	if re == nil {
+		return nil
+	}
+	m := re.FindStringSubmatch(path)
+	if m == nil {
+		return nil
+	}
+	var fields map[string]string
+	for j, name := range re.SubexpNames() {
+		if name == "" || m[j] == "" {
+			continue
+		}
+		if fields == nil {
+			fields = make(map[string]string)
+		}
+		fields[name] = m[j]
+	}
+	return fields
+}
+
+// tailOptions builds the options for a tailer of target i.
+func (a *Agent) tailOptions(i int) forwarder.TailOptions {
+	target := a.cfg.Targets[i]
+	compiled := a.targetCache[i]
+	maxMultiline := target.MaxMultilineBytes
+	if maxMultiline == 0 {
+		maxMultiline = defaultMaxMultilineBytes
+	}
+	// Once mode reads whole files; a checkpoint still takes precedence
+	once := a.cfg.Mode == config.ModeOnce
+	return forwarder.TailOptions{
+		GroupName:          target.Name,
+		Hostname:           a.hostname,
+		TimestampFormat:    a.cfg.TimestampFormat,
+		ExcludeRegexes:     compiled.exclude,
+		IncludeRegexes:     compiled.include,
+		MultilineRegexes:   compiled.multiline,
+		CustomFields:       a.fieldCache[i],
+		CollapseWhitespace: target.CollapseWhitespace,
+		Redactions:         compiled.redact,
+		XattrFields:        target.XattrFields,
+		DrainOnShutdown:    a.cfg.ShutdownMode == config.ShutdownDrain,
+		DrainTimeout:       a.drainTimeout,
+		EOFPollInterval:    a.eofPoll,
+		ChannelFullPolicy:  a.cfg.ChannelFullPolicy,
+		Evict:              a.logCh,
+		Processors:         compiled.processors,
+		FileProcessors:     compiled.fileProcessors,
+		ReorderWindow:      compiled.reorder,
+		RateLimit:          target.RateLimit,
+		RateLimitBurst:     target.RateLimitBurst,
+		OverLimitPolicy:    target.OverLimitPolicy,
+		OverLimitSampleN:   target.OverLimitSampleN,
+		SampleRate:         target.SampleRate,
+		DedupWindowSize:    target.DedupWindowSize,
+		FollowSymlink:      target.FollowSymlink,
+		KeepPartialEntry:   target.KeepPartialEntry,
+		MaxLineBytes:       target.MaxLineBytes,
+		TruncationMarker:   target.TruncationMarker,
+		LineDelimiter:      target.LineDelimiter,
+		MaxLinesPerCycle:   target.MaxLinesPerCycle,
+		Live:               compiled.live,
+		Checkpoint:         a.checkpoints,
+		ReadFromBeginning:  target.ReadFromBeginning || once,
+		Once:               once,
+		PartialLineTimeout: compiled.partial,
+		MultilineTimeout:   compiled.mlTimeout,
+		MaxMultilineBytes:  maxMultiline,
+	}
+}
+
+// TargetOptions returns the tail options discover would use for path: those
+// of the first target with a path pattern matching it and no ignore_paths
+// pattern excluding it. ok is false when no target matches.
+func TargetOptions(cfg *config.Config, hostname, path string) (opts forwarder.TailOptions, ok bool, err error) {
+	cache, fields, err := compileTargets(cfg)
+	if err != nil {
+		return opts, false, err
+	}
+	a := &Agent{cfg: cfg, hostname: hostname, targetCache: cache, fieldCache: fields}
+	for i, target := range cfg.Targets {
+		for _, pattern := range target.Paths {
+			if matched, _ := matchPath(pattern, path); matched && !ignored(target.IgnorePaths, path) {
+				opts := a.tailOptions(i)
+				opts.PathFields = a.pathFields(i, path)
+				return opts, true, nil
+			}
+		}
+	}
+	return opts, false, nil
+}
+
+func (a *Agent) discover(ctx context.Context) {
+	activeInThisCycle := make(map[string]bool)
+	now := time.Now()
+
+	paths, owners, overlaps := a.claimPaths()
+	for path, others := range overlaps {
+		if !a.overlapWarned[path] {
+			a.overlapWarned[path] = true
+			names := make([]string, len(others))
+			for j, i := range others {
+				names[j] = a.cfg.Targets[i].Name
+			}
+			slog.Warn("File matches multiple targets", "path", path,
+				"target", a.cfg.Targets[owners[path]].Name, "ignored", strings.Join(names, ", "))
+		}
+	}
+
+	skipped := 0
+	for _, path := range paths {
+		i := owners[path]
+
+		activeInThisCycle[path] = true
+		if !a.retryAllowed(path, now) {
+			continue
+		}
+		// A reload may have handed the path to another target
+		if tf, ok := a.tracked[path]; ok && tf.target != a.targetCache[i].key {
+			a.stopTracking(path)
+			slog.Debug("Stopped tracking: now matched by another target", "path", path, "target", a.cfg.Targets[i].Name)
+		}
+		if _, ok := a.tracked[path]; !ok {
+			if limit := a.cfg.MaxTrackedFiles; limit > 0 && len(a.tracked) >= limit {
+				skipped++
+				continue
+			}
+			stdin := path == forwarder.StdinPath
+			if !stdin && !a.settled(path, a.targetCache[i].settle, now) {
+				continue
+			}
+			fileCtx, cancel := context.WithCancel(ctx)
+			a.tracked[path] = trackedFile{cancel: cancel, target: a.targetCache[i].key}
+			a.wg.Add(1)
+
+			opts := a.tailOptions(i)
+			opts.PathFields = a.pathFields(i, path)
+			if stdin {
+				// Read once: the path stays tracked after EOF
+				go readStreamFunc(fileCtx, &a.wg, forwarder.StdinSource, stdinReader, a.logCh, opts)
+				slog.Debug("Started reading stdin", "target", a.cfg.Targets[i].Name)
+				continue
+			}
+			opts.OnOpen = a.onOpen(path)
+			// There is no later to wait for in once mode
+			opts.WaitForCreation = a.cfg.Mode != config.ModeOnce
+			if a.watcher != nil {
+				opts.Wake = a.watcher.track(path)
+			}
+
+			go tailFileFunc(fileCtx, &a.wg, path, a.logCh, opts) // Use the mockable function
+			slog.Debug("Started tracking", "path", path)
+		}
+	}
+
+	a.discoverJournals(ctx, activeInThisCycle, now)
+
+	if skipped > 0 && !a.limitWarned {
+		slog.Warn("max_tracked_files reached, not tracking more files", "max_tracked_files", a.cfg.MaxTrackedFiles, "skipped", skipped)
+	}
+	a.limitWarned = skipped > 0
 
 	// Cleanup untracked files
-	for path, cancel := range a.tracked {
+	for path := range a.tracked {
 		if !activeInThisCycle[path] {
-			cancel()
-			delete(a.tracked, path)
-			log.Printf("Stopped tracking: %s", path)
+			a.stopTracking(path)
+			slog.Debug("Stopped tracking", "path", path)
+		}
+	}
+
+	for path := range a.overlapWarned {
+		if _, ok := overlaps[path]; !ok {
+			delete(a.overlapWarned, path)
+		}
+	}
+
+	for path := range a.settling {
+		if !activeInThisCycle[path] {
+			delete(a.settling, path)
+		}
+	}
+
+	// Forget the failure history of paths that no longer match
+	a.mu.Lock()
+	for path := range a.backoff {
+		if !activeInThisCycle[path] {
+			delete(a.backoff, path)
+		}
+	}
+	a.mu.Unlock()
+
+	a.updateTrackedFiles()
+}
+
+// journalKey is the key journald target i is tracked, backed off and
+// checkpointed under, alongside paths.
+func (a *Agent) journalKey(i int) string {
+	return "journald:" + a.targetCache[i].key
+}
+
+// discoverJournals starts a reader for every journald target that has none.
+// A reader whose journalctl exits is restarted, with the backoff of a file
+// that fails to open, after its saved cursor.
+func (a *Agent) discoverJournals(ctx context.Context, active map[string]bool, now time.Time) {
+	for i, target := range a.cfg.Targets {
+		if target.Journald == nil {
+			continue
+		}
+		key := a.journalKey(i)
+		active[key] = true
+		if !a.retryAllowed(key, now) {
+			continue
+		}
+		if _, ok := a.tracked[key]; ok {
+			continue
+		}
+		journalCtx, cancel := context.WithCancel(ctx)
+		a.tracked[key] = trackedFile{cancel: cancel, target: a.targetCache[i].key}
+		a.wg.Add(1)
+
+		opts := a.tailOptions(i)
+		opts.OnOpen = a.onOpen(key)
+		jopts := forwarder.JournalOptions{
+			Units:     target.Journald.Units,
+			Fields:    target.Journald.Fields,
+			CursorKey: key,
 		}
+		go readJournalFunc(journalCtx, &a.wg, key, a.logCh, opts, jopts)
+		slog.Debug("Started reading journal", "target", target.Name)
+	}
+}
+
+// updateTrackedFiles sets the tracked files gauge of every configured
+// target, dropping those of targets no longer configured.
+func (a *Agent) updateTrackedFiles() {
+	names := make(map[string]string, len(a.targetCache))
+	counts := make(map[string]float64, len(a.targetCache))
+	for i, ct := range a.targetCache {
+		names[ct.key] = a.cfg.Targets[i].Name
+		counts[a.cfg.Targets[i].Name] = 0
+	}
+	for _, tf := range a.tracked {
+		counts[names[tf.target]]++
+	}
+	metrics.TrackedFiles.Reset()
+	for name, n := range counts {
+		metrics.TrackedFiles.WithLabelValues(name).Set(n)
+	}
+}
+
+// stopTracking cancels the tailer of path and forgets it.
+func (a *Agent) stopTracking(path string) {
+	a.tracked[path].cancel()
+	delete(a.tracked, path)
+	if a.watcher != nil {
+		a.watcher.untrack(path)
 	}
 }
@@ -3,10 +3,16 @@ package agent
 import (
 	"context"
 	// "errors" // Removed unused import
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	// "regexp" // Removed unused import
 	"reflect" // Added for generic mapKeys
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -16,13 +22,18 @@ import (
 
 	"katalog/internal/config"
 	"katalog/internal/forwarder"
+	"katalog/internal/metrics"
 	"katalog/internal/models"
+
+	dto "github.com/prometheus/client_model/go"
 )
 
 // Helper function to reset mocks to their original implementations after each test
 func resetMocks() {
 	tailFileFunc = forwarder.TailFile
-	writeLogsFunc = forwarder.WriteLogs
+	readStreamFunc = forwarder.ReadStream
+	readJournalFunc = forwarder.ReadJournal
+	writeLogsFunc = forwarder.WriteLogsBuffered
 }
 
 // TestAgent_New verifies the agent's constructor behavior, including regex compilation.
@@ -57,6 +68,30 @@ func TestAgent_New(t *testing.T) {
 			expectError:   true,
 			errorContains: "invalid exclude_pattern",
 		},
+		{
+			name: "Invalid Include Regex",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "bad-regex", Paths: []string{"/tmp/*.log"}, IncludePattern: "("},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "invalid include_pattern",
+		},
+		{
+			name: "Invalid Exclude Regex In List",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "bad-regex", Paths: []string{"/tmp/*.log"}, ExcludePatterns: []string{"DEBUG", "[a-"}},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "invalid exclude_patterns[1] for target 'bad-regex'",
+		},
 		{
 			name: "Invalid Multiline Regex",
 			cfg: &config.Config{
@@ -69,6 +104,161 @@ func TestAgent_New(t *testing.T) {
 			expectError:   true,
 			errorContains: "invalid multiline_pattern",
 		},
+		{
+			name: "Valid Multiline Patterns List",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "multi", Paths: []string{"/tmp/*.log"}, MultilinePattern: `^\d{4}`, MultilinePatterns: []string{"^(INFO|ERROR)"}},
+				},
+			},
+			hostname:    "test-host",
+			expectError: false,
+		},
+		{
+			name: "Invalid Multiline Patterns List",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "bad-regex", Paths: []string{"/tmp/*.log"}, MultilinePatterns: []string{"^ok", "("}},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "invalid multiline_patterns[1]",
+		},
+		{
+			name: "Invalid Reorder Window",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "reorder", Paths: []string{"/tmp/*.log"}, ReorderWindow: "a bit"},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "invalid reorder_window",
+		},
+		{
+			name: "Invalid Partial Line Timeout",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "partial", Paths: []string{"/tmp/*.log"}, PartialLineTimeout: "soon"},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "invalid partial_line_timeout",
+		},
+		{
+			name: "Invalid Multiline Timeout",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "trace", Paths: []string{"/tmp/*.log"}, MultilinePattern: "^ERROR", MultilineTimeout: "-"},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "invalid multiline_timeout",
+		},
+		{
+			name: "Trace ID Pattern Without Capture Group",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "traced", Paths: []string{"/tmp/*.log"}, TraceIDPattern: `trace=[0-9a-f]+`},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "needs a capture group",
+		},
+		{
+			name: "Timestamp Pattern Without ts Group",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "stamped", Paths: []string{"/tmp/*.log"}, TimestampPattern: `^(\S+)`, TimestampLayout: "RFC3339"},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "needs a capture group named ts",
+		},
+		{
+			name: "Source Pattern Without Named Group",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "app", Paths: []string{"/tmp/*.log"}, SourcePattern: `app-(\d+)\.log`},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "invalid source_pattern for target 'app': needs a named capture group",
+		},
+		{
+			name: "Invalid Redact Pattern",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "pii", Paths: []string{"/tmp/*.log"}, RedactPatterns: []config.RedactRule{{Pattern: `\d+`}, {Pattern: `[`}}},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "invalid redact_patterns[1] pattern for target 'pii'",
+		},
+		{
+			name: "Unknown Grok Pattern",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "legacy", Paths: []string{"/tmp/*.log"}, GrokPattern: `%{TIMESTAMP_ISO8601:ts} %{LEVEL:level}`},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "invalid grok_pattern for target 'legacy': unknown grok pattern LEVEL",
+		},
+		{
+			name: "Grok Pattern Without Fields",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "legacy", Paths: []string{"/tmp/*.log"}, GrokPattern: `%{IP} %{GREEDYDATA}`},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "needs a %{PATTERN:field} reference",
+		},
+		{
+			name: "Field Pattern Without Named Group",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "plain", Paths: []string{"/tmp/*.log"}, FieldPattern: `(\w+)\s+(.*)`},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "needs a named capture group",
+		},
+		{
+			name: "Invalid Syslog Facility",
+			cfg: &config.Config{
+				PollInterval: "1s",
+				Targets: []config.Target{
+					{Name: "app", Paths: []string{"/tmp/*.log"}, Facility: "local9"},
+				},
+			},
+			hostname:      "test-host",
+			expectError:   true,
+			errorContains: "invalid syslog priority",
+		},
 	}
 
 	for _, tt := range tests {
@@ -109,7 +299,7 @@ func TestAgent_Run_Shutdown(t *testing.T) {
 	tailFileCalled := make(chan struct{}, 1)
 
 	// Mock writeLogsFunc
-	writeLogsFunc = func(out <-chan models.LogEntry, format string) {
+	writeLogsFunc = func(out <-chan models.LogEntry, dst io.Writer, serializer forwarder.Serializer, buffers map[string]forwarder.BufferPolicy) {
 		writeLogsCalled <- struct{}{}
 		for range out {
 			// Drain channel to allow agent to close it gracefully
@@ -194,7 +384,7 @@ func TestAgent_Discover(t *testing.T) {
 		PollInterval: "1s",
 		Targets: []config.Target{
 			{Name: "app-logs", Paths: []string{filepath.Join(tmpDir, "app-*.log")}},
-			{Name: "sys-logs", Paths: []string{filepath.Join(tmpDir, "sys.log")}}, // Initially no match
+			{Name: "sys-logs", Paths: []string{filepath.Join(tmpDir, "sys-*.log")}}, // Initially no match
 		},
 	}
 	ag, err := New(cfg, "test-host")
@@ -241,6 +431,7 @@ func TestAgent_Discover(t *testing.T) {
 	if len(ag.tracked) != 2 {
 		t.Errorf("Expected 2 files tracked initially, got %d. Tracked: %v", len(ag.tracked), mapKeys(ag.tracked))
 	}
+	assertTrackedFiles(t, map[string]float64{"app-logs": 2, "sys-logs": 0})
 
 	// Create a new file, discover again - should start tailing the new file
 	if _, err := os.Create(file3Path); err != nil {
@@ -259,6 +450,7 @@ func TestAgent_Discover(t *testing.T) {
 	if len(ag.tracked) != 3 {
 		t.Errorf("Expected 3 files tracked after new file, got %d. Tracked: %v", len(ag.tracked), mapKeys(ag.tracked))
 	}
+	assertTrackedFiles(t, map[string]float64{"app-logs": 3, "sys-logs": 0})
 
 	// Remove an existing file, discover again - should stop tailing the removed file
 	os.Remove(file1Path)
@@ -286,6 +478,21 @@ func TestAgent_Discover(t *testing.T) {
 	if len(ag.tracked) != 2 {
 		t.Errorf("Expected 2 files tracked after removal, got %d. Tracked: %v", len(ag.tracked), mapKeys(ag.tracked))
 	}
+	assertTrackedFiles(t, map[string]float64{"app-logs": 2, "sys-logs": 0})
+}
+
+// assertTrackedFiles checks the tracked files gauge of each given target.
+func assertTrackedFiles(t *testing.T, want map[string]float64) {
+	t.Helper()
+	for target, n := range want {
+		var m dto.Metric
+		if err := metrics.TrackedFiles.WithLabelValues(target).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.GetGauge().GetValue(); got != n {
+			t.Errorf("Expected %v tracked files for target '%s', got %v", n, target, got)
+		}
+	}
 }
 
 // mapKeys is a helper to get keys from any map with string keys (for easier debugging output)
@@ -302,3 +509,1611 @@ func mapKeys(m interface{}) []string {
 	}
 	return keys
 }
+
+// TestAgent_Discover_OpenBackoff verifies that paths failing to open are retried with backoff instead of every cycle.
+func TestAgent_Discover_OpenBackoff(t *testing.T) {
+	t.Cleanup(resetMocks)
+	origBase, origMax := openBackoffBase, openBackoffMax
+	openBackoffBase, openBackoffMax = 50*time.Millisecond, 200*time.Millisecond
+	t.Cleanup(func() { openBackoffBase, openBackoffMax = origBase, origMax })
+
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "denied.log")
+	if err := os.WriteFile(logPath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		PollInterval: "1s",
+		Targets:      []config.Target{{Name: "denied", Paths: []string{logPath}}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	// Mock tailFileFunc to fail the open until told otherwise
+	var mu sync.Mutex
+	attempts := 0
+	failOpen := true
+	tailFileFunc = func(ctx context.Context, wg *sync.WaitGroup, path string, out chan<- models.LogEntry, opts forwarder.TailOptions) {
+		defer wg.Done()
+		mu.Lock()
+		attempts++
+		fail := failOpen
+		mu.Unlock()
+		if fail {
+			opts.OnOpen(fmt.Errorf("open %s: permission denied", path))
+			return
+		}
+		opts.OnOpen(nil)
+		<-ctx.Done()
+	}
+	getAttempts := func() int {
+		ag.wg.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// First cycle tries once; immediate re-discovery is held back by the backoff
+	ag.discover(ctx)
+	if n := getAttempts(); n != 1 {
+		t.Fatalf("Expected 1 open attempt, got %d", n)
+	}
+	ag.discover(ctx)
+	ag.discover(ctx)
+	if n := getAttempts(); n != 1 {
+		t.Fatalf("Expected retries to be suppressed during backoff, got %d attempts", n)
+	}
+	if _, ok := ag.tracked[logPath]; ok {
+		t.Error("Failed path should not stay tracked")
+	}
+
+	// After the window expires the path is retried, and the next window is longer
+	time.Sleep(60 * time.Millisecond)
+	ag.discover(ctx)
+	if n := getAttempts(); n != 2 {
+		t.Fatalf("Expected a retry after the backoff window, got %d attempts", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+	ag.discover(ctx)
+	if n := getAttempts(); n != 2 {
+		t.Fatalf("Expected the second window to be longer, got %d attempts", n)
+	}
+
+	// A successful open resets the backoff history
+	mu.Lock()
+	failOpen = false
+	mu.Unlock()
+	time.Sleep(60 * time.Millisecond)
+	ag.discover(ctx)
+	time.Sleep(20 * time.Millisecond)
+	ag.mu.Lock()
+	_, stillBackingOff := ag.backoff[logPath]
+	ag.mu.Unlock()
+	if stillBackingOff {
+		t.Error("Expected backoff state to be cleared after a successful open")
+	}
+	if _, ok := ag.tracked[logPath]; !ok {
+		t.Error("Expected path to be tracked after a successful open")
+	}
+	cancel()
+	ag.wg.Wait()
+}
+
+// TestAgent_Discover_Recursive verifies that a "**" pattern matches files at
+// any depth, including directories created after the agent started.
+func TestAgent_Discover_Recursive(t *testing.T) {
+	t.Cleanup(resetMocks)
+
+	root := filepath.Join(t.TempDir(), "logs")
+	write := func(rel string) string {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	top := write("top.log")
+	svcA := write(filepath.Join("svc-a", "app.log"))
+	deep := write(filepath.Join("svc-b", "2024", "01", "app.log"))
+	write(filepath.Join("svc-a", "notes.txt"))
+
+	cfg := &config.Config{
+		PollInterval: "1s",
+		Targets:      []config.Target{{Name: "tree", Paths: []string{filepath.Join(root, "**", "*.log")}}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	started := make(chan string, 10)
+	tailFileFunc = func(ctx context.Context, wg *sync.WaitGroup, path string, out chan<- models.LogEntry, opts forwarder.TailOptions) {
+		defer wg.Done()
+		started <- path
+		<-ctx.Done()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		ag.wg.Wait()
+	}()
+
+	collect := func(n int) []string {
+		var got []string
+		for i := 0; i < n; i++ {
+			select {
+			case path := <-started:
+				got = append(got, path)
+			case <-time.After(time.Second):
+				t.Fatalf("Timeout waiting for tailer %d of %d, got %v", i+1, n, got)
+			}
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	ag.discover(ctx)
+	want := []string{svcA, deep, top}
+	sort.Strings(want)
+	if got := collect(3); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// A directory created between cycles is picked up by the next one
+	added := write(filepath.Join("svc-c", "new", "app.log"))
+	ag.discover(ctx)
+	if got := collect(1); got[0] != added {
+		t.Errorf("Expected %s, got %s", added, got[0])
+	}
+	select {
+	case path := <-started:
+		t.Errorf("Unexpected tailer for %s", path)
+	default:
+	}
+}
+
+// TestAgent_Discover_IgnorePaths verifies that files matching a target's
+// ignore_paths are never tracked, and that a tracked file renamed to an
+// ignored name is dropped by the next cycle.
+func TestAgent_Discover_IgnorePaths(t *testing.T) {
+	t.Cleanup(resetMocks)
+
+	tmpDir := t.TempDir()
+	appLog := filepath.Join(tmpDir, "app-1.log")
+	for _, name := range []string{"app-1.log", "app-2.audit.log", "app-3.log"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{
+		PollInterval: "1s",
+		Targets: []config.Target{{
+			Name:        "app",
+			Paths:       []string{filepath.Join(tmpDir, "app-*.log")},
+			IgnorePaths: []string{"*.audit.log", filepath.Join(tmpDir, "app-3.log")},
+		}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	stopped := make(chan string, 5)
+	tailFileFunc = func(ctx context.Context, wg *sync.WaitGroup, path string, out chan<- models.LogEntry, opts forwarder.TailOptions) {
+		defer wg.Done()
+		<-ctx.Done()
+		stopped <- path
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		ag.wg.Wait()
+	}()
+
+	ag.discover(ctx)
+	if got := mapKeys(ag.tracked); !reflect.DeepEqual(got, []string{appLog}) {
+		t.Errorf("Expected only %s tracked, got %v", appLog, got)
+	}
+
+	if err := os.Rename(appLog, filepath.Join(tmpDir, "app-1.audit.log")); err != nil {
+		t.Fatal(err)
+	}
+	ag.discover(ctx)
+	if len(ag.tracked) != 0 {
+		t.Errorf("Expected nothing tracked after the rename, got %v", mapKeys(ag.tracked))
+	}
+	select {
+	case path := <-stopped:
+		if path != appLog {
+			t.Errorf("Expected %s to be stopped, got %s", appLog, path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the renamed file's tailer to stop")
+	}
+}
+
+// TestMatchPath verifies "**" matching, and that patterns without it match
+// exactly as filepath.Match does.
+func TestMatchPath(t *testing.T) {
+	j := filepath.Join
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{j("logs", "*.log"), j("logs", "a.log"), true},
+		{j("logs", "*.log"), j("logs", "x", "a.log"), false},
+		{j("logs", "**", "*.log"), j("logs", "a.log"), true},
+		{j("logs", "**", "*.log"), j("logs", "x", "y", "a.log"), true},
+		{j("logs", "**", "*.log"), j("logs", "x", "a.txt"), false},
+		{j("logs", "**", "svc-*", "*.log"), j("logs", "x", "svc-a", "a.log"), true},
+		{j("logs", "**", "svc-*", "*.log"), j("logs", "svc-a", "x", "a.log"), false},
+		{j("logs", "**"), j("logs", "x", "a.log"), true},
+		{j("logs", "**", "*.log"), j("other", "a.log"), false},
+	}
+	for _, tt := range tests {
+		got, err := matchPath(tt.pattern, tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+	if _, err := glob(j("logs", "**", "[")); err == nil {
+		t.Error("Expected an error for a malformed pattern")
+	}
+}
+
+// TestAgent_Discover_WaitForCreation verifies that a literal path that
+// doesn't exist yet is tracked, and read from the start once created.
+func TestAgent_Discover_WaitForCreation(t *testing.T) {
+	t.Cleanup(resetMocks)
+	logPath := filepath.Join(t.TempDir(), "later.log")
+	cfg := &config.Config{
+		PollInterval: "1h",
+		Targets:      []config.Target{{Name: "later", Paths: []string{logPath}}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	entries := make(chan models.LogEntry, 10)
+	writeLogsFunc = func(out <-chan models.LogEntry, dst io.Writer, serializer forwarder.Serializer, buffers map[string]forwarder.BufferPolicy) {
+		for entry := range out {
+			entries <- entry
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	go func() {
+		defer runWg.Done()
+		ag.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		runWg.Wait()
+	}()
+
+	// The first cycle tracks the missing path; with a 1h poll interval only
+	// its waiting tailer can pick up the file
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(logPath, []byte("first line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case entry := <-entries:
+		if entry.Event != "first line" {
+			t.Errorf("Expected 'first line', got %q", entry.Event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the created file to be read")
+	}
+}
+
+// TestAgent_Discover_Journald verifies that a journald target gets one
+// reader, keyed and checkpointed apart from files, which is restarted after
+// its journalctl exits.
+func TestAgent_Discover_Journald(t *testing.T) {
+	t.Cleanup(resetMocks)
+	origBase := openBackoffBase
+	openBackoffBase = 0
+	t.Cleanup(func() { openBackoffBase = origBase })
+
+	cfg := &config.Config{
+		PollInterval: "1h",
+		Targets: []config.Target{{
+			Name:     "system",
+			Journald: &config.Journald{Units: []string{"nginx.service"}, Fields: []string{"_PID"}},
+		}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	starts := make(chan forwarder.JournalOptions, 10)
+	exit := make(chan struct{})
+	readJournalFunc = func(ctx context.Context, wg *sync.WaitGroup, name string, out chan<- models.LogEntry, opts forwarder.TailOptions, jopts forwarder.JournalOptions) {
+		defer wg.Done()
+		opts.OnOpen(nil)
+		starts <- jopts
+		select {
+		case <-exit:
+			opts.OnOpen(fmt.Errorf("journalctl exited"))
+		case <-ctx.Done():
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		ag.wg.Wait()
+	}()
+
+	// 1. One reader, however often discovery runs
+	ag.discover(ctx)
+	ag.discover(ctx)
+	want := forwarder.JournalOptions{Units: []string{"nginx.service"}, Fields: []string{"_PID"}, CursorKey: "journald:system"}
+	if got := <-starts; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected a reader with %+v, got %+v", want, got)
+	}
+
+	// 2. A reader whose journalctl exited is started again
+	close(exit)
+	ag.wg.Wait()
+	ag.discover(ctx)
+	select {
+	case <-starts:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the reader to be restarted")
+	}
+	if len(starts) != 0 {
+		t.Errorf("Expected a single restart, got %d more", len(starts))
+	}
+}
+
+// TestAgent_Discover_SourcePattern verifies that fields captured from a
+// matched path are added to that file's entries.
+func TestAgent_Discover_SourcePattern(t *testing.T) {
+	t.Cleanup(resetMocks)
+	dir := t.TempDir()
+	for _, name := range []string{"app-1.log", "app-2.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("started\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{
+		PollInterval: "1s",
+		Targets: []config.Target{{
+			Name:              "app",
+			Paths:             []string{filepath.Join(dir, "app-*.log")},
+			ReadFromBeginning: true,
+			Fields:            map[string]string{"env": "prod", "instance": "static"},
+			SourcePattern:     `app-(?P<instance>\d+)\.log$`,
+		}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		ag.wg.Wait()
+	}()
+	ag.discover(ctx)
+
+	// The path's field wins over the static one of the same name
+	got := make(map[string]map[string]string)
+	for len(got) < 2 {
+		select {
+		case entry := <-ag.logCh:
+			got[entry.Source] = entry.Fields
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for entries, got %v", got)
+		}
+	}
+	want := map[string]map[string]string{
+		"app-1.log": {"env": "prod", "instance": "1"},
+		"app-2.log": {"env": "prod", "instance": "2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestAgent_Discover_TargetOverlap verifies that a path matched by several targets is tailed once, by the first target.
+func TestAgent_Discover_TargetOverlap(t *testing.T) {
+	t.Cleanup(resetMocks)
+
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "app.log")
+	if err := os.WriteFile(logPath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		PollInterval: "1s",
+		TagTarget:    true,
+		Targets: []config.Target{
+			{Name: "specific", Paths: []string{logPath}, Fields: map[string]string{"team": "payments"}},
+			{Name: "catch-all", Paths: []string{filepath.Join(tmpDir, "*.log")}},
+		},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	started := make(chan forwarder.TailOptions, 5)
+	tailFileFunc = func(ctx context.Context, wg *sync.WaitGroup, path string, out chan<- models.LogEntry, opts forwarder.TailOptions) {
+		defer wg.Done()
+		started <- opts
+		<-ctx.Done()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ag.discover(ctx)
+
+	select {
+	case opts := <-started:
+		if opts.GroupName != "specific" {
+			t.Errorf("Expected first target 'specific' to win, got '%s'", opts.GroupName)
+		}
+		if opts.CustomFields[TargetField] != "specific" {
+			t.Errorf("Expected %s field 'specific', got '%s'", TargetField, opts.CustomFields[TargetField])
+		}
+		if opts.CustomFields["team"] != "payments" {
+			t.Errorf("Expected static fields to be kept, got %v", opts.CustomFields)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Timeout waiting for overlapping file to be tailed")
+	}
+	select {
+	case opts := <-started:
+		t.Errorf("Path tailed a second time by target '%s'", opts.GroupName)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !ag.overlapWarned[logPath] {
+		t.Error("Expected the overlap to be recorded as warned")
+	}
+	if _, ok := cfg.Targets[0].Fields[TargetField]; ok {
+		t.Error("Tagging must not mutate the configured fields map")
+	}
+
+	cancel()
+	ag.wg.Wait()
+
+	// With overlap rejection enabled the same config is refused up front
+	cfg.RejectTargetOverlap = true
+	if _, err := New(cfg, "test-host"); err == nil || !strings.Contains(err.Error(), "multiple targets") {
+		t.Errorf("Expected overlap error from New, got %v", err)
+	}
+}
+
+// TestAgent_Run_Taps verifies that registered taps observe entries on their way to the writer.
+func TestAgent_Run_Taps(t *testing.T) {
+	t.Cleanup(resetMocks)
+
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "app.log")
+	if err := os.WriteFile(logPath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		PollInterval: "10ms",
+		Targets:      []config.Target{{Name: "app", Paths: []string{logPath}}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	tapped := make(chan models.LogEntry, 1)
+	ag.AddTap(func(e models.LogEntry) { tapped <- e })
+
+	written := make(chan models.LogEntry, 1)
+	writeLogsFunc = func(out <-chan models.LogEntry, dst io.Writer, serializer forwarder.Serializer, buffers map[string]forwarder.BufferPolicy) {
+		for e := range out {
+			written <- e
+		}
+	}
+	tailFileFunc = func(ctx context.Context, wg *sync.WaitGroup, path string, out chan<- models.LogEntry, opts forwarder.TailOptions) {
+		defer wg.Done()
+		out <- models.LogEntry{Event: "hello", SourceType: opts.GroupName}
+		<-ctx.Done()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	go func() {
+		defer runWg.Done()
+		ag.Run(ctx)
+	}()
+
+	for name, ch := range map[string]chan models.LogEntry{"tap": tapped, "writer": written} {
+		select {
+		case e := <-ch:
+			if e.Event != "hello" {
+				t.Errorf("Expected %s to see 'hello', got '%s'", name, e.Event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for entry at %s", name)
+		}
+	}
+
+	cancel()
+	select {
+	case <-waitChannel(&runWg):
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for agent.Run to finish")
+	}
+}
+
+// TestAgent_Run_Once verifies that in once mode Run reads a file from the
+// start, emits every line, including an unterminated last one, and returns
+// on its own.
+func TestAgent_Run_Once(t *testing.T) {
+	t.Cleanup(resetMocks)
+
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "app.log")
+	if err := os.WriteFile(logPath, []byte("one\ntwo\nthree\nfour"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		PollInterval: "10ms",
+		Mode:         config.ModeOnce,
+		Targets:      []config.Target{{Name: "app", Paths: []string{logPath}}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	var mu sync.Mutex
+	var got []string
+	writeLogsFunc = func(out <-chan models.LogEntry, dst io.Writer, serializer forwarder.Serializer, buffers map[string]forwarder.BufferPolicy) {
+		for e := range out {
+			mu.Lock()
+			got = append(got, e.Event)
+			mu.Unlock()
+		}
+	}
+
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	go func() {
+		defer runWg.Done()
+		ag.Run(context.Background())
+	}()
+	select {
+	case <-waitChannel(&runWg):
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for agent.Run to return in once mode")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"one", "two", "three", "four"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// TestAgent_Run_Stdin verifies that a target reading "-" runs what is piped
+// in through the target's filtering, multiline assembly and fields, and that
+// Run returns once it is exhausted.
+func TestAgent_Run_Stdin(t *testing.T) {
+	t.Cleanup(resetMocks)
+	t.Cleanup(func() { stdinReader = os.Stdin })
+
+	stdinReader = strings.NewReader("2024-01-01 start\n  at frame\n2024-01-01 DEBUG noise\n2024-01-02 next\n2024-01-03 last")
+	cfg := &config.Config{
+		PollInterval: "10ms",
+		Targets: []config.Target{{
+			Name:             "piped",
+			Paths:            []string{forwarder.StdinPath},
+			ExcludePattern:   "DEBUG",
+			MultilinePattern: `^\d{4}-`,
+			Fields:           map[string]string{"env": "test"},
+		}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	var mu sync.Mutex
+	var got []models.LogEntry
+	writeLogsFunc = func(out <-chan models.LogEntry, dst io.Writer, serializer forwarder.Serializer, buffers map[string]forwarder.BufferPolicy) {
+		for e := range out {
+			mu.Lock()
+			got = append(got, e)
+			mu.Unlock()
+		}
+	}
+
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	go func() {
+		defer runWg.Done()
+		ag.Run(context.Background())
+	}()
+	select {
+	case <-waitChannel(&runWg):
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for agent.Run to return at the end of stdin")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var events []string
+	for _, e := range got {
+		events = append(events, e.Event)
+		if e.Source != forwarder.StdinSource || e.Fields["env"] != "test" {
+			t.Errorf("Expected source %s with the target's fields, got %s %v", forwarder.StdinSource, e.Source, e.Fields)
+		}
+	}
+	if want := []string{"2024-01-01 start\n  at frame", "2024-01-02 next", "2024-01-03 last"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("Expected %q, got %q", want, events)
+	}
+}
+
+func TestAgent_Run_TargetOutputFormat(t *testing.T) {
+	t.Cleanup(resetMocks)
+
+	tmpDir := t.TempDir()
+	noisy, api := filepath.Join(tmpDir, "noisy.log"), filepath.Join(tmpDir, "api.log")
+	if err := os.WriteFile(noisy, []byte("debug chatter\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(api, []byte("request done\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outPath := filepath.Join(tmpDir, "out.log")
+	cfg := &config.Config{
+		PollInterval: "10ms",
+		Mode:         config.ModeOnce,
+		Output:       &config.Output{Transport: "file", Path: outPath},
+		Targets: []config.Target{
+			{Name: "noisy", Paths: []string{noisy}, OutputFormat: "raw"},
+			{Name: "api", Paths: []string{api}},
+		},
+	}
+	if _, err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	ag.Run(context.Background())
+
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	sort.Strings(lines)
+	if len(lines) != 2 || lines[0] != "debug chatter" || !strings.HasPrefix(lines[1], "{") || !strings.Contains(lines[1], `"event":"request done"`) {
+		t.Errorf("Expected the noisy target raw and the api target as JSON, got %q", lines)
+	}
+}
+
+// failingSink is a transport whose writes fail while err is set.
+type failingSink struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (f *failingSink) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+	return len(p), nil
+}
+
+func (f *failingSink) Close() error { return nil }
+
+// TestAgent_Health verifies /healthz and /readyz: both fail before Run, pass
+// once the first discovery is done, and readiness drops while a network
+// output has been failing for longer than the timeout.
+func TestAgent_Health(t *testing.T) {
+	t.Cleanup(resetMocks)
+
+	cfg := &config.Config{
+		PollInterval: "10ms",
+		Targets:      []config.Target{{Name: "app", Paths: []string{filepath.Join(t.TempDir(), "*.log")}}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	sink := &failingSink{}
+	ag.outputs[0].conns[0].health = &sinkHealth{WriteCloser: sink}
+	ag.readyTimeout = 50 * time.Millisecond
+	writeLogsFunc = func(out <-chan models.LogEntry, dst io.Writer, serializer forwarder.Serializer, buffers map[string]forwarder.BufferPolicy) {
+		for range out {
+		}
+	}
+
+	status := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+	if got := status(ag.HealthHandler()); got != http.StatusServiceUnavailable {
+		t.Errorf("Expected /healthz to fail before Run, got %d", got)
+	}
+	if got := status(ag.ReadyHandler()); got != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to fail before Run, got %d", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	go func() {
+		defer runWg.Done()
+		ag.Run(ctx)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for ag.Ready() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for readiness: %v", ag.Ready())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := status(ag.HealthHandler()); got != http.StatusOK {
+		t.Errorf("Expected /healthz 200 while running, got %d", got)
+	}
+
+	// A failing sink only makes the agent unready once past the timeout
+	sink.mu.Lock()
+	sink.err = fmt.Errorf("collector unreachable")
+	sink.mu.Unlock()
+	ag.outputs[0].conns[0].health.Write([]byte("batch"))
+	if err := ag.Ready(); err != nil {
+		t.Errorf("Expected ready within the timeout, got %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if got := status(ag.ReadyHandler()); got != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz 503 with a failing sink, got %d", got)
+	}
+	sink.mu.Lock()
+	sink.err = nil
+	sink.mu.Unlock()
+	ag.outputs[0].conns[0].health.Write([]byte("batch"))
+	if err := ag.Ready(); err != nil {
+		t.Errorf("Expected ready after the sink recovered, got %v", err)
+	}
+
+	cancel()
+	select {
+	case <-waitChannel(&runWg):
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for agent.Run to finish")
+	}
+	if ag.Healthy() {
+		t.Error("Expected unhealthy after Run returned")
+	}
+}
+
+// TestAgent_HealthDroppedBatches verifies that an output whose transport
+// keeps giving up on batches, though its writes return no error, makes the
+// agent unready.
+func TestAgent_HealthDroppedBatches(t *testing.T) {
+	var mu sync.Mutex
+	code := http.StatusBadRequest
+	var conn *outputConn
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if code == http.StatusInternalServerError {
+			// Give up on the batch instead of waiting out the backoff
+			conn.stopRetries()
+		}
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+	setCode := func(c int) {
+		mu.Lock()
+		code = c
+		mu.Unlock()
+	}
+
+	cfg := &config.Config{
+		PollInterval: "10ms",
+		Targets:      []config.Target{{Name: "app", Paths: []string{filepath.Join(t.TempDir(), "*.log")}}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	o := &output{name: "collector"}
+	if conn, err = o.openConn(config.Output{Name: "collector", Transport: "http", URL: srv.URL, Serializer: "json"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.dst.Close()
+	o.conns = []*outputConn{conn}
+	ag.outputs = []*output{o}
+	ag.readyTimeout = 50 * time.Millisecond
+	ag.writerUp.Store(true)
+	ag.discovered.Store(true)
+	write := func() {
+		t.Helper()
+		if _, err := conn.dst.Write([]byte(`{"event":"x"}` + "\n")); err != nil {
+			t.Fatalf("Expected the transport to swallow the failure, got %v", err)
+		}
+	}
+
+	// 1. A batch rejected with a 4xx keeps the output failing
+	write()
+	time.Sleep(60 * time.Millisecond)
+	write()
+	if err := ag.Ready(); err == nil || !strings.Contains(err.Error(), "output 'collector' failing") {
+		t.Errorf("Expected the rejecting output to make the agent unready, got %v", err)
+	}
+
+	// 2. A delivered batch makes it healthy again
+	setCode(http.StatusOK)
+	write()
+	if err := ag.Ready(); err != nil {
+		t.Errorf("Expected ready after a delivered batch, got %v", err)
+	}
+
+	// 3. So does a batch dropped after its 500s were retried
+	setCode(http.StatusInternalServerError)
+	write()
+	time.Sleep(60 * time.Millisecond)
+	if err := ag.Ready(); err == nil {
+		t.Error("Expected an output failing with 500s to make the agent unready")
+	}
+}
+
+func TestAgent_CheckpointErrors(t *testing.T) {
+	orig := checkpointRetryDelay
+	checkpointRetryDelay = time.Millisecond
+	t.Cleanup(func() { checkpointRetryDelay = orig })
+
+	// 1. A checkpoint file whose directory doesn't exist can't be written
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	os.WriteFile(logFile, []byte("line\n"), 0o644)
+	cpDir := filepath.Join(dir, "state")
+	cfg := &config.Config{
+		PollInterval:   "10ms",
+		CheckpointFile: filepath.Join(cpDir, "checkpoints.json"),
+		Targets:        []config.Target{{Name: "app", Paths: []string{logFile}}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	ag.writerUp.Store(true)
+	ag.discovered.Store(true)
+	errorCount := func() float64 {
+		var m dto.Metric
+		if err := metrics.CheckpointErrors.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	// 2. The save fails after its retries, is counted and makes the agent
+	// unready, while offsets keep being recorded in memory
+	before := errorCount()
+	ag.checkpoints.Set(logFile, 1, 5)
+	if err := ag.saveCheckpoint(context.Background()); err == nil {
+		t.Fatal("Expected the checkpoint save to fail")
+	}
+	if got := errorCount() - before; got != 1 {
+		t.Errorf("Expected 1 checkpoint error, got %.0f", got)
+	}
+	if err := ag.Ready(); err == nil || !strings.Contains(err.Error(), "checkpoints failing to save") {
+		t.Errorf("Expected unready with failing checkpoints, got %v", err)
+	}
+	ag.checkpoints.Set(logFile, 1, 10)
+	if offset, ok := ag.checkpoints.Get(logFile, 1); !ok || offset != 10 {
+		t.Errorf("Expected offset 10 kept in memory, got %d (ok=%v)", offset, ok)
+	}
+
+	// 3. Once the file can be written again, the next save recovers
+	if err := os.Mkdir(cpDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ag.saveCheckpoint(context.Background()); err != nil {
+		t.Fatalf("Expected the checkpoint save to succeed, got %v", err)
+	}
+	if err := ag.Ready(); err != nil {
+		t.Errorf("Expected ready after checkpoints were saved, got %v", err)
+	}
+}
+
+// TestTargetOptions verifies that a path resolves to the options of the first matching target.
+func TestTargetOptions(t *testing.T) {
+	cfg := &config.Config{
+		PollInterval: "1s",
+		Targets: []config.Target{
+			{Name: "nginx", Paths: []string{"/var/log/nginx/*.log"}, ExcludePattern: "healthz"},
+			{Name: "all", Paths: []string{"/var/log/*/*.log"}},
+		},
+	}
+
+	opts, ok, err := TargetOptions(cfg, "test-host", "/var/log/nginx/access.log")
+	if err != nil || !ok {
+		t.Fatalf("Expected a matching target, got ok=%v err=%v", ok, err)
+	}
+	if opts.GroupName != "nginx" || len(opts.ExcludeRegexes) != 1 || opts.Hostname != "test-host" {
+		t.Errorf("Unexpected options: %+v", opts)
+	}
+
+	if _, ok, _ := TargetOptions(cfg, "test-host", "/tmp/other.log"); ok {
+		t.Error("Expected no target to match /tmp/other.log")
+	}
+
+	cfg.Targets[0].ExcludePattern = "["
+	if _, _, err := TargetOptions(cfg, "test-host", "/var/log/nginx/access.log"); err == nil {
+		t.Error("Expected invalid regex to be reported")
+	}
+}
+
+func TestCompileTargets_GlobalFields(t *testing.T) {
+	cfg := &config.Config{
+		PollInterval: "1s",
+		TagTarget:    true,
+		Fields:       map[string]string{"env": "production", "datacenter": "eu-1"},
+		Targets: []config.Target{
+			{Name: "staging", Paths: []string{"/tmp/a.log"}, Fields: map[string]string{"env": "staging", "app": "api"}},
+			{Name: "plain", Paths: []string{"/tmp/b.log"}},
+		},
+	}
+	_, fields, err := compileTargets(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Target fields win over global ones, and the tag over both
+	want := map[int]map[string]string{
+		0: {"env": "staging", "datacenter": "eu-1", "app": "api", TargetField: "staging"},
+		1: {"env": "production", "datacenter": "eu-1", TargetField: "plain"},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("Expected %v, got %v", want, fields)
+	}
+
+	// Each target gets its own map, leaving the config's untouched
+	fields[1]["env"] = "changed"
+	if cfg.Fields["env"] != "production" || fields[0]["env"] != "staging" {
+		t.Error("Expected merged fields not to alias the config's maps")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{
+		PollInterval: "1s",
+		Targets: []config.Target{
+			{Name: "logs", Paths: []string{filepath.Join(dir, "*.log")}},
+			{Name: "all", Paths: []string{filepath.Join(dir, "*")}},             // the .log files are claimed above
+			{Name: "later", Paths: []string{filepath.Join(dir, "missing.log")}}, // tracked, but no file yet
+		},
+	}
+	counts, err := Check(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2, 1, 0}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("Expected %v files per target, got %v", want, counts)
+	}
+
+	cfg.RejectTargetOverlap = true
+	if _, err := Check(cfg); err == nil || !strings.Contains(err.Error(), "matched by multiple targets") {
+		t.Errorf("Expected an overlap error, got %v", err)
+	}
+	cfg.RejectTargetOverlap = false
+	cfg.Targets[0].MultilinePattern = "("
+	if _, err := Check(cfg); err == nil || !strings.Contains(err.Error(), "invalid multiline_pattern") {
+		t.Errorf("Expected a multiline_pattern error, got %v", err)
+	}
+}
+
+func TestAgent_Discover_MaxTrackedFiles(t *testing.T) {
+	t.Cleanup(resetMocks)
+
+	tmpDir := t.TempDir()
+	for i := 1; i <= 3; i++ {
+		if err := os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("app-%d.log", i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{
+		PollInterval:    "1s",
+		MaxTrackedFiles: 2,
+		Targets:         []config.Target{{Name: "app", Paths: []string{filepath.Join(tmpDir, "app-*.log")}}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	tailFileFunc = func(ctx context.Context, wg *sync.WaitGroup, path string, out chan<- models.LogEntry, opts forwarder.TailOptions) {
+		defer wg.Done()
+		<-ctx.Done()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		ag.wg.Wait()
+	}()
+
+	ag.discover(ctx)
+	if len(ag.tracked) != 2 || !ag.limitWarned {
+		t.Fatalf("Expected 2 tracked files at the limit, got %v", mapKeys(ag.tracked))
+	}
+
+	// Once a tracked file goes away, the waiting one takes its slot
+	if err := os.Remove(filepath.Join(tmpDir, "app-1.log")); err != nil {
+		t.Fatal(err)
+	}
+	ag.discover(ctx) // frees the slot
+	ag.discover(ctx) // fills it
+	expected := []string{filepath.Join(tmpDir, "app-2.log"), filepath.Join(tmpDir, "app-3.log")}
+	got := mapKeys(ag.tracked)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v tracked, got %v", expected, got)
+	}
+}
+
+// TestAgent_NoGoroutineLeak runs real tailers through tracking/untracking
+// churn and checks the goroutine count returns to its baseline afterwards,
+// catching cancelled tailers that never actually exit.
+func TestAgent_NoGoroutineLeak(t *testing.T) {
+	t.Cleanup(resetMocks)
+	baseline := runtime.NumGoroutine()
+
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		PollInterval: "20ms",
+		Output:       &config.Output{Transport: "file", Path: filepath.Join(tmpDir, "out.log")},
+		Targets:      []config.Target{{Name: "churn", Paths: []string{filepath.Join(tmpDir, "app-*.log")}}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	go func() {
+		defer runWg.Done()
+		ag.Run(ctx)
+	}()
+
+	// Several generations of short-lived files, written to while tracked
+	for gen := 0; gen < 3; gen++ {
+		var paths []string
+		for i := 0; i < 5; i++ {
+			p := filepath.Join(tmpDir, fmt.Sprintf("app-%d-%d.log", gen, i))
+			if err := os.WriteFile(p, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			paths = append(paths, p)
+		}
+		time.Sleep(100 * time.Millisecond)
+		for _, p := range paths {
+			f, err := os.OpenFile(p, os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintln(f, "line")
+			f.Close()
+		}
+		time.Sleep(100 * time.Millisecond)
+		for _, p := range paths {
+			os.Remove(p)
+		}
+	}
+
+	cancel()
+	select {
+	case <-waitChannel(&runWg):
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for agent.Run to finish")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("Goroutine leak: %d running, baseline %d", runtime.NumGoroutine(), baseline)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestAgent_Discover_SettleTime(t *testing.T) {
+	t.Cleanup(resetMocks)
+
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "app.log")
+	if err := os.WriteFile(logPath, []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		PollInterval: "1s",
+		Targets:      []config.Target{{Name: "app", Paths: []string{logPath}, SettleTime: "1s"}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	tailFileFunc = func(ctx context.Context, wg *sync.WaitGroup, path string, out chan<- models.LogEntry, opts forwarder.TailOptions) {
+		defer wg.Done()
+		<-ctx.Done()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		ag.wg.Wait()
+	}()
+
+	// First sighting only records the size
+	start := time.Now()
+	if ag.settled(logPath, time.Second, start) {
+		t.Fatal("A newly seen file must not be opened before it settles")
+	}
+	// Still growing: the clock restarts
+	if err := os.WriteFile(logPath, []byte("partial, now longer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if ag.settled(logPath, time.Second, start.Add(2*time.Second)) {
+		t.Fatal("A file whose size changed must not count as settled")
+	}
+	if ag.settled(logPath, time.Second, start.Add(2500*time.Millisecond)) {
+		t.Fatal("Settled too early after the size change")
+	}
+	if !ag.settled(logPath, time.Second, start.Add(3*time.Second)) {
+		t.Fatal("Expected the file to be settled after an unchanged settle_time")
+	}
+
+	// Through discover, the file is not tracked on first sight
+	ag.discover(ctx)
+	if len(ag.tracked) != 0 {
+		t.Errorf("Expected nothing tracked before the file settles, got %v", mapKeys(ag.tracked))
+	}
+}
+
+func TestAgent_ApplyReload(t *testing.T) {
+	cfg := &config.Config{
+		PollInterval: "1s",
+		Targets: []config.Target{
+			{Name: "app", Paths: []string{"/var/log/app/*.log"}, ExcludePattern: "healthz"},
+			{Name: "web", Paths: []string{"/var/log/web/*.log"}},
+		},
+	}
+	a, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := a.tailOptions(0).Live
+	webLive := a.tailOptions(1).Live
+
+	// 1. Only patterns/fields changed on "app"; "web" gained a new path
+	updated := &config.Config{
+		PollInterval: "1s",
+		Targets: []config.Target{
+			{Name: "web", Paths: []string{"/var/log/web/*.log", "/srv/web/*.log"}},
+			{Name: "app", Paths: []string{"/var/log/app/*.log"}, ExcludePattern: "DEBUG", Fields: map[string]string{"env": "prod"}},
+		},
+	}
+	if err := a.applyReload(updated); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2. The running "app" tailers see the new patterns; "web" gets new ones
+	if a.tailOptions(1).Live != live {
+		t.Error("Expected 'app' to keep its live patterns across the reload")
+	}
+	p := live.Load()
+	if len(p.Exclude) != 1 || p.Exclude[0].String() != "DEBUG" || p.Fields["env"] != "prod" {
+		t.Errorf("Unexpected live patterns: %+v", p)
+	}
+	if a.tailOptions(0).Live == webLive {
+		t.Error("Expected changed target 'web' to get new live patterns")
+	}
+
+	// 3. An invalid pattern leaves the current config in place
+	broken := *updated
+	broken.Targets = []config.Target{{Name: "app", Paths: []string{"/var/log/app/*.log"}, ExcludePattern: "["}}
+	if err := a.applyReload(&broken); err == nil {
+		t.Error("Expected invalid regex to be reported")
+	}
+	if live.Load() != p || len(a.cfg.Targets) != 2 {
+		t.Error("Expected config and live patterns to be unchanged after a failed reload")
+	}
+}
+
+// TestAgent_Run_Reload verifies that a reload starts tailers for new
+// targets, restarts those of changed targets and leaves the rest running.
+func TestAgent_Run_Reload(t *testing.T) {
+	t.Cleanup(resetMocks)
+
+	tmpDir := t.TempDir()
+	appPath := filepath.Join(tmpDir, "app.log")
+	webPath := filepath.Join(tmpDir, "web.log")
+	for _, p := range []string{appPath, webPath} {
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{
+		PollInterval: "10ms",
+		Targets:      []config.Target{{Name: "app", Paths: []string{appPath}}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	started := make(chan string, 10)
+	stopped := make(chan string, 10)
+	writeLogsFunc = func(out <-chan models.LogEntry, dst io.Writer, serializer forwarder.Serializer, buffers map[string]forwarder.BufferPolicy) {
+		for range out {
+		}
+	}
+	tailFileFunc = func(ctx context.Context, wg *sync.WaitGroup, path string, out chan<- models.LogEntry, opts forwarder.TailOptions) {
+		defer wg.Done()
+		started <- opts.GroupName + ":" + filepath.Base(path)
+		<-ctx.Done()
+		stopped <- filepath.Base(path)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	go func() {
+		defer runWg.Done()
+		ag.Run(ctx)
+	}()
+
+	expect := func(ch chan string, want string) {
+		t.Helper()
+		select {
+		case got := <-ch:
+			if got != want {
+				t.Errorf("Expected %s, got %s", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for %s", want)
+		}
+	}
+
+	// 1. Initial discovery
+	expect(started, "app:app.log")
+
+	// 2. A pattern change on "app" and a new target: only "web" starts
+	if err := ag.Reload(ctx, &config.Config{
+		PollInterval: "10ms",
+		Targets: []config.Target{
+			{Name: "app", Paths: []string{appPath}, ExcludePattern: "DEBUG"},
+			{Name: "web", Paths: []string{webPath}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expect(started, "web:web.log")
+
+	// 3. Dropping "web" and changing "app" beyond patterns restarts "app"
+	if err := ag.Reload(ctx, &config.Config{
+		PollInterval: "10ms",
+		Targets:      []config.Target{{Name: "app", Paths: []string{appPath}, CollapseWhitespace: true}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{<-stopped, <-stopped}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"app.log", "web.log"}) {
+		t.Errorf("Expected both tailers to stop, got %v", got)
+	}
+	expect(started, "app:app.log")
+
+	cancel()
+	select {
+	case <-waitChannel(&runWg):
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for agent.Run to finish")
+	}
+	select {
+	case path := <-started:
+		t.Errorf("Unexpected tailer start: %s", path)
+	default:
+	}
+}
+
+func TestAgent_Run_WatchMode(t *testing.T) {
+	t.Cleanup(resetMocks)
+	dir := t.TempDir()
+
+	// 1. A poll interval far longer than the test: only events can drive it
+	cfg := &config.Config{
+		PollInterval: "1h",
+		WatchMode:    config.WatchInotify,
+		Targets:      []config.Target{{Name: "app", Paths: []string{filepath.Join(dir, "*.log")}}},
+	}
+	ag, err := New(cfg, "test-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ag.watcher == nil {
+		t.Skip("File watching is not available here")
+	}
+	// The directory is only watched once a file in it is tracked
+	first := filepath.Join(dir, "first.log")
+	if err := os.WriteFile(first, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan string, 10)
+	stopped := make(chan string, 10)
+	writeLogsFunc = func(out <-chan models.LogEntry, dst io.Writer, serializer forwarder.Serializer, buffers map[string]forwarder.BufferPolicy) {
+		for range out {
+		}
+	}
+	tailFileFunc = func(ctx context.Context, wg *sync.WaitGroup, path string, out chan<- models.LogEntry, opts forwarder.TailOptions) {
+		defer wg.Done()
+		if opts.Wake == nil {
+			t.Errorf("Expected a wake channel for %s", path)
+		}
+		started <- path
+		<-ctx.Done()
+		stopped <- path
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	go func() {
+		defer runWg.Done()
+		ag.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		runWg.Wait()
+	}()
+
+	expect := func(ch chan string, want, what string) {
+		t.Helper()
+		select {
+		case got := <-ch:
+			if got != want {
+				t.Fatalf("Expected %s %s, got %s", want, what, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for %s to be %s", want, what)
+		}
+	}
+	expect(started, first, "started")
+
+	// 2. A created file is discovered without waiting for the poll
+	second := filepath.Join(dir, "second.log")
+	if err := os.WriteFile(second, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect(started, second, "started")
+
+	// 3. A removed file's tailer is cancelled the same way
+	if err := os.Remove(first); err != nil {
+		t.Fatal(err)
+	}
+	expect(stopped, first, "stopped")
+}
+
+// TestFanOut verifies that every output receives each entry and that an
+// output with queue_full_policy drop does not hold up the others.
+func TestFanOut(t *testing.T) {
+	t.Cleanup(resetMocks)
+
+	fast := &output{name: "fast", conns: []*outputConn{{dst: &nopCloser{}}}, queues: []chan models.LogEntry{make(chan models.LogEntry, 1)}}
+	stuck := &output{name: "stuck", conns: []*outputConn{{dst: &nopCloser{}}}, drop: true, queues: []chan models.LogEntry{make(chan models.LogEntry, 1)}}
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	got := make(map[*output]int)
+	writeLogsFunc = func(out <-chan models.LogEntry, dst io.Writer, serializer forwarder.Serializer, buffers map[string]forwarder.BufferPolicy) {
+		o := fast
+		if dst == stuck.conns[0].dst {
+			o = stuck
+			<-release
+		}
+		for range out {
+			mu.Lock()
+			got[o]++
+			mu.Unlock()
+		}
+	}
+
+	// 1. Send more entries than the stuck output can queue
+	in := make(chan models.LogEntry)
+	done := make(chan struct{})
+	go func() {
+		fanOut(in, []*output{fast, stuck})
+		close(done)
+	}()
+	for i := 0; i < 10; i++ {
+		select {
+		case in <- models.LogEntry{Event: "line", SourceType: "app"}:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("fanOut blocked on entry %d", i)
+		}
+	}
+
+	// 2. Let the stuck output drain and wait for the fan-out to finish
+	close(in)
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for fanOut to finish")
+	}
+
+	if got[fast] != 10 {
+		t.Errorf("Expected fast output to get 10 entries, got %d", got[fast])
+	}
+	if got[stuck] < 1 || got[stuck] >= 10 {
+		t.Errorf("Expected stuck output to drop some entries, got %d", got[stuck])
+	}
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// TestFanOut_ConnectionPool verifies that every connection of a pooled
+// output gets a writer, and that with preserve_order each file's entries
+// are all written in order by the same connection.
+func TestFanOut_ConnectionPool(t *testing.T) {
+	t.Cleanup(resetMocks)
+
+	var mu sync.Mutex
+	got := make(map[io.Writer][]models.LogEntry)
+	writeLogsFunc = func(out <-chan models.LogEntry, dst io.Writer, serializer forwarder.Serializer, buffers map[string]forwarder.BufferPolicy) {
+		for e := range out {
+			mu.Lock()
+			got[dst] = append(got[dst], e)
+			mu.Unlock()
+		}
+	}
+	pooled := func(queues int) *output {
+		o := &output{name: "pooled"}
+		for range 3 {
+			o.conns = append(o.conns, &outputConn{dst: &nopCloser{}})
+		}
+		for range queues {
+			o.queues = append(o.queues, make(chan models.LogEntry, 10))
+		}
+		return o
+	}
+	send := func(o *output) {
+		in := make(chan models.LogEntry)
+		done := make(chan struct{})
+		go func() {
+			fanOut(in, []*output{o})
+			close(done)
+		}()
+		for i := range 100 {
+			in <- models.LogEntry{Source: fmt.Sprintf("app-%d.log", i%10), Event: fmt.Sprint(i)}
+		}
+		close(in)
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for fanOut to finish")
+		}
+	}
+
+	// 1. The connections share one queue: every entry is written once
+	send(pooled(1))
+	total := 0
+	for _, entries := range got {
+		total += len(entries)
+	}
+	if total != 100 {
+		t.Errorf("Expected 100 entries written, got %d", total)
+	}
+
+	// 2. preserve_order: a file sticks to one connection, in order
+	clear(got)
+	send(pooled(3))
+	owner := make(map[string]io.Writer)
+	for dst, entries := range got {
+		last := make(map[string]int)
+		for _, e := range entries {
+			if w, ok := owner[e.Source]; ok && w != dst {
+				t.Errorf("Entries of %s written by two connections", e.Source)
+			}
+			owner[e.Source] = dst
+			n, _ := strconv.Atoi(e.Event)
+			if prev, ok := last[e.Source]; ok && n < prev {
+				t.Errorf("Entries of %s out of order: %d after %d", e.Source, n, prev)
+			}
+			last[e.Source] = n
+		}
+	}
+	if len(got) < 2 {
+		t.Errorf("Expected files spread over the connections, got %d used", len(got))
+	}
+}
+
+// slowSink takes a fixed time per batch, as a collector's round trip does.
+type slowSink struct{}
+
+func (slowSink) Write(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return len(p), nil
+}
+
+func (slowSink) Close() error { return nil }
+
+// BenchmarkFanOut_ConnectionPool shows throughput scaling with
+// connection_pool_size when the sink, not katalog, is the bottleneck.
+func BenchmarkFanOut_ConnectionPool(b *testing.B) {
+	policies := map[string]forwarder.BufferPolicy{"": {BatchSize: 100, FlushInterval: time.Second}}
+	for _, size := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("connections=%d", size), func(b *testing.B) {
+			o := &output{name: "bench", serializer: forwarder.RawSerializer{}, buffers: policies}
+			for range size {
+				o.conns = append(o.conns, &outputConn{dst: slowSink{}})
+			}
+			o.queues = []chan models.LogEntry{make(chan models.LogEntry, outputQueueSize)}
+			in := make(chan models.LogEntry, outputQueueSize)
+			done := make(chan struct{})
+			go func() {
+				fanOut(in, []*output{o})
+				close(done)
+			}()
+			entry := models.LogEntry{Source: "app.log", Event: "GET /index.html 200"}
+			b.ResetTimer()
+			for range b.N {
+				in <- entry
+			}
+			close(in)
+			<-done
+		})
+	}
+}
+
+func TestOpenOutput_ConnectionPool(t *testing.T) {
+	out := config.Output{Name: "pooled", Transport: "http", Serializer: "json", URL: "http://collector", ConnectionPoolSize: 3, PreserveOrder: true}
+	o, err := openOutput(&config.Config{FlushInterval: "1s"}, out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.close()
+	if len(o.conns) != 3 || len(o.queues) != 3 {
+		t.Fatalf("Expected 3 connections with a queue each, got %d and %d", len(o.conns), len(o.queues))
+	}
+	for i, conn := range o.conns {
+		if conn.health == nil || conn.stopRetries == nil {
+			t.Errorf("Expected connection %d to track its own health and retries", i)
+		}
+	}
+}
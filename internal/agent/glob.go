@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// globMeta are the characters filepath.Match treats specially (it has no
+// escapes on Windows).
+var globMeta = func() string {
+	if runtime.GOOS == "windows" {
+		return `*?[`
+	}
+	return `*?[\`
+}()
+
+// isLiteral reports whether pattern has no glob meta characters.
+func isLiteral(pattern string) bool {
+	return !strings.ContainsAny(pattern, globMeta)
+}
+
+// doublestar is the path element matching any number of directories.
+const doublestar = "**"
+
+// splitPath splits a cleaned path or pattern into its elements.
+func splitPath(p string) []string {
+	return strings.Split(filepath.Clean(p), string(filepath.Separator))
+}
+
+func hasDoublestar(pattern string) bool {
+	for _, elem := range splitPath(pattern) {
+		if elem == doublestar {
+			return true
+		}
+	}
+	return false
+}
+
+// glob is filepath.Glob, except that a "**" path element matches zero or
+// more directories. Such a pattern walks the tree below its last literal
+// directory on every call, so directories created since are picked up, and
+// only matches files. Unreadable directories are skipped.
+func glob(pattern string) ([]string, error) {
+	if !hasDoublestar(pattern) {
+		return filepath.Glob(pattern)
+	}
+	for _, elem := range splitPath(pattern) {
+		if _, err := filepath.Match(elem, ""); err != nil {
+			return nil, err
+		}
+	}
+	root := filepath.Dir(pattern[:strings.IndexAny(pattern, globMeta)])
+	var matches []string
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if ok, _ := matchPath(pattern, path); ok {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	return matches, nil
+}
+
+// matchPath is filepath.Match with glob's "**" elements.
+func matchPath(pattern, path string) (bool, error) {
+	if !hasDoublestar(pattern) {
+		return filepath.Match(pattern, path)
+	}
+	return matchElems(splitPath(pattern), splitPath(path))
+}
+
+func matchElems(pattern, elems []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == doublestar {
+			for i := 0; i <= len(elems); i++ {
+				if ok, err := matchElems(pattern[1:], elems[i:]); ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(elems) == 0 {
+			return false, nil
+		}
+		if ok, err := filepath.Match(pattern[0], elems[0]); !ok || err != nil {
+			return false, err
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0, nil
+}
+
+// ignored reports whether path matches any of patterns: the whole path for
+// a pattern with a separator, else just its base name.
+func ignored(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		subject := filepath.Base(path)
+		if strings.ContainsRune(pattern, '/') || strings.ContainsRune(pattern, filepath.Separator) {
+			subject = path
+		}
+		if ok, _ := matchPath(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
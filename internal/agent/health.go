@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sinkHealth wraps the transport of a network output to tell how long it
+// has been failing: since the start of the oldest write that hasn't
+// succeeded yet. Transports retry inside Write, so a stuck collector shows
+// up as a write that doesn't return as well as one that fails. A batch the
+// transport gave up on (see dropped) doesn't count as a success, although
+// its write returns no error.
+type sinkHealth struct {
+	io.WriteCloser
+
+	mu           sync.Mutex
+	failingSince time.Time // zero while the last write succeeded
+	drops        bool      // whether a batch was given up on in the current write
+}
+
+func (s *sinkHealth) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	if s.failingSince.IsZero() {
+		s.failingSince = time.Now()
+	}
+	s.drops = false
+	s.mu.Unlock()
+	n, err := s.WriteCloser.Write(p)
+	s.mu.Lock()
+	if err == nil && !s.drops {
+		s.failingSince = time.Time{}
+	}
+	s.mu.Unlock()
+	return n, err
+}
+
+// dropped is the transport's SetOnDrop callback.
+func (s *sinkHealth) dropped(error) {
+	s.mu.Lock()
+	s.drops = true
+	s.mu.Unlock()
+}
+
+// failingFor returns how long the sink has been failing at now, or 0.
+func (s *sinkHealth) failingFor(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failingSince.IsZero() {
+		return 0
+	}
+	return now.Sub(s.failingSince)
+}
+
+// checkpointHealth remembers whether the last checkpoint save failed, and
+// since when.
+type checkpointHealth struct {
+	mu           sync.Mutex
+	err          error
+	failingSince time.Time
+}
+
+func (c *checkpointHealth) record(err error, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+	if err == nil {
+		c.failingSince = time.Time{}
+	} else if c.failingSince.IsZero() {
+		c.failingSince = now
+	}
+}
+
+// failing returns why checkpoints haven't been saved since failingSince, or
+// nil if the last save succeeded.
+func (c *checkpointHealth) failing(now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		return nil
+	}
+	return fmt.Errorf("checkpoints failing to save for %s: %w", now.Sub(c.failingSince).Round(time.Second), c.err)
+}
+
+// Healthy reports whether the main loop is running.
+func (a *Agent) Healthy() bool {
+	return a.running.Load()
+}
+
+// Ready returns nil once the writer is up and the first discovery has
+// completed, as long as no network output has been failing for longer than
+// ready_failure_timeout and the last checkpoint save succeeded.
+func (a *Agent) Ready() error {
+	switch {
+	case !a.writerUp.Load():
+		return errors.New("writer not running")
+	case !a.discovered.Load():
+		return errors.New("first discovery not completed")
+	}
+	now := time.Now()
+	for _, o := range a.outputs {
+		for _, conn := range o.conns {
+			if conn.health == nil {
+				continue
+			}
+			if d := conn.health.failingFor(now); d > a.readyTimeout {
+				return fmt.Errorf("output '%s' failing for %s", o.name, d.Round(time.Second))
+			}
+		}
+	}
+	return a.checkpointh.failing(now)
+}
+
+// HealthHandler serves /healthz: 200 while the main loop runs, else 503.
+func (a *Agent) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Healthy() {
+			http.Error(w, "not running", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// ReadyHandler serves /readyz: 200 when Ready, else 503 with the reason.
+func (a *Agent) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := a.Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
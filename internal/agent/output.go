@@ -0,0 +1,454 @@
+package agent
+
+import (
+	"crypto/tls"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"katalog/internal/config"
+	"katalog/internal/forwarder"
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// outputQueueSize bounds each output's queue of entries waiting for its
+// writer.
+const outputQueueSize = 1000
+
+// defaultMaxDiskBytes caps an output's spool when max_disk_bytes is unset.
+const defaultMaxDiskBytes = 1 << 30
+
+// output is one destination: a transport and serializer fed by its own
+// buffered writer, so a slow output only holds up the others when its
+// queue fills and its policy is to block. With connection_pool_size, it has
+// several connections to the sink, each with a writer of its own.
+type output struct {
+	name       string
+	conns      []*outputConn
+	serializer forwarder.Serializer
+	buffers    map[string]forwarder.BufferPolicy // per-target output buffering
+	drop       bool                              // drop instead of blocking on a full queue
+	// queues feed the writers: one shared by every connection, or one per
+	// connection with preserve_order
+	queues     []chan models.LogEntry
+	deadLetter *forwarder.DeadLetter // nil without dead_letter_path
+}
+
+// outputConn is one connection of an output, which reconnects on its own.
+type outputConn struct {
+	dst    io.WriteCloser
+	health *sinkHealth // nil unless the transport is a network one
+	// stopRetries makes the transport give up a failing batch instead of
+	// waiting to retry it; nil when it doesn't retry
+	stopRetries func()
+}
+
+// openOutput opens the transports of out and resolves its serializer and
+// buffering.
+func openOutput(cfg *config.Config, out config.Output, priorities map[string]int) (*output, error) {
+	buffers, err := bufferPolicies(cfg, out)
+	if err != nil {
+		return nil, err
+	}
+	serializer, err := outputSerializer(cfg, out, priorities)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := outputTLS(out.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output '%s': %w", out.Name, err)
+	}
+	o := &output{name: out.Name, serializer: serializer, buffers: buffers, drop: out.QueueFullPolicy == "drop"}
+	for range max(out.ConnectionPoolSize, 1) {
+		conn, err := o.openConn(out, tlsConfig)
+		if err != nil {
+			o.close()
+			return nil, fmt.Errorf("failed to open output '%s': %w", out.Name, err)
+		}
+		o.conns = append(o.conns, conn)
+	}
+	queues := 1
+	if out.PreserveOrder {
+		queues = len(o.conns)
+	}
+	for range queues {
+		o.queues = append(o.queues, make(chan models.LogEntry, outputQueueSize))
+	}
+	return o, nil
+}
+
+// openConn opens one connection of out: its transport, under health
+// tracking and whatever spools, frames or compresses what it is written.
+func (o *output) openConn(out config.Output, tlsConfig *tls.Config) (*outputConn, error) {
+	dst, err := openTransport(out, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	conn := &outputConn{}
+	if r, ok := dst.(interface{ StopRetries() }); ok {
+		conn.stopRetries = r.StopRetries
+	}
+	if o.deadLetter, err = openDeadLetter(out, dst, o.deadLetter); err != nil {
+		dst.Close()
+		return nil, err
+	}
+	// Under the spool, which accepts writes while the collector is down
+	if out.IsNetwork() {
+		conn.health = &sinkHealth{WriteCloser: dst}
+		if t, ok := dst.(interface{ SetOnDrop(func(error)) }); ok {
+			t.SetOnDrop(conn.health.dropped)
+		}
+		dst = conn.health
+	}
+	if out.SpoolDir != "" {
+		maxBytes := out.MaxDiskBytes
+		if maxBytes == 0 {
+			maxBytes = defaultMaxDiskBytes
+		}
+		spool, err := forwarder.NewSpool(dst, forwarder.SpoolConfig{
+			Name:       out.Name,
+			Dir:        out.SpoolDir,
+			MaxBytes:   maxBytes,
+			DropOldest: out.SpoolFullPolicy == "drop_oldest",
+		})
+		if err != nil {
+			dst.Close()
+			return nil, err
+		}
+		dst = spool
+	}
+	// The http transports compress each request body instead
+	var streamCompress string
+	if out.Transport == "file" {
+		streamCompress = out.Compress
+	}
+	// Compressed output always blocks on a full disk: dropping bytes would
+	// corrupt the gzip stream
+	dst = forwarder.GuardDiskFull(dst, out.DiskFullPolicy == "block" || streamCompress != "")
+	compressed, err := forwarder.Compress(dst, streamCompress)
+	if err != nil {
+		dst.Close()
+		return nil, err
+	}
+	if out.Serializer == "json_array" {
+		compressed = forwarder.NewJSONArrayWriter(compressed)
+	}
+	conn.dst = compressed
+	return conn, nil
+}
+
+// openTransport opens a transport to the destination of out.
+func openTransport(out config.Output, tlsConfig *tls.Config) (dst io.WriteCloser, err error) {
+	switch out.Transport {
+	case "http":
+		contentType := "text/plain; charset=utf-8"
+		switch out.Serializer {
+		case "json":
+			contentType = "application/x-ndjson"
+		case "json_array":
+			contentType = "application/json"
+		}
+		dst, err = forwarder.NewHTTPTransport(forwarder.HTTPOutputConfig{
+			URL:         out.URL,
+			ContentType: contentType,
+			Compression: bodyCompression(out),
+			TLS:         tlsConfig,
+			Auth:        httpAuth(out.Auth),
+		})
+	case "syslog":
+		dst, err = forwarder.NewSyslogTransport(out.SyslogAddr, tlsConfig)
+	case "file":
+		if out.MaxSizeMB > 0 {
+			dst, err = forwarder.NewRotatingFile(out.Path, int64(out.MaxSizeMB)<<20, out.MaxBackups)
+			break
+		}
+		dst, err = forwarder.OpenTransport(out.Transport, out.Path)
+	case "kafka":
+		dst, err = openKafka(out.Kafka, tlsConfig)
+	case "hec":
+		dst, err = forwarder.NewHECTransport(out.URL, out.Token, bodyCompression(out))
+	case "loki":
+		dst, err = forwarder.NewLokiTransport(out.URL, bodyCompression(out))
+	case "otlp":
+		dst, err = openOTLP(out)
+	case "fluentd":
+		dst, err = openFluentd(out.Fluentd)
+	case "datadog":
+		dst, err = forwarder.NewDatadogTransport(forwarder.DatadogOutputConfig{URL: out.URL, Site: out.Datadog.Site, APIKey: out.Datadog.APIKey})
+	case "syslog_udp":
+		dst, err = forwarder.NewUDPSyslogTransport(out.SyslogAddr, out.MaxDatagramSize)
+	default:
+		dst, err = forwarder.OpenTransport(out.Transport, out.Path)
+	}
+	return dst, err
+}
+
+// openDeadLetter hands the dead_letter_path of out, if set, to its transport
+// dst, opening it unless d, which the output's connections share, already
+// is.
+func openDeadLetter(out config.Output, dst io.WriteCloser, d *forwarder.DeadLetter) (*forwarder.DeadLetter, error) {
+	if out.DeadLetterPath == "" {
+		return nil, nil
+	}
+	t, ok := dst.(interface{ SetDeadLetter(*forwarder.DeadLetter) })
+	if !ok {
+		return nil, fmt.Errorf("the %s transport can't dead-letter entries", out.Transport)
+	}
+	if d == nil {
+		maxBytes := int64(out.DeadLetterMaxSizeMB) << 20
+		if maxBytes == 0 {
+			maxBytes = forwarder.DefaultDeadLetterMaxBytes
+		}
+		var err error
+		if d, err = forwarder.NewDeadLetter(out.DeadLetterPath, out.Name, maxBytes); err != nil {
+			return nil, err
+		}
+	}
+	t.SetDeadLetter(d)
+	return d, nil
+}
+
+func closeDeadLetter(d *forwarder.DeadLetter) {
+	if d == nil {
+		return
+	}
+	if err := d.Close(); err != nil {
+		slog.Error("Error closing dead-letter file", "error", err)
+	}
+}
+
+// targetFormatTransports are the transports that carry any serializer, so
+// a target's output_format replaces the output's serializer for its
+// entries. The others keep the format their protocol expects.
+var targetFormatTransports = map[string]bool{"stdout": true, "file": true, "http": true}
+
+// syslogTransports are the transports that take octet-counted syslog
+// messages; on the others rfc5424 and rfc3164 messages end in a newline.
+var syslogTransports = map[string]bool{"syslog": true, "syslog_udp": true}
+
+// outputSerializer resolves the serializer of out, switching to each
+// target's output_format for its entries where the transport allows it.
+func outputSerializer(cfg *config.Config, out config.Output, priorities map[string]int) (forwarder.Serializer, error) {
+	serializer, err := newSerializer(cfg, out, priorities)
+	// json_array batches must hold JSON objects only
+	if err != nil || !targetFormatTransports[out.Transport] || out.Serializer == "json_array" {
+		return serializer, err
+	}
+	byTarget := make(map[string]forwarder.Serializer)
+	for _, target := range cfg.Targets {
+		if target.OutputFormat == "" {
+			continue
+		}
+		o := out
+		o.Serializer = target.OutputFormat
+		if byTarget[target.Name], err = newSerializer(cfg, o, priorities); err != nil {
+			return nil, err
+		}
+	}
+	if len(byTarget) == 0 {
+		return serializer, nil
+	}
+	return forwarder.TargetSerializer{Default: serializer, ByTarget: byTarget}, nil
+}
+
+// newSerializer returns the serializer named by out.Serializer, set up for
+// out.
+func newSerializer(cfg *config.Config, out config.Output, priorities map[string]int) (forwarder.Serializer, error) {
+	switch {
+	case out.Serializer == "rfc5424":
+		return forwarder.RFC5424Serializer{Priorities: priorities, OctetCounting: syslogTransports[out.Transport]}, nil
+	case out.Serializer == "rfc3164":
+		return forwarder.RFC3164Serializer{Priorities: priorities, OctetCounting: syslogTransports[out.Transport]}, nil
+	case out.Serializer == "loki":
+		return forwarder.LokiSerializer{Labels: out.LokiLabels}, nil
+	case out.Serializer == "hec":
+		return forwarder.HECSerializer{Index: out.Index}, nil
+	case out.Serializer == "kafka":
+		var keyField string
+		if out.Kafka != nil {
+			keyField = out.Kafka.KeyField
+		}
+		return forwarder.KafkaSerializer{KeyField: keyField}, nil
+	case out.Serializer == "otlp":
+		return forwarder.OTLPSerializer{}, nil
+	case out.Serializer == "datadog":
+		var service string
+		if out.Datadog != nil {
+			service = out.Datadog.Service
+		}
+		return forwarder.DatadogSerializer{Service: service}, nil
+	case out.Transport == "stdout":
+		return forwarder.StdoutSerializer(out.Serializer, cfg.Color)
+	default:
+		return forwarder.NewSerializer(out.Serializer)
+	}
+}
+
+func openKafka(k *config.Kafka, tlsConfig *tls.Config) (io.WriteCloser, error) {
+	linger, _ := time.ParseDuration(k.Linger)
+	return forwarder.NewKafkaTransport(forwarder.KafkaOutputConfig{
+		Brokers:         k.Brokers,
+		Topic:           k.Topic,
+		Acks:            k.Acks,
+		Linger:          linger,
+		MaxRetries:      k.MaxRetries,
+		DeadLetterTopic: k.DeadLetterTopic,
+		SASLMechanism:   k.SASLMechanism,
+		Username:        k.Username,
+		Password:        k.Password,
+		TLS:             k.TLS,
+		TLSConfig:       tlsConfig,
+	})
+}
+
+// httpAuth converts the auth block of an http output, if any.
+func httpAuth(a *config.Auth) *forwarder.HTTPAuth {
+	if a == nil {
+		return nil
+	}
+	return &forwarder.HTTPAuth{
+		Type:        a.Type,
+		Token:       a.Token,
+		TokenFile:   a.TokenFile,
+		Username:    a.Username,
+		Password:    a.Password,
+		HeaderName:  a.HeaderName,
+		HeaderValue: a.HeaderValue,
+	}
+}
+
+// outputTLS loads the tls block of an output, if any.
+func outputTLS(t *config.TLS) (*tls.Config, error) {
+	if t == nil {
+		return nil, nil
+	}
+	return forwarder.NewTLSConfig(forwarder.TLSOptions{
+		CAFile:             t.CAFile,
+		CertFile:           t.CertFile,
+		KeyFile:            t.KeyFile,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		ServerName:         t.ServerName,
+	})
+}
+
+// bodyCompression is how the http transports of out compress their request
+// bodies.
+func bodyCompression(out config.Output) forwarder.BodyCompression {
+	minBytes := out.CompressMinBytes
+	if minBytes == 0 {
+		minBytes = forwarder.DefaultCompressMinBytes
+	}
+	return forwarder.BodyCompression{Kind: out.Compress, MinBytes: minBytes}
+}
+
+func openOTLP(out config.Output) (io.WriteCloser, error) {
+	cfg := forwarder.OTLPOutputConfig{URL: out.URL, Compression: bodyCompression(out)}
+	if out.OTLP != nil {
+		cfg.Headers = out.OTLP.Headers
+		cfg.CAFile = out.OTLP.CAFile
+		cfg.InsecureSkipVerify = out.OTLP.InsecureSkipVerify
+	}
+	return forwarder.NewOTLPTransport(cfg)
+}
+
+func openFluentd(f *config.Fluentd) (io.WriteCloser, error) {
+	ackTimeout, _ := time.ParseDuration(f.AckTimeout)
+	return forwarder.NewFluentdTransport(forwarder.FluentdOutputConfig{
+		Addr:               f.Address,
+		RequireAck:         f.RequireAck,
+		AckTimeout:         ackTimeout,
+		SharedKey:          f.SharedKey,
+		Username:           f.Username,
+		Password:           f.Password,
+		TLS:                f.TLS,
+		CAFile:             f.CAFile,
+		InsecureSkipVerify: f.InsecureSkipVerify,
+	})
+}
+
+// syslogPriorities resolves each target's facility and severity to a syslog
+// PRI value, keyed by target name.
+func syslogPriorities(cfg *config.Config) (map[string]int, error) {
+	priorities := make(map[string]int)
+	for _, target := range cfg.Targets {
+		pri, err := forwarder.SyslogPriority(target.Facility, target.Severity)
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog priority for target '%s': %w", target.Name, err)
+		}
+		priorities[target.Name] = pri
+	}
+	return priorities, nil
+}
+
+func closeOutputs(outputs []*output) {
+	for _, o := range outputs {
+		o.close()
+	}
+}
+
+func (o *output) close() {
+	for _, conn := range o.conns {
+		if err := conn.dst.Close(); err != nil {
+			slog.Error("Error closing output", "output", o.name, "error", err)
+		}
+	}
+	closeDeadLetter(o.deadLetter)
+}
+
+// queueFor returns the queue entry goes to. With preserve_order that is the
+// one its file hashes to, so the file's entries are all written in order by
+// the same connection.
+func (o *output) queueFor(entry models.LogEntry) chan models.LogEntry {
+	if len(o.queues) == 1 {
+		return o.queues[0]
+	}
+	h := fnv.New32a()
+	io.WriteString(h, entry.Host)
+	h.Write([]byte{0})
+	io.WriteString(h, entry.SourceType)
+	h.Write([]byte{0})
+	io.WriteString(h, entry.Source)
+	return o.queues[h.Sum32()%uint32(len(o.queues))]
+}
+
+// fanOut copies every entry from in to each output's queue and starts the
+// writer of each output's connections. It returns once in is closed and
+// every writer is done.
+func fanOut(in <-chan models.LogEntry, outputs []*output) {
+	var wg sync.WaitGroup
+	for _, o := range outputs {
+		// Without preserve_order the writers share a queue, so entries go
+		// to whichever connection is free
+		for i, conn := range o.conns {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				writeLogsFunc(o.queues[i%len(o.queues)], conn.dst, o.serializer, o.buffers) // Use the mockable function
+			}()
+		}
+	}
+	for entry := range in {
+		for _, o := range outputs {
+			queue := o.queueFor(entry)
+			if !o.drop {
+				queue <- entry
+				continue
+			}
+			select {
+			case queue <- entry:
+			default:
+				metrics.OutputDropped.WithLabelValues(o.name).Inc()
+			}
+		}
+	}
+	for _, o := range outputs {
+		for _, queue := range o.queues {
+			close(queue)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"time"
+
+	"katalog/internal/config"
+	"katalog/internal/forwarder"
+)
+
+// reloadRequest hands a new config to the Run loop, which owns the tracked
+// files, and carries back the result.
+type reloadRequest struct {
+	cfg  *config.Config
+	done chan error
+}
+
+// Reload applies cfg, already loaded and validated, to the running agent.
+// Targets are matched by name: a target whose only changes are its
+// exclude/multiline patterns and fields keeps its tailers running with the
+// new patterns; a new or otherwise changed target has its files picked up
+// (again) by the next discovery, and a removed target's tailers are stopped.
+// Besides targets, poll_interval, max_tracked_files, tag_target,
+// reject_target_overlap and the global fields are applied; other settings
+// need a restart. On error the current config stays in place.
+func (a *Agent) Reload(ctx context.Context, cfg *config.Config) error {
+	r := reloadRequest{cfg: cfg, done: make(chan error, 1)}
+	select {
+	case a.reloads <- r:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-r.done
+}
+
+// applyReload swaps in the reloadable parts of cfg. It runs on the Run loop.
+func (a *Agent) applyReload(cfg *config.Config) error {
+	merged := *a.cfg
+	merged.PollInterval = cfg.PollInterval
+	merged.MaxTrackedFiles = cfg.MaxTrackedFiles
+	merged.TagTarget = cfg.TagTarget
+	merged.RejectTargetOverlap = cfg.RejectTargetOverlap
+	merged.Fields = cfg.Fields
+	merged.Targets = cfg.Targets
+	if !reflect.DeepEqual(merged, *cfg) {
+		slog.Warn("Config changes beyond targets, fields, poll_interval, max_tracked_files, tag_target and reject_target_overlap need a restart")
+	}
+
+	cache, fields, err := compileTargets(&merged)
+	if err != nil {
+		return err
+	}
+	if merged.RejectTargetOverlap {
+		next := &Agent{cfg: &merged}
+		_, owners, overlaps := next.claimPaths()
+		if err := next.overlapError(owners, overlaps); err != nil {
+			return err
+		}
+	}
+
+	current := make(map[string]int, len(a.cfg.Targets))
+	for i := range a.cfg.Targets {
+		current[a.targetCache[i].key] = i
+	}
+	// Unchanged targets keep their live patterns, which their tailers read
+	kept := make(map[string]bool)
+	for i, target := range merged.Targets {
+		ct := cache[i]
+		j, ok := current[ct.key]
+		if !ok || !onlyPatternsChanged(a.cfg.Targets[j], target) {
+			continue
+		}
+		ct.live = a.targetCache[j].live
+		ct.live.Store(forwarder.Patterns{Exclude: ct.exclude, Include: ct.include, Multiline: ct.multiline, Fields: fields[i]})
+		cache[i] = ct
+		kept[ct.key] = true
+	}
+	for path, tf := range a.tracked {
+		if !kept[tf.target] {
+			a.stopTracking(path)
+			slog.Debug("Stopped tracking: target changed or removed", "path", path)
+		}
+	}
+
+	a.cfg, a.targetCache, a.fieldCache = &merged, cache, fields
+	a.updateTrackedFiles()
+	slog.Info("Reloaded config", "targets", len(merged.Targets), "kept", len(kept))
+	return nil
+}
+
+// onlyPatternsChanged reports whether old and updated differ at most in
+// what a reload can apply to running tailers.
+func onlyPatternsChanged(old, updated config.Target) bool {
+	for _, t := range []*config.Target{&old, &updated} {
+		t.ExcludePattern, t.IncludePattern, t.MultilinePattern = "", "", ""
+		t.ExcludePatterns, t.IncludePatterns, t.MultilinePatterns, t.Fields = nil, nil, nil, nil
+	}
+	return reflect.DeepEqual(old, updated)
+}
+
+// targetKeys identifies each target by its name, suffixed with its
+// occurrence number when several targets share a name.
+func targetKeys(cfg *config.Config) []string {
+	seen := make(map[string]int)
+	keys := make([]string, len(cfg.Targets))
+	for i, target := range cfg.Targets {
+		keys[i] = target.Name
+		if n := seen[target.Name]; n > 0 {
+			keys[i] = fmt.Sprintf("%s#%d", target.Name, n)
+		}
+		seen[target.Name]++
+	}
+	return keys
+}
+
+func (a *Agent) pollInterval() time.Duration {
+	d, _ := time.ParseDuration(a.cfg.PollInterval)
+	return d
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watcher turns filesystem events in the directories of tracked files into
+// wake-ups: a write to (or creation of) a tracked file wakes its tailer,
+// and any file created, removed or renamed triggers a discovery.
+type watcher struct {
+	fs     *fsnotify.Watcher
+	rescan chan struct{}
+
+	mu      sync.Mutex
+	dirs    map[string]bool // watched, or false once a watch failed
+	wake    map[string]chan struct{}
+	limited bool // a watch failed; logged once
+}
+
+func newWatcher() (*watcher, error) {
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{
+		fs:     fs,
+		rescan: make(chan struct{}, 1),
+		dirs:   make(map[string]bool),
+		wake:   make(map[string]chan struct{}),
+	}, nil
+}
+
+// track watches path's directory and returns the channel that wakes its
+// tailer. It returns nil when the directory can't be watched (typically
+// out of inotify watches), leaving that tailer to poll.
+func (w *watcher) track(path string) <-chan struct{} {
+	path = filepath.Clean(path)
+	dir := filepath.Dir(path)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	watched, tried := w.dirs[dir]
+	if !tried {
+		err := w.fs.Add(dir)
+		watched = err == nil
+		w.dirs[dir] = watched
+		if err != nil && !w.limited {
+			w.limited = true
+			slog.Warn("Cannot watch directory, polling its files instead", "dir", dir, "error", err)
+		}
+	}
+	if !watched {
+		return nil
+	}
+	ch := make(chan struct{}, 1)
+	w.wake[path] = ch
+	return ch
+}
+
+func (w *watcher) untrack(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.wake, filepath.Clean(path))
+}
+
+// run dispatches events until ctx is done, then closes the watcher.
+func (w *watcher) run(ctx context.Context) {
+	defer w.fs.Close()
+	for {
+		select {
+		case ev, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			if ev.Has(fsnotify.Write) || ev.Has(fsnotify.Create) {
+				w.mu.Lock()
+				ch := w.wake[ev.Name]
+				w.mu.Unlock()
+				notify(ch)
+			}
+			if ev.Has(fsnotify.Create) || ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+				notify(w.rescan)
+			}
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return
+			}
+			// Typically a queue overflow: events were lost, so rescan
+			slog.Error("File watcher error", "error", err)
+			notify(w.rescan)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notify signals ch without blocking; a pending signal already covers it.
+func notify(ch chan struct{}) {
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
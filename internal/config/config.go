@@ -1,37 +1,436 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	PollInterval string   `yaml:"poll_interval"`
-	OutputFormat string   `yaml:"output_format,omitempty"`
-	Targets      []Target `yaml:"targets"`
+	PollInterval        string            `yaml:"poll_interval"`
+	Mode                string            `yaml:"mode,omitempty"`
+	WatchMode           string            `yaml:"watch_mode,omitempty"`
+	EnvExpansion        string            `yaml:"env_expansion,omitempty"`
+	OutputFormat        string            `yaml:"output_format,omitempty"`
+	Output              *Output           `yaml:"output,omitempty"`
+	Outputs             []Output          `yaml:"outputs,omitempty"`
+	ShutdownMode        string            `yaml:"shutdown_mode,omitempty"`
+	ShutdownTimeout     string            `yaml:"shutdown_timeout,omitempty"`
+	ReadyFailureTimeout string            `yaml:"ready_failure_timeout,omitempty"`
+	ChannelBuffer       int               `yaml:"channel_buffer,omitempty"`
+	ChannelFullPolicy   string            `yaml:"channel_full_policy,omitempty"`
+	TagTarget           bool              `yaml:"tag_target,omitempty"`
+	RejectTargetOverlap bool              `yaml:"reject_target_overlap,omitempty"`
+	Color               string            `yaml:"color,omitempty"`
+	TimestampFormat     string            `yaml:"timestamp_format,omitempty"`
+	Fields              map[string]string `yaml:"fields,omitempty"`
+	LocalCopy           *LocalCopy        `yaml:"local_copy,omitempty"`
+	MaxTrackedFiles     int               `yaml:"max_tracked_files,omitempty"`
+	StartupDelay        string            `yaml:"startup_delay,omitempty"`
+	StatsInterval       string            `yaml:"stats_interval,omitempty"`
+	EOFPollInterval     string            `yaml:"eof_poll_interval,omitempty"`
+	FlushInterval       string            `yaml:"flush_interval,omitempty"`
+	DerivedMetrics      []Derived         `yaml:"derived_metrics,omitempty"`
+	CheckpointFile      string            `yaml:"checkpoint_file,omitempty"`
+	CheckpointInterval  string            `yaml:"checkpoint_interval,omitempty"`
+	Targets             []Target          `yaml:"targets"`
 }
 
+// Derived is a counter of the lines matching Pattern, per group, exposed
+// as katalog_derived_<Name>.
+type Derived struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+	Help    string `yaml:"help,omitempty"`
+}
+
+// MaxDerivedMetrics bounds derived_metrics, as each one is matched against
+// every line.
+const MaxDerivedMetrics = 50
+
+var identifier = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Output separates where entries go (Transport) from how each one is
+// rendered (Serializer), so any serializer works with any transport.
+// BatchSize, FlushInterval and MaxBatchBytes are the buffering defaults
+// for targets that don't set their own. With several outputs, each has its
+// own queue; QueueFullPolicy says whether a full queue blocks everything
+// (the default) or drops entries for that output only.
+type Output struct {
+	Name                string   `yaml:"name,omitempty"`
+	Transport           string   `yaml:"transport,omitempty"`
+	Serializer          string   `yaml:"serializer,omitempty"`
+	Path                string   `yaml:"path,omitempty"`
+	MaxSizeMB           int      `yaml:"max_size_mb,omitempty"`
+	MaxBackups          int      `yaml:"max_backups,omitempty"`
+	URL                 string   `yaml:"url,omitempty"`
+	SyslogAddr          string   `yaml:"syslog_addr,omitempty"`
+	MaxDatagramSize     int      `yaml:"max_datagram_size,omitempty"`
+	LokiLabels          []string `yaml:"loki_labels,omitempty"`
+	Token               string   `yaml:"token,omitempty"`
+	Index               string   `yaml:"index,omitempty"`
+	Kafka               *Kafka   `yaml:"kafka,omitempty"`
+	OTLP                *OTLP    `yaml:"otlp,omitempty"`
+	Fluentd             *Fluentd `yaml:"fluentd,omitempty"`
+	Datadog             *Datadog `yaml:"datadog,omitempty"`
+	TLS                 *TLS     `yaml:"tls,omitempty"`
+	Auth                *Auth    `yaml:"auth,omitempty"`
+	DiskFullPolicy      string   `yaml:"disk_full_policy,omitempty"`
+	Compress            string   `yaml:"compress,omitempty"`
+	CompressMinBytes    int      `yaml:"compress_min_bytes,omitempty"`
+	BatchSize           int      `yaml:"batch_size,omitempty"`
+	FlushInterval       string   `yaml:"flush_interval,omitempty"`
+	MaxBatchBytes       int      `yaml:"max_batch_bytes,omitempty"`
+	QueueFullPolicy     string   `yaml:"queue_full_policy,omitempty"`
+	SpoolDir            string   `yaml:"spool_dir,omitempty"`
+	MaxDiskBytes        int64    `yaml:"max_disk_bytes,omitempty"`
+	SpoolFullPolicy     string   `yaml:"spool_full_policy,omitempty"`
+	DeadLetterPath      string   `yaml:"dead_letter_path,omitempty"`
+	DeadLetterMaxSizeMB int      `yaml:"dead_letter_max_size_mb,omitempty"`
+	ConnectionPoolSize  int      `yaml:"connection_pool_size,omitempty"`
+	PreserveOrder       bool     `yaml:"preserve_order,omitempty"`
+}
+
+// Kafka configures the kafka transport.
+type Kafka struct {
+	Brokers         []string `yaml:"brokers"`
+	Topic           string   `yaml:"topic"`
+	KeyField        string   `yaml:"key_field,omitempty"`
+	Acks            string   `yaml:"acks,omitempty"`
+	Linger          string   `yaml:"linger,omitempty"`
+	MaxRetries      int      `yaml:"max_retries,omitempty"`
+	DeadLetterTopic string   `yaml:"dead_letter_topic,omitempty"`
+	SASLMechanism   string   `yaml:"sasl_mechanism,omitempty"`
+	Username        string   `yaml:"username,omitempty"`
+	Password        string   `yaml:"password,omitempty"`
+	TLS             bool     `yaml:"tls,omitempty"`
+}
+
+// OTLP configures the otlp transport, which exports to the output's URL.
+// Headers are sent with every request, e.g. for authentication; CAFile
+// and InsecureSkipVerify adjust how the collector's certificate is checked.
+type OTLP struct {
+	Headers            map[string]string `yaml:"headers,omitempty"`
+	CAFile             string            `yaml:"ca_file,omitempty"`
+	InsecureSkipVerify bool              `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// Fluentd configures the fluentd transport, which forwards to Address
+// (host:port) over the forward protocol. RequireAck waits up to AckTimeout
+// for the server to acknowledge every chunk, for at-least-once delivery.
+// SharedKey, plus Username and Password if the server requires them,
+// authenticate the connection; TLS, CAFile and InsecureSkipVerify encrypt
+// it.
+type Fluentd struct {
+	Address            string `yaml:"address"`
+	RequireAck         bool   `yaml:"require_ack,omitempty"`
+	AckTimeout         string `yaml:"ack_timeout,omitempty"`
+	SharedKey          string `yaml:"shared_key,omitempty"`
+	Username           string `yaml:"username,omitempty"`
+	Password           string `yaml:"password,omitempty"`
+	TLS                bool   `yaml:"tls,omitempty"`
+	CAFile             string `yaml:"ca_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// Datadog configures the datadog transport, which sends to the logs intake
+// of Site (datadoghq.com by default, e.g. datadoghq.eu in the EU region),
+// or of the output's URL instead. Service replaces the sourcetype as every
+// log's service.
+type Datadog struct {
+	APIKey  string `yaml:"api_key"`
+	Site    string `yaml:"site,omitempty"`
+	Service string `yaml:"service,omitempty"`
+}
+
+// TLS configures the TLS connection of an http, syslog or kafka output.
+// CAFile verifies the server against that CA on top of the system roots;
+// CertFile and KeyFile are the client certificate for servers requiring
+// mutual TLS; ServerName overrides the name the server's certificate is
+// checked against.
+type TLS struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+}
+
+// Auth configures how the http transport authenticates: Type "bearer"
+// sends Token, "basic" Username and Password, "header" HeaderValue in the
+// HeaderName header. TokenFile replaces Token or HeaderValue with the
+// content of a file, read again whenever it changes.
+type Auth struct {
+	Type        string `yaml:"type"`
+	Token       string `yaml:"token,omitempty"`
+	TokenFile   string `yaml:"token_file,omitempty"`
+	Username    string `yaml:"username,omitempty"`
+	Password    string `yaml:"password,omitempty"`
+	HeaderName  string `yaml:"header_name,omitempty"`
+	HeaderValue string `yaml:"header_value,omitempty"`
+}
+
+// LocalCopy keeps a rolling copy of every entry on local disk, whatever the
+// primary output. MaxSize is in megabytes.
+type LocalCopy struct {
+	Path    string `yaml:"path"`
+	MaxSize int    `yaml:"max_size,omitempty"`
+	MaxAge  string `yaml:"max_age,omitempty"`
+}
+
+var (
+	validTransports  = []string{"stdout", "file", "http", "syslog", "syslog_udp", "loki", "hec", "kafka", "otlp", "fluentd", "datadog"}
+	validSerializers = []string{"json", "json_array", "raw", "logfmt", "cef", "rfc5424", "rfc3164", "loki", "hec", "kafka", "otlp", "fluentd", "datadog"}
+	// Serializers that only work with the transport of the same name
+	transportSerializers = []string{"loki", "kafka", "otlp", "fluentd", "datadog"}
+	// Transports json_array can frame batches for
+	jsonArrayTransports = []string{"stdout", "file", "http"}
+	// Transports the tls block applies to
+	tlsTransports = []string{"http", "syslog", "kafka"}
+	// Transports with a connection of their own, which connection_pool_size
+	// can open several of
+	poolTransports = []string{"http", "syslog"}
+	// Transports to a remote collector, which spool_dir can buffer for
+	networkTransports = []string{"http", "syslog", "syslog_udp", "loki", "hec", "kafka", "otlp", "fluentd", "datadog"}
+	// Transports posting batches over HTTP, which compress compresses one
+	// request body at a time
+	bodyCompressTransports = []string{"http", "loki", "hec", "otlp"}
+)
+
+// ResolvedOutput returns the configured output block, falling back to the
+// output_format shorthand (a stdout transport with that serializer).
+func (c *Config) ResolvedOutput() Output {
+	if c.Output != nil {
+		return c.Output.resolved()
+	}
+	return Output{Serializer: c.OutputFormat}.resolved()
+}
+
+// ResolvedOutputs returns every output entries are sent to: the outputs
+// list, or else the single output (or output_format shorthand).
+func (c *Config) ResolvedOutputs() []Output {
+	if len(c.Outputs) == 0 {
+		return []Output{c.ResolvedOutput()}
+	}
+	outs := make([]Output, len(c.Outputs))
+	for i, out := range c.Outputs {
+		outs[i] = out.resolved()
+	}
+	return outs
+}
+
+// IsNetwork reports whether the output sends to a remote collector.
+func (o Output) IsNetwork() bool {
+	return slices.Contains(networkTransports, o.Transport)
+}
+
+// resolved fills in the defaults of an output block.
+func (o Output) resolved() Output {
+	if o.Transport == "" {
+		o.Transport = "stdout"
+	}
+	if o.Serializer == "" && o.Transport == "syslog" {
+		o.Serializer = "rfc5424"
+	}
+	if o.Serializer == "" && o.Transport == "syslog_udp" {
+		o.Serializer = "rfc3164"
+	}
+	if o.Serializer == "" && o.Transport == "loki" {
+		o.Serializer = "loki"
+	}
+	if o.Serializer == "" && o.Transport == "hec" {
+		o.Serializer = "hec"
+	}
+	if o.Serializer == "" && o.Transport == "kafka" {
+		o.Serializer = "kafka"
+	}
+	if o.Serializer == "" && o.Transport == "otlp" {
+		o.Serializer = "otlp"
+	}
+	if o.Serializer == "" && o.Transport == "fluentd" {
+		o.Serializer = "fluentd"
+	}
+	if o.Serializer == "" && o.Transport == "datadog" {
+		o.Serializer = "datadog"
+	}
+	if o.Serializer == "" {
+		o.Serializer = "json"
+	}
+	if o.Name == "" {
+		o.Name = o.Transport
+	}
+	return o
+}
+
+// Shutdown modes. ShutdownStop stops each tailer where it is; ShutdownDrain
+// lets it read up to EOF first, bounded by ShutdownTimeout.
+const (
+	ShutdownStop  = "stop"
+	ShutdownDrain = "drain_to_eof"
+)
+
+// Run modes. ModeFollow tails files until stopped; ModeOnce reads every
+// matched file from the start to its EOF and exits.
+const (
+	ModeFollow = "follow"
+	ModeOnce   = "once"
+)
+
+// Environment variable expansion modes. EnvLenient replaces unset variables
+// with an empty string; EnvStrict fails the load instead.
+const (
+	EnvLenient = "lenient"
+	EnvStrict  = "strict"
+)
+
+// Watch modes. WatchPoll rescans globs every poll_interval and has each
+// tailer poll its file; WatchInotify is event-driven, with polling as the
+// fallback for directories that can't be watched.
+const (
+	WatchPoll    = "poll"
+	WatchInotify = "inotify"
+)
+
 type Target struct {
-	Name             string            `yaml:"name"`
-	Paths            []string          `yaml:"paths"`
-	ExcludePattern   string            `yaml:"exclude_pattern,omitempty"`
-	MultilinePattern string            `yaml:"multiline_pattern,omitempty"`
-	Fields           map[string]string `yaml:"fields,omitempty"`
+	Name               string            `yaml:"name"`
+	Paths              []string          `yaml:"paths"`
+	IgnorePaths        []string          `yaml:"ignore_paths,omitempty"`
+	ReadFromBeginning  bool              `yaml:"read_from_beginning,omitempty"`
+	ExcludePattern     string            `yaml:"exclude_pattern,omitempty"`
+	ExcludePatterns    []string          `yaml:"exclude_patterns,omitempty"`
+	IncludePattern     string            `yaml:"include_pattern,omitempty"`
+	IncludePatterns    []string          `yaml:"include_patterns,omitempty"`
+	MultilinePattern   string            `yaml:"multiline_pattern,omitempty"`
+	MultilinePatterns  []string          `yaml:"multiline_patterns,omitempty"`
+	MultilineTimeout   string            `yaml:"multiline_timeout,omitempty"`
+	MaxMultilineBytes  int               `yaml:"max_multiline_bytes,omitempty"`
+	KeepPartialEntry   bool              `yaml:"multiline_keep_partial,omitempty"`
+	BatchSize          int               `yaml:"batch_size,omitempty"`
+	FlushInterval      string            `yaml:"flush_interval,omitempty"`
+	MaxBatchBytes      int               `yaml:"max_batch_bytes,omitempty"`
+	MaxLineBytes       int               `yaml:"max_line_bytes,omitempty"`
+	TruncationMarker   string            `yaml:"truncation_marker,omitempty"`
+	LineDelimiter      string            `yaml:"line_delimiter,omitempty"`
+	MaxLinesPerCycle   int               `yaml:"max_lines_per_cycle,omitempty"`
+	SettleTime         string            `yaml:"settle_time,omitempty"`
+	PartialLineTimeout string            `yaml:"partial_line_timeout,omitempty"`
+	Fields             map[string]string `yaml:"fields,omitempty"`
+	SourcePattern      string            `yaml:"source_pattern,omitempty"`
+	Parse              string            `yaml:"parse,omitempty"`
+	OutputFormat       string            `yaml:"output_format,omitempty"`
+	MessageKey         string            `yaml:"message_key,omitempty"`
+	JSONNested         string            `yaml:"json_nested,omitempty"`
+	CSVHeaders         []string          `yaml:"csv_headers,omitempty"`
+	CSVHeaderFromFile  bool              `yaml:"csv_header_from_first_line,omitempty"`
+	CSVDelimiter       string            `yaml:"csv_delimiter,omitempty"`
+	FieldPattern       string            `yaml:"field_pattern,omitempty"`
+	FieldPatternReq    bool              `yaml:"field_pattern_required,omitempty"`
+	GrokPattern        string            `yaml:"grok_pattern,omitempty"`
+	TraceIDPattern     string            `yaml:"trace_id_pattern,omitempty"`
+	TimestampPattern   string            `yaml:"timestamp_pattern,omitempty"`
+	TimestampLayout    string            `yaml:"timestamp_layout,omitempty"`
+	ConditionalFields  []FieldRule       `yaml:"conditional_fields,omitempty"`
+	ConditionalMatch   string            `yaml:"conditional_fields_match,omitempty"`
+	RedactPatterns     []RedactRule      `yaml:"redact_patterns,omitempty"`
+	CollapseWhitespace bool              `yaml:"collapse_whitespace,omitempty"`
+	XattrFields        map[string]string `yaml:"xattr_fields,omitempty"`
+	RenameFields       map[string]string `yaml:"rename_fields,omitempty"`
+	KeepFields         []string          `yaml:"keep_fields,omitempty"`
+	DropFields         []string          `yaml:"drop_fields,omitempty"`
+	ReorderWindow      string            `yaml:"reorder_window,omitempty"`
+	SampleRate         float64           `yaml:"sample_rate,omitempty"`
+	RateLimit          float64           `yaml:"rate_limit,omitempty"`
+	RateLimitBurst     int               `yaml:"rate_limit_burst,omitempty"`
+	OverLimitPolicy    string            `yaml:"over_limit_policy,omitempty"`
+	OverLimitSampleN   int               `yaml:"over_limit_sample,omitempty"`
+	DedupWindowSize    int               `yaml:"dedup_window_size,omitempty"`
+	FollowSymlink      bool              `yaml:"follow_symlink,omitempty"`
+	Facility           string            `yaml:"facility,omitempty"`
+	Severity           string            `yaml:"severity,omitempty"`
+	Journald           *Journald         `yaml:"journald,omitempty"`
+}
+
+// Journald makes a target read the systemd journal, on Linux, instead of
+// files: the entries of Units (all of them when empty), each a log entry
+// with the journal fields named in Fields.
+type Journald struct {
+	Units  []string `yaml:"units,omitempty"`
+	Fields []string `yaml:"fields,omitempty"`
 }
 
+// FieldRule adds Fields to lines matching Pattern.
+type FieldRule struct {
+	Pattern string            `yaml:"pattern"`
+	Fields  map[string]string `yaml:"fields"`
+}
+
+// RedactRule replaces every match of Pattern in events with Replacement
+// (default "***").
+type RedactRule struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement,omitempty"`
+}
+
+// Load reads the config file at path, expanding environment variables
+// first (see ExpandEnv).
 func Load(path string) (Config, error) {
 	yamlFile, err := os.ReadFile(path)
 	var cfg Config
 	if err != nil {
 		return cfg, err
 	}
-	err = yaml.Unmarshal(yamlFile, &cfg)
+	// The mode has to be known before expanding, so it is read on its own
+	var mode struct {
+		EnvExpansion string `yaml:"env_expansion"`
+	}
+	if err := yaml.Unmarshal(yamlFile, &mode); err != nil {
+		return cfg, err
+	}
+	expanded, err := ExpandEnv(yamlFile, mode.EnvExpansion == EnvStrict)
+	if err != nil {
+		return cfg, err
+	}
+	err = yaml.Unmarshal(expanded, &cfg)
 	return cfg, err
 }
 
+// ExpandEnv substitutes ${VAR} and $VAR in data with the values of
+// environment variables. $$ stands for a literal $, and a $ not followed by
+// a variable name (like a regex's end anchor) is kept as is. Unset
+// variables expand to an empty string, or are reported as an error when
+// strict is set.
+func ExpandEnv(data []byte, strict bool) ([]byte, error) {
+	var missing []string
+	expanded := os.Expand(string(data), func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		if !identifier.MatchString(name) {
+			// A shell special parameter such as $1 or $?: not ours to expand
+			return "$" + name
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if strict && len(missing) > 0 {
+		return nil, fmt.Errorf("undefined environment variables in config: %s", strings.Join(missing, ", "))
+	}
+	return []byte(expanded), nil
+}
+
 func (c *Config) Validate() (time.Duration, error) {
 	if c.PollInterval == "" {
 		return 0, fmt.Errorf("poll_interval must be set")
@@ -39,9 +438,157 @@ func (c *Config) Validate() (time.Duration, error) {
 	if c.OutputFormat == "" {
 		c.OutputFormat = "json"
 	}
-	if c.OutputFormat != "json" && c.OutputFormat != "raw" {
+	// The shorthand is a stdout output, which the serializers of a
+	// transport of their own don't work with
+	if !slices.Contains(validSerializers, c.OutputFormat) || slices.Contains(transportSerializers, c.OutputFormat) {
 		return 0, fmt.Errorf("invalid output_format: %s", c.OutputFormat)
 	}
+	if c.Output != nil && len(c.Outputs) > 0 {
+		return 0, fmt.Errorf("set either output or outputs, not both")
+	}
+	if c.Output != nil || len(c.Outputs) > 0 {
+		names := make(map[string]bool)
+		spools := make(map[string]bool)
+		deadLetters := make(map[string]bool)
+		for _, out := range c.ResolvedOutputs() {
+			if err := validateOutput(out); err != nil {
+				return 0, err
+			}
+			if names[out.Name] {
+				return 0, fmt.Errorf("duplicate output name '%s': set a unique name on each output", out.Name)
+			}
+			names[out.Name] = true
+			if out.SpoolDir != "" {
+				dir := filepath.Clean(out.SpoolDir)
+				if spools[dir] {
+					return 0, fmt.Errorf("duplicate output spool_dir '%s': each output needs its own", out.SpoolDir)
+				}
+				spools[dir] = true
+			}
+			if out.DeadLetterPath != "" {
+				path := filepath.Clean(out.DeadLetterPath)
+				if deadLetters[path] {
+					return 0, fmt.Errorf("duplicate output dead_letter_path '%s': each output needs its own", out.DeadLetterPath)
+				}
+				deadLetters[path] = true
+			}
+		}
+	}
+	if c.EnvExpansion != "" && c.EnvExpansion != EnvLenient && c.EnvExpansion != EnvStrict {
+		return 0, fmt.Errorf("invalid env_expansion: %s", c.EnvExpansion)
+	}
+	if c.WatchMode == "" {
+		c.WatchMode = WatchPoll
+	}
+	if c.WatchMode != WatchPoll && c.WatchMode != WatchInotify {
+		return 0, fmt.Errorf("invalid watch_mode: %s", c.WatchMode)
+	}
+	if c.Color == "" {
+		c.Color = "auto"
+	}
+	if c.Color != "auto" && c.Color != "always" && c.Color != "never" {
+		return 0, fmt.Errorf("invalid color: %s", c.Color)
+	}
+	if c.TimestampFormat == "" {
+		c.TimestampFormat = "unix"
+	}
+	if c.TimestampFormat != "unix" && c.TimestampFormat != "unix_ms" && c.TimestampFormat != "rfc3339" {
+		return 0, fmt.Errorf("invalid timestamp_format: %s", c.TimestampFormat)
+	}
+	// HEC only takes epoch seconds
+	for _, out := range c.ResolvedOutputs() {
+		if out.Transport == "hec" && out.Serializer == "json" && c.TimestampFormat != "unix" {
+			return 0, fmt.Errorf("timestamp_format %s cannot be used with the hec transport and json serializer", c.TimestampFormat)
+		}
+	}
+	if c.StartupDelay != "" {
+		if _, err := time.ParseDuration(c.StartupDelay); err != nil {
+			return 0, fmt.Errorf("invalid startup_delay: %w", err)
+		}
+	}
+	if c.StatsInterval != "" {
+		if _, err := time.ParseDuration(c.StatsInterval); err != nil {
+			return 0, fmt.Errorf("invalid stats_interval: %w", err)
+		}
+	}
+	// Both trade latency for wakeups; empty keeps the 200ms and 500ms defaults
+	if c.EOFPollInterval != "" {
+		if d, err := time.ParseDuration(c.EOFPollInterval); err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid eof_poll_interval: %s", c.EOFPollInterval)
+		}
+	}
+	if c.FlushInterval != "" {
+		if d, err := time.ParseDuration(c.FlushInterval); err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid flush_interval: %s", c.FlushInterval)
+		}
+	}
+	if c.CheckpointFile != "" {
+		if c.CheckpointInterval == "" {
+			c.CheckpointInterval = "5s"
+		}
+		if d, err := time.ParseDuration(c.CheckpointInterval); err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid checkpoint_interval: %s", c.CheckpointInterval)
+		}
+	}
+	if len(c.DerivedMetrics) > MaxDerivedMetrics {
+		return 0, fmt.Errorf("too many derived_metrics: %d (max %d)", len(c.DerivedMetrics), MaxDerivedMetrics)
+	}
+	seen := make(map[string]bool, len(c.DerivedMetrics))
+	for _, d := range c.DerivedMetrics {
+		if !identifier.MatchString(d.Name) || seen[d.Name] {
+			return 0, fmt.Errorf("invalid derived_metrics name '%s': must be unique and match %s", d.Name, identifier)
+		}
+		seen[d.Name] = true
+		if _, err := regexp.Compile(d.Pattern); err != nil {
+			return 0, fmt.Errorf("invalid derived_metrics pattern for '%s': %w", d.Name, err)
+		}
+	}
+	if c.MaxTrackedFiles < 0 {
+		return 0, fmt.Errorf("invalid max_tracked_files: must not be negative")
+	}
+	if c.LocalCopy != nil {
+		if c.LocalCopy.Path == "" {
+			return 0, fmt.Errorf("local_copy path must be set")
+		}
+		if c.LocalCopy.MaxSize < 0 {
+			return 0, fmt.Errorf("invalid local_copy max_size: must not be negative")
+		}
+		if c.LocalCopy.MaxAge != "" {
+			if _, err := time.ParseDuration(c.LocalCopy.MaxAge); err != nil {
+				return 0, fmt.Errorf("invalid local_copy max_age: %w", err)
+			}
+		}
+	}
+	if c.Mode == "" {
+		c.Mode = ModeFollow
+	}
+	if c.Mode != ModeFollow && c.Mode != ModeOnce {
+		return 0, fmt.Errorf("invalid mode: %s", c.Mode)
+	}
+	if c.ShutdownMode == "" {
+		c.ShutdownMode = ShutdownStop
+	}
+	if c.ShutdownMode != ShutdownStop && c.ShutdownMode != ShutdownDrain {
+		return 0, fmt.Errorf("invalid shutdown_mode: %s", c.ShutdownMode)
+	}
+	if c.ShutdownTimeout == "" {
+		c.ShutdownTimeout = "10s"
+	}
+	if _, err := time.ParseDuration(c.ShutdownTimeout); err != nil {
+		return 0, fmt.Errorf("invalid shutdown_timeout: %w", err)
+	}
+	if c.ChannelBuffer < 0 {
+		return 0, fmt.Errorf("invalid channel_buffer: %d", c.ChannelBuffer)
+	}
+	if c.ChannelFullPolicy != "" && c.ChannelFullPolicy != "block" && c.ChannelFullPolicy != "drop_newest" && c.ChannelFullPolicy != "drop_oldest" {
+		return 0, fmt.Errorf("invalid channel_full_policy: %s", c.ChannelFullPolicy)
+	}
+	if c.ReadyFailureTimeout == "" {
+		c.ReadyFailureTimeout = "5m"
+	}
+	if d, err := time.ParseDuration(c.ReadyFailureTimeout); err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid ready_failure_timeout: %s", c.ReadyFailureTimeout)
+	}
 	pollDur, err := time.ParseDuration(c.PollInterval)
 	if err != nil {
 		return 0, fmt.Errorf("invalid poll_interval: %w", err)
@@ -49,5 +596,405 @@ func (c *Config) Validate() (time.Duration, error) {
 	if len(c.Targets) == 0 {
 		return 0, fmt.Errorf("no targets configured")
 	}
+	for _, t := range c.Targets {
+		if t.RateLimit < 0 {
+			return 0, fmt.Errorf("invalid rate_limit for target '%s': must not be negative", t.Name)
+		}
+		if t.SampleRate < 0 || t.SampleRate > 1 {
+			return 0, fmt.Errorf("invalid sample_rate for target '%s': must be between 0 and 1", t.Name)
+		}
+		if t.ConditionalMatch != "" && t.ConditionalMatch != "first" && t.ConditionalMatch != "all" {
+			return 0, fmt.Errorf("invalid conditional_fields_match for target '%s': %s", t.Name, t.ConditionalMatch)
+		}
+		if t.Parse != "" && t.Parse != "json" && t.Parse != "logfmt" && t.Parse != "csv" && t.Parse != "auto" {
+			return 0, fmt.Errorf("invalid parse for target '%s': %s", t.Name, t.Parse)
+		}
+		if t.JSONNested != "" && t.Parse != "json" && t.Parse != "auto" {
+			return 0, fmt.Errorf("json_nested for target '%s' requires parse: json or auto", t.Name)
+		}
+		if t.JSONNested != "" && t.JSONNested != "flatten" && t.JSONNested != "encode" {
+			return 0, fmt.Errorf("invalid json_nested for target '%s': %s", t.Name, t.JSONNested)
+		}
+		if err := validateCSV(t); err != nil {
+			return 0, err
+		}
+		// json_array frames whole batches, which mix targets
+		if t.OutputFormat != "" && (!slices.Contains(validSerializers, t.OutputFormat) || slices.Contains(transportSerializers, t.OutputFormat) || t.OutputFormat == "json_array") {
+			return 0, fmt.Errorf("invalid output_format for target '%s': %s", t.Name, t.OutputFormat)
+		}
+		if (t.TimestampPattern == "") != (t.TimestampLayout == "") {
+			return 0, fmt.Errorf("timestamp_pattern and timestamp_layout for target '%s' must be set together", t.Name)
+		}
+		if t.MaxLinesPerCycle < 0 {
+			return 0, fmt.Errorf("invalid max_lines_per_cycle for target '%s': must not be negative", t.Name)
+		}
+		if t.MaxLineBytes < 0 {
+			return 0, fmt.Errorf("invalid max_line_bytes for target '%s': must not be negative", t.Name)
+		}
+		if t.TruncationMarker != "" && t.MaxLineBytes == 0 {
+			return 0, fmt.Errorf("truncation_marker for target '%s' requires max_line_bytes", t.Name)
+		}
+		if len(t.LineDelimiter) > 1 {
+			return 0, fmt.Errorf("invalid line_delimiter for target '%s': must be a single byte", t.Name)
+		}
+		if t.BatchSize < 0 {
+			return 0, fmt.Errorf("invalid batch_size for target '%s': must not be negative", t.Name)
+		}
+		if t.MaxBatchBytes < 0 {
+			return 0, fmt.Errorf("invalid max_batch_bytes for target '%s': must not be negative", t.Name)
+		}
+		if t.DedupWindowSize < 0 {
+			return 0, fmt.Errorf("invalid dedup_window_size for target '%s': must not be negative", t.Name)
+		}
+		if t.OverLimitPolicy != "" && t.OverLimitPolicy != "drop" && t.OverLimitPolicy != "sample" && t.OverLimitPolicy != "block" {
+			return 0, fmt.Errorf("invalid over_limit_policy for target '%s': %s", t.Name, t.OverLimitPolicy)
+		}
+		for _, p := range t.IgnorePaths {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return 0, fmt.Errorf("invalid ignore_paths pattern for target '%s': %s", t.Name, p)
+			}
+		}
+		if err := validateJournald(t); err != nil {
+			return 0, err
+		}
+	}
 	return pollDur, nil
 }
+
+// journalField matches the names journald allows for fields.
+var journalField = regexp.MustCompile(`^[A-Z0-9_]+$`)
+
+// validateJournald checks the journald block of a target, which replaces
+// its paths and, as every journal entry is a log entry, multiline assembly.
+func validateJournald(t Target) error {
+	j := t.Journald
+	if j == nil {
+		return nil
+	}
+	if len(t.Paths) > 0 {
+		return fmt.Errorf("target '%s' can't read both paths and journald", t.Name)
+	}
+	if t.MultilinePattern != "" || len(t.MultilinePatterns) > 0 {
+		return fmt.Errorf("multiline_pattern for target '%s' doesn't apply to journald", t.Name)
+	}
+	for _, unit := range j.Units {
+		if unit == "" {
+			return fmt.Errorf("invalid journald unit for target '%s': must not be empty", t.Name)
+		}
+	}
+	for _, f := range j.Fields {
+		if !journalField.MatchString(f) {
+			return fmt.Errorf("invalid journald field for target '%s': %s", t.Name, f)
+		}
+	}
+	return nil
+}
+
+// validateKafka checks the kafka block of a kafka output.
+func validateKafka(k *Kafka) error {
+	if k == nil || len(k.Brokers) == 0 || k.Topic == "" {
+		return fmt.Errorf("output kafka brokers and topic must be set for the kafka transport")
+	}
+	if k.Acks != "" && k.Acks != "none" && k.Acks != "one" && k.Acks != "all" {
+		return fmt.Errorf("invalid output kafka acks: %s", k.Acks)
+	}
+	if k.Linger != "" {
+		if _, err := time.ParseDuration(k.Linger); err != nil {
+			return fmt.Errorf("invalid output kafka linger: %w", err)
+		}
+	}
+	if k.MaxRetries < 0 {
+		return fmt.Errorf("invalid output kafka max_retries: must not be negative")
+	}
+	switch k.SASLMechanism {
+	case "":
+	case "plain", "scram-sha-256", "scram-sha-512":
+		if k.Username == "" {
+			return fmt.Errorf("output kafka username must be set for sasl_mechanism %s", k.SASLMechanism)
+		}
+	default:
+		return fmt.Errorf("invalid output kafka sasl_mechanism: %s", k.SASLMechanism)
+	}
+	return nil
+}
+
+func validateCSV(t Target) error {
+	if t.Parse != "csv" {
+		if len(t.CSVHeaders) > 0 || t.CSVHeaderFromFile || t.CSVDelimiter != "" {
+			return fmt.Errorf("csv settings for target '%s' require parse: csv", t.Name)
+		}
+		return nil
+	}
+	if (len(t.CSVHeaders) > 0) == t.CSVHeaderFromFile {
+		return fmt.Errorf("target '%s' with parse: csv needs one of csv_headers or csv_header_from_first_line", t.Name)
+	}
+	if t.CSVDelimiter != "" {
+		if d := []rune(t.CSVDelimiter); len(d) != 1 || d[0] == '"' || d[0] == '\r' || d[0] == '\n' || d[0] == utf8.RuneError {
+			return fmt.Errorf("invalid csv_delimiter for target '%s': must be a single character other than a quote or newline", t.Name)
+		}
+	}
+	return nil
+}
+
+func validateFluentd(f *Fluentd) error {
+	if f == nil || f.Address == "" {
+		return fmt.Errorf("output fluentd address must be set for the fluentd transport")
+	}
+	if _, _, err := net.SplitHostPort(f.Address); err != nil {
+		return fmt.Errorf("invalid output fluentd address: %w", err)
+	}
+	if f.AckTimeout != "" {
+		if d, err := time.ParseDuration(f.AckTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid output fluentd ack_timeout: %s", f.AckTimeout)
+		}
+	}
+	if f.Username != "" && f.SharedKey == "" {
+		return fmt.Errorf("output fluentd username requires a shared_key")
+	}
+	return nil
+}
+
+func validateDatadog(out Output) error {
+	d := out.Datadog
+	if d == nil || d.APIKey == "" {
+		return fmt.Errorf("output datadog api_key must be set for the datadog transport")
+	}
+	// The url replaces the site's intake
+	if d.Site != "" && out.URL != "" {
+		return fmt.Errorf("output datadog site and url cannot both be set")
+	}
+	return nil
+}
+
+// validateAuth checks the auth block of an http output. Secrets are never
+// part of the errors.
+func validateAuth(a *Auth) error {
+	switch a.Type {
+	case "bearer":
+		if (a.Token == "") == (a.TokenFile == "") {
+			return fmt.Errorf("output auth type bearer needs one of token or token_file")
+		}
+	case "basic":
+		if a.Username == "" {
+			return fmt.Errorf("output auth type basic needs a username")
+		}
+		if a.TokenFile != "" {
+			return fmt.Errorf("output auth token_file requires type bearer or header")
+		}
+	case "header":
+		if a.HeaderName == "" {
+			return fmt.Errorf("output auth type header needs a header_name")
+		}
+		if (a.HeaderValue == "") == (a.TokenFile == "") {
+			return fmt.Errorf("output auth type header needs one of header_value or token_file")
+		}
+	default:
+		return fmt.Errorf("invalid output auth type: %s", a.Type)
+	}
+	return nil
+}
+
+// validateTLS checks the tls block of an output, loading its certificates
+// so that a bad file fails at startup rather than on the first connection.
+func validateTLS(t *TLS) error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("output tls cert_file and key_file must be set together")
+	}
+	if t.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+			return fmt.Errorf("invalid output tls cert_file or key_file: %w", err)
+		}
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return fmt.Errorf("invalid output tls ca_file: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return fmt.Errorf("invalid output tls ca_file: no certificates found in %s", t.CAFile)
+		}
+	}
+	return nil
+}
+
+// validateOutput checks one resolved output block.
+func validateOutput(out Output) error {
+	if !slices.Contains(validTransports, out.Transport) {
+		return fmt.Errorf("invalid output transport: %s", out.Transport)
+	}
+	if !slices.Contains(validSerializers, out.Serializer) {
+		return fmt.Errorf("invalid output serializer: %s", out.Serializer)
+	}
+	if out.Transport == "file" && out.Path == "" {
+		return fmt.Errorf("output path must be set for the file transport")
+	}
+	if out.MaxSizeMB < 0 || out.MaxBackups < 0 {
+		return fmt.Errorf("invalid output rotation: max_size_mb and max_backups must not be negative")
+	}
+	if (out.MaxSizeMB > 0 || out.MaxBackups > 0) && out.Transport != "file" {
+		return fmt.Errorf("output max_size_mb and max_backups require the file transport")
+	}
+	if out.Transport == "http" && out.URL == "" {
+		return fmt.Errorf("output url must be set for the http transport")
+	}
+	if (out.Transport == "syslog" || out.Transport == "syslog_udp") && out.SyslogAddr == "" {
+		return fmt.Errorf("output syslog_addr must be set for the %s transport", out.Transport)
+	}
+	// Datagrams are cut from the syslog serializers' framing
+	if out.Transport == "syslog_udp" && out.Serializer != "rfc3164" && out.Serializer != "rfc5424" {
+		return fmt.Errorf("output syslog_udp transport requires the rfc3164 or rfc5424 serializer")
+	}
+	// The loki transport regroups what its serializer writes, and nothing
+	// else reads that format
+	if (out.Transport == "loki") != (out.Serializer == "loki") {
+		return fmt.Errorf("output loki transport and serializer must be used together")
+	}
+	if out.Transport == "loki" && out.URL == "" {
+		return fmt.Errorf("output url must be set for the loki transport")
+	}
+	if out.Transport == "hec" {
+		if out.URL == "" || out.Token == "" {
+			return fmt.Errorf("output url and token must be set for the hec transport")
+		}
+		// The json serializer already writes HEC's event shape
+		if out.Serializer != "hec" && out.Serializer != "json" {
+			return fmt.Errorf("output hec transport requires the hec or json serializer")
+		}
+	}
+	if (out.Transport == "kafka") != (out.Serializer == "kafka") {
+		return fmt.Errorf("output kafka transport and serializer must be used together")
+	}
+	if out.Transport == "kafka" {
+		if err := validateKafka(out.Kafka); err != nil {
+			return err
+		}
+	}
+	// Like loki, the otlp transport regroups its serializer's lines
+	if (out.Transport == "otlp") != (out.Serializer == "otlp") {
+		return fmt.Errorf("output otlp transport and serializer must be used together")
+	}
+	if out.Transport == "otlp" && out.URL == "" {
+		return fmt.Errorf("output url must be set for the otlp transport")
+	}
+	if out.OTLP != nil && out.Transport != "otlp" {
+		return fmt.Errorf("output otlp settings require the otlp transport")
+	}
+	if out.Serializer == "json_array" && !slices.Contains(jsonArrayTransports, out.Transport) {
+		return fmt.Errorf("output json_array serializer requires the stdout, file or http transport")
+	}
+	if (out.Transport == "fluentd") != (out.Serializer == "fluentd") {
+		return fmt.Errorf("output fluentd transport and serializer must be used together")
+	}
+	if out.Transport == "fluentd" {
+		if err := validateFluentd(out.Fluentd); err != nil {
+			return err
+		}
+	} else if out.Fluentd != nil {
+		return fmt.Errorf("output fluentd settings require the fluentd transport")
+	}
+	if (out.Transport == "datadog") != (out.Serializer == "datadog") {
+		return fmt.Errorf("output datadog transport and serializer must be used together")
+	}
+	if out.Transport == "datadog" {
+		if err := validateDatadog(out); err != nil {
+			return err
+		}
+	} else if out.Datadog != nil {
+		return fmt.Errorf("output datadog settings require the datadog transport")
+	}
+	if out.Auth != nil {
+		if out.Transport != "http" {
+			return fmt.Errorf("output auth settings require the http transport")
+		}
+		if err := validateAuth(out.Auth); err != nil {
+			return err
+		}
+	}
+	if out.TLS != nil {
+		if !slices.Contains(tlsTransports, out.Transport) {
+			return fmt.Errorf("output tls settings require one of %s", strings.Join(tlsTransports, ", "))
+		}
+		if err := validateTLS(out.TLS); err != nil {
+			return err
+		}
+	}
+	if out.MaxDatagramSize < 0 {
+		return fmt.Errorf("invalid output max_datagram_size: must not be negative")
+	}
+	if out.BatchSize < 0 || out.MaxBatchBytes < 0 {
+		return fmt.Errorf("invalid output batching: batch_size and max_batch_bytes must not be negative")
+	}
+	if out.FlushInterval != "" {
+		if _, err := time.ParseDuration(out.FlushInterval); err != nil {
+			return fmt.Errorf("invalid output flush_interval: %w", err)
+		}
+	}
+	if out.DiskFullPolicy != "" && out.DiskFullPolicy != "drop" && out.DiskFullPolicy != "block" {
+		return fmt.Errorf("invalid output disk_full_policy: %s", out.DiskFullPolicy)
+	}
+	if out.Compress != "" {
+		if out.Compress != "gzip" {
+			return fmt.Errorf("invalid output compress: %s", out.Compress)
+		}
+		if out.Transport != "file" && !slices.Contains(bodyCompressTransports, out.Transport) {
+			return fmt.Errorf("output compress requires the file transport or one of %s", strings.Join(bodyCompressTransports, ", "))
+		}
+		// Rotation would cut the gzip stream between files
+		if out.Transport == "file" && out.MaxSizeMB > 0 {
+			return fmt.Errorf("output compress cannot be combined with max_size_mb")
+		}
+		// Dropping part of a compressed stream would corrupt it
+		if out.Transport == "file" && out.DiskFullPolicy == "drop" {
+			return fmt.Errorf("output compress requires disk_full_policy block")
+		}
+	}
+	if out.CompressMinBytes < 0 {
+		return fmt.Errorf("invalid output compress_min_bytes: must not be negative")
+	}
+	if out.CompressMinBytes > 0 && (out.Compress == "" || !slices.Contains(bodyCompressTransports, out.Transport)) {
+		return fmt.Errorf("output compress_min_bytes requires compress on one of %s", strings.Join(bodyCompressTransports, ", "))
+	}
+	if out.QueueFullPolicy != "" && out.QueueFullPolicy != "block" && out.QueueFullPolicy != "drop" {
+		return fmt.Errorf("invalid output queue_full_policy: %s", out.QueueFullPolicy)
+	}
+	if out.SpoolDir != "" && !out.IsNetwork() {
+		return fmt.Errorf("output spool_dir requires a network transport")
+	}
+	if out.MaxDiskBytes < 0 {
+		return fmt.Errorf("invalid output max_disk_bytes: must not be negative")
+	}
+	if out.SpoolFullPolicy != "" && out.SpoolFullPolicy != "block" && out.SpoolFullPolicy != "drop_oldest" {
+		return fmt.Errorf("invalid output spool_full_policy: %s", out.SpoolFullPolicy)
+	}
+	if (out.MaxDiskBytes > 0 || out.SpoolFullPolicy != "") && out.SpoolDir == "" {
+		return fmt.Errorf("output max_disk_bytes and spool_full_policy require spool_dir")
+	}
+	// syslog_udp never gives up on a batch: it doesn't retry any
+	if out.DeadLetterPath != "" && (!out.IsNetwork() || out.Transport == "syslog_udp") {
+		return fmt.Errorf("output dead_letter_path requires a network transport other than syslog_udp")
+	}
+	// A json_array batch is one array, not entries that can be set aside
+	if out.DeadLetterPath != "" && out.Serializer == "json_array" {
+		return fmt.Errorf("output dead_letter_path does not support the json_array serializer")
+	}
+	if out.ConnectionPoolSize < 0 {
+		return fmt.Errorf("invalid output connection_pool_size: must not be negative")
+	}
+	if out.ConnectionPoolSize > 1 && !slices.Contains(poolTransports, out.Transport) {
+		return fmt.Errorf("output connection_pool_size requires the http or syslog transport")
+	}
+	// The spool delivers one batch at a time
+	if out.ConnectionPoolSize > 1 && out.SpoolDir != "" {
+		return fmt.Errorf("output connection_pool_size can't be combined with spool_dir")
+	}
+	if out.PreserveOrder && out.ConnectionPoolSize <= 1 {
+		return fmt.Errorf("output preserve_order requires a connection_pool_size above 1")
+	}
+	if out.DeadLetterMaxSizeMB < 0 {
+		return fmt.Errorf("invalid output dead_letter_max_size_mb: must not be negative")
+	}
+	if out.DeadLetterMaxSizeMB > 0 && out.DeadLetterPath == "" {
+		return fmt.Errorf("output dead_letter_max_size_mb requires dead_letter_path")
+	}
+	return nil
+}
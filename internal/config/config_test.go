@@ -75,6 +75,1169 @@ targets:
 			expectError:   true,
 			errorContains: "invalid output_format",
 		},
+		{
+			name: "Kafka Output Format Without Kafka Transport",
+			content: `
+poll_interval: "1s"
+output_format: "kafka"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid output_format: kafka",
+		},
+		{
+			name: "Datadog Output Format Without Datadog Transport",
+			content: `
+poll_interval: "1s"
+output_format: "datadog"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid output_format: datadog",
+		},
+		{
+			name: "Loki Output Format Without Loki Transport",
+			content: `
+poll_interval: "1s"
+output_format: "loki"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid output_format: loki",
+		},
+		{
+			name: "OTLP Output Format Without OTLP Transport",
+			content: `
+poll_interval: "1s"
+output_format: "otlp"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid output_format: otlp",
+		},
+		{
+			name: "Fluentd Output Format Without Fluentd Transport",
+			content: `
+poll_interval: "1s"
+output_format: "fluentd"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid output_format: fluentd",
+		},
+		{
+			name: "Valid Drain Shutdown Mode",
+			content: `
+poll_interval: "1s"
+shutdown_mode: "drain_to_eof"
+shutdown_timeout: "30s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError: false,
+		},
+		{
+			name: "Invalid Shutdown Mode",
+			content: `
+poll_interval: "1s"
+shutdown_mode: "graceful"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid shutdown_mode",
+		},
+		{
+			name: "Invalid Shutdown Timeout",
+			content: `
+poll_interval: "1s"
+shutdown_mode: "drain_to_eof"
+shutdown_timeout: "soon"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid shutdown_timeout",
+		},
+		{
+			name: "Valid Output Block",
+			content: `
+poll_interval: "1s"
+output:
+  transport: file
+  serializer: logfmt
+  path: /tmp/katalog.out
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError: false,
+		},
+		{
+			name: "Invalid Output Transport",
+			content: `
+poll_interval: "1s"
+output:
+  transport: carrier-pigeon
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid output transport",
+		},
+		{
+			name: "Invalid Output Serializer",
+			content: `
+poll_interval: "1s"
+output:
+  serializer: xml
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid output serializer",
+		},
+		{
+			name: "Invalid Disk Full Policy",
+			content: `
+poll_interval: "1s"
+output:
+  transport: file
+  path: /tmp/katalog.out
+  disk_full_policy: panic
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid output disk_full_policy",
+		},
+		{
+			name: "File Transport Without Path",
+			content: `
+poll_interval: "1s"
+output:
+  transport: file
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output path must be set",
+		},
+		{
+			name: "Local Copy Without Path",
+			content: `
+poll_interval: "1s"
+local_copy:
+  max_size: 100
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "local_copy path must be set",
+		},
+		{
+			name: "Invalid Conditional Fields Match",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+    conditional_fields:
+      - pattern: "login"
+        fields:
+          category: "auth"
+    conditional_fields_match: "any"
+`,
+			expectError:   true,
+			errorContains: "invalid conditional_fields_match",
+		},
+		{
+			name: "Invalid Color",
+			content: `
+poll_interval: "1s"
+color: rainbow
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid color",
+		},
+		{
+			name: "Compress With Drop Policy",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "file"
+  path: "/var/log/katalog/out.log.gz"
+  compress: "gzip"
+  disk_full_policy: "drop"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "requires disk_full_policy block",
+		},
+		{
+			name: "Valid HTTP Compression",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "loki"
+  url: "http://localhost:3100"
+  compress: "gzip"
+  compress_min_bytes: 4096
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError: false,
+		},
+		{
+			name: "Compress On Syslog",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "syslog"
+  syslog_addr: "localhost:514"
+  compress: "gzip"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output compress requires the file transport or one of http, loki, hec, otlp",
+		},
+		{
+			name: "Compress Min Bytes Without Compress",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "http"
+  url: "http://localhost:8080"
+  compress_min_bytes: 4096
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output compress_min_bytes requires compress",
+		},
+		{
+			name: "Invalid Derived Metric Name",
+			content: `
+poll_interval: "1s"
+derived_metrics:
+  - name: "http-5xx"
+    pattern: 'status=5\d\d'
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid derived_metrics name",
+		},
+		{
+			name: "HTTP Transport Without URL",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "http"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output url must be set",
+		},
+		{
+			name: "Invalid Checkpoint Interval",
+			content: `
+poll_interval: "1s"
+checkpoint_file: "/var/lib/katalog/checkpoints.json"
+checkpoint_interval: "0s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid checkpoint_interval",
+		},
+		{
+			name: "Invalid Watch Mode",
+			content: `
+poll_interval: "1s"
+watch_mode: "kqueue"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid watch_mode",
+		},
+		{
+			name: "Invalid Parse Format",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+    parse: "xml"
+`,
+			expectError:   true,
+			errorContains: "invalid parse",
+		},
+		{
+			name: "Invalid JSON Nested",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+    parse: "json"
+    json_nested: "drop"
+`,
+			expectError:   true,
+			errorContains: "invalid json_nested",
+		},
+		{
+			name: "JSON Nested Without JSON Parse",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+    json_nested: "encode"
+`,
+			expectError:   true,
+			errorContains: "json_nested for target 'logs' requires parse: json or auto",
+		},
+		{
+			name: "JSON Array Over Syslog",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "syslog"
+  syslog_addr: "localhost:514"
+  serializer: "json_array"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output json_array serializer requires the stdout, file or http transport",
+		},
+		{
+			name: "Invalid Target Output Format",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "noisy"
+    paths: ["/var/log/noisy.log"]
+    output_format: "loki"
+`,
+			expectError:   true,
+			errorContains: "invalid output_format for target 'noisy': loki",
+		},
+		{
+			name: "CSV Without Headers",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "access"
+    paths: ["/var/log/access.csv"]
+    parse: "csv"
+`,
+			expectError:   true,
+			errorContains: "needs one of csv_headers or csv_header_from_first_line",
+		},
+		{
+			name: "Invalid CSV Delimiter",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "access"
+    paths: ["/var/log/access.csv"]
+    parse: "csv"
+    csv_headers: ["ip", "status"]
+    csv_delimiter: "||"
+`,
+			expectError:   true,
+			errorContains: "invalid csv_delimiter for target 'access'",
+		},
+		{
+			name: "CSV Settings Without CSV Parse",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "access"
+    paths: ["/var/log/access.csv"]
+    csv_headers: ["ip", "status"]
+`,
+			expectError:   true,
+			errorContains: "csv settings for target 'access' require parse: csv",
+		},
+		{
+			name: "Output And Outputs",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "stdout"
+outputs:
+  - transport: "stdout"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "not both",
+		},
+		{
+			name: "Duplicate Output Names",
+			content: `
+poll_interval: "1s"
+outputs:
+  - transport: "stdout"
+  - transport: "stdout"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "duplicate output name",
+		},
+		{
+			name: "Strict Env Expansion With Unset Variable",
+			content: `
+poll_interval: "1s"
+env_expansion: "strict"
+targets:
+  - name: "logs"
+    paths: ["${KATALOG_TEST_UNSET_DIR}/app.log"]
+`,
+			expectError:   true,
+			errorContains: "KATALOG_TEST_UNSET_DIR",
+		},
+		{
+			name: "Invalid Env Expansion",
+			content: `
+poll_interval: "1s"
+env_expansion: "shell"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid env_expansion",
+		},
+		{
+			name: "Syslog Output Without Address",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "syslog"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "syslog_addr must be set",
+		},
+		{
+			name: "Syslog UDP Output With Line Serializer",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "syslog_udp"
+  serializer: "json"
+  syslog_addr: "127.0.0.1:514"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "requires the rfc3164 or rfc5424 serializer",
+		},
+		{
+			name: "Loki Output With Other Serializer",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "loki"
+  serializer: "json"
+  url: "http://loki:3100"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "loki transport and serializer",
+		},
+		{
+			name: "HEC Output Without Token",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "hec"
+  url: "https://splunk:8088"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "url and token must be set",
+		},
+		{
+			name: "Kafka Output Without Topic",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "kafka"
+  kafka:
+    brokers: ["localhost:9092"]
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "brokers and topic must be set",
+		},
+		{
+			name: "Rotation Without File Transport",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "stdout"
+  max_size_mb: 10
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "require the file transport",
+		},
+		{
+			name: "Spool Without Network Transport",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "file"
+  path: "/var/log/forwarded.log"
+  spool_dir: "/var/lib/katalog/spool"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "spool_dir requires a network transport",
+		},
+		{
+			name: "Duplicate Spool Dir",
+			content: `
+poll_interval: "1s"
+outputs:
+  - name: "a"
+    transport: "http"
+    url: "http://a"
+    spool_dir: "/var/lib/katalog/spool"
+  - name: "b"
+    transport: "http"
+    url: "http://b"
+    spool_dir: "/var/lib/katalog/spool/"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "duplicate output spool_dir",
+		},
+		{
+			name: "Dead Letter Path",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "hec"
+  url: "https://splunk:8088"
+  token: "secret"
+  serializer: "hec"
+  dead_letter_path: "/var/lib/katalog/hec.dead"
+  dead_letter_max_size_mb: 10
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError: false,
+		},
+		{
+			name: "Dead Letter Without Retries",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "syslog_udp"
+  syslog_addr: "localhost:514"
+  serializer: "rfc5424"
+  dead_letter_path: "/var/lib/katalog/syslog.dead"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "dead_letter_path requires a network transport",
+		},
+		{
+			name: "Connection Pool On Kafka",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "kafka"
+  connection_pool_size: 2
+  kafka:
+    brokers: ["localhost:9092"]
+    topic: "logs"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "connection_pool_size requires the http or syslog transport",
+		},
+		{
+			name: "Connection Pool With Spool",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "syslog"
+  syslog_addr: "localhost:514"
+  connection_pool_size: 2
+  spool_dir: "/var/lib/katalog/spool"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "connection_pool_size can't be combined with spool_dir",
+		},
+		{
+			name: "Preserve Order Without Pool",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "http"
+  url: "http://collector"
+  preserve_order: true
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "preserve_order requires a connection_pool_size above 1",
+		},
+		{
+			name: "Dead Letter With JSON Array",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "http"
+  url: "http://collector"
+  serializer: "json_array"
+  dead_letter_path: "/var/lib/katalog/http.dead"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "dead_letter_path does not support the json_array serializer",
+		},
+		{
+			name: "Duplicate Dead Letter Path",
+			content: `
+poll_interval: "1s"
+outputs:
+  - name: "a"
+    transport: "http"
+    url: "http://a"
+    dead_letter_path: "/var/lib/katalog/dead"
+  - name: "b"
+    transport: "http"
+    url: "http://b"
+    dead_letter_path: "/var/lib/katalog/./dead"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "duplicate output dead_letter_path",
+		},
+		{
+			name: "HTTP Auth Bearer",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "http"
+  url: "https://collector:8443"
+  auth:
+    type: "bearer"
+    token_file: "/run/secrets/collector-token"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError: false,
+		},
+		{
+			name: "HTTP Auth Header Without Name",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "http"
+  url: "https://collector:8443"
+  auth:
+    type: "header"
+    header_value: "s3cret"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "needs a header_name",
+		},
+		{
+			name: "HTTP Auth Bearer Token And File",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "http"
+  url: "https://collector:8443"
+  auth:
+    type: "bearer"
+    token: "s3cret"
+    token_file: "/run/secrets/collector-token"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "needs one of token or token_file",
+		},
+		{
+			name: "HTTP Auth Without HTTP Transport",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "loki"
+  serializer: "loki"
+  url: "http://loki:3100"
+  auth:
+    type: "basic"
+    username: "katalog"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output auth settings require the http transport",
+		},
+		{
+			name: "TLS Without Supported Transport",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "syslog_udp"
+  syslog_addr: "localhost:514"
+  serializer: "rfc5424"
+  tls:
+    server_name: "collector"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output tls settings require one of http, syslog, kafka",
+		},
+		{
+			name: "TLS Cert Without Key",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "http"
+  url: "https://collector:8443"
+  tls:
+    cert_file: "/etc/katalog/client.crt"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "cert_file and key_file must be set together",
+		},
+		{
+			name: "TLS Missing Cert File",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "syslog"
+  syslog_addr: "collector:6514"
+  serializer: "rfc5424"
+  tls:
+    cert_file: "/nonexistent/client.crt"
+    key_file: "/nonexistent/client.key"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid output tls cert_file or key_file",
+		},
+		{
+			name: "TLS Missing CA File",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "http"
+  url: "https://collector:8443"
+  tls:
+    ca_file: "/nonexistent/ca.crt"
+    server_name: "collector.internal"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid output tls ca_file",
+		},
+		{
+			name: "Sample Rate Out Of Range",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+    sample_rate: 10
+`,
+			expectError:   true,
+			errorContains: "invalid sample_rate",
+		},
+		{
+			name: "Invalid Timestamp Format",
+			content: `
+poll_interval: "1s"
+timestamp_format: "iso"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid timestamp_format",
+		},
+		{
+			name: "Timestamp Pattern Without Layout",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+    timestamp_pattern: '^(?P<ts>\S+)'
+`,
+			expectError:   true,
+			errorContains: "must be set together",
+		},
+		{
+			name: "Invalid Mode",
+			content: `
+poll_interval: "1s"
+mode: "oneshot"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid mode",
+		},
+		{
+			name: "Invalid Ready Failure Timeout",
+			content: `
+poll_interval: "1s"
+ready_failure_timeout: "0s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid ready_failure_timeout",
+		},
+		{
+			name: "Invalid Channel Full Policy",
+			content: `
+poll_interval: "1s"
+channel_full_policy: "drop"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid channel_full_policy",
+		},
+		{
+			name: "Invalid Ignore Paths Pattern",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/*.log"]
+    ignore_paths: ["[audit"]
+`,
+			expectError:   true,
+			errorContains: "invalid ignore_paths pattern for target 'logs'",
+		},
+		{
+			name: "OTLP Without URL",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "otlp"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output url must be set for the otlp transport",
+		},
+		{
+			name: "OTLP Settings Without OTLP Transport",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "http"
+  url: "http://collector:8080"
+  otlp:
+    headers: {Authorization: "Bearer x"}
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output otlp settings require the otlp transport",
+		},
+		{
+			name: "Fluentd Without Address",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "fluentd"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output fluentd address must be set for the fluentd transport",
+		},
+		{
+			name: "Fluentd Serializer Without Fluentd Transport",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "stdout"
+  serializer: "fluentd"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output fluentd transport and serializer must be used together",
+		},
+		{
+			name: "Invalid Fluentd Ack Timeout",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "fluentd"
+  fluentd:
+    address: "fluentd:24224"
+    require_ack: true
+    ack_timeout: "0s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid output fluentd ack_timeout: 0s",
+		},
+		{
+			name: "Datadog Without API Key",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "datadog"
+  datadog:
+    site: "datadoghq.eu"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output datadog api_key must be set for the datadog transport",
+		},
+		{
+			name: "Datadog Site And URL",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "datadog"
+  url: "https://intake.example.com"
+  datadog:
+    api_key: "secret"
+    site: "datadoghq.eu"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output datadog site and url cannot both be set",
+		},
+		{
+			name: "Datadog Settings Without Transport",
+			content: `
+poll_interval: "1s"
+output:
+  transport: "http"
+  url: "https://intake.example.com"
+  datadog:
+    api_key: "secret"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "output datadog settings require the datadog transport",
+		},
+		{
+			name: "Invalid Stats Interval",
+			content: `
+poll_interval: "1s"
+stats_interval: hourly
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid stats_interval",
+		},
+		{
+			name: "Invalid EOF Poll Interval",
+			content: `
+poll_interval: "1s"
+eof_poll_interval: 0s
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid eof_poll_interval",
+		},
+		{
+			name: "Invalid Flush Interval",
+			content: `
+poll_interval: "1s"
+flush_interval: soon
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+`,
+			expectError:   true,
+			errorContains: "invalid flush_interval",
+		},
+		{
+			name: "Invalid Line Delimiter",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+    line_delimiter: "\r\n"
+`,
+			expectError:   true,
+			errorContains: "invalid line_delimiter for target 'logs'",
+		},
+		{
+			name: "Truncation Marker Without Max Line Bytes",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+    truncation_marker: "..."
+`,
+			expectError:   true,
+			errorContains: "truncation_marker for target 'logs' requires max_line_bytes",
+		},
+		{
+			name: "Valid Journald Target",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "system"
+    journald:
+      units: ["nginx.service"]
+      fields: ["_PID", "PRIORITY"]
+`,
+			expectError: false,
+		},
+		{
+			name: "Journald Target With Paths",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "system"
+    paths: ["/var/log/app.log"]
+    journald: {}
+`,
+			expectError:   true,
+			errorContains: "target 'system' can't read both paths and journald",
+		},
+		{
+			name: "Invalid Journald Field",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "system"
+    journald:
+      fields: ["_pid"]
+`,
+			expectError:   true,
+			errorContains: "invalid journald field for target 'system': _pid",
+		},
+		{
+			name: "Invalid Over Limit Policy",
+			content: `
+poll_interval: "1s"
+targets:
+  - name: "logs"
+    paths: ["/var/log/app.log"]
+    rate_limit: 100
+    over_limit_policy: queue
+`,
+			expectError:   true,
+			errorContains: "invalid over_limit_policy",
+		},
 		{
 			name: "No Targets",
 			content: `
@@ -130,3 +1293,49 @@ targets: []
 		})
 	}
 }
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("KATALOG_TEST_ENV", "prod")
+	t.Setenv("KATALOG_TEST_DIR", "/srv/app")
+
+	tests := []struct {
+		name    string
+		input   string
+		strict  bool
+		want    string
+		wantErr bool
+	}{
+		{name: "Braced", input: `env: "${KATALOG_TEST_ENV}"`, want: `env: "prod"`},
+		{name: "Bare", input: `path: "$KATALOG_TEST_DIR/*.log"`, want: `path: "/srv/app/*.log"`},
+		{name: "Unset Lenient", input: `env: "${KATALOG_TEST_UNSET}"`, want: `env: ""`},
+		{name: "Unset Strict", input: `env: "${KATALOG_TEST_UNSET}"`, strict: true, wantErr: true},
+		{name: "Escaped Dollar", input: `exclude_pattern: "^$$KATALOG_TEST_ENV"`, want: `exclude_pattern: "^$KATALOG_TEST_ENV"`},
+		{name: "Regex Anchor", input: `exclude_pattern: "^(GET|HEAD) /healthz$"`, want: `exclude_pattern: "^(GET|HEAD) /healthz$"`},
+		{name: "Special Parameter", input: `pattern: "(\\d+)$1"`, strict: true, want: `pattern: "(\\d+)$1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandEnv([]byte(tt.input), tt.strict)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExpandEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Errorf("ExpandEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvedOutput(t *testing.T) {
+	// output_format is shorthand for a stdout transport
+	cfg := Config{OutputFormat: "raw"}
+	if out := cfg.ResolvedOutput(); out.Transport != "stdout" || out.Serializer != "raw" {
+		t.Errorf("Expected stdout/raw, got %s/%s", out.Transport, out.Serializer)
+	}
+
+	// An explicit output block wins and gets defaults filled in
+	cfg.Output = &Output{Transport: "file", Path: "/tmp/out.log"}
+	if out := cfg.ResolvedOutput(); out.Transport != "file" || out.Serializer != "json" {
+		t.Errorf("Expected file/json, got %s/%s", out.Transport, out.Serializer)
+	}
+}
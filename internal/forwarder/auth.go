@@ -0,0 +1,105 @@
+package forwarder
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// HTTPAuth configures how the http transport authenticates its requests.
+// Type "bearer" sends Token as a bearer token, "basic" Username and
+// Password, and "header" HeaderValue in the header named HeaderName. With
+// TokenFile set, the token (or header value) is read from that file instead,
+// and read again whenever the file changes, so credentials can be rotated
+// without a restart.
+type HTTPAuth struct {
+	Type        string
+	Token       string
+	TokenFile   string
+	Username    string
+	Password    string
+	HeaderName  string
+	HeaderValue string
+}
+
+// httpAuth sets the credentials of an HTTPAuth on requests. Secrets are
+// never logged.
+type httpAuth struct {
+	cfg HTTPAuth
+
+	mu      sync.Mutex
+	secret  string    // Token or HeaderValue, or the content of TokenFile
+	modTime time.Time // of TokenFile when last read
+	size    int64
+}
+
+func newHTTPAuth(cfg HTTPAuth) (*httpAuth, error) {
+	a := &httpAuth{cfg: cfg}
+	switch cfg.Type {
+	case "bearer":
+		a.secret = cfg.Token
+	case "header":
+		if cfg.HeaderName == "" {
+			return nil, fmt.Errorf("http auth type header requires a header name")
+		}
+		a.secret = cfg.HeaderValue
+	case "basic":
+		return a, nil
+	default:
+		return nil, fmt.Errorf("unknown http auth type: %s", cfg.Type)
+	}
+	if cfg.TokenFile != "" {
+		if err := a.reload(); err != nil {
+			return nil, fmt.Errorf("failed to read http auth token_file: %w", err)
+		}
+	}
+	return a, nil
+}
+
+// apply sets the credentials on req.
+func (a *httpAuth) apply(req *http.Request) {
+	switch a.cfg.Type {
+	case "basic":
+		req.SetBasicAuth(a.cfg.Username, a.cfg.Password)
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+a.token())
+	case "header":
+		req.Header.Set(a.cfg.HeaderName, a.token())
+	}
+}
+
+// token returns the secret, reading TokenFile again first if it changed.
+// If it can't be read the previous secret is kept.
+func (a *httpAuth) token() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cfg.TokenFile != "" {
+		if err := a.reload(); err != nil {
+			slog.Warn("Error reading http auth token_file, keeping the previous token", "path", a.cfg.TokenFile, "error", err)
+		}
+	}
+	return a.secret
+}
+
+// reload reads TokenFile unless its size and modification time are those
+// of the last read.
+func (a *httpAuth) reload() error {
+	fi, err := os.Stat(a.cfg.TokenFile)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(a.modTime) && fi.Size() == a.size {
+		return nil
+	}
+	b, err := os.ReadFile(a.cfg.TokenFile)
+	if err != nil {
+		return err
+	}
+	a.secret = string(bytes.TrimSpace(b))
+	a.modTime, a.size = fi.ModTime(), fi.Size()
+	return nil
+}
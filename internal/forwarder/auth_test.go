@@ -0,0 +1,108 @@
+package forwarder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPTransportAuth(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		auth   HTTPAuth
+		header string
+		want   string
+	}{
+		{name: "Bearer", auth: HTTPAuth{Type: "bearer", Token: "s3cret"}, header: "Authorization", want: "Bearer s3cret"},
+		{name: "Bearer From File", auth: HTTPAuth{Type: "bearer", TokenFile: tokenFile}, header: "Authorization", want: "Bearer from-file"},
+		{name: "Basic", auth: HTTPAuth{Type: "basic", Username: "katalog", Password: "pa:ss"}, header: "Authorization", want: "Basic a2F0YWxvZzpwYTpzcw=="},
+		{name: "Header", auth: HTTPAuth{Type: "header", HeaderName: "X-API-Key", HeaderValue: "k3y"}, header: "X-API-Key", want: "k3y"},
+		{name: "Header From File", auth: HTTPAuth{Type: "header", HeaderName: "X-API-Key", TokenFile: tokenFile}, header: "X-API-Key", want: "from-file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get(tt.header)
+			}))
+			defer srv.Close()
+			tr, err := NewHTTPTransport(HTTPOutputConfig{URL: srv.URL, Auth: &tt.auth})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tr.Close()
+			if _, err := tr.Write([]byte("line\n")); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %s %q, got %q", tt.header, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHTTPTransportAuthTokenRotation(t *testing.T) {
+	// 1. A token file, and a collector recording the tokens it gets
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		tokens = append(tokens, r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+	tr, err := NewHTTPTransport(HTTPOutputConfig{URL: srv.URL, Auth: &HTTPAuth{Type: "bearer", TokenFile: tokenFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	tr.Write([]byte("a\n"))
+
+	// 2. The rotated token is used from the next request on
+	if err := os.WriteFile(tokenFile, []byte("second"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(tokenFile, future, future)
+	tr.Write([]byte("b\n"))
+
+	// 3. A token file gone missing keeps the last token
+	os.Remove(tokenFile)
+	tr.Write([]byte("c\n"))
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"Bearer first", "Bearer second", "Bearer second"}
+	if len(tokens) != len(want) {
+		t.Fatalf("Expected %d requests, got %q", len(want), tokens)
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("Request %d: expected %q, got %q", i, want[i], tokens[i])
+		}
+	}
+}
+
+func TestNewHTTPTransportAuthErrors(t *testing.T) {
+	for name, auth := range map[string]HTTPAuth{
+		"Unknown Type":       {Type: "digest"},
+		"Missing Token File": {Type: "bearer", TokenFile: filepath.Join(t.TempDir(), "missing")},
+		"No Header Name":     {Type: "header", HeaderValue: "k3y"},
+	} {
+		if _, err := NewHTTPTransport(HTTPOutputConfig{URL: "http://collector", Auth: &auth}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
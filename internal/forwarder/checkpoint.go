@@ -0,0 +1,140 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checkpoint is how far into a file lines have been handed to the writer.
+// FileID (the inode, where there is one) ties it to the file that was at
+// the path, so a file replaced while the agent was down isn't resumed at
+// the old file's offset. A source that isn't a file, such as the journal,
+// saves a Cursor instead.
+type Checkpoint struct {
+	FileID uint64 `json:"file_id"`
+	Offset int64  `json:"offset"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// CheckpointStore keeps the Checkpoint of every tailed file in memory and
+// persists them, keyed by path, as a JSON file on Save. It is safe for
+// concurrent use by the tailers.
+type CheckpointStore struct {
+	path string
+
+	mu          sync.Mutex
+	checkpoints map[string]Checkpoint
+	dirty       bool
+}
+
+// OpenCheckpointStore loads the checkpoints saved at path, if any.
+func OpenCheckpointStore(path string) (*CheckpointStore, error) {
+	s := &CheckpointStore{path: path, checkpoints: make(map[string]Checkpoint)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.checkpoints); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the saved offset for path, if it was saved for the same file.
+func (s *CheckpointStore) Get(path string, fileID uint64) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.checkpoints[path]
+	if !ok || cp.FileID != fileID {
+		return 0, false
+	}
+	return cp.Offset, true
+}
+
+func (s *CheckpointStore) Set(path string, fileID uint64, offset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := Checkpoint{FileID: fileID, Offset: offset}
+	if s.checkpoints[path] != cp {
+		s.checkpoints[path] = cp
+		s.dirty = true
+	}
+}
+
+// Cursor returns the cursor saved for key, if any.
+func (s *CheckpointStore) Cursor(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.checkpoints[key]
+	return cp.Cursor, ok && cp.Cursor != ""
+}
+
+func (s *CheckpointStore) SetCursor(key, cursor string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := Checkpoint{Cursor: cursor}
+	if s.checkpoints[key] != cp {
+		s.checkpoints[key] = cp
+		s.dirty = true
+	}
+}
+
+// Save writes the checkpoints out if they changed since the last Save,
+// replacing the file atomically. Paths that no longer exist are dropped;
+// cursors are kept.
+func (s *CheckpointStore) Save() error {
+	s.mu.Lock()
+	for path, cp := range s.checkpoints {
+		if cp.Cursor != "" {
+			continue
+		}
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			delete(s.checkpoints, path)
+			s.dirty = true
+		}
+	}
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(s.checkpoints)
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := writeAtomic(s.path, data); err != nil {
+		// Try again on the next Save
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// writeAtomic replaces path with data via a temporary file and a rename,
+// so a crash never leaves a half-written file behind.
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
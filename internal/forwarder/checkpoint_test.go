@@ -0,0 +1,56 @@
+package forwarder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointStore(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(logPath, []byte("line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	storePath := filepath.Join(dir, "checkpoints.json")
+
+	// 1. A missing file is an empty store
+	s, err := OpenCheckpointStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set(logPath, 42, 5)
+	s.Set(filepath.Join(dir, "gone.log"), 7, 100)
+	s.SetCursor("journald:system", "s=abc;i=1")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2. Checkpoints survive a reload, matched on path and file ID
+	s, err = OpenCheckpointStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, ok := s.Get(logPath, 42); !ok || offset != 5 {
+		t.Errorf("Expected offset 5, got %d (found: %v)", offset, ok)
+	}
+	if _, ok := s.Get(logPath, 43); ok {
+		t.Error("Expected no checkpoint for a different file at the same path")
+	}
+
+	// 3. Files that no longer exist are dropped on save
+	if _, ok := s.Get(filepath.Join(dir, "gone.log"), 7); ok {
+		t.Error("Expected the checkpoint of a deleted file to be dropped")
+	}
+	if cursor, ok := s.Cursor("journald:system"); !ok || cursor != "s=abc;i=1" {
+		t.Errorf("Expected the saved cursor to be kept, got %q (found: %v)", cursor, ok)
+	}
+
+	// 4. A corrupt store is reported rather than silently reset
+	if err := os.WriteFile(storePath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenCheckpointStore(storePath); err == nil {
+		t.Error("Expected an error for a corrupt checkpoint file")
+	}
+}
@@ -0,0 +1,81 @@
+package forwarder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"katalog/internal/metrics"
+)
+
+// gzipWriter compresses everything written to a transport. Each Write is
+// sync-flushed so what was written can be read back (zcat) while the agent
+// runs; Close finalizes the gzip stream. Opening an existing file appends a
+// new gzip member, which gzip readers treat as one continuous stream.
+type gzipWriter struct {
+	gz  *gzip.Writer
+	dst io.WriteCloser
+}
+
+// Compress wraps w with the named compression. An empty kind returns w
+// unchanged.
+func Compress(w io.WriteCloser, kind string) (io.WriteCloser, error) {
+	switch kind {
+	case "":
+		return w, nil
+	case "gzip":
+		return &gzipWriter{gz: gzip.NewWriter(w), dst: w}, nil
+	}
+	return nil, fmt.Errorf("unknown compression: %s", kind)
+}
+
+func (g *gzipWriter) Write(p []byte) (int, error) {
+	n, err := g.gz.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, g.gz.Flush()
+}
+
+func (g *gzipWriter) Close() error {
+	return errors.Join(g.gz.Close(), g.dst.Close())
+}
+
+// DefaultCompressMinBytes is the body size above which the http transports
+// compress a batch when no MinBytes is set.
+const DefaultCompressMinBytes = 1024
+
+// BodyCompression is how a transport posting batches over HTTP compresses
+// its request bodies. With Kind "gzip", bodies larger than MinBytes are
+// gzipped and sent with Content-Encoding: gzip; smaller ones aren't worth
+// the overhead. An empty Kind sends every body as is.
+type BodyCompression struct {
+	Kind     string
+	MinBytes int
+}
+
+// body returns what to send for p and its Content-Encoding, empty when p is
+// sent as is. It is called once per batch, so retries send the same bytes.
+// Compressed bodies are counted for transport in
+// katalog_compression_bytes_total, raw and compressed.
+func (c BodyCompression) body(transport string, p []byte) (body []byte, encoding string, err error) {
+	if c.Kind == "" || len(p) <= c.MinBytes {
+		return p, "", nil
+	}
+	if c.Kind != "gzip" {
+		return nil, "", fmt.Errorf("unknown compression: %s", c.Kind)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(p); err != nil {
+		return nil, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", err
+	}
+	metrics.CompressionBytes.WithLabelValues(transport, "raw").Add(float64(len(p)))
+	metrics.CompressionBytes.WithLabelValues(transport, "compressed").Add(float64(buf.Len()))
+	return buf.Bytes(), c.Kind, nil
+}
@@ -0,0 +1,53 @@
+package forwarder
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log.gz")
+
+	// 1. Two runs append to the same file, each closing its own gzip member
+	for _, line := range []string{"first run\n", "second run\n"} {
+		f, err := OpenTransport("file", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := Compress(f, "gzip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 2. The file reads back as one valid gzip stream
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Expected a valid gzip stream, got %v", err)
+	}
+	if string(got) != "first run\nsecond run\n" {
+		t.Errorf("Unexpected content: %q", got)
+	}
+
+	if _, err := Compress(f, "zstd"); err == nil {
+		t.Error("Expected an unknown compression to be rejected")
+	}
+}
@@ -0,0 +1,191 @@
+package forwarder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// datadogLogsPath is Datadog's logs intake API, appended to a url without
+// a path.
+const datadogLogsPath = "/api/v2/logs"
+
+// DefaultDatadogSite is the Datadog site logs are sent to when neither a
+// site nor a url is configured.
+const DefaultDatadogSite = "datadoghq.com"
+
+// Datadog's intake limits per request: entries in the array, and size
+// before compression.
+const (
+	datadogMaxBatchEntries = 1000
+	datadogMaxBatchBytes   = 5 << 20
+)
+
+// DatadogSerializer writes each entry as one line of JSON in the shape of
+// a Datadog log, for the datadog transport to batch into intake requests.
+// The event is the message, the host the hostname and the sourcetype both
+// the ddsource and, unless Service is set, the service. Custom fields are
+// top-level attributes, but never override those.
+type DatadogSerializer struct {
+	Service string
+}
+
+func (s DatadogSerializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	log := make(map[string]any, len(entry.Fields)+5)
+	for k, v := range entry.Fields {
+		log[k] = v
+	}
+	service := s.Service
+	if service == "" {
+		service = entry.SourceType
+	}
+	log["message"] = entry.Event
+	log["hostname"] = entry.Host
+	log["ddsource"] = entry.SourceType
+	log["service"] = service
+	log["timestamp"] = entry.Timestamp().UnixMilli()
+	b, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// DatadogOutputConfig configures the datadog transport. URL, when set, is
+// the intake to send to; otherwise it is the logs intake of Site (empty
+// means DefaultDatadogSite), e.g. "datadoghq.eu" for the EU region.
+type DatadogOutputConfig struct {
+	URL    string
+	Site   string
+	APIKey string
+}
+
+// datadogTransport sends each batch written by DatadogSerializer to the
+// Datadog logs intake as gzipped JSON arrays, split to stay within its
+// limits of 1000 entries and 5MB. Retryable failures (see retryable) are
+// retried with exponential backoff, or after the delay a Retry-After header
+// asks for, holding up the writer meanwhile; other responses, and requests
+// still failing after MaxRetries, are dropped. Logs are counted in
+// katalog_datadog_logs_total as accepted or rejected.
+type datadogTransport struct {
+	retrier
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewDatadogTransport returns a transport sending logs to the Datadog
+// intake cfg selects.
+func NewDatadogTransport(cfg DatadogOutputConfig) (io.WriteCloser, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("datadog transport requires an api key")
+	}
+	rawURL := cfg.URL
+	if rawURL == "" {
+		site := cfg.Site
+		if site == "" {
+			site = DefaultDatadogSite
+		}
+		rawURL = "https://http-intake.logs." + site
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid datadog url: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = datadogLogsPath
+	}
+	return &datadogTransport{
+		retrier: newRetrier(10),
+		url:     u.String(),
+		apiKey:  cfg.APIKey,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (d *datadogTransport) Write(p []byte) (int, error) {
+	// size counts the array's brackets and commas too
+	var batch [][]byte
+	size := 1
+	for _, line := range bytes.Split(bytes.TrimSuffix(p, []byte("\n")), []byte("\n")) {
+		if len(batch) == datadogMaxBatchEntries || (len(batch) > 0 && size+len(line)+1 > datadogMaxBatchBytes) {
+			d.send(batch)
+			batch, size = nil, 1
+		}
+		batch = append(batch, line)
+		size += len(line) + 1
+	}
+	if len(batch) > 0 {
+		d.send(batch)
+	}
+	return len(p), nil
+}
+
+// send posts logs as one request, retrying as needed.
+func (d *datadogTransport) send(logs [][]byte) {
+	body, err := datadogBody(logs)
+	if err != nil {
+		slog.Error("Error encoding Datadog batch", "error", err)
+		metrics.DatadogLogs.WithLabelValues("rejected").Add(float64(len(logs)))
+		return
+	}
+	if err := d.retry("datadog", func() error { return d.post(body) }); err != nil {
+		slog.Error("Dropping Datadog batch", "url", d.url, "logs", len(logs), "error", err)
+		metrics.DatadogLogs.WithLabelValues("rejected").Add(float64(len(logs)))
+		d.giveUp(logs, err)
+		return
+	}
+	metrics.DatadogLogs.WithLabelValues("accepted").Add(float64(len(logs)))
+}
+
+// datadogBody gzips logs as one JSON array.
+func datadogBody(logs [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("["))
+	gz.Write(bytes.Join(logs, []byte(",")))
+	gz.Write([]byte("]"))
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends one request.
+func (d *datadogTransport) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("DD-API-KEY", d.apiKey)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		metrics.OutputErrors.WithLabelValues("datadog", "error").Inc()
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	metrics.OutputErrors.WithLabelValues("datadog", strconv.Itoa(resp.StatusCode)).Inc()
+	return newStatusError(resp, bytes.TrimSpace(msg))
+}
+
+func (d *datadogTransport) Close() error {
+	d.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,153 @@
+package forwarder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+func TestDatadogTransport(t *testing.T) {
+	orig := retryBase
+	retryBase = 10 * time.Millisecond
+	t.Cleanup(func() { retryBase = orig })
+
+	// 1. An intake that is unavailable for the first request
+	var mu sync.Mutex
+	var requests [][]map[string]any
+	unavailable := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != datadogLogsPath {
+			t.Errorf("Expected a request to %s, got %s", datadogLogsPath, r.URL.Path)
+		}
+		if got := r.Header.Get("DD-API-KEY"); got != "secret" {
+			t.Errorf("Expected the api key header, got %q", got)
+		}
+		if unavailable > 0 {
+			unavailable--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if got := r.Header.Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("Expected a gzipped body, got %q", got)
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("Invalid gzip body: %v", err)
+			return
+		}
+		var logs []map[string]any
+		if err := json.NewDecoder(gz).Decode(&logs); err != nil {
+			t.Errorf("Invalid body: %v", err)
+		}
+		requests = append(requests, logs)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	tr, err := NewDatadogTransport(DatadogOutputConfig{URL: srv.URL, APIKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	// 2. More entries than fit in one request
+	var batch bytes.Buffer
+	entry := models.LogEntry{Time: 1700000000, Host: "h", SourceType: "app", Event: "one", Fields: map[string]string{"env": "prod", "message": "lost"}}
+	if err := (DatadogSerializer{}).Serialize(&batch, entry); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < datadogMaxBatchEntries; i++ {
+		(DatadogSerializer{Service: "api"}).Serialize(&batch, models.LogEntry{Host: "h", SourceType: "app", Event: "more"})
+	}
+	accepted := counterValue(t, metrics.DatadogLogs.WithLabelValues("accepted"))
+	if _, err := tr.Write(batch.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 3. The entries are split by the intake's limit, the first request
+	// sent again
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 2 || len(requests[0]) != datadogMaxBatchEntries || len(requests[1]) != 1 {
+		t.Fatalf("Expected requests of %d and 1 logs, got %d requests", datadogMaxBatchEntries, len(requests))
+	}
+	want := map[string]any{"message": "one", "hostname": "h", "ddsource": "app", "service": "app", "timestamp": 1.7e12, "env": "prod"}
+	for k, v := range want {
+		if requests[0][0][k] != v {
+			t.Errorf("Expected %s %v, got %v", k, v, requests[0][0][k])
+		}
+	}
+	if got := requests[1][0]["service"]; got != "api" {
+		t.Errorf("Expected the configured service, got %v", got)
+	}
+	if got := counterValue(t, metrics.DatadogLogs.WithLabelValues("accepted")) - accepted; got != datadogMaxBatchEntries+1 {
+		t.Errorf("Expected %d accepted logs, got %.0f", datadogMaxBatchEntries+1, got)
+	}
+}
+
+func TestDatadogTransportRejected(t *testing.T) {
+	// A bad request is dropped without retrying
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	tr, err := NewDatadogTransport(DatadogOutputConfig{URL: srv.URL + "/custom", APIKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	var batch bytes.Buffer
+	DatadogSerializer{}.Serialize(&batch, models.LogEntry{Host: "h", SourceType: "app", Event: "bad"})
+	before := counterValue(t, metrics.DatadogLogs.WithLabelValues("rejected"))
+	tr.Write(batch.Bytes())
+	if calls != 1 {
+		t.Errorf("Expected 1 request, got %d", calls)
+	}
+	if got := counterValue(t, metrics.DatadogLogs.WithLabelValues("rejected")) - before; got != 1 {
+		t.Errorf("Expected 1 rejected log, got %.0f", got)
+	}
+}
+
+func TestNewDatadogTransportSite(t *testing.T) {
+	for site, want := range map[string]string{
+		"":             "https://http-intake.logs.datadoghq.com/api/v2/logs",
+		"datadoghq.eu": "https://http-intake.logs.datadoghq.eu/api/v2/logs",
+	} {
+		tr, err := NewDatadogTransport(DatadogOutputConfig{Site: site, APIKey: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tr.(*datadogTransport).url; got != want {
+			t.Errorf("Expected %s for site %q, got %s", want, site, got)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for v, want := range map[string]time.Duration{
+		"":                              0,
+		"7":                             7 * time.Second,
+		"-1":                            0,
+		"Fri, 01 Mar 2024 12:00:30 GMT": 30 * time.Second,
+		"Fri, 01 Mar 2024 11:00:00 GMT": 0,
+		"soon":                          0,
+	} {
+		if got := parseRetryAfter(v, now); got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"katalog/internal/metrics"
+)
+
+// DefaultDeadLetterMaxBytes is the size a dead-letter file is rotated at
+// unless configured otherwise.
+const DefaultDeadLetterMaxBytes = 100 << 20
+
+// DeadLetter appends the entries of the batches a network transport gave up
+// on to a local file, one JSON object per line, to be inspected or replayed
+// later. Once the file would grow past its size limit it is rotated to
+// path.1, replacing the previous one, so at most twice the limit is kept.
+type DeadLetter struct {
+	output string
+
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+// deadLetterRecord is one line of a dead-letter file. Entry is the entry as
+// the output serialized it: embedded as is when it is JSON, otherwise as a
+// string.
+type deadLetterRecord struct {
+	Time   string          `json:"time"`
+	Output string          `json:"output"`
+	Reason string          `json:"reason"`
+	Entry  json.RawMessage `json:"entry"`
+}
+
+// NewDeadLetter opens (or appends to) the dead-letter file at path for the
+// output named output, rotated at maxBytes.
+func NewDeadLetter(path, output string, maxBytes int64) (*DeadLetter, error) {
+	w, err := NewRotatingFile(path, maxBytes, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetter{output: output, w: w}, nil
+}
+
+// Add appends entries, which failed with reason, and counts them in
+// katalog_dead_lettered_total.
+func (d *DeadLetter) Add(entries [][]byte, reason error) {
+	var buf bytes.Buffer
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, entry := range entries {
+		rec := deadLetterRecord{Time: now, Output: d.output, Reason: reason.Error(), Entry: entry}
+		if !json.Valid(entry) {
+			rec.Entry, _ = json.Marshal(string(entry))
+		}
+		if err := enc.Encode(rec); err != nil {
+			slog.Error("Error encoding dead letter", "output", d.output, "error", err)
+			return
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.w.Write(buf.Bytes()); err != nil {
+		slog.Error("Error writing dead letters", "output", d.output, "entries", len(entries), "error", err)
+		return
+	}
+	metrics.DeadLettered.WithLabelValues(d.output).Add(float64(len(entries)))
+}
+
+func (d *DeadLetter) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.w.Close()
+}
+
+// batchEntries splits a batch written by the writer into its entries.
+func batchEntries(p []byte) [][]byte {
+	return bytes.Split(bytes.TrimSuffix(p, []byte("\n")), []byte("\n"))
+}
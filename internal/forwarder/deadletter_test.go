@@ -0,0 +1,171 @@
+package forwarder
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+)
+
+func TestDeadLetter(t *testing.T) {
+	// 1. A collector rejecting every batch, and an output dead-lettering them
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad format", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "dead.log")
+	d, err := NewDeadLetter(path, "collector", DefaultDeadLetterMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := NewHTTPTransport(HTTPOutputConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	tr.(*httpTransport).SetDeadLetter(d)
+	before := counterValue(t, metrics.DeadLettered.WithLabelValues("collector"))
+
+	// 2. The batch is given up on: its entries land in the file
+	if _, err := tr.Write([]byte("{\"event\":\"a\"}\nplain <b>\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []map[string]any
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("Invalid dead letter %q: %v", sc.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 dead letters, got %d", len(records))
+	}
+
+	// 3. JSON entries are kept as JSON, others as strings, with the reason
+	if entry, ok := records[0]["entry"].(map[string]any); !ok || entry["event"] != "a" {
+		t.Errorf("Expected the JSON entry embedded, got %v", records[0]["entry"])
+	}
+	if got := records[1]["entry"]; got != "plain <b>" {
+		t.Errorf("Expected the raw entry as a string, got %v", got)
+	}
+	for _, rec := range records {
+		if rec["output"] != "collector" {
+			t.Errorf("Expected the output name, got %v", rec["output"])
+		}
+		if reason, _ := rec["reason"].(string); !strings.Contains(reason, "400") || !strings.Contains(reason, "bad format") {
+			t.Errorf("Expected the response as the reason, got %q", reason)
+		}
+	}
+	if got := counterValue(t, metrics.DeadLettered.WithLabelValues("collector")) - before; got != 2 {
+		t.Errorf("Expected 2 dead-lettered entries, got %.0f", got)
+	}
+}
+
+func TestDeadLetterSyslog(t *testing.T) {
+	orig := retryBase
+	retryBase = time.Millisecond
+	t.Cleanup(func() { retryBase = orig })
+
+	// 1. A collector that is down, and a syslog output dead-lettering
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	path := filepath.Join(t.TempDir(), "dead.log")
+	d, err := NewDeadLetter(path, "syslog", DefaultDeadLetterMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := NewSyslogTransport(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	tr.(*syslogTransport).SetDeadLetter(d)
+
+	// 2. Octet-counted frames, which have no newline between them and may
+	// hold one, are set aside one per entry
+	msgs := []string{"<14>1 - host app - - - first", "<14>1 - host app - - - multi\nline"}
+	var batch []byte
+	for _, msg := range msgs {
+		batch = fmt.Appendf(batch, "%d %s", len(msg), msg)
+	}
+	tr.Write(batch)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec struct{ Entry string }
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("Invalid dead letter %q: %v", sc.Text(), err)
+		}
+		entries = append(entries, rec.Entry)
+	}
+	if !reflect.DeepEqual(entries, msgs) {
+		t.Errorf("Expected %q, got %q", msgs, entries)
+	}
+
+	// 3. Batches that aren't octet-counted are split into lines
+	if got := frameEntries([]byte("{\"a\":1}\nplain\n")); len(got) != 2 || string(got[1]) != "plain" {
+		t.Errorf("Expected 2 lines, got %q", got)
+	}
+}
+
+func TestDeadLetterRotation(t *testing.T) {
+	// A limit below two batches: the second rotates the first out to path.1
+	path := filepath.Join(t.TempDir(), "dead.log")
+	d, err := NewDeadLetter(path, "collector", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reason := errors.New("connection refused")
+	d.Add([][]byte{[]byte("first batch entry")}, reason)
+	d.Add([][]byte{[]byte("second batch entry")}, reason)
+	d.Add([][]byte{[]byte("third batch entry")}, reason)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range map[string]string{path: "third", path + ".1": "second"} {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), want) || strings.Count(string(b), "\n") != 1 {
+			t.Errorf("Expected only the %s batch in %s, got %s", want, filepath.Base(p), b)
+		}
+	}
+	if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Errorf("Expected a single old file, got %v", err)
+	}
+}
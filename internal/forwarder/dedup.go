@@ -0,0 +1,109 @@
+package forwarder
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// dedupFilter remembers roughly the last size distinct lines using two
+// generations of bloom filters: once the current generation holds size
+// lines it becomes the previous one and a fresh filter takes its place, so
+// memory stays bounded while lookups still cover the recent window.
+// Like any bloom filter it has false positives (about 1% per generation
+// at capacity), so an occasional unique line may be suppressed.
+type dedupFilter struct {
+	size      int
+	k         int
+	cur, prev *bloom
+}
+
+const (
+	dedupFalsePositive = 0.01
+	// dedupMaxSize bounds the per-file filter to ~2.4MB (two generations)
+	dedupMaxSize = 1 << 20
+)
+
+func newDedupFilter(size int) *dedupFilter {
+	size = min(max(size, 1), dedupMaxSize)
+	// Optimal bits per item and hash count for the target false positive rate
+	bits := int(math.Ceil(-float64(size) * math.Log(dedupFalsePositive) / (math.Ln2 * math.Ln2)))
+	k := max(1, int(math.Round(float64(bits)/float64(size)*math.Ln2)))
+	return &dedupFilter{size: size, k: k, cur: newBloom(bits), prev: newBloom(bits)}
+}
+
+// seen records s and reports whether it was (probably) seen before.
+func (d *dedupFilter) seen(s string) bool {
+	h1, h2 := hashPair(s)
+	if d.cur.has(h1, h2, d.k) {
+		return true
+	}
+	if d.prev.has(h1, h2, d.k) {
+		// Refresh into the current generation so it stays remembered
+		d.cur.add(h1, h2, d.k)
+		d.rotate()
+		return true
+	}
+	d.cur.add(h1, h2, d.k)
+	d.rotate()
+	return false
+}
+
+func (d *dedupFilter) rotate() {
+	if d.cur.count < d.size {
+		return
+	}
+	d.prev, d.cur = d.cur, d.prev
+	d.cur.reset()
+}
+
+// fillRatio is the fraction of set bits in the current generation.
+func (d *dedupFilter) fillRatio() float64 {
+	return float64(d.cur.set) / float64(d.cur.m)
+}
+
+type bloom struct {
+	bits  []uint64
+	m     uint64
+	set   int
+	count int
+}
+
+func newBloom(m int) *bloom {
+	words := (m + 63) / 64
+	return &bloom{bits: make([]uint64, words), m: uint64(words * 64)}
+}
+
+func (b *bloom) has(h1, h2 uint64, k int) bool {
+	for i := 0; i < k; i++ {
+		pos := (h1 + uint64(i)*h2) % b.m
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloom) add(h1, h2 uint64, k int) {
+	for i := 0; i < k; i++ {
+		pos := (h1 + uint64(i)*h2) % b.m
+		if mask := uint64(1) << (pos % 64); b.bits[pos/64]&mask == 0 {
+			b.bits[pos/64] |= mask
+			b.set++
+		}
+	}
+	b.count++
+}
+
+func (b *bloom) reset() {
+	clear(b.bits)
+	b.set, b.count = 0, 0
+}
+
+// hashPair derives the two base hashes for double hashing from one FNV-1a
+// pass; h2 is forced odd so the probe sequence covers the table.
+func hashPair(s string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	return sum, (sum>>32 | sum<<32) | 1
+}
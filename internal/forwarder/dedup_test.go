@@ -0,0 +1,48 @@
+package forwarder
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDedupFilter(t *testing.T) {
+	d := newDedupFilter(100)
+
+	// Repeats are suppressed even when not consecutive
+	for _, line := range []string{"a", "b", "c"} {
+		if d.seen(line) {
+			t.Fatalf("First occurrence of %q reported as seen", line)
+		}
+	}
+	if !d.seen("a") || !d.seen("c") {
+		t.Fatal("Expected repeated lines to be reported as seen")
+	}
+	if d.fillRatio() <= 0 {
+		t.Error("Expected a non-zero fill ratio")
+	}
+
+	// Lines older than two generations are forgotten, bounding memory
+	for i := 0; i < 250; i++ {
+		d.seen(fmt.Sprintf("filler-%d", i))
+	}
+	if d.seen("b") {
+		t.Error("Expected a line outside the window to be forgotten")
+	}
+}
+
+func TestDedupFilterFalsePositiveRate(t *testing.T) {
+	d := newDedupFilter(10000)
+	for i := 0; i < 10000; i++ {
+		d.seen(fmt.Sprintf("line-%d", i))
+	}
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if d.seen(fmt.Sprintf("other-%d", i)) {
+			fp++
+		}
+	}
+	// Documented as about 1% per generation; allow some slack
+	if fp > 300 {
+		t.Errorf("False positive rate too high: %d/10000", fp)
+	}
+}
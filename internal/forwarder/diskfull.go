@@ -0,0 +1,60 @@
+package forwarder
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"syscall"
+	"time"
+
+	"katalog/internal/metrics"
+)
+
+// How often a blocked write is retried while the disk is full.
+var diskFullRetryInterval = time.Second
+
+// diskFullWriter sits between the bufio.Writer and the transport. A full
+// disk (ENOSPC) is logged once and reported through the DiskFull gauge
+// instead of failing every write; bufio.Writer errors are sticky, so
+// handling it below the buffer keeps the writer usable once space frees up.
+type diskFullWriter struct {
+	io.WriteCloser
+	block bool
+	full  bool
+}
+
+// GuardDiskFull wraps w so that ENOSPC either blocks and retries the write
+// until it succeeds (block=true, applying backpressure to the pipeline) or
+// drops the data until space is available again.
+func GuardDiskFull(w io.WriteCloser, block bool) io.WriteCloser {
+	return &diskFullWriter{WriteCloser: w, block: block}
+}
+
+func (d *diskFullWriter) Write(p []byte) (int, error) {
+	written := 0
+	for {
+		n, err := d.WriteCloser.Write(p[written:])
+		written += n
+		if !errors.Is(err, syscall.ENOSPC) {
+			if err == nil && d.full {
+				d.full = false
+				metrics.DiskFull.Set(0)
+				slog.Info("Output has free space again, resuming writes")
+			}
+			return written, err
+		}
+		if !d.full {
+			d.full = true
+			metrics.DiskFull.Set(1)
+			if d.block {
+				slog.Warn("Output disk is full, pausing writes until space frees up", "error", err)
+			} else {
+				slog.Warn("Output disk is full, dropping entries until space frees up", "error", err)
+			}
+		}
+		if !d.block {
+			return len(p), nil
+		}
+		time.Sleep(diskFullRetryInterval)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package forwarder
+
+import "os"
+
+// fileID has no inode to report here; checkpoints match on the path alone.
+func fileID(fi os.FileInfo) uint64 {
+	return 0
+}
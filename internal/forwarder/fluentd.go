@@ -0,0 +1,357 @@
+package forwarder
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// FluentdSerializer writes each entry as one line of JSON holding its
+// Fluentd tag, time and record, for the fluentd transport to pack into
+// forward protocol messages. The tag is the sourcetype followed by the
+// source file name, e.g. "app.app.log", so Fluentd can route on the
+// target with a pattern like "app.**". The record has the event as
+// "message", plus "host", "source" and the custom fields.
+type FluentdSerializer struct{}
+
+type fluentdLine struct {
+	Tag    string            `json:"tag"`
+	Time   int64             `json:"time"` // unix nanoseconds
+	Record map[string]string `json:"record"`
+}
+
+func (FluentdSerializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	record := make(map[string]string, len(entry.Fields)+3)
+	for k, v := range entry.Fields {
+		record[k] = v
+	}
+	record["message"] = entry.Event
+	record["host"] = entry.Host
+	record["source"] = entry.Source
+	b, err := json.Marshal(fluentdLine{
+		Tag:    entry.SourceType + "." + entry.Source,
+		Time:   entry.Timestamp().UnixNano(),
+		Record: record,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// FluentdOutputConfig configures the fluentd transport. RequireAck waits
+// up to AckTimeout (0 means 30s) for the server to acknowledge every
+// chunk. SharedKey, and Username and Password when the server asks for
+// them, authenticate in the forward protocol's handshake. TLS encrypts the
+// connection, verifying the server against CAFile, when set, on top of the
+// system roots.
+type FluentdOutputConfig struct {
+	Addr               string
+	RequireAck         bool
+	AckTimeout         time.Duration
+	SharedKey          string
+	Username           string
+	Password           string
+	TLS                bool
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+// fluentdTransport keeps one connection to a Fluentd (or Fluent Bit)
+// forward input, dialing it on first use and again after a failure, and
+// sends every batch as one PackedForward message per tag. A failure is
+// retried from the first message not yet sent (or acknowledged) on a new
+// connection with exponential backoff, holding up the writer meanwhile;
+// the rest of the batch is dropped after MaxRetries. With acks, delivery
+// is at least once: a chunk whose ack was lost is sent again. Events are
+// counted in katalog_fluentd_events_total as sent, acked or failed.
+type fluentdTransport struct {
+	retrier
+	cfg       FluentdOutputConfig
+	tlsConfig *tls.Config // nil without TLS
+	hostname  string
+	conn      net.Conn
+	r         *bufio.Reader
+}
+
+// NewFluentdTransport returns a transport to the forward input at cfg.Addr
+// (host:port). It does not connect until the first Write.
+func NewFluentdTransport(cfg FluentdOutputConfig) (io.WriteCloser, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("fluentd transport requires an address")
+	}
+	if cfg.AckTimeout <= 0 {
+		cfg.AckTimeout = 30 * time.Second
+	}
+	f := &fluentdTransport{retrier: newRetrier(10), cfg: cfg}
+	f.hostname, _ = os.Hostname()
+	if cfg.TLS {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		f.tlsConfig = &tls.Config{ServerName: host, InsecureSkipVerify: cfg.InsecureSkipVerify}
+		if cfg.CAFile != "" {
+			pem, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read fluentd ca_file: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in fluentd ca_file %s", cfg.CAFile)
+			}
+			f.tlsConfig.RootCAs = pool
+		}
+	}
+	return f, nil
+}
+
+// fluentdMessage is one PackedForward message, the number of events in it
+// and the serialized entries it was packed from.
+type fluentdMessage struct {
+	tag     string
+	events  int
+	chunk   string // ack id, empty without acks
+	data    []byte
+	entries [][]byte
+}
+
+func (f *fluentdTransport) Write(p []byte) (int, error) {
+	msgs, err := f.pack(p)
+	if err != nil {
+		return 0, err
+	}
+	err = f.retry("fluentd", func() error {
+		for len(msgs) > 0 {
+			if err := f.send(msgs[0]); err != nil {
+				return err
+			}
+			msgs = msgs[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		events := 0
+		var entries [][]byte
+		for _, m := range msgs {
+			events += m.events
+			entries = append(entries, m.entries...)
+		}
+		slog.Error("Dropping fluentd events", "addr", f.cfg.Addr, "events", events, "error", err)
+		metrics.FluentdEvents.WithLabelValues("failed").Add(float64(events))
+		f.giveUp(entries, err)
+	}
+	return len(p), nil
+}
+
+// pack groups the serialized entries in p by tag, in order of first
+// appearance, into PackedForward messages: [tag, entries, option], where
+// entries is the concatenated MessagePack of every [time, record].
+func (f *fluentdTransport) pack(p []byte) ([]fluentdMessage, error) {
+	var tags []string
+	entries := make(map[string][]byte)
+	lines := make(map[string][][]byte)
+	for _, line := range batchEntries(p) {
+		var l fluentdLine
+		if err := json.Unmarshal(line, &l); err != nil {
+			return nil, fmt.Errorf("fluentd: invalid entry: %w", err)
+		}
+		if _, ok := entries[l.Tag]; !ok {
+			tags = append(tags, l.Tag)
+		}
+		b := mpAppendArrayHeader(entries[l.Tag], 2)
+		b = mpAppendEventTime(b, time.Unix(0, l.Time))
+		b = mpAppendMapHeader(b, len(l.Record))
+		for k, v := range l.Record {
+			b = mpAppendString(mpAppendString(b, k), v)
+		}
+		entries[l.Tag] = b
+		lines[l.Tag] = append(lines[l.Tag], line)
+	}
+	msgs := make([]fluentdMessage, len(tags))
+	for i, tag := range tags {
+		m := fluentdMessage{tag: tag, events: len(lines[tag]), entries: lines[tag]}
+		options := 1
+		if f.cfg.RequireAck {
+			id := make([]byte, 16)
+			rand.Read(id)
+			m.chunk = base64.StdEncoding.EncodeToString(id)
+			options++
+		}
+		b := mpAppendArrayHeader(nil, 3)
+		b = mpAppendString(b, tag)
+		b = mpAppendBin(b, entries[tag])
+		b = mpAppendMapHeader(b, options)
+		b = mpAppendInt(mpAppendString(b, "size"), int64(m.events))
+		if m.chunk != "" {
+			b = mpAppendString(mpAppendString(b, "chunk"), m.chunk)
+		}
+		m.data = b
+		msgs[i] = m
+	}
+	return msgs, nil
+}
+
+// send writes m, connecting first if needed, and waits for its ack when
+// acks are required. On failure the connection is dropped.
+func (f *fluentdTransport) send(m fluentdMessage) error {
+	if f.conn == nil {
+		if err := f.connect(); err != nil {
+			metrics.OutputErrors.WithLabelValues("fluentd", "connect").Inc()
+			return err
+		}
+	}
+	err := f.write(m)
+	if err != nil {
+		f.disconnect()
+	}
+	return err
+}
+
+func (f *fluentdTransport) write(m fluentdMessage) error {
+	f.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := f.conn.Write(m.data); err != nil {
+		metrics.OutputErrors.WithLabelValues("fluentd", "write").Inc()
+		return err
+	}
+	metrics.FluentdEvents.WithLabelValues("sent").Add(float64(m.events))
+	if m.chunk == "" {
+		return nil
+	}
+	f.conn.SetReadDeadline(time.Now().Add(f.cfg.AckTimeout))
+	resp, err := mpDecode(f.r)
+	if err != nil {
+		metrics.OutputErrors.WithLabelValues("fluentd", "ack").Inc()
+		return fmt.Errorf("waiting for ack: %w", err)
+	}
+	if ack, _ := resp.(map[string]any)["ack"].(string); ack != m.chunk {
+		metrics.OutputErrors.WithLabelValues("fluentd", "ack").Inc()
+		return fmt.Errorf("unexpected ack %v for chunk %s", resp, m.chunk)
+	}
+	metrics.FluentdEvents.WithLabelValues("acked").Add(float64(m.events))
+	return nil
+}
+
+// connect dials the server and, with a shared key, runs the handshake.
+func (f *fluentdTransport) connect() error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if f.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", f.cfg.Addr, f.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", f.cfg.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	f.conn, f.r = conn, bufio.NewReader(conn)
+	if f.cfg.SharedKey == "" {
+		return nil
+	}
+	if err := f.handshake(); err != nil {
+		f.disconnect()
+		return fmt.Errorf("handshake: %w", err)
+	}
+	return nil
+}
+
+// handshake answers the server's HELO with a PING and checks its PONG, as
+// specified by the forward protocol: both sides prove they know the shared
+// key by hashing it with a salt, their hostname and the server's nonce.
+func (f *fluentdTransport) handshake() error {
+	f.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer f.conn.SetDeadline(time.Time{})
+
+	helo, err := mpDecode(f.r)
+	if err != nil {
+		return err
+	}
+	msg, _ := helo.([]any)
+	if len(msg) < 2 || msg[0] != "HELO" {
+		return fmt.Errorf("expected HELO, got %v", helo)
+	}
+	opts, _ := msg[1].(map[string]any)
+	nonce, auth := mpBytes(opts["nonce"]), mpBytes(opts["auth"])
+
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	digest := func(parts ...[]byte) string {
+		h := sha512.New()
+		for _, p := range parts {
+			h.Write(p)
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	key := []byte(f.cfg.SharedKey)
+	password := ""
+	if len(auth) > 0 {
+		password = digest(auth, []byte(f.cfg.Username), []byte(f.cfg.Password))
+	}
+	ping := mpAppendArrayHeader(nil, 6)
+	ping = mpAppendString(ping, "PING")
+	ping = mpAppendString(ping, f.hostname)
+	ping = mpAppendBin(ping, salt)
+	ping = mpAppendString(ping, digest(salt, []byte(f.hostname), nonce, key))
+	ping = mpAppendString(ping, f.cfg.Username)
+	ping = mpAppendString(ping, password)
+	if _, err := f.conn.Write(ping); err != nil {
+		return err
+	}
+
+	pong, err := mpDecode(f.r)
+	if err != nil {
+		return err
+	}
+	msg, _ = pong.([]any)
+	if len(msg) < 5 || msg[0] != "PONG" {
+		return fmt.Errorf("expected PONG, got %v", pong)
+	}
+	if ok, _ := msg[1].(bool); !ok {
+		return fmt.Errorf("authentication failed: %v", msg[2])
+	}
+	server, _ := msg[3].(string)
+	if got, _ := msg[4].(string); got != digest(salt, []byte(server), nonce, key) {
+		return errors.New("server failed to prove the shared key")
+	}
+	return nil
+}
+
+// mpBytes returns a decoded string or binary value as bytes.
+func mpBytes(v any) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	}
+	return nil
+}
+
+func (f *fluentdTransport) disconnect() {
+	f.conn.Close()
+	f.conn, f.r = nil, nil
+}
+
+func (f *fluentdTransport) Close() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
@@ -0,0 +1,206 @@
+package forwarder
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// fluentdServer is a forward input requiring a shared key. It closes its
+// first connection after reading one message without acknowledging it,
+// then acknowledges everything, passing each message on.
+func fluentdServer(t *testing.T, key string) (string, <-chan []any) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	msgs := make(chan []any, 10)
+	digest := func(parts ...[]byte) string {
+		h := sha512.New()
+		for _, p := range parts {
+			h.Write(p)
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	go func() {
+		for first := true; ; first = false {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			nonce := []byte("0123456789abcdef")
+			helo := mpAppendArrayHeader(nil, 2)
+			helo = mpAppendString(helo, "HELO")
+			helo = mpAppendMapHeader(helo, 3)
+			helo = mpAppendBin(mpAppendString(helo, "nonce"), nonce)
+			helo = mpAppendBin(mpAppendString(helo, "auth"), nil)
+			helo = mpAppendBool(mpAppendString(helo, "keepalive"), true)
+			conn.Write(helo)
+
+			v, err := mpDecode(r)
+			ping, _ := v.([]any)
+			if err != nil || len(ping) != 6 || ping[0] != "PING" {
+				t.Errorf("Expected a PING, got %v (%v)", v, err)
+				conn.Close()
+				return
+			}
+			salt := mpBytes(ping[2])
+			if ping[3] != digest(salt, mpBytes(ping[1]), nonce, []byte(key)) {
+				t.Errorf("Unexpected shared key digest %v", ping[3])
+			}
+			pong := mpAppendArrayHeader(nil, 5)
+			pong = mpAppendString(pong, "PONG")
+			pong = mpAppendBool(pong, true)
+			pong = mpAppendString(pong, "")
+			pong = mpAppendString(pong, "server")
+			pong = mpAppendString(pong, digest(salt, []byte("server"), nonce, []byte(key)))
+			conn.Write(pong)
+
+			for {
+				v, err := mpDecode(r)
+				if err != nil {
+					break
+				}
+				msg, _ := v.([]any)
+				msgs <- msg
+				if first {
+					break
+				}
+				chunk := msg[2].(map[string]any)["chunk"].(string)
+				conn.Write(mpAppendString(mpAppendString(mpAppendMapHeader(nil, 1), "ack"), chunk))
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), msgs
+}
+
+func TestFluentdTransport(t *testing.T) {
+	orig := retryBase
+	retryBase = 10 * time.Millisecond
+	t.Cleanup(func() { retryBase = orig })
+
+	addr, msgs := fluentdServer(t, "secret")
+	tr, err := NewFluentdTransport(FluentdOutputConfig{Addr: addr, RequireAck: true, AckTimeout: time.Second, SharedKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	// 1. Three entries from two targets
+	var batch bytes.Buffer
+	for _, e := range []models.LogEntry{
+		{Time: 1700000000, TimeNano: 1700000000123456789, Host: "h", Source: "app.log", SourceType: "app", Event: "one", Fields: map[string]string{"env": "prod"}},
+		{Time: 1700000001, Host: "h", Source: "web.log", SourceType: "web", Event: "two"},
+		{Time: 1700000002, Host: "h", Source: "app.log", SourceType: "app", Event: "three"},
+	} {
+		if err := (FluentdSerializer{}).Serialize(&batch, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := counterValue(t, metrics.FluentdEvents.WithLabelValues("sent"))
+	acked := counterValue(t, metrics.FluentdEvents.WithLabelValues("acked"))
+	if _, err := tr.Write(batch.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2. The unacknowledged first message is sent again on a new
+	// connection, followed by the second
+	var got [][]any
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-msgs:
+			got = append(got, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected 3 messages, got %d", len(got))
+		}
+	}
+	if got[0][0] != "app.app.log" || got[1][0] != "app.app.log" || got[2][0] != "web.web.log" {
+		t.Fatalf("Unexpected tags %v, %v, %v", got[0][0], got[1][0], got[2][0])
+	}
+	if got[0][2].(map[string]any)["chunk"] != got[1][2].(map[string]any)["chunk"] {
+		t.Error("Expected the resent message to keep its chunk id")
+	}
+
+	// 3. A PackedForward message of [EventTime, record] entries
+	msg := got[1]
+	if size := msg[2].(map[string]any)["size"]; size != int64(2) {
+		t.Errorf("Expected size 2, got %v", size)
+	}
+	r := bufio.NewReader(bytes.NewReader(msg[1].([]byte)))
+	var entries [][]any
+	for {
+		v, err := mpDecode(r)
+		if err != nil {
+			break
+		}
+		entries = append(entries, v.([]any))
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", entries)
+	}
+	ts, ok := entries[0][0].(mpExt)
+	if !ok || ts.Type != 0 || len(ts.Data) != 8 {
+		t.Fatalf("Expected an EventTime, got %v", entries[0][0])
+	}
+	if sec, nsec := binary.BigEndian.Uint32(ts.Data), binary.BigEndian.Uint32(ts.Data[4:]); sec != 1700000000 || nsec != 123456789 {
+		t.Errorf("Expected time 1700000000.123456789, got %d.%d", sec, nsec)
+	}
+	record := entries[0][1].(map[string]any)
+	if record["message"] != "one" || record["host"] != "h" || record["source"] != "app.log" || record["env"] != "prod" {
+		t.Errorf("Unexpected record %v", record)
+	}
+	if record := entries[1][1].(map[string]any); record["message"] != "three" {
+		t.Errorf("Unexpected second record %v", record)
+	}
+
+	// 4. Every event is acked once, the resent one sent twice
+	if n := counterValue(t, metrics.FluentdEvents.WithLabelValues("sent")) - sent; n != 5 {
+		t.Errorf("Expected 5 sent events, got %.0f", n)
+	}
+	if n := counterValue(t, metrics.FluentdEvents.WithLabelValues("acked")) - acked; n != 3 {
+		t.Errorf("Expected 3 acked events, got %.0f", n)
+	}
+}
+
+func TestFluentdTransportDropsAfterRetries(t *testing.T) {
+	orig := retryBase
+	retryBase = time.Millisecond
+	t.Cleanup(func() { retryBase = orig })
+
+	// Nothing listens on the address
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	tr, err := NewFluentdTransport(FluentdOutputConfig{Addr: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	tr.(*fluentdTransport).policy.MaxAttempts = 3
+
+	var batch bytes.Buffer
+	FluentdSerializer{}.Serialize(&batch, models.LogEntry{Host: "h", Source: "a.log", SourceType: "a", Event: "lost"})
+	before := counterValue(t, metrics.FluentdEvents.WithLabelValues("failed"))
+	if _, err := tr.Write(batch.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if got := counterValue(t, metrics.FluentdEvents.WithLabelValues("failed")) - before; got != 1 {
+		t.Errorf("Expected 1 failed event, got %.0f", got)
+	}
+}
@@ -0,0 +1,81 @@
+package forwarder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+func TestCompileGrok(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		event    string
+		expected map[string]string
+	}{
+		{"Timestamp Level Message", `%{TIMESTAMP_ISO8601:ts} %{LOGLEVEL:level} %{GREEDYDATA:msg}`,
+			"2024-03-01T12:30:45.123Z WARN disk almost full",
+			map[string]string{"ts": "2024-03-01T12:30:45.123Z", "level": "WARN", "msg": "disk almost full"}},
+		{"Typed Fields", `%{IP:client} %{WORD:method} %{URIPATHPARAM:path} %{NUMBER:duration:float}`,
+			"10.0.0.7 GET /api/users?id=3 0.043",
+			map[string]string{"client": "10.0.0.7", "method": "GET", "path": "/api/users?id=3", "duration": "0.043"}},
+		{"IPv6", `^%{IP:client} `, "2001:db8::1 - ok",
+			map[string]string{"client": "2001:db8::1"}},
+		{"Combined Apache Log", `%{COMBINEDAPACHELOG}`,
+			`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`,
+			map[string]string{
+				"clientip": "127.0.0.1", "ident": "-", "auth": "frank", "timestamp": "10/Oct/2000:13:55:36 -0700",
+				"verb": "GET", "request": "/apache_pb.gif", "httpversion": "1.0", "response": "200", "bytes": "2326",
+				"referrer": `"http://www.example.com/start.html"`, "agent": `"Mozilla/4.08"`,
+			}},
+		{"Syslog", `%{SYSLOGBASE} %{GREEDYDATA:message}`,
+			"Mar  1 12:30:45 web-1 sshd[4242]: Accepted publickey for deploy",
+			map[string]string{"timestamp": "Mar  1 12:30:45", "logsource": "web-1", "program": "sshd", "pid": "4242", "message": "Accepted publickey for deploy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re, err := CompileGrok(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry := models.LogEntry{Event: tt.event}
+			if !ParseGrok(re)(&entry) {
+				t.Fatal("Expected the entry to be kept")
+			}
+			if !reflect.DeepEqual(entry.Fields, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, entry.Fields)
+			}
+		})
+	}
+}
+
+func TestCompileGrokErrors(t *testing.T) {
+	for pattern, want := range map[string]string{
+		`%{IP:client} %{NOPE:x}`:  "unknown grok pattern NOPE",
+		`%{IP:client.ip}`:         "invalid grok field name",
+		`%{WORD:w} (unterminated`: "missing closing )",
+	} {
+		if _, err := CompileGrok(pattern); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("CompileGrok(%q) = %v, want an error containing %q", pattern, err, want)
+		}
+	}
+}
+
+func TestParseGrokNoMatch(t *testing.T) {
+	re, err := CompileGrok(`^%{IPV4:client} %{GREEDYDATA:rest}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := map[string]string{"env": "prod"}
+	before := counterValue(t, metrics.ParseErrors.WithLabelValues("legacy", "grok"))
+	entry := models.LogEntry{SourceType: "legacy", Event: "no address here", Fields: shared}
+	if !ParseGrok(re)(&entry) || !reflect.DeepEqual(entry.Fields, shared) {
+		t.Errorf("Expected the entry to pass unchanged, got %v", entry.Fields)
+	}
+	if got := counterValue(t, metrics.ParseErrors.WithLabelValues("legacy", "grok")) - before; got != 1 {
+		t.Errorf("Expected 1 parse error, got %.0f", got)
+	}
+}
@@ -0,0 +1,136 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// hecEventPath is Splunk's HTTP Event Collector endpoint, appended to a
+// url without a path.
+const hecEventPath = "/services/collector/event"
+
+// hecGzipThreshold is the body size above which a batch is gzipped when no
+// compression is configured.
+var hecGzipThreshold = 64 << 10
+
+// HECSerializer writes Splunk HEC events, one JSON object per line, with
+// custom fields as indexed fields and an optional index.
+type HECSerializer struct {
+	Index string
+}
+
+type hecEvent struct {
+	Time       int64             `json:"time"`
+	Host       string            `json:"host"`
+	Source     string            `json:"source"`
+	SourceType string            `json:"sourcetype"`
+	Index      string            `json:"index,omitempty"`
+	Event      string            `json:"event"`
+	Fields     map[string]string `json:"fields,omitempty"`
+}
+
+func (s HECSerializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	b, err := json.Marshal(hecEvent{
+		Time:       entry.Time,
+		Host:       entry.Host,
+		Source:     entry.Source,
+		SourceType: entry.SourceType,
+		Index:      s.Index,
+		Event:      entry.Event,
+		Fields:     entry.Fields,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// hecTransport POSTs every Write, a batch of newline-separated HEC events,
+// as one request. Retryable failures (see retryable), such as a busy HEC's
+// 503s, are retried with exponential backoff, holding up the writer
+// meanwhile; other failures, and batches still failing after MaxRetries, are
+// dropped. Events are counted in katalog_hec_events_total as acknowledged or
+// failed.
+type hecTransport struct {
+	retrier
+	url         string
+	token       string
+	client      *http.Client
+	compression BodyCompression
+}
+
+// NewHECTransport returns a transport to the HEC at rawURL, authenticating
+// with token. Without a compression Kind, batches above 64KB are gzipped.
+func NewHECTransport(rawURL, token string, compression BodyCompression) (io.WriteCloser, error) {
+	if rawURL == "" || token == "" {
+		return nil, fmt.Errorf("hec transport requires a url and a token")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hec url: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = hecEventPath
+	}
+	if compression.Kind == "" {
+		compression = BodyCompression{Kind: "gzip", MinBytes: hecGzipThreshold}
+	}
+	return &hecTransport{retrier: newRetrier(10), url: u.String(), token: token, client: &http.Client{Timeout: 10 * time.Second}, compression: compression}, nil
+}
+
+func (h *hecTransport) Write(p []byte) (int, error) {
+	events := float64(bytes.Count(p, []byte("\n")))
+	body, encoding, err := h.compression.body("hec", p)
+	if err != nil {
+		return 0, err
+	}
+	if err := h.retry("hec", func() error { return h.post(body, encoding) }); err != nil {
+		slog.Error("Dropping HEC batch", "url", h.url, "events", events, "error", err)
+		metrics.HECEvents.WithLabelValues("failed").Add(events)
+		h.giveUp(batchEntries(p), err)
+		return len(p), nil
+	}
+	metrics.HECEvents.WithLabelValues("acknowledged").Add(events)
+	return len(p), nil
+}
+
+// post sends one request.
+func (h *hecTransport) post(body []byte, encoding string) error {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Authorization", "Splunk "+h.token)
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		metrics.OutputErrors.WithLabelValues("hec", "error").Inc()
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	metrics.OutputErrors.WithLabelValues("hec", strconv.Itoa(resp.StatusCode)).Inc()
+	return newStatusError(resp, bytes.TrimSpace(msg))
+}
+
+func (h *hecTransport) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,115 @@
+package forwarder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+func TestHECTransport(t *testing.T) {
+	orig, origThreshold := retryBase, hecGzipThreshold
+	retryBase, hecGzipThreshold = 10*time.Millisecond, 200
+	t.Cleanup(func() { retryBase, hecGzipThreshold = orig, origThreshold })
+
+	// 1. A HEC that is busy for the first request
+	var mu sync.Mutex
+	var events []hecEvent
+	busy := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != hecEventPath || r.Header.Get("Authorization") != "Splunk secret" {
+			t.Errorf("Unexpected request to %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if busy > 0 {
+			busy--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("Invalid gzip body: %v", err)
+				return
+			}
+			body = zr
+		}
+		scanner := bufio.NewScanner(body)
+		for scanner.Scan() {
+			var e hecEvent
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Errorf("Invalid event: %v", err)
+			}
+			events = append(events, e)
+		}
+	}))
+	defer srv.Close()
+
+	tr, err := NewHECTransport(srv.URL, "secret", BodyCompression{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	acked := metrics.HECEvents.WithLabelValues("acknowledged")
+	before := counterValue(t, acked)
+
+	// 2. A batch above the gzip threshold, retried past the 503
+	s := HECSerializer{Index: "main"}
+	var batch bytes.Buffer
+	for i := 0; i < 3; i++ {
+		s.Serialize(&batch, models.LogEntry{Time: 1700000000, Host: "h", SourceType: "app", Event: strings.Repeat("x", 100), Fields: map[string]string{"env": "prod"}})
+	}
+	if _, err := tr.Write(batch.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	if e := events[0]; e.Index != "main" || e.Fields["env"] != "prod" || e.Time != 1700000000 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	if got := counterValue(t, acked) - before; got != 3 {
+		t.Errorf("Expected 3 acknowledged events, got %.0f", got)
+	}
+}
+
+func TestHECTransportDropsRejectedBatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"text":"Invalid token","code":4}`, http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	tr, err := NewHECTransport(srv.URL, "wrong", BodyCompression{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	failed := metrics.HECEvents.WithLabelValues("failed")
+	before := counterValue(t, failed)
+
+	// A 403 is not retried: the batch is dropped and counted at once
+	var batch bytes.Buffer
+	HECSerializer{}.Serialize(&batch, models.LogEntry{Event: "a"})
+	HECSerializer{}.Serialize(&batch, models.LogEntry{Event: "b"})
+	if _, err := tr.Write(batch.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if got := counterValue(t, failed) - before; got != 2 {
+		t.Errorf("Expected 2 failed events, got %.0f", got)
+	}
+}
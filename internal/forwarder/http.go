@@ -0,0 +1,110 @@
+package forwarder
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"katalog/internal/metrics"
+)
+
+// HTTPOutputConfig configures the http transport. Each batch the writer
+// flushes is POSTed to URL as one request body.
+type HTTPOutputConfig struct {
+	URL         string
+	ContentType string
+	Timeout     time.Duration // per request; 0 means 10s
+	MaxRetries  int           // per batch; 0 means 10
+	Compression BodyCompression
+	TLS         *tls.Config // for https URLs; nil means the defaults
+	Auth        *HTTPAuth   // nil means no authentication
+}
+
+// httpTransport POSTs every Write as one request. A retryable failure (see
+// retryable) is counted and retried with exponential backoff, holding up
+// the writer (and so the tailers) meanwhile; a batch that fails otherwise,
+// or still fails after MaxRetries, is logged and dropped.
+type httpTransport struct {
+	retrier
+	cfg    HTTPOutputConfig
+	client *http.Client
+	auth   *httpAuth // nil without Auth
+}
+
+func NewHTTPTransport(cfg HTTPOutputConfig) (io.WriteCloser, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("http transport requires a url")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 10
+	}
+	// A transport of its own keeps its connections apart from those of the
+	// other http transports, as in a connection pool
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg.TLS
+	client := &http.Client{Timeout: cfg.Timeout, Transport: transport}
+	h := &httpTransport{retrier: newRetrier(cfg.MaxRetries), cfg: cfg, client: client}
+	if cfg.Auth != nil {
+		auth, err := newHTTPAuth(*cfg.Auth)
+		if err != nil {
+			return nil, err
+		}
+		h.auth = auth
+	}
+	return h, nil
+}
+
+func (h *httpTransport) Write(p []byte) (int, error) {
+	body, encoding, err := h.cfg.Compression.body("http", p)
+	if err != nil {
+		return 0, err
+	}
+	if err := h.retry("http", func() error { return h.post(body, encoding) }); err != nil {
+		slog.Error("Dropping batch", "url", h.cfg.URL, "bytes", len(p), "error", err)
+		metrics.HTTPOutputDropped.Inc()
+		h.giveUp(batchEntries(p), err)
+	}
+	return len(p), nil
+}
+
+func (h *httpTransport) post(body []byte, encoding string) error {
+	req, err := http.NewRequest(http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	if h.cfg.ContentType != "" {
+		req.Header.Set("Content-Type", h.cfg.ContentType)
+	}
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if h.auth != nil {
+		h.auth.apply(req)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		metrics.HTTPOutputErrors.WithLabelValues("error").Inc()
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	io.Copy(io.Discard, resp.Body) // let the connection be reused
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		metrics.HTTPOutputErrors.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+		return newStatusError(resp, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (h *httpTransport) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
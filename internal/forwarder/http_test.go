@@ -0,0 +1,163 @@
+package forwarder
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestHTTPTransportRetries(t *testing.T) {
+	orig := retryBase
+	retryBase = 10 * time.Millisecond
+	t.Cleanup(func() { retryBase = orig })
+
+	// 1. A collector that fails twice before accepting
+	var mu sync.Mutex
+	var bodies []string
+	failures := 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("Expected the ndjson content type, got '%s'", ct)
+		}
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer srv.Close()
+
+	before := counterValue(t, metrics.HTTPOutputErrors.WithLabelValues("503"))
+	tr, err := NewHTTPTransport(HTTPOutputConfig{URL: srv.URL, ContentType: "application/x-ndjson"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	// 2. The batch is retried until accepted, not dropped
+	batch := "{\"event\":\"a\"}\n{\"event\":\"b\"}\n"
+	if n, err := tr.Write([]byte(batch)); err != nil || n != len(batch) {
+		t.Fatalf("Expected a successful write, got n=%d err=%v", n, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 || bodies[0] != batch {
+		t.Errorf("Expected the batch once, got %q", bodies)
+	}
+	if got := counterValue(t, metrics.HTTPOutputErrors.WithLabelValues("503")) - before; got != 2 {
+		t.Errorf("Expected 2 failed posts counted, got %v", got)
+	}
+}
+
+func TestHTTPTransportGivesUp(t *testing.T) {
+	orig := retryBase
+	retryBase = time.Millisecond
+	t.Cleanup(func() { retryBase = orig })
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	before := counterValue(t, metrics.HTTPOutputDropped)
+	tr, err := NewHTTPTransport(HTTPOutputConfig{URL: srv.URL, MaxRetries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	if _, err := tr.Write([]byte("line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := counterValue(t, metrics.HTTPOutputDropped) - before; got != 1 {
+		t.Errorf("Expected the batch to be counted as dropped, got %v", got)
+	}
+}
+
+func TestHTTPTransportCompression(t *testing.T) {
+	orig := retryBase
+	retryBase = time.Millisecond
+	t.Cleanup(func() { retryBase = orig })
+
+	// 1. A collector that fails the first request
+	var mu sync.Mutex
+	var bodies []string
+	failures := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("Invalid gzip body: %v", err)
+				return
+			}
+			body = zr
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Errorf("Invalid body: %v", err)
+		}
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bodies = append(bodies, r.Header.Get("Content-Encoding")+":"+string(data))
+	}))
+	defer srv.Close()
+
+	tr, err := NewHTTPTransport(HTTPOutputConfig{URL: srv.URL, Compression: BodyCompression{Kind: "gzip", MinBytes: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	raw := counterValue(t, metrics.CompressionBytes.WithLabelValues("http", "raw"))
+	compressed := counterValue(t, metrics.CompressionBytes.WithLabelValues("http", "compressed"))
+
+	// 2. A batch over the threshold is gzipped, the retry sending the same
+	// valid body; a small one is sent as is
+	large := strings.Repeat("{\"event\":\"repeated\"}\n", 20)
+	for _, batch := range []string{large, "{\"event\":\"small\"}\n"} {
+		if _, err := tr.Write([]byte(batch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"gzip:" + large, ":{\"event\":\"small\"}\n"}; !reflect.DeepEqual(bodies, want) {
+		t.Errorf("Expected %q, got %q", want, bodies)
+	}
+
+	// 3. Only the compressed batch is counted, once
+	if got := counterValue(t, metrics.CompressionBytes.WithLabelValues("http", "raw")) - raw; got != float64(len(large)) {
+		t.Errorf("Expected %d raw bytes, got %.0f", len(large), got)
+	}
+	if got := counterValue(t, metrics.CompressionBytes.WithLabelValues("http", "compressed")) - compressed; got <= 0 || got >= float64(len(large)) {
+		t.Errorf("Expected fewer compressed bytes than %d, got %.0f", len(large), got)
+	}
+}
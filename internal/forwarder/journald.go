@@ -0,0 +1,188 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"strconv"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// JournalSource is the source of journal entries without a
+// SYSLOG_IDENTIFIER.
+const JournalSource = "journald"
+
+// JournalOptions selects the journal entries a journald source reads.
+type JournalOptions struct {
+	// Units limits the entries to those of these systemd units, matched
+	// exactly on _SYSTEMD_UNIT; empty means all of them.
+	Units []string
+	// Fields are the journal fields copied into Fields, under their own
+	// names. Fields of the target take precedence.
+	Fields []string
+	// CursorKey is the key of the cursor saved in the Checkpoint store,
+	// which a restarted source resumes after.
+	CursorKey string
+}
+
+// journalctlArgs returns the arguments for journalctl to print the entries
+// jopts selects as JSON: after cursor when there is one, otherwise new ones
+// or, with ReadFromBeginning, all of them. It follows the journal unless
+// opts.Once is set.
+func journalctlArgs(opts TailOptions, jopts JournalOptions, cursor string) []string {
+	args := []string{"--output=json", "--no-pager"}
+	if !opts.Once {
+		args = append(args, "--follow")
+	}
+	switch {
+	case cursor != "":
+		args = append(args, "--after-cursor="+cursor)
+	case !opts.ReadFromBeginning:
+		args = append(args, "--lines=0")
+	}
+	// journalctl ORs matches on the same field
+	for _, unit := range jopts.Units {
+		args = append(args, "_SYSTEMD_UNIT="+unit)
+	}
+	return args
+}
+
+// journalRecord is what a journal entry sets on top of the entry built from
+// its MESSAGE.
+type journalRecord struct {
+	host   string
+	source string
+	time   time.Time
+	fields map[string]string
+}
+
+// apply is the processor giving the entry being emitted the details of the
+// journal entry it came from.
+func (r *journalRecord) apply(entry *models.LogEntry) bool {
+	if r.host != "" {
+		entry.Host = r.host
+	}
+	entry.Source = r.source
+	if !r.time.IsZero() {
+		entry.Time = r.time.Unix()
+		entry.TimeNano = r.time.UnixNano()
+	}
+	if len(r.fields) > 0 {
+		fields := make(map[string]string, len(entry.Fields)+len(r.fields))
+		maps.Copy(fields, r.fields)
+		maps.Copy(fields, entry.Fields)
+		entry.Fields = fields
+	}
+	return true
+}
+
+// parseJournalEntry reads one entry of journalctl's JSON output: MESSAGE,
+// the cursor and the record of the rest, with the fields named by want.
+func parseJournalEntry(line []byte, want []string) (msg, cursor string, rec journalRecord, err error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return "", "", rec, err
+	}
+	msg, _ = journalValue(raw["MESSAGE"])
+	cursor, _ = journalValue(raw["__CURSOR"])
+	rec.host, _ = journalValue(raw["_HOSTNAME"])
+	rec.source = JournalSource
+	if id, ok := journalValue(raw["SYSLOG_IDENTIFIER"]); ok && id != "" {
+		rec.source = id
+	}
+	if ts, ok := journalValue(raw["__REALTIME_TIMESTAMP"]); ok {
+		if usec, err := strconv.ParseInt(ts, 10, 64); err == nil {
+			rec.time = time.UnixMicro(usec)
+		}
+	}
+	for _, name := range want {
+		if v, ok := journalValue(raw[name]); ok {
+			if rec.fields == nil {
+				rec.fields = make(map[string]string, len(want))
+			}
+			rec.fields[name] = v
+		}
+	}
+	return msg, cursor, rec, nil
+}
+
+// journalValue decodes a field of journalctl's JSON output: a string, an
+// array of bytes for a value that isn't valid UTF-8, or an array of those
+// for a field set more than once, of which the first is taken.
+func journalValue(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 {
+		return "", false
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, true
+	}
+	var b []byte
+	var ints []int
+	if json.Unmarshal(raw, &ints) == nil {
+		for _, c := range ints {
+			b = append(b, byte(c))
+		}
+		return string(b), true
+	}
+	var values []json.RawMessage
+	if json.Unmarshal(raw, &values) == nil && len(values) > 0 {
+		return journalValue(values[0])
+	}
+	return "", false
+}
+
+// readJournal emits an entry for every journal entry r, journalctl's JSON
+// output, holds, until it ends or the tailer is aborted. Each journal entry
+// is one log entry: its MESSAGE goes through the target's filters and
+// processors but not multiline assembly. The cursor of each one handed to
+// the writer is saved.
+func readJournal(t *tailer, r io.Reader, jopts JournalOptions) error {
+	var rec journalRecord
+	t.processors = append([]Processor{rec.apply}, t.processors...)
+	lr := newLineReader(r, '\n', 0)
+	for {
+		line, err := lr.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		msg, cursor, next, err := parseJournalEntry(line, jopts.Fields)
+		if err != nil {
+			metrics.FileErrors.WithLabelValues(t.path, "parse").Inc()
+			slog.Warn("Skipping unreadable journal entry", "source", t.path, "error", err)
+			continue
+		}
+		rec = next
+		t.refreshPatterns()
+		if m := bytes.TrimSpace([]byte(msg)); !t.excluded(m) {
+			if !t.emit(string(m), t.done) || !t.releaseReordered(false, t.done) {
+				return nil
+			}
+		}
+		if cursor == "" {
+			continue
+		}
+		t.journalCursor = cursor
+		if t.opts.Checkpoint == nil {
+			continue
+		}
+		// Entries held for reordering haven't been handed over yet: resume
+		// before the first one read
+		if t.reorder != nil {
+			if from, ok := t.reorder.resume(); ok {
+				cursor = from.cursor
+			}
+		}
+		if cursor != "" {
+			t.opts.Checkpoint.SetCursor(jopts.CursorKey, cursor)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+//go:build linux
+
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"sync"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// JournalSupported reports whether journald sources can be read here.
+const JournalSupported = true
+
+// journalctlPath is the journalctl run to read the journal.
+var journalctlPath = "journalctl"
+
+// ReadJournal reads the systemd journal through journalctl, as jopts
+// selects, until ctx is done or, with opts.Once, the end is reached. name
+// labels the source's metrics. The start of journalctl, and its exit while
+// following, are reported through opts.OnOpen, so that the agent restarts it
+// after the saved cursor.
+func ReadJournal(ctx context.Context, wg *sync.WaitGroup, name string, out chan<- models.LogEntry, opts TailOptions, jopts JournalOptions) {
+	defer wg.Done()
+
+	t := newStreamTailer(ctx, name, out, opts)
+	var cursor string
+	if opts.Checkpoint != nil {
+		cursor, _ = opts.Checkpoint.Cursor(jopts.CursorKey)
+	}
+	cmd := exec.CommandContext(ctx, journalctlPath, journalctlArgs(opts, jopts, cursor)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if opts.OnOpen != nil {
+		opts.OnOpen(err)
+	}
+	if err != nil {
+		metrics.FileErrors.WithLabelValues(name, "open").Inc()
+		slog.Error("Error starting journalctl", "source", name, "error", err)
+		return
+	}
+	slog.Debug("Reading journal", "source", name, "cursor", cursor)
+
+	readErr := readJournal(t, stdout, jopts)
+	if readErr != nil {
+		metrics.FileErrors.WithLabelValues(name, "read").Inc()
+		slog.Error("Error reading journal", "source", name, "error", readErr)
+	}
+	// Stops journalctl if reading ended first
+	waitErr := cmd.Wait()
+	t.releaseReordered(true, t.deadline)
+	if ctx.Err() != nil || (opts.Once && waitErr == nil && readErr == nil) {
+		return
+	}
+	if waitErr == nil {
+		waitErr = fmt.Errorf("end of output")
+	}
+	if opts.OnOpen != nil {
+		opts.OnOpen(errJournalExited(waitErr, stderr.Bytes()))
+	}
+}
+
+// errJournalExited reports journalctl stopping while it should follow.
+func errJournalExited(err error, stderr []byte) error {
+	if msg := bytes.TrimSpace(stderr); len(msg) > 0 {
+		return fmt.Errorf("journalctl exited: %v: %s", err, msg)
+	}
+	return fmt.Errorf("journalctl exited: %v", err)
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"katalog/internal/models"
+)
+
+// JournalSupported reports whether journald sources can be read here.
+const JournalSupported = false
+
+// ReadJournal only reports, through opts.OnOpen, that there is no journal
+// to read where systemd isn't available.
+func ReadJournal(ctx context.Context, wg *sync.WaitGroup, name string, out chan<- models.LogEntry, opts TailOptions, jopts JournalOptions) {
+	defer wg.Done()
+	if opts.OnOpen != nil {
+		opts.OnOpen(errors.New("the journal can only be read on Linux"))
+	}
+}
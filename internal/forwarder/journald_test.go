@@ -0,0 +1,131 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"katalog/internal/models"
+)
+
+func TestReadJournal(t *testing.T) {
+	store, err := OpenCheckpointStore(filepath.Join(t.TempDir(), "checkpoints.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal := strings.Join([]string{
+		`{"__CURSOR":"c1","__REALTIME_TIMESTAMP":"1700000000123456","_HOSTNAME":"web1","SYSLOG_IDENTIFIER":"nginx","_SYSTEMD_UNIT":"nginx.service","_PID":"42","MESSAGE":"started"}`,
+		`{"__CURSOR":"c2","_HOSTNAME":"web1","SYSLOG_IDENTIFIER":"nginx","MESSAGE":"debug: noise"}`,
+		`not json`,
+		`{"__CURSOR":"c3","_SYSTEMD_UNIT":["a.service","b.service"],"MESSAGE":[104,105,255]}`,
+		"",
+	}, "\n")
+
+	out := make(chan models.LogEntry, 10)
+	opts := TailOptions{
+		GroupName:      "system",
+		Hostname:       "agent-host",
+		ExcludeRegexes: []*regexp.Regexp{regexp.MustCompile("^debug:")},
+		CustomFields:   map[string]string{"env": "prod", "_PID": "kept"},
+		Checkpoint:     store,
+	}
+	tl := newStreamTailer(context.Background(), "journald:system", out, opts)
+	jopts := JournalOptions{Fields: []string{"_SYSTEMD_UNIT", "_PID"}, CursorKey: "journald:system"}
+	if err := readJournal(tl, strings.NewReader(journal), jopts); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	var got []models.LogEntry
+	for e := range out {
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 entries, got %d: %+v", len(got), got)
+	}
+
+	// 1. The journal's details override the tailer's, target fields win
+	first := got[0]
+	if first.Event != "started" || first.Host != "web1" || first.Source != "nginx" || first.SourceType != "system" {
+		t.Errorf("Unexpected entry: %+v", first)
+	}
+	if want := time.UnixMicro(1700000000123456); !first.Timestamp().Equal(want) {
+		t.Errorf("Expected the journal's time %v, got %v", want, first.Timestamp())
+	}
+	if want := map[string]string{"env": "prod", "_PID": "kept", "_SYSTEMD_UNIT": "nginx.service"}; !reflect.DeepEqual(first.Fields, want) {
+		t.Errorf("Expected fields %v, got %v", want, first.Fields)
+	}
+
+	// 2. Without them, the agent's host and a generic source; byte arrays
+	// and repeated fields are decoded
+	second := got[1]
+	if second.Event != "hi\xff" || second.Host != "agent-host" || second.Source != JournalSource {
+		t.Errorf("Unexpected entry: %+v", second)
+	}
+	if second.Fields["_SYSTEMD_UNIT"] != "a.service" {
+		t.Errorf("Expected the first value of a repeated field, got %q", second.Fields["_SYSTEMD_UNIT"])
+	}
+
+	// 3. The cursor of the last entry, even an excluded one, is saved
+	if cursor, ok := store.Cursor("journald:system"); !ok || cursor != "c3" {
+		t.Errorf("Expected cursor c3, got %q", cursor)
+	}
+}
+
+func TestReadJournalReorder(t *testing.T) {
+	store, err := OpenCheckpointStore(filepath.Join(t.TempDir(), "checkpoints.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan models.LogEntry, 10)
+	tl := newStreamTailer(context.Background(), "journald:system", out, TailOptions{ReorderWindow: time.Hour, Checkpoint: store})
+	jopts := JournalOptions{CursorKey: "journald:system"}
+	read := func(cursors ...string) {
+		t.Helper()
+		var journal strings.Builder
+		for _, c := range cursors {
+			fmt.Fprintf(&journal, `{"__CURSOR":"%s","MESSAGE":"%s"}`+"\n", c, c)
+		}
+		if err := readJournal(tl, strings.NewReader(journal.String()), jopts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 1. Nothing is saved while the first entries are held
+	read("c1", "c2")
+	if cursor, ok := store.Cursor("journald:system"); ok {
+		t.Errorf("Expected no cursor while c1 is held, got %q", cursor)
+	}
+
+	// 2. With c1 and c2 handed over, the cursor stops before the first entry
+	// still held
+	tl.releaseReordered(true, nil)
+	read("c3", "c4")
+	if cursor, ok := store.Cursor("journald:system"); !ok || cursor != "c2" {
+		t.Errorf("Expected cursor c2, got %q", cursor)
+	}
+}
+
+func TestJournalctlArgs(t *testing.T) {
+	jopts := JournalOptions{Units: []string{"a.service", "b.service"}}
+	tests := []struct {
+		name   string
+		opts   TailOptions
+		cursor string
+		want   []string
+	}{
+		{"new entries", TailOptions{}, "", []string{"--output=json", "--no-pager", "--follow", "--lines=0", "_SYSTEMD_UNIT=a.service", "_SYSTEMD_UNIT=b.service"}},
+		{"after cursor", TailOptions{ReadFromBeginning: true}, "c1", []string{"--output=json", "--no-pager", "--follow", "--after-cursor=c1", "_SYSTEMD_UNIT=a.service", "_SYSTEMD_UNIT=b.service"}},
+		{"once", TailOptions{Once: true, ReadFromBeginning: true}, "", []string{"--output=json", "--no-pager", "_SYSTEMD_UNIT=a.service", "_SYSTEMD_UNIT=b.service"}},
+	}
+	for _, tt := range tests {
+		if got := journalctlArgs(tt.opts, jopts, tt.cursor); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// KafkaSerializer writes each entry as one line of JSON holding its
+// message key and JSON-encoded value, for the kafka transport to produce.
+// The key is the KeyField custom field when set and present, else the
+// target name, so related entries land on the same partition.
+type KafkaSerializer struct {
+	KeyField string
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (s KafkaSerializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	key := entry.SourceType
+	if v, ok := entry.Fields[s.KeyField]; ok && s.KeyField != "" {
+		key = v
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(kafkaRecord{Key: key, Value: value})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// KafkaOutputConfig configures the kafka transport.
+type KafkaOutputConfig struct {
+	Brokers         []string
+	Topic           string
+	Acks            string        // none, one or all (default)
+	Linger          time.Duration // how long the producer waits to fill a batch; 0 means 10ms
+	MaxRetries      int           // per batch; 0 means 3
+	DeadLetterTopic string        // where messages go once retries are exhausted
+	SASLMechanism   string        // plain, scram-sha-256 or scram-sha-512
+	Username        string
+	Password        string
+	TLS             bool
+	TLSConfig       *tls.Config // replaces TLS's default config when set
+}
+
+// kafkaTransport produces every batch the writer flushes, one message per
+// entry, and waits for it to be acknowledged. Failed messages are produced
+// again with exponential backoff, unless the broker rejected them for good;
+// those still failing after MaxRetries are counted and, when a dead-letter
+// topic is set, produced there once instead.
+type kafkaTransport struct {
+	retrier
+	w   *kafka.Writer
+	dlq *kafka.Writer
+}
+
+func NewKafkaTransport(cfg KafkaOutputConfig) (io.WriteCloser, error) {
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return nil, fmt.Errorf("kafka transport requires brokers and a topic")
+	}
+	acks := kafka.RequireAll
+	switch cfg.Acks {
+	case "", "all":
+	case "one":
+		acks = kafka.RequireOne
+	case "none":
+		acks = kafka.RequireNone
+	default:
+		return nil, fmt.Errorf("unknown kafka acks: %s", cfg.Acks)
+	}
+	if cfg.Linger <= 0 {
+		cfg.Linger = 10 * time.Millisecond
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 3
+	}
+	transport := &kafka.Transport{}
+	switch {
+	case cfg.TLSConfig != nil:
+		transport.TLS = cfg.TLSConfig
+	case cfg.TLS:
+		transport.TLS = &tls.Config{}
+	}
+	if cfg.SASLMechanism != "" {
+		mechanism, err := kafkaSASL(cfg.SASLMechanism, cfg.Username, cfg.Password)
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+	writer := func(topic string) *kafka.Writer {
+		return &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: acks,
+			BatchTimeout: cfg.Linger,
+			// The writer upstream already batches: send each of its
+			// batches whole
+			BatchSize: 1 << 16,
+			// Retries are ours, for the failed messages only
+			MaxAttempts: 1,
+			Transport:   transport,
+		}
+	}
+	t := &kafkaTransport{retrier: newRetrier(cfg.MaxRetries), w: writer(cfg.Topic)}
+	if cfg.DeadLetterTopic != "" {
+		t.dlq = writer(cfg.DeadLetterTopic)
+	}
+	return t, nil
+}
+
+func kafkaSASL(mechanism, username, password string) (sasl.Mechanism, error) {
+	switch mechanism {
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	}
+	return nil, fmt.Errorf("unknown kafka sasl mechanism: %s", mechanism)
+}
+
+func (k *kafkaTransport) Write(p []byte) (int, error) {
+	var msgs []kafka.Message
+	for _, line := range bytes.Split(bytes.TrimSuffix(p, []byte("\n")), []byte("\n")) {
+		var r kafkaRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return 0, fmt.Errorf("kafka: invalid record: %w", err)
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(r.Key), Value: r.Value})
+	}
+	failed, err := k.produce(k.w, msgs, true)
+	if len(failed) > 0 && k.dlq != nil {
+		failed, err = k.produce(k.dlq, failed, false)
+	}
+	if len(failed) > 0 {
+		entries := make([][]byte, len(failed))
+		for i, m := range failed {
+			entries[i] = m.Value
+		}
+		k.giveUp(entries, err)
+	}
+	return len(p), nil
+}
+
+// produce writes msgs with w, retrying those that fail when retry is set,
+// and returns those that failed in the end, with the last error.
+func (k *kafkaTransport) produce(w *kafka.Writer, msgs []kafka.Message, retry bool) ([]kafka.Message, error) {
+	failed := msgs
+	attempt := func() error {
+		var err error
+		failed, err = writeMessages(w, failed)
+		return err
+	}
+	var err error
+	if retry {
+		err = k.retry("kafka", attempt)
+	} else {
+		err = attempt()
+	}
+	metrics.KafkaMessages.WithLabelValues(w.Topic, "produced").Add(float64(len(msgs) - len(failed)))
+	if len(failed) > 0 {
+		metrics.KafkaMessages.WithLabelValues(w.Topic, "failed").Add(float64(len(failed)))
+		slog.Error("Failed to produce messages", "topic", w.Topic, "messages", len(failed), "error", err)
+	}
+	return failed, err
+}
+
+// writeMessages makes one attempt at writing msgs with w and returns those
+// that failed. The error is permanent when the broker rejected every one of
+// them with an error that isn't temporary.
+func writeMessages(w *kafka.Writer, msgs []kafka.Message) ([]kafka.Message, error) {
+	err := w.WriteMessages(context.Background(), msgs...)
+	if err == nil {
+		return nil, nil
+	}
+	var writeErrs kafka.WriteErrors
+	if !errors.As(err, &writeErrs) {
+		return msgs, err
+	}
+	var failed []kafka.Message
+	final := true
+	for i, e := range writeErrs {
+		if e == nil {
+			continue
+		}
+		failed = append(failed, msgs[i])
+		var ke kafka.Error
+		if !errors.As(e, &ke) || ke.Temporary() {
+			final = false
+		}
+	}
+	switch {
+	case len(failed) == 0:
+		return nil, nil
+	case final:
+		err = permanent(err)
+	}
+	return failed, err
+}
+
+func (k *kafkaTransport) Close() error {
+	err := k.w.Close()
+	if k.dlq != nil {
+		err = errors.Join(err, k.dlq.Close())
+	}
+	return err
+}
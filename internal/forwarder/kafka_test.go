@@ -0,0 +1,83 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+func TestKafkaSerializer(t *testing.T) {
+	entry := models.LogEntry{Time: 1700000000, Host: "h", SourceType: "app", Event: "hello", Fields: map[string]string{"tenant": "acme"}}
+	tests := []struct {
+		name     string
+		keyField string
+		entry    models.LogEntry
+		wantKey  string
+	}{
+		{name: "Target Name", entry: entry, wantKey: "app"},
+		{name: "Field", keyField: "tenant", entry: entry, wantKey: "acme"},
+		{name: "Missing Field", keyField: "user", entry: entry, wantKey: "app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := (KafkaSerializer{KeyField: tt.keyField}).Serialize(&buf, tt.entry); err != nil {
+				t.Fatal(err)
+			}
+			var r kafkaRecord
+			if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+				t.Fatal(err)
+			}
+			if r.Key != tt.wantKey {
+				t.Errorf("Expected key %q, got %q", tt.wantKey, r.Key)
+			}
+			var value models.LogEntry
+			if err := json.Unmarshal(r.Value, &value); err != nil || value.Event != "hello" {
+				t.Errorf("Expected the JSON-encoded entry as value, got %s (%v)", r.Value, err)
+			}
+		})
+	}
+}
+
+func TestKafkaTransportDeadLetter(t *testing.T) {
+	orig := retryBase
+	retryBase = 10 * time.Millisecond
+	t.Cleanup(func() { retryBase = orig })
+
+	// 1. A broker address nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	tr, err := NewKafkaTransport(KafkaOutputConfig{Brokers: []string{addr}, Topic: "logs", MaxRetries: 1, DeadLetterTopic: "logs-dlq"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	failed := metrics.KafkaMessages.WithLabelValues("logs", "failed")
+	dlqFailed := metrics.KafkaMessages.WithLabelValues("logs-dlq", "failed")
+	before, dlqBefore := counterValue(t, failed), counterValue(t, dlqFailed)
+
+	// 2. The batch fails on its topic and then on the dead-letter topic; the
+	// writer is never handed an error
+	var batch bytes.Buffer
+	KafkaSerializer{}.Serialize(&batch, models.LogEntry{SourceType: "app", Event: "a"})
+	KafkaSerializer{}.Serialize(&batch, models.LogEntry{SourceType: "app", Event: "b"})
+	if n, err := tr.Write(batch.Bytes()); err != nil || n != batch.Len() {
+		t.Fatalf("Write() = %d, %v", n, err)
+	}
+	if got := counterValue(t, failed) - before; got != 2 {
+		t.Errorf("Expected 2 failed messages on the topic, got %.0f", got)
+	}
+	if got := counterValue(t, dlqFailed) - dlqBefore; got != 2 {
+		t.Errorf("Expected 2 failed messages on the dead-letter topic, got %.0f", got)
+	}
+}
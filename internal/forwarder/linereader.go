@@ -0,0 +1,100 @@
+package forwarder
+
+import (
+	"bufio"
+	"io"
+	"unicode/utf8"
+)
+
+// lineReader splits a file into lines terminated by delim (usually '\n')
+// without allocating per line: lines are returned as slices into the bufio
+// buffer, or into a reused scratch buffer when a line spans reads. A
+// trailing partial line is held until its delimiter arrives instead of
+// being returned early. A line longer than max bytes is cut at max, on a
+// UTF-8 boundary, and the rest of it up to the delimiter is skipped, so it
+// is never held in memory whole.
+type lineReader struct {
+	r          *bufio.Reader
+	delim      byte
+	max        int // 0 means no limit
+	partial    []byte
+	discarding bool  // skipping the rest of a truncated line
+	pending    int64 // bytes read of the line not yet returned
+	pos        int64 // offset just past the last line returned
+	last       int64 // bytes the last line returned took in the file
+	truncated  bool  // whether the last line returned was cut short
+}
+
+func newLineReader(r io.Reader, delim byte, max int) *lineReader {
+	return &lineReader{r: bufio.NewReader(r), delim: delim, max: max}
+}
+
+// reset switches to r, read from its start, discarding any buffered or
+// partial data.
+func (lr *lineReader) reset(r io.Reader) {
+	lr.r.Reset(r)
+	lr.partial = lr.partial[:0]
+	lr.discarding = false
+	lr.pending = 0
+	lr.pos = 0
+}
+
+// next returns the next complete line, including its delimiter unless it
+// was truncated. The slice is only valid until the next call. At the end
+// of the data it returns io.EOF and keeps any unterminated remainder for a
+// later call (or rest).
+func (lr *lineReader) next() ([]byte, error) {
+	for {
+		chunk, err := lr.r.ReadSlice(lr.delim)
+		lr.pending += int64(len(chunk))
+		if !lr.discarding {
+			if err == nil && len(lr.partial) == 0 && !lr.over(len(chunk)-1) {
+				return lr.done(chunk, false), nil
+			}
+			lr.partial = append(lr.partial, chunk...)
+			if n := len(lr.partial); lr.over(n) && (err != nil || lr.over(n-1)) {
+				lr.partial = lr.partial[:lr.cut(lr.partial)]
+				lr.discarding = true
+			}
+		}
+		if err == nil {
+			line := lr.partial
+			lr.partial = line[:0]
+			return lr.done(line, lr.discarding), nil
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+}
+
+// rest returns and clears the unterminated remainder, for when no more data
+// will follow (shutdown, or a file that has been switched away from).
+func (lr *lineReader) rest() []byte {
+	line := lr.partial
+	lr.partial = lr.partial[len(lr.partial):]
+	return lr.done(line, lr.discarding)
+}
+
+// done accounts for the line about to be returned.
+func (lr *lineReader) done(line []byte, truncated bool) []byte {
+	lr.pos += lr.pending
+	lr.last, lr.pending = lr.pending, 0
+	lr.truncated, lr.discarding = truncated, false
+	return line
+}
+
+// over reports whether n bytes of content exceed the limit.
+func (lr *lineReader) over(n int) bool {
+	return lr.max > 0 && n > lr.max
+}
+
+// cut returns where to truncate b, longer than max: at max, moved back to
+// the start of the rune it falls in.
+func (lr *lineReader) cut(b []byte) int {
+	n := lr.max
+	for n > 0 && !utf8.RuneStart(b[n]) {
+		n--
+	}
+	return n
+}
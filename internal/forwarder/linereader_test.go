@@ -0,0 +1,116 @@
+package forwarder
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLineReader(t *testing.T) {
+	long := strings.Repeat("x", 10000) // Longer than the bufio buffer
+	var src bytes.Buffer
+	src.WriteString("first\n" + long + "\nsplit-")
+	lr := newLineReader(&src, '\n', 0)
+
+	for _, want := range []string{"first\n", long + "\n"} {
+		line, err := lr.next()
+		if err != nil || string(line) != want {
+			t.Fatalf("Expected %.20q, got %.20q (%v)", want, line, err)
+		}
+	}
+
+	// An unterminated line is held back until its newline arrives
+	if line, err := lr.next(); err != io.EOF || line != nil {
+		t.Fatalf("Expected EOF with the partial line held, got %q (%v)", line, err)
+	}
+	src.WriteString("line\nlast")
+	if line, err := lr.next(); err != nil || string(line) != "split-line\n" {
+		t.Fatalf("Expected the partial line completed, got %q (%v)", line, err)
+	}
+	if _, err := lr.next(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if rest := lr.rest(); string(rest) != "last" {
+		t.Errorf("Expected the unterminated remainder, got %q", rest)
+	}
+	if rest := lr.rest(); len(rest) != 0 {
+		t.Errorf("Expected rest to clear the remainder, got %q", rest)
+	}
+}
+
+// benchInput is a mix of short and long lines, roughly like an app log.
+var benchInput = strings.Repeat("2024-01-01T00:00:00Z INFO request handled path=/api/v1/items status=200 duration=3ms\n"+
+	"2024-01-01T00:00:00Z DEBUG cache hit key=items:42\n", 500)
+
+// BenchmarkReadString is the previous read loop: one string per line.
+func BenchmarkReadString(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := bufio.NewReader(strings.NewReader(benchInput))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			_ = strings.TrimSpace(line)
+		}
+	}
+}
+
+// BenchmarkLineReader is the current read loop, before any entry is built.
+func BenchmarkLineReader(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		lr := newLineReader(strings.NewReader(benchInput), '\n', 0)
+		for {
+			line, err := lr.next()
+			if err != nil {
+				break
+			}
+			_ = bytes.TrimSpace(line)
+		}
+	}
+}
+
+func TestLineReaderTruncation(t *testing.T) {
+	long := strings.Repeat("x", 10000) // Longer than the bufio buffer
+	var src bytes.Buffer
+	src.WriteString("short\n" + long + "\n" + "hellé\nexact\n" + long[:20])
+	lr := newLineReader(&src, '\n', 5)
+
+	tests := []struct {
+		want      string
+		truncated bool
+		size      int64
+	}{
+		{"short\n", false, 6},
+		{"xxxxx", true, 10001},
+		{"hell", true, 7}, // Not cut inside the é
+		{"exact\n", false, 6},
+	}
+	pos := int64(0)
+	for _, tt := range tests {
+		line, err := lr.next()
+		if err != nil || string(line) != tt.want || lr.truncated != tt.truncated {
+			t.Fatalf("Expected %q (truncated %v), got %q (%v, %v)", tt.want, tt.truncated, line, lr.truncated, err)
+		}
+		pos += tt.size
+		if lr.last != tt.size || lr.pos != pos {
+			t.Errorf("Expected %q to take %d bytes up to %d, got %d up to %d", tt.want, tt.size, pos, lr.last, lr.pos)
+		}
+	}
+
+	// The rest of a long unterminated line is skipped as it arrives
+	if _, err := lr.next(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	src.WriteString(long + "\nnext\n")
+	if line, err := lr.next(); err != nil || string(line) != "xxxxx" || !lr.truncated {
+		t.Fatalf("Expected the truncated line, got %q (%v)", line, err)
+	}
+	if line, err := lr.next(); err != nil || string(line) != "next\n" || lr.truncated {
+		t.Fatalf("Expected the next line whole, got %q (%v)", line, err)
+	}
+}
@@ -0,0 +1,38 @@
+package forwarder
+
+import (
+	"regexp"
+	"sync/atomic"
+)
+
+// Patterns are the parts of a target that can be changed while its tailers
+// keep running: the exclude, include and multiline patterns and the static
+// fields.
+type Patterns struct {
+	Exclude   []*regexp.Regexp
+	Include   []*regexp.Regexp
+	Multiline []*regexp.Regexp
+	Fields    map[string]string
+}
+
+// LivePatterns holds a target's current Patterns. Tailers load it once per
+// line and pick up a Store without being restarted, so multiline buffers
+// and read positions survive pattern tuning. A stored Patterns must not be
+// modified afterwards.
+type LivePatterns struct {
+	p atomic.Pointer[Patterns]
+}
+
+func NewLivePatterns(p Patterns) *LivePatterns {
+	l := &LivePatterns{}
+	l.Store(p)
+	return l
+}
+
+func (l *LivePatterns) Load() *Patterns {
+	return l.p.Load()
+}
+
+func (l *LivePatterns) Store(p Patterns) {
+	l.p.Store(&p)
+}
@@ -0,0 +1,148 @@
+package forwarder
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// localCopyBuffer is how many entries the local copy holds before dropping.
+const localCopyBuffer = 1000
+
+// LocalCopy keeps a rolling NDJSON copy of every entry on local disk for
+// debugging, independent of the primary output. Entries are handed over
+// through a bounded buffer and dropped when it is full, so a slow disk
+// never holds up the primary output. When the file exceeds maxSize bytes
+// or is older than maxAge it is renamed to path + ".1" (replacing the
+// previous one) and a fresh file is started.
+type LocalCopy struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+
+	ch   chan models.LogEntry
+	done chan struct{}
+
+	file   *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+}
+
+// NewLocalCopy opens (or appends to) the file at path and starts its writer.
+// Zero maxSize or maxAge disables that limit.
+func NewLocalCopy(path string, maxSize int64, maxAge time.Duration) (*LocalCopy, error) {
+	lc := &LocalCopy{
+		path:    path,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		ch:      make(chan models.LogEntry, localCopyBuffer),
+		done:    make(chan struct{}),
+	}
+	if err := lc.open(); err != nil {
+		return nil, err
+	}
+	go lc.run()
+	return lc, nil
+}
+
+// Publish queues entry for the local copy without blocking. It matches the
+// agent's tap signature.
+func (lc *LocalCopy) Publish(entry models.LogEntry) {
+	select {
+	case lc.ch <- entry:
+	default:
+		metrics.LocalCopyDropped.Inc()
+	}
+}
+
+// Close writes out what is queued and closes the file. Publish must not be
+// called afterwards.
+func (lc *LocalCopy) Close() error {
+	close(lc.ch)
+	<-lc.done
+	return lc.file.Close()
+}
+
+func (lc *LocalCopy) open() error {
+	f, err := os.OpenFile(lc.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	lc.file, lc.w, lc.size = f, bufio.NewWriter(f), fi.Size()
+	lc.opened = time.Now()
+	return nil
+}
+
+func (lc *LocalCopy) run() {
+	defer close(lc.done)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry, ok := <-lc.ch:
+			if !ok {
+				lc.w.Flush()
+				return
+			}
+			lc.write(entry)
+		case <-ticker.C:
+			if err := lc.w.Flush(); err != nil {
+				slog.Error("Error flushing local copy", "path", lc.path, "error", err)
+			}
+			if lc.maxAge > 0 && lc.size > 0 && time.Since(lc.opened) >= lc.maxAge {
+				lc.roll()
+			}
+		}
+	}
+}
+
+func (lc *LocalCopy) write(entry models.LogEntry) {
+	if lc.maxSize > 0 && lc.size >= lc.maxSize {
+		lc.roll()
+	}
+	cw := &countingWriter{w: lc.w}
+	if err := (JSONSerializer{}).Serialize(cw, entry); err != nil {
+		slog.Error("Error writing local copy", "path", lc.path, "error", err)
+	}
+	lc.size += cw.n
+}
+
+// roll moves the current file to path.1 and starts a new one. On failure
+// it keeps writing to the current file.
+func (lc *LocalCopy) roll() {
+	lc.w.Flush()
+	if err := os.Rename(lc.path, lc.path+".1"); err != nil {
+		slog.Error("Error rolling local copy", "path", lc.path, "error", err)
+		return
+	}
+	old := lc.file
+	if err := lc.open(); err != nil {
+		slog.Error("Error reopening local copy", "path", lc.path, "error", err)
+		// Keep appending to the renamed file rather than losing entries
+		lc.opened = time.Now()
+		return
+	}
+	old.Close()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,57 @@
+package forwarder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"katalog/internal/models"
+)
+
+func TestLocalCopyRollsBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "copy.ndjson")
+	// A 1 byte limit rolls before every entry after the first
+	lc, err := NewLocalCopy(path, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"first", "second", "third"} {
+		lc.Publish(models.LogEntry{Event: msg})
+	}
+	if err := lc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := os.ReadFile(path)
+	previous, _ := os.ReadFile(path + ".1")
+	if !strings.Contains(string(current), `"third"`) || strings.Count(string(current), "\n") != 1 {
+		t.Errorf("Expected only the last entry in the current file, got %q", current)
+	}
+	if !strings.Contains(string(previous), `"second"`) || strings.Contains(string(previous), `"first"`) {
+		t.Errorf("Expected only the second entry in the rolled file, got %q", previous)
+	}
+}
+
+func TestLocalCopyRollsByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "copy.ndjson")
+	lc, err := NewLocalCopy(path, 0, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc.Publish(models.LogEntry{Event: "old"})
+	time.Sleep(700 * time.Millisecond) // Let the periodic check notice the age
+	lc.Publish(models.LogEntry{Event: "new"})
+	if err := lc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	previous, err := os.ReadFile(path + ".1")
+	if err != nil || !strings.Contains(string(previous), `"old"`) {
+		t.Errorf("Expected the old entry in the rolled file, got %q (%v)", previous, err)
+	}
+	if current, _ := os.ReadFile(path); !strings.Contains(string(current), `"new"`) || strings.Contains(string(current), `"old"`) {
+		t.Errorf("Expected only the new entry in the current file, got %q", current)
+	}
+}
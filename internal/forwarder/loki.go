@@ -0,0 +1,186 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// lokiPushPath is Loki's push API, appended to a url without a path.
+const lokiPushPath = "/loki/api/v1/push"
+
+// LokiSerializer writes each entry as one line of JSON carrying its stream
+// labels, timestamp and event, for the loki transport to group into push
+// requests. sourcetype and host are always labels; Labels names the custom
+// fields that become labels too.
+type LokiSerializer struct {
+	Labels []string
+}
+
+type lokiEntry struct {
+	Stream map[string]string `json:"stream"`
+	TS     string            `json:"ts"`
+	Line   string            `json:"line"`
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// lokiLabelName makes k a valid Prometheus-style label name.
+func lokiLabelName(k string) string {
+	k = invalidLabelChars.ReplaceAllString(k, "_")
+	if k == "" || (k[0] >= '0' && k[0] <= '9') {
+		k = "_" + k
+	}
+	return k
+}
+
+func (s LokiSerializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	stream := map[string]string{"sourcetype": entry.SourceType, "host": entry.Host}
+	for _, k := range s.Labels {
+		if v, ok := entry.Fields[k]; ok {
+			stream[lokiLabelName(k)] = v
+		}
+	}
+	b, err := json.Marshal(lokiEntry{
+		Stream: stream,
+		TS:     strconv.FormatInt(entry.Timestamp().UnixNano(), 10),
+		Line:   entry.Event,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// lokiTransport groups each batch written by LokiSerializer into streams
+// and pushes it to Loki as one JSON request. Retryable failures (see
+// retryable) are retried with exponential backoff, holding up the writer
+// meanwhile; other responses, and batches still failing after MaxRetries,
+// are dropped and counted per stream.
+type lokiTransport struct {
+	retrier
+	url         string
+	client      *http.Client
+	compression BodyCompression
+}
+
+// NewLokiTransport returns a transport pushing to the Loki at rawURL.
+func NewLokiTransport(rawURL string, compression BodyCompression) (io.WriteCloser, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("loki transport requires a url")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid loki url: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = lokiPushPath
+	}
+	return &lokiTransport{retrier: newRetrier(10), url: u.String(), client: &http.Client{Timeout: 10 * time.Second}, compression: compression}, nil
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (l *lokiTransport) Write(p []byte) (int, error) {
+	streams, err := lokiStreams(p)
+	if err != nil {
+		return 0, err
+	}
+	raw, err := json.Marshal(map[string][]*lokiStream{"streams": streams})
+	if err != nil {
+		return 0, err
+	}
+	body, encoding, err := l.compression.body("loki", raw)
+	if err != nil {
+		return 0, err
+	}
+	if err := l.retry("loki", func() error { return l.push(body, encoding) }); err != nil {
+		slog.Error("Dropping Loki batch", "url", l.url, "error", err)
+		for _, s := range streams {
+			metrics.LokiDroppedLines.WithLabelValues(streamName(s.Stream)).Add(float64(len(s.Values)))
+		}
+		l.giveUp(batchEntries(p), err)
+	}
+	return len(p), nil
+}
+
+// lokiStreams groups the serialized entries in p by stream, in order of
+// first appearance.
+func lokiStreams(p []byte) ([]*lokiStream, error) {
+	var streams []*lokiStream
+	byName := make(map[string]*lokiStream)
+	for _, line := range bytes.Split(bytes.TrimSuffix(p, []byte("\n")), []byte("\n")) {
+		var e lokiEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("loki: invalid entry: %w", err)
+		}
+		name := streamName(e.Stream)
+		s, ok := byName[name]
+		if !ok {
+			s = &lokiStream{Stream: e.Stream}
+			byName[name] = s
+			streams = append(streams, s)
+		}
+		s.Values = append(s.Values, [2]string{e.TS, e.Line})
+	}
+	return streams, nil
+}
+
+// streamName renders labels in Loki's selector syntax, e.g. {host="a"}.
+func streamName(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + strconv.Quote(labels[k])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// push sends one request.
+func (l *lokiTransport) push(body []byte, encoding string) error {
+	req, err := http.NewRequest(http.MethodPost, l.url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		metrics.OutputErrors.WithLabelValues("loki", "error").Inc()
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	metrics.OutputErrors.WithLabelValues("loki", strconv.Itoa(resp.StatusCode)).Inc()
+	return newStatusError(resp, bytes.TrimSpace(msg))
+}
+
+func (l *lokiTransport) Close() error {
+	l.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,113 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+func TestLokiTransport(t *testing.T) {
+	orig := retryBase
+	retryBase = 10 * time.Millisecond
+	t.Cleanup(func() { retryBase = orig })
+
+	// 1. A Loki that rate-limits the first push
+	var mu sync.Mutex
+	var pushes []map[string][]lokiStream
+	limited := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != lokiPushPath {
+			t.Errorf("Expected a push to %s, got %s", lokiPushPath, r.URL.Path)
+		}
+		if limited > 0 {
+			limited--
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		var body map[string][]lokiStream
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Invalid push body: %v", err)
+		}
+		pushes = append(pushes, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	tr, err := NewLokiTransport(srv.URL, BodyCompression{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	// 2. Three entries over two streams, with env as an extra label
+	s := LokiSerializer{Labels: []string{"env"}}
+	var batch bytes.Buffer
+	for _, e := range []models.LogEntry{
+		{Time: 1700000000, Host: "h", SourceType: "app", Event: "one", Fields: map[string]string{"env": "prod", "user": "x"}},
+		{Time: 1700000001, Host: "h", SourceType: "web", Event: "two"},
+		{Time: 1700000002, Host: "h", SourceType: "app", Event: "three", Fields: map[string]string{"env": "prod"}},
+	} {
+		if err := s.Serialize(&batch, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tr.Write(batch.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 3. One push after the retry, grouped by stream with nanosecond timestamps
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pushes) != 1 {
+		t.Fatalf("Expected 1 accepted push, got %d", len(pushes))
+	}
+	streams := pushes[0]["streams"]
+	if len(streams) != 2 {
+		t.Fatalf("Expected 2 streams, got %+v", streams)
+	}
+	app := streams[0]
+	if name := streamName(app.Stream); name != `{env="prod",host="h",sourcetype="app"}` {
+		t.Errorf("Unexpected first stream %s", name)
+	}
+	if len(app.Values) != 2 || app.Values[0] != [2]string{"1700000000000000000", "one"} || app.Values[1][1] != "three" {
+		t.Errorf("Unexpected values %v", app.Values)
+	}
+	if name := streamName(streams[1].Stream); name != `{host="h",sourcetype="web"}` {
+		t.Errorf("Unexpected second stream %s", name)
+	}
+}
+
+func TestLokiTransportDropsRejectedBatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "entry too far behind", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	tr, err := NewLokiTransport(srv.URL+lokiPushPath, BodyCompression{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	var batch bytes.Buffer
+	LokiSerializer{}.Serialize(&batch, models.LogEntry{Host: "h", SourceType: "old", Event: "stale"})
+	counter := metrics.LokiDroppedLines.WithLabelValues(`{host="h",sourcetype="old"}`)
+	before := counterValue(t, counter)
+
+	// A 400 is not retried: the line is dropped and counted at once
+	if _, err := tr.Write(batch.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if got := counterValue(t, counter) - before; got != 1 {
+		t.Errorf("Expected 1 dropped line, got %.0f", got)
+	}
+}
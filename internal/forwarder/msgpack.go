@@ -0,0 +1,242 @@
+package forwarder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// A minimal MessagePack encoder and decoder, covering what the Fluentd
+// forward protocol needs.
+
+func mpAppendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+func mpAppendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= 0x7f:
+		return append(b, byte(v))
+	case v < 0 && v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+	}
+}
+
+// mpAppendHeader appends the header of a string, binary, array or map of n
+// items, given its fix type (0 when there is none) and 8, 16 and 32-bit
+// type bytes (0 when there is no 8-bit form).
+func mpAppendHeader(b []byte, n int, fix, fixMax, t8, t16, t32 byte) []byte {
+	switch {
+	case fix != 0 && n <= int(fixMax):
+		return append(b, fix|byte(n))
+	case t8 != 0 && n <= math.MaxUint8:
+		return append(b, t8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, t16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, t32), uint32(n))
+	}
+}
+
+func mpAppendString(b []byte, s string) []byte {
+	return append(mpAppendHeader(b, len(s), 0xa0, 31, 0xd9, 0xda, 0xdb), s...)
+}
+
+func mpAppendBin(b []byte, p []byte) []byte {
+	return append(mpAppendHeader(b, len(p), 0, 0, 0xc4, 0xc5, 0xc6), p...)
+}
+
+func mpAppendArrayHeader(b []byte, n int) []byte {
+	return mpAppendHeader(b, n, 0x90, 15, 0, 0xdc, 0xdd)
+}
+
+func mpAppendMapHeader(b []byte, n int) []byte {
+	return mpAppendHeader(b, n, 0x80, 15, 0, 0xde, 0xdf)
+}
+
+// mpAppendEventTime appends t as Fluentd's EventTime extension (type 0):
+// seconds and nanoseconds as two big-endian uint32s.
+func mpAppendEventTime(b []byte, t time.Time) []byte {
+	b = append(b, 0xd7, 0x00)
+	b = binary.BigEndian.AppendUint32(b, uint32(t.Unix()))
+	return binary.BigEndian.AppendUint32(b, uint32(t.Nanosecond()))
+}
+
+// mpExt is a decoded extension value.
+type mpExt struct {
+	Type int8
+	Data []byte
+}
+
+// mpMaxLen bounds the length of a decoded string, binary, array or map, so
+// a bad peer can't make the decoder allocate without limit.
+const mpMaxLen = 1 << 20
+
+// mpDecode reads one value: nil, bool, int64, float64, string, []byte,
+// []any, map[string]any (other keys are formatted with %v) or mpExt.
+func mpDecode(r *bufio.Reader) (any, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return mpDecodeMap(r, int(c&0x0f))
+	case c&0xf0 == 0x90:
+		return mpDecodeArray(r, int(c&0x0f))
+	case c&0xe0 == 0xa0:
+		b, err := mpRead(r, int(c&0x1f))
+		return string(b), err
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := mpReadLen(r, c-0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return mpRead(r, n)
+	case 0xca:
+		b, err := mpRead(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := mpRead(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		b, err := mpRead(r, 1<<(c-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return int64(mpUint(b)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		b, err := mpRead(r, 1<<(c-0xd0))
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend from the value's width
+		shift := 64 - 8*len(b)
+		return int64(mpUint(b)<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return mpDecodeExt(r, 1<<(c-0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := mpReadLen(r, c-0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return mpDecodeExt(r, n)
+	case 0xd9, 0xda, 0xdb:
+		n, err := mpReadLen(r, c-0xd9)
+		if err != nil {
+			return nil, err
+		}
+		b, err := mpRead(r, n)
+		return string(b), err
+	case 0xdc, 0xdd:
+		n, err := mpReadLen(r, c-0xdc+1)
+		if err != nil {
+			return nil, err
+		}
+		return mpDecodeArray(r, n)
+	case 0xde, 0xdf:
+		n, err := mpReadLen(r, c-0xde+1)
+		if err != nil {
+			return nil, err
+		}
+		return mpDecodeMap(r, n)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type byte 0x%02x", c)
+}
+
+// mpReadLen reads a big-endian length of 1, 2 or 4 bytes for size 0, 1
+// or 2.
+func mpReadLen(r *bufio.Reader, size byte) (int, error) {
+	b, err := mpRead(r, 1<<size)
+	if err != nil {
+		return 0, err
+	}
+	n := mpUint(b)
+	if n > mpMaxLen {
+		return 0, fmt.Errorf("msgpack: length %d too large", n)
+	}
+	return int(n), nil
+}
+
+func mpUint(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+func mpRead(r *bufio.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+func mpDecodeExt(r *bufio.Reader, n int) (any, error) {
+	t, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := mpRead(r, n)
+	return mpExt{Type: int8(t), Data: data}, err
+}
+
+func mpDecodeArray(r *bufio.Reader, n int) ([]any, error) {
+	a := make([]any, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := mpDecode(r)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func mpDecodeMap(r *bufio.Reader, n int) (map[string]any, error) {
+	m := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		k, err := mpDecode(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := mpDecode(r)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		m[key] = v
+	}
+	return m, nil
+}
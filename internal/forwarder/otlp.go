@@ -0,0 +1,270 @@
+package forwarder
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// otlpLogsPath is the OTLP/HTTP logs endpoint, appended to a url without a
+// path.
+const otlpLogsPath = "/v1/logs"
+
+// otlpScopeName identifies katalog as the instrumentation scope of its
+// records.
+const otlpScopeName = "katalog"
+
+// OTLPSerializer writes each entry as one line of JSON holding an OTLP log
+// record and its resource, for the otlp transport to group into export
+// requests. The event is the body and the read time the observed
+// timestamp. The host is the resource's host.name; the source file name,
+// sourcetype and custom fields are record attributes.
+type OTLPSerializer struct{}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpLogRecord struct {
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+// otlpLine is what OTLPSerializer writes for one entry.
+type otlpLine struct {
+	Resource []otlpKeyValue `json:"resource"`
+	Record   otlpLogRecord  `json:"record"`
+}
+
+func otlpAttr(k, v string) otlpKeyValue {
+	return otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: v}}
+}
+
+func (OTLPSerializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	attrs := []otlpKeyValue{otlpAttr("log.file.name", entry.Source), otlpAttr("sourcetype", entry.SourceType)}
+	keys := make([]string, 0, len(entry.Fields))
+	for k := range entry.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, otlpAttr(k, entry.Fields[k]))
+	}
+	b, err := json.Marshal(otlpLine{
+		Resource: []otlpKeyValue{otlpAttr("host.name", entry.Host)},
+		Record: otlpLogRecord{
+			ObservedTimeUnixNano: strconv.FormatInt(entry.Timestamp().UnixNano(), 10),
+			Body:                 otlpAnyValue{StringValue: entry.Event},
+			Attributes:           attrs,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// OTLPOutputConfig configures the otlp transport. Headers are added to
+// every request, e.g. for authentication. CAFile adds a CA to verify the
+// collector's certificate with, on top of the system roots.
+type OTLPOutputConfig struct {
+	URL                string
+	Headers            map[string]string
+	CAFile             string
+	InsecureSkipVerify bool
+	Compression        BodyCompression
+}
+
+// otlpTransport groups each batch written by OTLPSerializer by resource and
+// exports it as one OTLP/HTTP request with JSON encoding. Retryable
+// failures (see retryable), which include the statuses the OTLP spec marks
+// retryable (429, 502, 503, 504), are retried with exponential backoff,
+// holding up the writer meanwhile; other responses, and batches still
+// failing after MaxRetries, are dropped.
+// Records are counted in katalog_otlp_log_records_total as exported or
+// failed, including those a collector rejects in a partial success.
+type otlpTransport struct {
+	retrier
+	url         string
+	headers     map[string]string
+	client      *http.Client
+	compression BodyCompression
+}
+
+// NewOTLPTransport returns a transport exporting logs to the collector at
+// cfg.URL.
+func NewOTLPTransport(cfg OTLPOutputConfig) (io.WriteCloser, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("otlp transport requires a url")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid otlp url: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpLogsPath
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read otlp ca_file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in otlp ca_file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &otlpTransport{
+		retrier:     newRetrier(10),
+		url:         u.String(),
+		headers:     cfg.Headers,
+		client:      &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		compression: cfg.Compression,
+	}, nil
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpResourceLogs struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeLogs []*otlpScopeLogs `json:"scopeLogs"`
+}
+
+func (o *otlpTransport) Write(p []byte) (int, error) {
+	resources, records, err := otlpResources(p)
+	if err != nil {
+		return 0, err
+	}
+	raw, err := json.Marshal(map[string][]*otlpResourceLogs{"resourceLogs": resources})
+	if err != nil {
+		return 0, err
+	}
+	body, encoding, err := o.compression.body("otlp", raw)
+	if err != nil {
+		return 0, err
+	}
+	var rejected int
+	err = o.retry("otlp", func() (err error) {
+		rejected, err = o.export(body, encoding)
+		return err
+	})
+	if err != nil {
+		slog.Error("Dropping OTLP batch", "url", o.url, "records", records, "error", err)
+		metrics.OTLPLogRecords.WithLabelValues("failed").Add(float64(records))
+		o.giveUp(batchEntries(p), err)
+		return len(p), nil
+	}
+	metrics.OTLPLogRecords.WithLabelValues("exported").Add(float64(records - rejected))
+	metrics.OTLPLogRecords.WithLabelValues("failed").Add(float64(rejected))
+	return len(p), nil
+}
+
+// otlpResources groups the serialized entries in p by resource, in order of
+// first appearance, and counts them.
+func otlpResources(p []byte) ([]*otlpResourceLogs, int, error) {
+	var resources []*otlpResourceLogs
+	byKey := make(map[string]*otlpScopeLogs)
+	records := 0
+	for _, line := range bytes.Split(bytes.TrimSuffix(p, []byte("\n")), []byte("\n")) {
+		var l otlpLine
+		if err := json.Unmarshal(line, &l); err != nil {
+			return nil, 0, fmt.Errorf("otlp: invalid entry: %w", err)
+		}
+		key, _ := json.Marshal(l.Resource)
+		scope, ok := byKey[string(key)]
+		if !ok {
+			scope = &otlpScopeLogs{Scope: otlpScope{Name: otlpScopeName}}
+			r := &otlpResourceLogs{ScopeLogs: []*otlpScopeLogs{scope}}
+			r.Resource.Attributes = l.Resource
+			byKey[string(key)] = scope
+			resources = append(resources, r)
+		}
+		scope.LogRecords = append(scope.LogRecords, l.Record)
+		records++
+	}
+	return resources, records, nil
+}
+
+// export sends one request. rejected is the number of records a collector
+// refused in a partial success.
+func (o *otlpTransport) export(body []byte, encoding string) (rejected int, err error) {
+	req, err := http.NewRequest(http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, permanent(err)
+	}
+	for k, v := range o.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		metrics.OutputErrors.WithLabelValues("otlp", "error").Inc()
+		return 0, err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return otlpRejected(msg), nil
+	}
+	metrics.OutputErrors.WithLabelValues("otlp", strconv.Itoa(resp.StatusCode)).Inc()
+	return 0, newStatusError(resp, bytes.TrimSpace(msg[:min(len(msg), 1024)]))
+}
+
+// otlpRejected reads rejectedLogRecords from an export response. Being an
+// int64, it may be encoded as a JSON string.
+func otlpRejected(body []byte) int {
+	var resp struct {
+		PartialSuccess struct {
+			RejectedLogRecords json.RawMessage `json:"rejectedLogRecords"`
+		} `json:"partialSuccess"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(string(bytes.Trim(resp.PartialSuccess.RejectedLogRecords, `"`)))
+	return n
+}
+
+func (o *otlpTransport) Close() error {
+	o.client.CloseIdleConnections()
+	return nil
+}
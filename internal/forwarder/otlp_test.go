@@ -0,0 +1,146 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+func TestOTLPTransport(t *testing.T) {
+	orig := retryBase
+	retryBase = 10 * time.Millisecond
+	t.Cleanup(func() { retryBase = orig })
+
+	// 1. A TLS collector requiring a header, unavailable for the first
+	// request and rejecting one record of the second
+	var mu sync.Mutex
+	var exports []map[string][]otlpResourceLogs
+	unavailable := 1
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != otlpLogsPath {
+			t.Errorf("Expected an export to %s, got %s", otlpLogsPath, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Expected the auth header, got %q", got)
+		}
+		if unavailable > 0 {
+			unavailable--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body map[string][]otlpResourceLogs
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Invalid export body: %v", err)
+		}
+		exports = append(exports, body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"partialSuccess":{"rejectedLogRecords":"1","errorMessage":"too old"}}`))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tr, err := NewOTLPTransport(OTLPOutputConfig{
+		URL:     srv.URL,
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+		CAFile:  caFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	// 2. Three entries from two hosts
+	var batch bytes.Buffer
+	for _, e := range []models.LogEntry{
+		{Time: 1700000000, Host: "a", Source: "app.log", SourceType: "app", Event: "one", Fields: map[string]string{"env": "prod"}},
+		{Time: 1700000001, Host: "b", Source: "web.log", SourceType: "web", Event: "two"},
+		{Time: 1700000002, Host: "a", Source: "app.log", SourceType: "app", Event: "three"},
+	} {
+		if err := (OTLPSerializer{}).Serialize(&batch, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exported := counterValue(t, metrics.OTLPLogRecords.WithLabelValues("exported"))
+	failed := counterValue(t, metrics.OTLPLogRecords.WithLabelValues("failed"))
+	if _, err := tr.Write(batch.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 3. One export after the retry, grouped by resource
+	mu.Lock()
+	defer mu.Unlock()
+	if len(exports) != 1 {
+		t.Fatalf("Expected 1 accepted export, got %d", len(exports))
+	}
+	resources := exports[0]["resourceLogs"]
+	if len(resources) != 2 {
+		t.Fatalf("Expected 2 resources, got %+v", resources)
+	}
+	a := resources[0]
+	if attrs := a.Resource.Attributes; len(attrs) != 1 || attrs[0] != otlpAttr("host.name", "a") {
+		t.Errorf("Unexpected first resource %+v", attrs)
+	}
+	if len(a.ScopeLogs) != 1 || a.ScopeLogs[0].Scope.Name != otlpScopeName {
+		t.Fatalf("Unexpected scope logs %+v", a.ScopeLogs)
+	}
+	records := a.ScopeLogs[0].LogRecords
+	if len(records) != 2 || records[0].Body.StringValue != "one" || records[1].Body.StringValue != "three" {
+		t.Fatalf("Unexpected records %+v", records)
+	}
+	if got := records[0].ObservedTimeUnixNano; got != "1700000000000000000" {
+		t.Errorf("Expected the observed time in nanoseconds, got %s", got)
+	}
+	want := []otlpKeyValue{otlpAttr("log.file.name", "app.log"), otlpAttr("sourcetype", "app"), otlpAttr("env", "prod")}
+	if got := records[0].Attributes; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("Expected attributes %+v, got %+v", want, got)
+	}
+
+	// 4. The rejected record counts as failed
+	if got := counterValue(t, metrics.OTLPLogRecords.WithLabelValues("exported")) - exported; got != 2 {
+		t.Errorf("Expected 2 exported records, got %.0f", got)
+	}
+	if got := counterValue(t, metrics.OTLPLogRecords.WithLabelValues("failed")) - failed; got != 1 {
+		t.Errorf("Expected 1 failed record, got %.0f", got)
+	}
+}
+
+func TestOTLPTransportDropsRejectedBatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "malformed", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	tr, err := NewOTLPTransport(OTLPOutputConfig{URL: srv.URL + "/custom/logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	var batch bytes.Buffer
+	OTLPSerializer{}.Serialize(&batch, models.LogEntry{Host: "h", Event: "bad"})
+	before := counterValue(t, metrics.OTLPLogRecords.WithLabelValues("failed"))
+
+	// A 400 is not retried: the record is dropped and counted at once
+	if _, err := tr.Write(batch.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if got := counterValue(t, metrics.OTLPLogRecords.WithLabelValues("failed")) - before; got != 1 {
+		t.Errorf("Expected 1 failed record, got %.0f", got)
+	}
+}
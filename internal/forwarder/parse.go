@@ -0,0 +1,467 @@
+package forwarder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// DefaultMessageKey is the JSON key used as the event when none is set.
+const DefaultMessageKey = "message"
+
+// ParseJSON parses events that are JSON objects and promotes their values
+// into Fields, with messageKey's value as the new event. Numbers and bools
+// are stringified, nested objects are flattened into dotted keys, or kept
+// as JSON like arrays with encodeNested, and arrays are kept as JSON.
+// Fields already on the entry (static config fields, the target tag) win
+// over parsed keys. Events that aren't a JSON object are counted and passed
+// on unchanged.
+func ParseJSON(messageKey string, encodeNested bool) Processor {
+	if messageKey == "" {
+		messageKey = DefaultMessageKey
+	}
+	return func(entry *models.LogEntry) bool {
+		var obj map[string]any
+		dec := json.NewDecoder(strings.NewReader(entry.Event))
+		dec.UseNumber() // keep large integers exact
+		if !strings.HasPrefix(entry.Event, "{") || dec.Decode(&obj) != nil || dec.More() {
+			metrics.ParseErrors.WithLabelValues(entry.SourceType, "json").Inc()
+			return true
+		}
+		fields := make(map[string]string, len(obj)+len(entry.Fields))
+		flattenJSON(fields, "", obj, encodeNested)
+		if msg, ok := fields[messageKey]; ok {
+			entry.Event = msg
+			delete(fields, messageKey)
+		}
+		for k, v := range entry.Fields {
+			fields[k] = v
+		}
+		entry.Fields = fields
+		return true
+	}
+}
+
+// flattenJSON adds the values of obj to fields, nested keys joined by dots
+// unless encode is set.
+func flattenJSON(fields map[string]string, prefix string, obj map[string]any, encode bool) {
+	for k, v := range obj {
+		key := prefix + k
+		switch v := v.(type) {
+		case nil:
+		case string:
+			fields[key] = v
+		case json.Number:
+			fields[key] = v.String()
+		case bool:
+			fields[key] = strconv.FormatBool(v)
+		case map[string]any:
+			if encode {
+				fields[key] = jsonText(v)
+			} else {
+				flattenJSON(fields, key+".", v, encode)
+			}
+		default:
+			fields[key] = jsonText(v)
+		}
+	}
+}
+
+// jsonText encodes v as compact JSON, leaving HTML characters as they are.
+func jsonText(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// ParseLogfmt parses events made of logfmt key=value pairs, such as
+// `level=info msg="request done" dur=3ms`, into Fields. With a messageKey,
+// its value becomes the new event; otherwise the event is kept whole. Bare
+// keys get an empty value, and malformed pairs are skipped. Fields already
+// on the entry win over parsed keys. Events without a single key=value pair
+// are counted and passed on unchanged.
+func ParseLogfmt(messageKey string) Processor {
+	return func(entry *models.LogEntry) bool {
+		pairs := splitLogfmt(entry.Event)
+		if !slices.ContainsFunc(pairs, func(p logfmtPair) bool { return p.hasValue }) {
+			metrics.ParseErrors.WithLabelValues(entry.SourceType, "logfmt").Inc()
+			return true
+		}
+		fields := make(map[string]string, len(pairs)+len(entry.Fields))
+		for _, p := range pairs {
+			fields[p.key] = p.value
+		}
+		if msg, ok := fields[messageKey]; ok && messageKey != "" {
+			entry.Event = msg
+			delete(fields, messageKey)
+		}
+		for k, v := range entry.Fields {
+			fields[k] = v
+		}
+		entry.Fields = fields
+		return true
+	}
+}
+
+// clfPattern matches the Common Log Format of Apache and nginx access logs,
+// and so the combined format that extends it.
+var clfPattern = func() *regexp.Regexp {
+	re, err := CompileGrok(`^%{COMMONAPACHELOG}`)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}()
+
+// ParseAuto picks the parser of a file from its first event: ParseJSON for
+// a JSON object, the fields of clfPattern for an access log line, or
+// ParseLogfmt for a line made only of key=value pairs. The choice is logged
+// and kept for every later event, so build one processor per file, as
+// FileProcessors do. When the first event is none of these the file's
+// events are passed on unchanged, without counting parse errors.
+func ParseAuto(path, messageKey string, encodeNested bool) Processor {
+	var parse Processor
+	return func(entry *models.LogEntry) bool {
+		if parse == nil {
+			format := detectFormat(entry.Event)
+			slog.Info("Detected log format", "path", path, "format", format)
+			switch format {
+			case "json":
+				parse = ParseJSON(messageKey, encodeNested)
+			case "logfmt":
+				parse = ParseLogfmt(messageKey)
+			case "clf":
+				parse = parseCLF()
+			default:
+				parse = func(*models.LogEntry) bool { return true }
+			}
+		}
+		return parse(entry)
+	}
+}
+
+// detectFormat tells the format of event: "json", "clf", "logfmt", or
+// "plain" when it is none of these or could be plain text as well.
+func detectFormat(event string) string {
+	switch {
+	case strings.HasPrefix(event, "{") && json.Valid([]byte(event)):
+		return "json"
+	case clfPattern.MatchString(event):
+		return "clf"
+	}
+	// A single pair or a bare word is as likely to be prose
+	pairs := splitLogfmt(event)
+	if len(pairs) >= 2 && !slices.ContainsFunc(pairs, func(p logfmtPair) bool { return !p.hasValue }) {
+		return "logfmt"
+	}
+	return "plain"
+}
+
+// parseCLF copies the fields of access log lines into Fields. Other events
+// are counted and passed on unchanged.
+func parseCLF() Processor {
+	extract := submatchFields(clfPattern)
+	return func(entry *models.LogEntry) bool {
+		if !extract(entry) {
+			metrics.ParseErrors.WithLabelValues(entry.SourceType, "clf").Inc()
+		}
+		return true
+	}
+}
+
+type logfmtPair struct {
+	key, value string
+	hasValue   bool // false for a bare key
+}
+
+// splitLogfmt splits s into its key=value pairs. A value may be quoted to
+// hold spaces, with backslash escapes inside the quotes. Tokens that aren't
+// a pair or a bare key, like `=x` or an unterminated quoted value, are
+// skipped.
+func splitLogfmt(s string) []logfmtPair {
+	var pairs []logfmtPair
+	i := 0
+	for i < len(s) {
+		if isLogfmtSpace(s[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(s) && !isLogfmtSpace(s[i]) && s[i] != '=' && s[i] != '"' {
+			i++
+		}
+		key := s[start:i]
+		switch {
+		case i == len(s) || isLogfmtSpace(s[i]):
+			pairs = append(pairs, logfmtPair{key: key})
+			continue
+		case key == "" || s[i] == '"':
+			i = skipLogfmtToken(s, i)
+			continue
+		}
+		// s[i] is '='
+		i++
+		if i < len(s) && s[i] == '"' {
+			value, n, ok := unquoteLogfmt(s[i:])
+			if !ok {
+				return pairs
+			}
+			i += n
+			pairs = append(pairs, logfmtPair{key: key, value: value, hasValue: true})
+			continue
+		}
+		start = i
+		for i < len(s) && !isLogfmtSpace(s[i]) {
+			i++
+		}
+		pairs = append(pairs, logfmtPair{key: key, value: s[start:i], hasValue: true})
+	}
+	return pairs
+}
+
+func isLogfmtSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// skipLogfmtToken returns the index of the first space after i, stepping
+// over quoted runs.
+func skipLogfmtToken(s string, i int) int {
+	for i < len(s) && !isLogfmtSpace(s[i]) {
+		if s[i] == '"' {
+			if _, n, ok := unquoteLogfmt(s[i:]); ok {
+				i += n
+				continue
+			}
+			return len(s)
+		}
+		i++
+	}
+	return i
+}
+
+// unquoteLogfmt reads the quoted value at the start of s, returning it
+// unescaped and the number of bytes it took. \n, \t and \r are control
+// characters; any other escaped character stands for itself.
+func unquoteLogfmt(s string) (string, int, bool) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), i + 1, true
+		case '\\':
+			if i++; i == len(s) {
+				return "", 0, false
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, false
+}
+
+// CSVOptions configures ParseCSV. Headers name the columns in order;
+// without them, they are read from the first line of HeaderFile. Delimiter
+// separates the columns (0 means a comma).
+type CSVOptions struct {
+	Headers    []string
+	HeaderFile string
+	Delimiter  rune
+}
+
+// ParseCSV parses events that are one CSV row into Fields, one per named
+// column, keeping the row as the event. Quoted values may hold delimiters.
+// Fields already on the entry win over parsed columns. Rows that don't
+// parse or have the wrong number of columns are counted and passed on
+// unchanged.
+//
+// With a HeaderFile, the header is read from the file when the first entry
+// is parsed and again when a row doesn't fit it, in case the file was
+// replaced by one with other columns; the header line itself is dropped.
+// Such a processor belongs to a single file's tailer.
+func ParseCSV(opts CSVOptions) Processor {
+	if opts.Delimiter == 0 {
+		opts.Delimiter = ','
+	}
+	p := &csvParser{opts: opts, header: opts.Headers}
+	return p.process
+}
+
+type csvParser struct {
+	opts       CSVOptions
+	header     []string
+	headerLine string // the raw header, with a HeaderFile
+}
+
+func (p *csvParser) process(entry *models.LogEntry) bool {
+	fromFile := p.opts.HeaderFile != ""
+	if fromFile && p.header == nil {
+		p.readHeader()
+	}
+	if fromFile && entry.Event == p.headerLine {
+		return false
+	}
+	row, err := p.split(entry.Event)
+	if (err != nil || len(row) != len(p.header)) && fromFile && p.readHeader() && entry.Event == p.headerLine {
+		return false
+	}
+	if err != nil || len(row) != len(p.header) {
+		metrics.ParseErrors.WithLabelValues(entry.SourceType, "csv").Inc()
+		return true
+	}
+	fields := make(map[string]string, len(row)+len(entry.Fields))
+	for i, name := range p.header {
+		if name != "" {
+			fields[name] = row[i]
+		}
+	}
+	for k, v := range entry.Fields {
+		fields[k] = v
+	}
+	entry.Fields = fields
+	return true
+}
+
+// split parses s as exactly one CSV row.
+func (p *csvParser) split(s string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(s))
+	r.Comma = p.opts.Delimiter
+	r.FieldsPerRecord = -1
+	row, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Read(); err != io.EOF {
+		return nil, fmt.Errorf("more than one row")
+	}
+	return row, nil
+}
+
+// readHeader reads the header from the first line of HeaderFile, which may
+// be gzipped like the file itself, and reports whether it changed.
+func (p *csvParser) readHeader() bool {
+	f, err := os.Open(p.opts.HeaderFile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if isGzip(p.opts.HeaderFile, f) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return false
+		}
+		r = gz
+	}
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return false
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == p.headerLine && p.header != nil {
+		return false
+	}
+	header, err := p.split(line)
+	if err != nil {
+		return false
+	}
+	p.header, p.headerLine = header, line
+	return true
+}
+
+// timestampLayouts are the named layouts ParseTimestamp takes besides Go
+// reference layouts and "unix"/"unix_ms" (epoch seconds, optionally with a
+// fraction, and milliseconds).
+var timestampLayouts = map[string]string{
+	"RFC3339": time.RFC3339Nano,
+	"syslog":  time.Stamp,
+	"common":  "02/Jan/2006:15:04:05 -0700", // Apache/nginx access logs
+}
+
+// ParseTimestamp sets the entry's time to the timestamp captured by the ts
+// group of re in the first line of the event, parsed with layout (a preset
+// or a Go reference layout; times without a zone are local). Events where
+// re doesn't match or the timestamp doesn't parse keep their read time and
+// are counted.
+func ParseTimestamp(re *regexp.Regexp, layout string) Processor {
+	group := re.SubexpIndex("ts")
+	return func(entry *models.LogEntry) bool {
+		line, _, _ := strings.Cut(entry.Event, "\n")
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			metrics.ParseErrors.WithLabelValues(entry.SourceType, "timestamp").Inc()
+			return true
+		}
+		ts, err := parseTimestamp(layout, m[group], time.Now())
+		if err != nil {
+			metrics.ParseErrors.WithLabelValues(entry.SourceType, "timestamp").Inc()
+			return true
+		}
+		entry.Time, entry.TimeNano = ts.Unix(), ts.UnixNano()
+		return true
+	}
+}
+
+// parseTimestamp parses s with layout. Syslog timestamps have no year: they
+// get the one that puts them closest before now.
+func parseTimestamp(layout, s string, now time.Time) (time.Time, error) {
+	switch layout {
+	case "unix":
+		sec, frac, _ := strings.Cut(s, ".")
+		n, err := strconv.ParseInt(sec, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		var nanos int64
+		if frac != "" {
+			if nanos, err = strconv.ParseInt((frac + "000000000")[:9], 10, 64); err != nil {
+				return time.Time{}, err
+			}
+		}
+		return time.Unix(n, nanos), nil
+	case "unix_ms":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.UnixMilli(n), nil
+	}
+	if preset, ok := timestampLayouts[layout]; ok {
+		layout = preset
+	}
+	ts, err := time.ParseInLocation(layout, s, now.Location())
+	if err != nil || ts.Year() != 0 {
+		return ts, err
+	}
+	ts = ts.AddDate(now.Year(), 0, 0)
+	// A December line read in January is from last year
+	if ts.After(now.AddDate(0, 0, 1)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts, nil
+}
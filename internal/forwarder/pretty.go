@@ -0,0 +1,125 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"katalog/internal/models"
+)
+
+// Color modes for the stdout transport. ColorAuto pretty-prints (with
+// colors) only when stdout is a terminal, so piped output stays NDJSON.
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// StdoutSerializer returns the serializer for entries written to stdout.
+// It is name's serializer, except that json is indented for an interactive
+// terminal (or with ColorAlways) and colored unless color is ColorNever.
+func StdoutSerializer(name, color string) (Serializer, error) {
+	s, err := NewSerializer(name)
+	if err != nil || name != "json" {
+		return s, err
+	}
+	tty := IsTerminal(os.Stdout)
+	switch color {
+	case ColorAlways:
+		return PrettyJSONSerializer{Color: true}, nil
+	case ColorNever:
+		if tty {
+			return PrettyJSONSerializer{}, nil
+		}
+	case ColorAuto, "":
+		if tty {
+			return PrettyJSONSerializer{Color: true}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown color mode: %s", color)
+	}
+	return s, nil
+}
+
+// IsTerminal reports whether f is attached to a terminal (character device).
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// PrettyJSONSerializer writes indented JSON for people reading a terminal,
+// optionally with ANSI colors. It is never used for file or network output.
+type PrettyJSONSerializer struct {
+	Color bool
+}
+
+func (p PrettyJSONSerializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	b, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	if p.Color {
+		b = colorizeJSON(b)
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiKey    = "\x1b[34m" // blue
+	ansiString = "\x1b[32m" // green
+	ansiLit    = "\x1b[33m" // yellow: numbers, true, false, null
+)
+
+// colorizeJSON wraps keys, string values and literals of valid JSON in
+// ANSI color codes.
+func colorizeJSON(b []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(b) * 2)
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for end < len(b) && b[end] != '"' {
+				if b[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end++ // closing quote
+			color := ansiString
+			if j := skipSpace(b, end); j < len(b) && b[j] == ':' {
+				color = ansiKey
+			}
+			out.WriteString(color)
+			out.Write(b[i:end])
+			out.WriteString(ansiReset)
+			i = end
+		case c == '-' || c >= '0' && c <= '9' || c == 't' || c == 'f' || c == 'n':
+			end := i
+			for end < len(b) && !bytes.ContainsRune([]byte(",]} \n\t\r"), rune(b[end])) {
+				end++
+			}
+			out.WriteString(ansiLit)
+			out.Write(b[i:end])
+			out.WriteString(ansiReset)
+			i = end
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.Bytes()
+}
+
+func skipSpace(b []byte, i int) int {
+	for i < len(b) && (b[i] == ' ' || b[i] == '\n' || b[i] == '\t' || b[i] == '\r') {
+		i++
+	}
+	return i
+}
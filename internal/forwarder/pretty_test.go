@@ -0,0 +1,67 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"katalog/internal/models"
+)
+
+func TestPrettyJSONSerializer(t *testing.T) {
+	entry := models.LogEntry{Time: 1700000000, Host: "web-1", Event: `say "hi"`, Fields: map[string]string{"env": "prod"}}
+
+	var plain, colored bytes.Buffer
+	if err := (PrettyJSONSerializer{}).Serialize(&plain, entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := (PrettyJSONSerializer{Color: true}).Serialize(&colored, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(plain.String(), "\n  \"host\": \"web-1\"") {
+		t.Errorf("Expected indented JSON, got %q", plain.String())
+	}
+	if strings.Contains(plain.String(), "\x1b[") {
+		t.Error("Expected no ANSI codes without Color")
+	}
+	if !strings.Contains(colored.String(), ansiKey+`"host"`+ansiReset) ||
+		!strings.Contains(colored.String(), ansiString+`"web-1"`+ansiReset) ||
+		!strings.Contains(colored.String(), ansiLit+"1700000000"+ansiReset) {
+		t.Errorf("Expected colored keys, strings and numbers, got %q", colored.String())
+	}
+
+	// Stripping the colors gives back the same valid JSON
+	stripped := regexp.MustCompile("\x1b\\[[0-9;]*m").ReplaceAllString(colored.String(), "")
+	if stripped != plain.String() {
+		t.Errorf("Colorizing changed the document:\n%s\nvs\n%s", stripped, plain.String())
+	}
+	var decoded models.LogEntry
+	if err := json.Unmarshal([]byte(stripped), &decoded); err != nil || decoded.Event != entry.Event {
+		t.Errorf("Round trip failed: %v, %+v", err, decoded)
+	}
+}
+
+func TestStdoutSerializer(t *testing.T) {
+	// Tests don't run on a terminal: auto and never keep compact NDJSON
+	for _, color := range []string{ColorAuto, ColorNever, ""} {
+		s, err := StdoutSerializer("json", color)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.(JSONSerializer); !ok {
+			t.Errorf("color=%q: expected JSONSerializer, got %T", color, s)
+		}
+	}
+	if s, _ := StdoutSerializer("json", ColorAlways); s != (PrettyJSONSerializer{Color: true}) {
+		t.Errorf("color=always: expected colored pretty JSON, got %#v", s)
+	}
+	if s, _ := StdoutSerializer("logfmt", ColorAlways); s != (LogfmtSerializer{}) {
+		t.Errorf("Non-JSON serializers are left alone, got %#v", s)
+	}
+	if _, err := StdoutSerializer("json", "rainbow"); err == nil {
+		t.Error("Expected an error for an unknown color mode")
+	}
+}
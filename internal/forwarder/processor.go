@@ -0,0 +1,207 @@
+package forwarder
+
+import (
+	"regexp"
+	"sort"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// Processor transforms an entry just before it is emitted; returning false
+// drops the entry. Entry.Fields may be shared with other entries, so a
+// processor must replace the map rather than modify it in place.
+type Processor func(entry *models.LogEntry) bool
+
+// KeepFields keeps only the listed field keys.
+func KeepFields(keys []string) Processor {
+	keep := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		keep[k] = true
+	}
+	return func(entry *models.LogEntry) bool {
+		entry.Fields = filterFields(entry.Fields, func(k string) bool { return keep[k] })
+		return true
+	}
+}
+
+// DropFields removes the listed field keys.
+func DropFields(keys []string) Processor {
+	drop := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		drop[k] = true
+	}
+	return func(entry *models.LogEntry) bool {
+		entry.Fields = filterFields(entry.Fields, func(k string) bool { return !drop[k] })
+		return true
+	}
+}
+
+// filterFields returns a copy of fields holding the keys accepted by keep,
+// or nil when none are left.
+func filterFields(fields map[string]string, keep func(string) bool) map[string]string {
+	var out map[string]string
+	for k, v := range fields {
+		if !keep(k) {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string, len(fields))
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// RenameFields renames field keys old->new. A renamed value overwrites an
+// existing field with the new name; when several old keys map to the same
+// new key, the lexically last old key wins, so the result never depends on
+// map iteration order.
+func RenameFields(renames map[string]string) Processor {
+	olds := make([]string, 0, len(renames))
+	for old := range renames {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	return func(entry *models.LogEntry) bool {
+		var out map[string]string
+		for _, old := range olds {
+			v, ok := entry.Fields[old]
+			if !ok {
+				continue
+			}
+			if out == nil {
+				out = make(map[string]string, len(entry.Fields))
+				for k, v := range entry.Fields {
+					if _, renamed := renames[k]; !renamed {
+						out[k] = v
+					}
+				}
+			}
+			out[renames[old]] = v
+		}
+		if out != nil {
+			entry.Fields = out
+		}
+		return true
+	}
+}
+
+// Redaction replaces every match of Pattern in an event with Replacement,
+// which may refer to capture groups as in regexp.Expand.
+type Redaction struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// FieldRule adds Fields to entries whose event matches Pattern.
+type FieldRule struct {
+	Pattern *regexp.Regexp
+	Fields  map[string]string
+}
+
+// ConditionalFields applies the fields of the matching rules, in order, on
+// top of the entry's fields; later rules win on conflicts. With firstOnly
+// only the first matching rule applies.
+func ConditionalFields(rules []FieldRule, firstOnly bool) Processor {
+	return func(entry *models.LogEntry) bool {
+		var out map[string]string
+		for _, r := range rules {
+			if !r.Pattern.MatchString(entry.Event) {
+				continue
+			}
+			if out == nil {
+				out = make(map[string]string, len(entry.Fields)+len(r.Fields))
+				for k, v := range entry.Fields {
+					out[k] = v
+				}
+			}
+			for k, v := range r.Fields {
+				out[k] = v
+			}
+			if firstOnly {
+				break
+			}
+		}
+		if out != nil {
+			entry.Fields = out
+		}
+		return true
+	}
+}
+
+// ExtractFields copies the named capture groups of re into Fields when re
+// matches the event. Groups that didn't participate in the match are left
+// out. With required, events that don't match are dropped.
+func ExtractFields(re *regexp.Regexp, required bool) Processor {
+	extract := submatchFields(re)
+	return func(entry *models.LogEntry) bool {
+		return extract(entry) || !required
+	}
+}
+
+// submatchFields returns a function copying the named capture groups of re
+// into Fields, reporting whether re matched the event.
+func submatchFields(re *regexp.Regexp) func(entry *models.LogEntry) bool {
+	names := re.SubexpNames()
+	return func(entry *models.LogEntry) bool {
+		m := re.FindStringSubmatchIndex(entry.Event)
+		if m == nil {
+			return false
+		}
+		fields := make(map[string]string, len(entry.Fields)+len(names))
+		for k, v := range entry.Fields {
+			fields[k] = v
+		}
+		for i, name := range names {
+			if name != "" && m[2*i] >= 0 {
+				fields[name] = entry.Event[m[2*i]:m[2*i+1]]
+			}
+		}
+		entry.Fields = fields
+		return true
+	}
+}
+
+// CountMatches calls inc for every event re matches. It never changes or
+// drops the entry.
+func CountMatches(re *regexp.Regexp, inc func()) Processor {
+	return func(entry *models.LogEntry) bool {
+		if re.MatchString(entry.Event) {
+			inc()
+		}
+		return true
+	}
+}
+
+// TraceIDField is the documented field a trace/correlation ID is promoted to.
+const TraceIDField = "trace_id"
+
+// ExtractTraceID sets TraceIDField from the first capture group of re (or
+// the group named trace_id, if there is one) when re matches the event.
+// Events without a match are counted per sourcetype and passed on as is.
+func ExtractTraceID(re *regexp.Regexp) Processor {
+	group := 1
+	if i := re.SubexpIndex(TraceIDField); i > 0 {
+		group = i
+	}
+	return func(entry *models.LogEntry) bool {
+		m := re.FindStringSubmatch(entry.Event)
+		if m == nil || m[group] == "" {
+			metrics.TraceIDMissing.WithLabelValues(entry.SourceType).Inc()
+			return true
+		}
+		entry.Fields = withField(entry.Fields, TraceIDField, m[group])
+		return true
+	}
+}
+
+// withField returns a copy of fields with k set to v.
+func withField(fields map[string]string, k, v string) map[string]string {
+	out := make(map[string]string, len(fields)+1)
+	for fk, fv := range fields {
+		out[fk] = fv
+	}
+	out[k] = v
+	return out
+}
@@ -0,0 +1,482 @@
+package forwarder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+func TestFieldFilterProcessors(t *testing.T) {
+	shared := map[string]string{"env": "prod", "app": "api", "pid": "42", "host_ip": "10.0.0.1"}
+
+	tests := []struct {
+		name       string
+		processors []Processor
+		expected   map[string]string
+	}{
+		{"Keep", []Processor{KeepFields([]string{"env", "app", "absent"})}, map[string]string{"env": "prod", "app": "api"}},
+		{"Drop", []Processor{DropFields([]string{"pid", "host_ip"})}, map[string]string{"env": "prod", "app": "api"}},
+		{"Keep Then Drop", []Processor{KeepFields([]string{"env", "pid"}), DropFields([]string{"pid"})}, map[string]string{"env": "prod"}},
+		{"Nothing Left", []Processor{KeepFields([]string{"absent"})}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := models.LogEntry{Event: "e", Fields: shared}
+			for _, p := range tt.processors {
+				if !p(&entry) {
+					t.Fatal("Field filters must not drop entries")
+				}
+			}
+			if !reflect.DeepEqual(entry.Fields, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, entry.Fields)
+			}
+		})
+	}
+
+	if len(shared) != 4 {
+		t.Errorf("Processors must not modify the shared fields map, got %v", shared)
+	}
+}
+
+func TestRenameFields(t *testing.T) {
+	shared := map[string]string{"status_code": "500", "http.status": "old", "a": "1", "b": "2", "env": "prod"}
+
+	// "a" and "b" both map to "x": the lexically last source ("b") wins
+	p := RenameFields(map[string]string{"status_code": "http.status", "a": "x", "b": "x", "absent": "y"})
+
+	for i := 0; i < 20; i++ { // Repeat to catch map-order dependence
+		entry := models.LogEntry{Fields: shared}
+		p(&entry)
+		expected := map[string]string{"http.status": "500", "x": "2", "env": "prod"}
+		if !reflect.DeepEqual(entry.Fields, expected) {
+			t.Fatalf("Expected %v, got %v", expected, entry.Fields)
+		}
+	}
+	if shared["status_code"] != "500" || len(shared) != 5 {
+		t.Errorf("RenameFields must not modify the shared fields map, got %v", shared)
+	}
+
+	// Entries without any of the keys keep their map untouched
+	untouched := map[string]string{"env": "prod"}
+	entry := models.LogEntry{Fields: untouched}
+	p(&entry)
+	if !reflect.DeepEqual(entry.Fields, untouched) {
+		t.Errorf("Expected fields unchanged, got %v", entry.Fields)
+	}
+}
+
+func TestExtractTraceID(t *testing.T) {
+	shared := map[string]string{"env": "prod"}
+
+	p := ExtractTraceID(regexp.MustCompile(`trace_id=([0-9a-f]+)`))
+	entry := models.LogEntry{Event: "GET /api trace_id=4bf92f3577b34da6 200", Fields: shared}
+	p(&entry)
+	expected := map[string]string{"env": "prod", TraceIDField: "4bf92f3577b34da6"}
+	if !reflect.DeepEqual(entry.Fields, expected) {
+		t.Errorf("Expected %v, got %v", expected, entry.Fields)
+	}
+	if len(shared) != 1 {
+		t.Errorf("ExtractTraceID must not modify the shared fields map, got %v", shared)
+	}
+
+	// A group named trace_id is preferred over the first group
+	p = ExtractTraceID(regexp.MustCompile(`(GET|POST) .* traceparent=00-(?P<trace_id>[0-9a-f]{32})-`))
+	entry = models.LogEntry{Event: "POST /x traceparent=00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	p(&entry)
+	if entry.Fields[TraceIDField] != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("Expected the named group, got %v", entry.Fields)
+	}
+
+	// No match leaves the entry alone and still forwards it
+	entry = models.LogEntry{Event: "no trace here", Fields: shared}
+	if !p(&entry) || !reflect.DeepEqual(entry.Fields, shared) {
+		t.Errorf("Expected an unmatched entry to pass unchanged, got %v", entry.Fields)
+	}
+}
+
+func TestConditionalFields(t *testing.T) {
+	shared := map[string]string{"env": "prod", "category": "general"}
+	rules := []FieldRule{
+		{Pattern: regexp.MustCompile(`login|logout`), Fields: map[string]string{"category": "auth"}},
+		{Pattern: regexp.MustCompile(`failed`), Fields: map[string]string{"category": "failure", "alert": "true"}},
+	}
+
+	tests := []struct {
+		name      string
+		firstOnly bool
+		event     string
+		expected  map[string]string
+	}{
+		{"no match keeps static fields", false, "GET /health", shared},
+		{"single rule", false, "user login ok", map[string]string{"env": "prod", "category": "auth"}},
+		{"all rules, later wins", false, "login failed", map[string]string{"env": "prod", "category": "failure", "alert": "true"}},
+		{"first rule only", true, "login failed", map[string]string{"env": "prod", "category": "auth"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := models.LogEntry{Event: tt.event, Fields: shared}
+			ConditionalFields(rules, tt.firstOnly)(&entry)
+			if !reflect.DeepEqual(entry.Fields, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, entry.Fields)
+			}
+		})
+	}
+	if shared["category"] != "general" || len(shared) != 2 {
+		t.Errorf("ConditionalFields must not modify the shared fields map, got %v", shared)
+	}
+}
+
+func TestCountMatches(t *testing.T) {
+	count := 0
+	p := CountMatches(regexp.MustCompile(`status=5\d\d`), func() { count++ })
+	for _, event := range []string{"GET / status=200", "GET /a status=503", "POST /b status=500"} {
+		entry := models.LogEntry{Event: event}
+		if !p(&entry) || entry.Event != event {
+			t.Errorf("Expected '%s' to pass unchanged", event)
+		}
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 matches, got %d", count)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	shared := map[string]string{"env": "prod"}
+
+	// 1. Keys are promoted, values stringified, the message becomes the event
+	p := ParseJSON("", false)
+	entry := models.LogEntry{
+		SourceType: "api",
+		Event:      `{"message":"request done","status":200,"id":12345678901234,"ok":true,"env":"dev","http":{"method":"GET"},"tags":["a","b"],"none":null}`,
+		Fields:     shared,
+	}
+	if !p(&entry) {
+		t.Fatal("Expected the entry to be kept")
+	}
+	expected := map[string]string{
+		"status":      "200",
+		"id":          "12345678901234",
+		"ok":          "true",
+		"env":         "prod", // static fields win
+		"http.method": "GET",
+		"tags":        `["a","b"]`,
+	}
+	if entry.Event != "request done" || !reflect.DeepEqual(entry.Fields, expected) {
+		t.Errorf("Expected 'request done' with %v, got '%s' with %v", expected, entry.Event, entry.Fields)
+	}
+	if len(shared) != 1 {
+		t.Errorf("ParseJSON must not modify the shared fields map, got %v", shared)
+	}
+
+	// 2. A custom message key
+	entry = models.LogEntry{Event: `{"msg":"hi","level":"info"}`}
+	ParseJSON("msg", false)(&entry)
+	if entry.Event != "hi" || entry.Fields["level"] != "info" {
+		t.Errorf("Expected the msg key as event, got '%s' with %v", entry.Event, entry.Fields)
+	}
+
+	// 3. Anything but a single JSON object falls through raw
+	for _, event := range []string{"plain text", `{"broken":`, `["array"]`, `{"a":1} trailing`} {
+		entry = models.LogEntry{Event: event, Fields: shared}
+		if !p(&entry) || entry.Event != event || !reflect.DeepEqual(entry.Fields, shared) {
+			t.Errorf("Expected '%s' to pass unchanged, got '%s' with %v", event, entry.Event, entry.Fields)
+		}
+	}
+}
+
+func TestParseJSONNested(t *testing.T) {
+	event := `{"message":"hi","a":{"b":1,"c":{"d":"<x>"}},"tags":["a"]}`
+	tests := []struct {
+		name   string
+		encode bool
+		want   map[string]string
+	}{
+		{name: "Flatten", want: map[string]string{"a.b": "1", "a.c.d": "<x>", "tags": `["a"]`}},
+		{name: "Encode", encode: true, want: map[string]string{"a": `{"b":1,"c":{"d":"<x>"}}`, "tags": `["a"]`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := models.LogEntry{Event: event}
+			ParseJSON("", tt.encode)(&entry)
+			if entry.Event != "hi" || !reflect.DeepEqual(entry.Fields, tt.want) {
+				t.Errorf("Expected 'hi' with %v, got '%s' with %v", tt.want, entry.Event, entry.Fields)
+			}
+		})
+	}
+}
+
+func TestParseAuto(t *testing.T) {
+	tests := []struct {
+		name  string
+		first string
+		want  map[string]string
+		next  string // of another format, left alone by the parser picked
+	}{
+		{name: "JSON", first: `{"message":"hi","level":"info"}`, want: map[string]string{"level": "info"}, next: `level=warn msg=x`},
+		{name: "Logfmt", first: `level=info msg="request done"`, want: map[string]string{"level": "info", "msg": "request done"}, next: `{"level":"warn"}`},
+		{
+			name:  "CLF",
+			first: `10.0.0.1 - bob [10/Oct/2023:13:55:36 -0700] "GET /a?b=c HTTP/1.1" 200 2326 "-" "curl/8.0"`,
+			want: map[string]string{"clientip": "10.0.0.1", "ident": "-", "auth": "bob", "timestamp": "10/Oct/2023:13:55:36 -0700",
+				"verb": "GET", "request": "/a?b=c", "httpversion": "1.1", "response": "200", "bytes": "2326"},
+			next: `level=warn msg=x`,
+		},
+		{name: "Plain", first: "connection reset by peer", next: `level=warn msg=x`},
+		{name: "Ambiguous Single Pair", first: "retrying with timeout=5s", next: `level=warn msg=x`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := ParseAuto("app.log", "", false)
+			entry := models.LogEntry{Event: tt.first}
+			if !p(&entry) {
+				t.Fatal("Expected the entry to be kept")
+			}
+			if len(entry.Fields) != len(tt.want) || (tt.want != nil && !reflect.DeepEqual(entry.Fields, tt.want)) {
+				t.Errorf("Expected %v, got %v", tt.want, entry.Fields)
+			}
+			// The format detected first sticks for the file
+			entry = models.LogEntry{Event: tt.next}
+			if !p(&entry) || entry.Event != tt.next || len(entry.Fields) != 0 {
+				t.Errorf("Expected '%s' to pass unchanged, got '%s' with %v", tt.next, entry.Event, entry.Fields)
+			}
+		})
+	}
+}
+
+func TestSplitLogfmt(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want []logfmtPair
+	}{
+		{"Simple", `level=info dur=3ms`,
+			[]logfmtPair{{"level", "info", true}, {"dur", "3ms", true}}},
+		{"Quoted", `msg="hello world"  path=/a`,
+			[]logfmtPair{{"msg", "hello world", true}, {"path", "/a", true}}},
+		{"Escaped Quotes", `msg="say \"hi\"\n" x=\"y`,
+			[]logfmtPair{{"msg", "say \"hi\"\n", true}, {"x", `\"y`, true}}},
+		{"Bare Keys", `debug level=warn trace`,
+			[]logfmtPair{{"debug", "", false}, {"level", "warn", true}, {"trace", "", false}}},
+		{"Empty Values", `a= b="" c=d=e`,
+			[]logfmtPair{{"a", "", true}, {"b", "", true}, {"c", "d=e", true}}},
+		{"Malformed Pairs Skipped", `=x "q w"=1 k"e y"=2 ok=1`,
+			[]logfmtPair{{"ok", "1", true}}},
+		{"Unterminated Quote", `a=1 msg="never closed b=2`,
+			[]logfmtPair{{"a", "1", true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitLogfmt(tt.line); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitLogfmt(%q) = %v, want %v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLogfmt(t *testing.T) {
+	shared := map[string]string{"env": "prod"}
+
+	// 1. Pairs become fields and the line is kept as the event
+	line := `level=info msg="request done" env=dev`
+	entry := models.LogEntry{SourceType: "api", Event: line, Fields: shared}
+	if !ParseLogfmt("")(&entry) {
+		t.Fatal("Expected the entry to be kept")
+	}
+	expected := map[string]string{"level": "info", "msg": "request done", "env": "prod"}
+	if entry.Event != line || !reflect.DeepEqual(entry.Fields, expected) {
+		t.Errorf("Expected the line with %v, got '%s' with %v", expected, entry.Event, entry.Fields)
+	}
+	if len(shared) != 1 {
+		t.Errorf("ParseLogfmt must not modify the shared fields map, got %v", shared)
+	}
+
+	// 2. A message key is promoted to the event
+	entry = models.LogEntry{Event: line}
+	ParseLogfmt("msg")(&entry)
+	if entry.Event != "request done" || entry.Fields["level"] != "info" || entry.Fields["msg"] != "" {
+		t.Errorf("Expected the msg key as event, got '%s' with %v", entry.Event, entry.Fields)
+	}
+
+	// 3. Lines without a key=value pair pass unchanged and are counted
+	before := counterValue(t, metrics.ParseErrors.WithLabelValues("api", "logfmt"))
+	for _, event := range []string{"plain text", "", `=x`} {
+		entry = models.LogEntry{SourceType: "api", Event: event, Fields: shared}
+		if !ParseLogfmt("msg")(&entry) || entry.Event != event || !reflect.DeepEqual(entry.Fields, shared) {
+			t.Errorf("Expected '%s' to pass unchanged, got '%s' with %v", event, entry.Event, entry.Fields)
+		}
+	}
+	if got := counterValue(t, metrics.ParseErrors.WithLabelValues("api", "logfmt")) - before; got != 3 {
+		t.Errorf("Expected 3 parse errors, got %.0f", got)
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	shared := map[string]string{"env": "prod"}
+	before := counterValue(t, metrics.ParseErrors.WithLabelValues("access", "csv"))
+
+	tests := []struct {
+		name     string
+		opts     CSVOptions
+		event    string
+		expected map[string]string
+	}{
+		{"Columns", CSVOptions{Headers: []string{"ip", "path", "status"}}, `10.0.0.1,/index.html,200`,
+			map[string]string{"ip": "10.0.0.1", "path": "/index.html", "status": "200", "env": "prod"}},
+		{"Quoted Fields", CSVOptions{Headers: []string{"ip", "agent", "status"}}, `10.0.0.1,"Mozilla/5.0 (X11, Linux)",200`,
+			map[string]string{"ip": "10.0.0.1", "agent": "Mozilla/5.0 (X11, Linux)", "status": "200", "env": "prod"}},
+		{"Escaped Quotes", CSVOptions{Headers: []string{"msg", "env"}}, `"say ""hi""",dev`,
+			map[string]string{"msg": `say "hi"`, "env": "prod"}}, // static fields win
+		{"Delimiter", CSVOptions{Headers: []string{"ip", "path", "status"}, Delimiter: '\t'}, "10.0.0.1\t/a,b\t404",
+			map[string]string{"ip": "10.0.0.1", "path": "/a,b", "status": "404", "env": "prod"}},
+		{"Unnamed Column Skipped", CSVOptions{Headers: []string{"ip", "", "status"}, Delimiter: ';'}, `10.0.0.1;-;200`,
+			map[string]string{"ip": "10.0.0.1", "status": "200", "env": "prod"}},
+		{"Wrong Column Count", CSVOptions{Headers: []string{"ip", "path", "status"}}, `10.0.0.1,/index.html`, shared},
+		{"Bad Quoting", CSVOptions{Headers: []string{"a", "b"}}, `"open,b`, shared},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := models.LogEntry{SourceType: "access", Event: tt.event, Fields: shared}
+			if !ParseCSV(tt.opts)(&entry) {
+				t.Fatal("Expected the entry to be kept")
+			}
+			if entry.Event != tt.event || !reflect.DeepEqual(entry.Fields, tt.expected) {
+				t.Errorf("Expected '%s' with %v, got '%s' with %v", tt.event, tt.expected, entry.Event, entry.Fields)
+			}
+		})
+	}
+	if len(shared) != 1 {
+		t.Errorf("ParseCSV must not modify the shared fields map, got %v", shared)
+	}
+	if got := counterValue(t, metrics.ParseErrors.WithLabelValues("access", "csv")) - before; got != 2 {
+		t.Errorf("Expected 2 parse errors, got %.0f", got)
+	}
+}
+
+func TestParseCSVHeaderFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.csv")
+	if err := os.WriteFile(path, []byte("ip,status\r\n10.0.0.1,200\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := ParseCSV(CSVOptions{HeaderFile: path})
+
+	// 1. The header line is dropped and names the columns of the rows
+	if entry := (models.LogEntry{Event: "ip,status"}); p(&entry) {
+		t.Error("Expected the header line to be dropped")
+	}
+	entry := models.LogEntry{Event: "10.0.0.1,200"}
+	if !p(&entry) || !reflect.DeepEqual(entry.Fields, map[string]string{"ip": "10.0.0.1", "status": "200"}) {
+		t.Errorf("Unexpected fields %v", entry.Fields)
+	}
+
+	// 2. A replacement file with other columns is picked up on the first
+	// row that doesn't fit
+	if err := os.WriteFile(path, []byte("ip,path,status\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if entry := (models.LogEntry{Event: "ip,path,status"}); p(&entry) {
+		t.Error("Expected the new header line to be dropped")
+	}
+	entry = models.LogEntry{Event: "10.0.0.2,/a,404"}
+	p(&entry)
+	if !reflect.DeepEqual(entry.Fields, map[string]string{"ip": "10.0.0.2", "path": "/a", "status": "404"}) {
+		t.Errorf("Unexpected fields %v", entry.Fields)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		layout  string
+		event   string
+		want    time.Time
+	}{
+		{"RFC3339", `^(?P<ts>\S+)`, "RFC3339", "2024-03-01T12:30:45.123Z INFO started",
+			time.Date(2024, 3, 1, 12, 30, 45, 123e6, time.UTC)},
+		{"Common", `\[(?P<ts>[^\]]+)\]`, "common", `10.0.0.1 - - [01/Mar/2024:12:30:45 +0100] "GET / HTTP/1.1" 200`,
+			time.Date(2024, 3, 1, 11, 30, 45, 0, time.UTC)},
+		{"Unix", `ts=(?P<ts>[\d.]+)`, "unix", "ts=1709296245.5 msg=hi",
+			time.Date(2024, 3, 1, 12, 30, 45, 5e8, time.UTC)},
+		{"Go Layout", `^(?P<ts>\S+ \S+ \S+)`, "2006-01-02 15:04:05.000 -0700", "2024-03-01 14:30:45.250 +0200 WARN slow",
+			time.Date(2024, 3, 1, 12, 30, 45, 250e6, time.UTC)},
+		{"Multiline First Line", `^(?P<ts>\S+)`, "RFC3339", "2024-03-01T12:30:45Z panic\n2024-03-01T13:00:00Z not this one",
+			time.Date(2024, 3, 1, 12, 30, 45, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := models.LogEntry{SourceType: "ts", Event: tt.event, Time: 1}
+			if !ParseTimestamp(regexp.MustCompile(tt.pattern), tt.layout)(&entry) {
+				t.Fatal("Expected the entry to be kept")
+			}
+			if got := entry.Timestamp(); !got.Equal(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			if entry.Time != tt.want.Unix() {
+				t.Errorf("Expected Time %d, got %d", tt.want.Unix(), entry.Time)
+			}
+		})
+	}
+
+	// Unparsable or missing timestamps keep the read time and are counted
+	errors := metrics.ParseErrors.WithLabelValues("ts", "timestamp")
+	before := counterValue(t, errors)
+	p := ParseTimestamp(regexp.MustCompile(`^(?P<ts>\S+)`), "RFC3339")
+	for _, event := range []string{"yesterday INFO started", ""} {
+		entry := models.LogEntry{SourceType: "ts", Event: event, Time: 1}
+		if !p(&entry) || entry.Time != 1 || entry.TimeNano != 0 {
+			t.Errorf("Expected '%s' to keep its read time, got %d", event, entry.Time)
+		}
+	}
+	if got := counterValue(t, errors) - before; got != 2 {
+		t.Errorf("Expected 2 timestamp parse errors, got %v", got)
+	}
+}
+
+func TestParseTimestampSyslogYear(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	for s, want := range map[string]time.Time{
+		"Jan  2 09:59:00": time.Date(2024, 1, 2, 9, 59, 0, 0, time.UTC),
+		"Dec 31 23:00:00": time.Date(2023, 12, 31, 23, 0, 0, 0, time.UTC),
+	} {
+		got, err := parseTimestamp("syslog", s, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("Expected %s to be %v, got %v", s, want, got)
+		}
+	}
+}
+
+func TestExtractFields(t *testing.T) {
+	shared := map[string]string{"env": "prod"}
+	re := regexp.MustCompile(`^(?P<level>[A-Z]+)\s+(?:\[(?P<thread>\w+)\]\s+)?(?P<msg>.*)`)
+
+	// 1. Named groups become fields; unnamed and non-participating are skipped
+	p := ExtractFields(re, false)
+	entry := models.LogEntry{Event: "ERROR disk full", Fields: shared}
+	p(&entry)
+	expected := map[string]string{"env": "prod", "level": "ERROR", "msg": "disk full"}
+	if !reflect.DeepEqual(entry.Fields, expected) {
+		t.Errorf("Expected %v, got %v", expected, entry.Fields)
+	}
+	if len(shared) != 1 {
+		t.Errorf("ExtractFields must not modify the shared fields map, got %v", shared)
+	}
+
+	// 2. Non-matching lines pass unchanged, or are dropped when required
+	entry = models.LogEntry{Event: "no level here", Fields: shared}
+	if !p(&entry) || !reflect.DeepEqual(entry.Fields, shared) {
+		t.Errorf("Expected a non-matching entry to pass unchanged, got %v", entry.Fields)
+	}
+	if ExtractFields(re, true)(&entry) {
+		t.Error("Expected a non-matching entry to be dropped when required")
+	}
+}
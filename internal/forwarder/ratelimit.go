@@ -0,0 +1,103 @@
+package forwarder
+
+import (
+	"strconv"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// Over-limit policies for a rate limited target.
+const (
+	OverLimitDrop   = "drop"
+	OverLimitSample = "sample"
+	OverLimitBlock  = "block"
+)
+
+// SampledField marks entries kept by sampling while over the rate limit.
+// Its value is N for a 1-in-N sample.
+const SampledField = "_sampled"
+
+// tokenBucket is a minimal token bucket: rate tokens per second, holding at
+// most burst tokens.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = max(1, int(rate))
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// allow takes a token if one is available at now.
+func (b *tokenBucket) allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// reserve takes a token at now, going into debt if there is none, and
+// returns how long to wait before using it.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// rateLimiter applies a target's rate limit and over-limit policy.
+type rateLimiter struct {
+	bucket    *tokenBucket
+	policy    string
+	sampleN   int
+	overCount int
+}
+
+func newRateLimiter(opts TailOptions) *rateLimiter {
+	n := opts.OverLimitSampleN
+	if n < 1 {
+		n = 10
+	}
+	return &rateLimiter{
+		bucket:  newTokenBucket(opts.RateLimit, opts.RateLimitBurst),
+		policy:  opts.OverLimitPolicy,
+		sampleN: n,
+	}
+}
+
+// admit reports whether entry may be sent. Under the limit everything
+// passes; over it entries are dropped, or 1 in N is kept and tagged with
+// SampledField when the policy is sample.
+func (r *rateLimiter) admit(entry *models.LogEntry, path, group string, now time.Time) bool {
+	if r.bucket.allow(now) {
+		r.overCount = 0
+		return true
+	}
+	if r.policy == OverLimitSample {
+		r.overCount++
+		if r.overCount%r.sampleN == 1 || r.sampleN == 1 {
+			entry.Fields = withField(entry.Fields, SampledField, strconv.Itoa(r.sampleN))
+			metrics.RateLimited.WithLabelValues(path, group, "sampled").Inc()
+			return true
+		}
+	}
+	metrics.RateLimited.WithLabelValues(path, group, "dropped").Inc()
+	return false
+}
@@ -0,0 +1,116 @@
+package forwarder
+
+import (
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 3)
+	now := time.Now()
+
+	// The burst is available immediately, then the bucket is empty
+	for i := 0; i < 3; i++ {
+		if !b.allow(now) {
+			t.Fatalf("Expected burst token %d to be allowed", i)
+		}
+	}
+	if b.allow(now) {
+		t.Fatal("Expected bucket to be empty after the burst")
+	}
+
+	// 10/s refills one token every 100ms
+	if !b.allow(now.Add(100 * time.Millisecond)) {
+		t.Fatal("Expected a token after 100ms")
+	}
+	if b.allow(now.Add(100 * time.Millisecond)) {
+		t.Fatal("Expected only one token after 100ms")
+	}
+}
+
+func TestRateLimiterPolicies(t *testing.T) {
+	now := time.Now()
+	shared := map[string]string{"env": "prod"}
+
+	admitted := func(policy string) []models.LogEntry {
+		r := newRateLimiter(TailOptions{RateLimit: 1, RateLimitBurst: 1, OverLimitPolicy: policy, OverLimitSampleN: 3})
+		var kept []models.LogEntry
+		for i := 0; i < 7; i++ {
+			e := models.LogEntry{Event: "storm", Fields: shared}
+			if r.admit(&e, "/tmp/app.log", "app", now) {
+				kept = append(kept, e)
+			}
+		}
+		return kept
+	}
+
+	if kept := admitted(OverLimitDrop); len(kept) != 1 {
+		t.Errorf("drop: expected only the burst entry, got %d", len(kept))
+	}
+
+	// 1 within the burst, then 1 in 3 of the 6 over the limit
+	kept := admitted(OverLimitSample)
+	if len(kept) != 3 {
+		t.Fatalf("sample: expected 3 entries, got %d", len(kept))
+	}
+	if _, ok := kept[0].Fields[SampledField]; ok {
+		t.Error("Entry within the limit should not be tagged")
+	}
+	for _, e := range kept[1:] {
+		if e.Fields[SampledField] != "3" || e.Fields["env"] != "prod" {
+			t.Errorf("Unexpected fields on sampled entry: %v", e.Fields)
+		}
+	}
+	if _, ok := shared[SampledField]; ok {
+		t.Error("Tagging must not mutate the shared fields map")
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	b := newTokenBucket(10, 1)
+	now := time.Now()
+
+	// The burst token is free, each one after it is due 100ms later
+	if d := b.reserve(now); d != 0 {
+		t.Errorf("Expected no wait for the burst token, got %v", d)
+	}
+	if d := b.reserve(now); d != 100*time.Millisecond {
+		t.Errorf("Expected a 100ms wait, got %v", d)
+	}
+	if d := b.reserve(now); d != 200*time.Millisecond {
+		t.Errorf("Expected a 200ms wait behind the first reservation, got %v", d)
+	}
+}
+
+func TestTailerRateLimitBlock(t *testing.T) {
+	outCh := make(chan models.LogEntry, 10)
+	tl := &tailer{path: "/var/log/blocked.log", out: outCh, opts: TailOptions{
+		GroupName:       "blocked",
+		RateLimit:       20,
+		RateLimitBurst:  1,
+		OverLimitPolicy: OverLimitBlock,
+	}}
+	tl.init()
+	before := counterValue(t, metrics.RateLimitedSeconds.WithLabelValues(tl.path, "blocked"))
+
+	// 6 lines at once against 20/s: none are lost, but the last 5 are
+	// spread over 250ms
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if !tl.handleLine([]byte("flood\n")) {
+			t.Fatal("Expected handleLine to succeed")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 240*time.Millisecond {
+		t.Errorf("Expected emitting to take at least 250ms, took %v", elapsed)
+	}
+	if len(outCh) != 6 {
+		t.Errorf("Expected all 6 lines to be sent, got %d", len(outCh))
+	}
+	if waited := counterValue(t, metrics.RateLimitedSeconds.WithLabelValues(tl.path, "blocked")) - before; waited < 0.24 {
+		t.Errorf("Expected at least 0.25s counted as rate limited, got %v", waited)
+	}
+}
@@ -0,0 +1,114 @@
+package forwarder
+
+import (
+	"container/heap"
+	"time"
+
+	"katalog/internal/models"
+)
+
+// reorderLimit caps how many entries a reorder buffer holds; beyond it the
+// oldest entry is released early so memory stays bounded.
+const reorderLimit = 1000
+
+// reorderBuffer holds entries for up to window and releases them in
+// timestamp order, smoothing out slightly out-of-order writes within a
+// file. Entries older than the last released one can no longer be put in
+// order and are reported as late so they can be sent right away.
+type reorderBuffer struct {
+	window    time.Duration
+	items     reorderHeap
+	seq       uint64
+	watermark int64 // unix nanoseconds of the last released entry
+	released  bool
+}
+
+type reorderItem struct {
+	entry   models.LogEntry
+	at      int64 // entry.Timestamp() in unix nanoseconds
+	arrived time.Time
+	seq     uint64 // keeps equal timestamps in arrival order
+	from    resumePoint
+}
+
+// resumePoint is where reading resumes to get an entry again after a
+// restart: the file offset of its first line, or the cursor of the journal
+// entry read before it.
+type resumePoint struct {
+	offset int64
+	cursor string
+}
+
+type reorderHeap []reorderItem
+
+func (h reorderHeap) Len() int { return len(h) }
+func (h reorderHeap) Less(i, j int) bool {
+	if h[i].at != h[j].at {
+		return h[i].at < h[j].at
+	}
+	return h[i].seq < h[j].seq
+}
+func (h reorderHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *reorderHeap) Push(x any)   { *h = append(*h, x.(reorderItem)) }
+func (h *reorderHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+func newReorderBuffer(window time.Duration) *reorderBuffer {
+	return &reorderBuffer{window: window}
+}
+
+// add buffers entry, read from from. It returns false, without buffering,
+// when the entry is older than what has already been released.
+func (b *reorderBuffer) add(entry models.LogEntry, now time.Time, from resumePoint) bool {
+	at := entry.Timestamp().UnixNano()
+	if b.released && at < b.watermark {
+		return false
+	}
+	b.seq++
+	heap.Push(&b.items, reorderItem{entry: entry, at: at, arrived: now, seq: b.seq, from: from})
+	return true
+}
+
+// resume returns where reading resumes to get every buffered entry again,
+// that of the first one read, and false when nothing is buffered.
+func (b *reorderBuffer) resume() (resumePoint, bool) {
+	if len(b.items) == 0 {
+		return resumePoint{}, false
+	}
+	first := b.items[0]
+	for _, item := range b.items[1:] {
+		if item.seq < first.seq {
+			first = item
+		}
+	}
+	return first.from, true
+}
+
+// due returns when the next entry to release will have waited out the
+// window, and false when nothing is buffered.
+func (b *reorderBuffer) due() (time.Time, bool) {
+	if len(b.items) == 0 {
+		return time.Time{}, false
+	}
+	return b.items[0].arrived.Add(b.window), true
+}
+
+// next returns the oldest buffered entry if it has waited out the window,
+// the buffer is over its limit, or all is set.
+func (b *reorderBuffer) next(now time.Time, all bool) (models.LogEntry, bool) {
+	if len(b.items) == 0 {
+		return models.LogEntry{}, false
+	}
+	head := b.items[0]
+	if !all && len(b.items) <= reorderLimit && now.Sub(head.arrived) < b.window {
+		return models.LogEntry{}, false
+	}
+	heap.Pop(&b.items)
+	b.watermark = head.at
+	b.released = true
+	return head.entry, true
+}
@@ -0,0 +1,128 @@
+package forwarder
+
+import (
+	"testing"
+	"time"
+
+	"katalog/internal/models"
+)
+
+func TestReorderBuffer(t *testing.T) {
+	b := newReorderBuffer(time.Second)
+	start := time.Now()
+
+	// Interleaved writes from several threads arrive slightly out of order
+	for _, ts := range []int64{103, 101, 102, 101} {
+		if !b.add(models.LogEntry{Time: ts, Event: "t"}, start, resumePoint{}) {
+			t.Fatalf("Entry %d should have been buffered", ts)
+		}
+	}
+
+	// Nothing is released before the window elapses
+	if _, ok := b.next(start.Add(500*time.Millisecond), false); ok {
+		t.Fatal("Entry released before the reorder window elapsed")
+	}
+
+	// Afterwards entries come out in timestamp order
+	var got []int64
+	for {
+		e, ok := b.next(start.Add(time.Second), false)
+		if !ok {
+			break
+		}
+		got = append(got, e.Time)
+	}
+	expected := []int64{101, 101, 102, 103}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, got)
+		}
+	}
+
+	// An entry older than the watermark is late and not buffered
+	if b.add(models.LogEntry{Time: 100}, start, resumePoint{}) {
+		t.Error("Expected entry older than the watermark to be reported late")
+	}
+	if !b.add(models.LogEntry{Time: 103}, start, resumePoint{}) {
+		t.Error("Expected entry at the watermark to be buffered")
+	}
+
+	// all releases regardless of the window
+	if _, ok := b.next(start, true); !ok {
+		t.Error("Expected forced release of buffered entry")
+	}
+}
+
+func TestReorderBufferLimit(t *testing.T) {
+	b := newReorderBuffer(time.Hour)
+	now := time.Now()
+	for i := 0; i <= reorderLimit; i++ {
+		b.add(models.LogEntry{Time: int64(reorderLimit - i)}, now, resumePoint{})
+	}
+	// Over the limit the oldest timestamp is released early
+	e, ok := b.next(now, false)
+	if !ok || e.Time != 0 {
+		t.Errorf("Expected early release of the oldest entry, got %v (ok=%v)", e.Time, ok)
+	}
+	if _, ok := b.next(now, false); ok {
+		t.Error("Expected no further release once back under the limit")
+	}
+}
+
+func TestReorderBufferSubSecond(t *testing.T) {
+	b := newReorderBuffer(time.Second)
+	now := time.Now()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Millisecond timestamps out of order within the same second
+	for _, ms := range []int{300, 100, 200} {
+		ts := base.Add(time.Duration(ms) * time.Millisecond)
+		b.add(models.LogEntry{Time: ts.Unix(), TimeNano: ts.UnixNano(), Event: "t"}, now, resumePoint{})
+	}
+	var got []int
+	for {
+		e, ok := b.next(now, true)
+		if !ok {
+			break
+		}
+		got = append(got, int(e.Timestamp().Sub(base)/time.Millisecond))
+	}
+	if len(got) != 3 || got[0] != 100 || got[1] != 200 || got[2] != 300 {
+		t.Fatalf("Expected [100 200 300], got %v", got)
+	}
+
+	// The watermark is as precise: an earlier millisecond of that second is late
+	late := base.Add(250 * time.Millisecond)
+	if b.add(models.LogEntry{Time: late.Unix(), TimeNano: late.UnixNano()}, now, resumePoint{}) {
+		t.Error("Expected entry older than the watermark to be reported late")
+	}
+}
+
+func TestReorderBufferResume(t *testing.T) {
+	b := newReorderBuffer(time.Second)
+	now := time.Now()
+	if _, ok := b.resume(); ok {
+		t.Error("Expected no resume point while empty")
+	}
+	// Read at offsets 0, 10 and 20, the first one with the latest time
+	for i, ts := range []int64{103, 101, 102} {
+		b.add(models.LogEntry{Time: ts}, now, resumePoint{offset: int64(i * 10)})
+	}
+	if from, _ := b.resume(); from.offset != 0 {
+		t.Errorf("Expected to resume at the first entry read, got %d", from.offset)
+	}
+	// Releasing 101 and 102 leaves 103, still the first read
+	b.next(now, true)
+	b.next(now, true)
+	if from, _ := b.resume(); from.offset != 0 {
+		t.Errorf("Expected to resume at offset 0, got %d", from.offset)
+	}
+	b.next(now, true)
+	b.add(models.LogEntry{Time: 104}, now, resumePoint{offset: 30})
+	if from, _ := b.resume(); from.offset != 30 {
+		t.Errorf("Expected to resume at offset 30, got %d", from.offset)
+	}
+}
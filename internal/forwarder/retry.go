@@ -0,0 +1,219 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"katalog/internal/metrics"
+)
+
+// Backoff between retries of a failed batch by the network transports:
+// doubling from the base up to the max, each delay spread by up to
+// retryJitter of itself either way so that agents don't retry in lockstep.
+var (
+	retryBase   = time.Second
+	retryMax    = 30 * time.Second
+	retryJitter = 0.2
+)
+
+// maxRetryAfter bounds how long a Retry-After header may hold up a batch.
+const maxRetryAfter = 5 * time.Minute
+
+// RetryPolicy is how a network transport retries a failed batch: up to
+// MaxAttempts attempts in all, waiting from BaseDelay, doubling up to
+// MaxDelay, in between. Jitter, between 0 and 1, is the fraction of each
+// delay it may be shortened or lengthened by at random.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// defaultRetryPolicy allows maxRetries retries with the shared backoff.
+func defaultRetryPolicy(maxRetries int) RetryPolicy {
+	return RetryPolicy{MaxAttempts: maxRetries + 1, BaseDelay: retryBase, MaxDelay: retryMax, Jitter: retryJitter}
+}
+
+// Do calls attempt until it succeeds, fails with an error that isn't
+// retryable, has been called MaxAttempts times, or ctx is done, and returns
+// its last error. Once ctx is done no attempt is made at all. A delay asked
+// for by a Retry-After header replaces the backoff. Every retry is counted
+// in katalog_retries_total by sink and outcome: success, error, or canceled
+// when ctx ended the wait.
+func (p RetryPolicy) Do(ctx context.Context, sink string, attempt func() error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("retries stopped: %w", err)
+	}
+	delay := p.BaseDelay
+	for n := 1; ; n++ {
+		err := attempt()
+		if n > 1 {
+			outcome := "success"
+			if err != nil {
+				outcome = "error"
+			}
+			metrics.Retries.WithLabelValues(sink, outcome).Inc()
+		}
+		if err == nil || !retryable(err) || n >= p.MaxAttempts {
+			return err
+		}
+		wait := p.jittered(delay)
+		var se *statusError
+		if errors.As(err, &se) && se.retryAfter > 0 {
+			wait = min(se.retryAfter, maxRetryAfter)
+		}
+		slog.Warn("Error sending batch, retrying", "sink", sink, "attempt", n, "retry_in", wait, "error", err)
+		if !sleepContext(ctx, wait) {
+			metrics.Retries.WithLabelValues(sink, "canceled").Inc()
+			return err
+		}
+		delay = min(delay*2, p.MaxDelay)
+	}
+}
+
+func (p RetryPolicy) jittered(d time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + p.Jitter*(2*rand.Float64()-1)))
+}
+
+// sleepContext waits for d and reports whether ctx stayed live meanwhile.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retrier is embedded by the network transports: their retry policy, the
+// context that StopRetries cancels, and where the entries they give up on
+// go.
+type retrier struct {
+	policy     RetryPolicy
+	ctx        context.Context
+	stop       context.CancelFunc
+	deadLetter *DeadLetter     // nil without a dead-letter file
+	onDrop     func(err error) // nil without SetOnDrop
+}
+
+func newRetrier(maxRetries int) retrier {
+	ctx, stop := context.WithCancel(context.Background())
+	return retrier{policy: defaultRetryPolicy(maxRetries), ctx: ctx, stop: stop}
+}
+
+// retry runs attempt under the transport's policy, as sink.
+func (r *retrier) retry(sink string, attempt func() error) error {
+	return r.policy.Do(r.ctx, sink, attempt)
+}
+
+// StopRetries makes the transport give up a failing batch at once instead
+// of waiting to retry it, so that it can't hold up a shutdown.
+func (r *retrier) StopRetries() {
+	r.stop()
+}
+
+// SetDeadLetter makes the transport append the entries of every batch it
+// gives up on to d.
+func (r *retrier) SetDeadLetter(d *DeadLetter) {
+	r.deadLetter = d
+}
+
+// SetOnDrop makes the transport call f with the error of every batch it
+// gives up on. Write still succeeds for such a batch, so this is how the
+// caller learns of it.
+func (r *retrier) SetOnDrop(f func(err error)) {
+	r.onDrop = f
+}
+
+// giveUp hands entries, which failed with err, to the dead-letter file if
+// there is one, and reports the drop.
+func (r *retrier) giveUp(entries [][]byte, err error) {
+	if r.deadLetter != nil {
+		r.deadLetter.Add(entries, err)
+	}
+	if r.onDrop != nil {
+		r.onDrop(err)
+	}
+}
+
+// statusError is a response with a status other than 2xx. retryAfter is the
+// delay its Retry-After header asked for, if any.
+type statusError struct {
+	status     string
+	code       int
+	msg        []byte
+	retryAfter time.Duration
+}
+
+// newStatusError describes resp, with msg the start of its body.
+func newStatusError(resp *http.Response, msg []byte) error {
+	return &statusError{
+		status:     resp.Status,
+		code:       resp.StatusCode,
+		msg:        msg,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+func (e *statusError) Error() string {
+	if len(e.msg) == 0 {
+		return "unexpected status " + e.status
+	}
+	return fmt.Sprintf("unexpected status %s: %s", e.status, e.msg)
+}
+
+// permanentError is a failure retrying can't fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// permanent marks err as not worth retrying.
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// retryable reports whether a batch that failed with err is worth sending
+// again: after a 5xx, 408 or 429 response, or a request error such as a
+// timeout or a refused connection, but not after another 4xx response or an
+// error marked permanent.
+func retryable(err error) bool {
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return false
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500 || se.code == http.StatusRequestTimeout || se.code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// parseRetryAfter reads a Retry-After header, given in seconds or as an
+// HTTP date. It returns 0 when there is none or it has passed.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "Server Error", err: &statusError{code: http.StatusBadGateway}, want: true},
+		{name: "Request Timeout", err: &statusError{code: http.StatusRequestTimeout}, want: true},
+		{name: "Too Many Requests", err: &statusError{code: http.StatusTooManyRequests}, want: true},
+		{name: "Bad Request", err: &statusError{code: http.StatusBadRequest}, want: false},
+		{name: "Forbidden", err: &statusError{code: http.StatusForbidden}, want: false},
+		{name: "Request Error", err: errors.New("connection refused"), want: true},
+		{name: "Permanent", err: permanent(errors.New("invalid url")), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond, Jitter: 0.2}
+	success := metrics.Retries.WithLabelValues("test", "success")
+	failed := metrics.Retries.WithLabelValues("test", "error")
+
+	// 1. A 5xx is retried until the attempt succeeds
+	successBefore, failedBefore := counterValue(t, success), counterValue(t, failed)
+	calls := 0
+	err := policy.Do(context.Background(), "test", func() error {
+		calls++
+		if calls < 3 {
+			return &statusError{code: http.StatusServiceUnavailable}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("Expected success on the 3rd attempt, got %v after %d", err, calls)
+	}
+	if got := counterValue(t, failed) - failedBefore; got != 1 {
+		t.Errorf("Expected 1 failed retry, got %.0f", got)
+	}
+	if got := counterValue(t, success) - successBefore; got != 1 {
+		t.Errorf("Expected 1 successful retry, got %.0f", got)
+	}
+
+	// 2. A 4xx isn't retried
+	calls = 0
+	err = policy.Do(context.Background(), "test", func() error {
+		calls++
+		return &statusError{code: http.StatusBadRequest}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Expected 1 attempt and its error, got %v after %d", err, calls)
+	}
+
+	// 3. Attempts stop at MaxAttempts
+	calls = 0
+	err = policy.Do(context.Background(), "test", func() error {
+		calls++
+		return errors.New("connection refused")
+	})
+	if err == nil || calls != policy.MaxAttempts {
+		t.Errorf("Expected %d attempts and the last error, got %v after %d", policy.MaxAttempts, err, calls)
+	}
+}
+
+func TestRetryPolicyDoCanceled(t *testing.T) {
+	// A backoff far longer than the test, cut short by the context
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	canceled := metrics.Retries.WithLabelValues("test", "canceled")
+	before := counterValue(t, canceled)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	calls := 0
+	err := policy.Do(ctx, "test", func() error {
+		calls++
+		return errors.New("timeout")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Expected 1 attempt and its error, got %v after %d", err, calls)
+	}
+	if got := counterValue(t, canceled) - before; got != 1 {
+		t.Errorf("Expected 1 canceled retry, got %.0f", got)
+	}
+
+	// Once stopped, batches are given up without an attempt
+	calls = 0
+	err = policy.Do(ctx, "test", func() error {
+		calls++
+		return nil
+	})
+	if err == nil || calls != 0 {
+		t.Errorf("Expected no attempt and an error, got %v after %d", err, calls)
+	}
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	policy := RetryPolicy{Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		if got := policy.jittered(time.Second); got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("Expected a delay within 20%% of 1s, got %v", got)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package forwarder
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"katalog/internal/models"
+)
+
+// reverseBlockSize is how much ReadLastLines reads per backward step.
+var reverseBlockSize int64 = 4096
+
+// ReadLastLines returns up to the last n lines of the file at path, oldest
+// first, without reading the whole file: it seeks back from the end one
+// block at a time until it has seen enough newlines. A trailing newline
+// does not count as an empty last line. Splitting on '\n' is safe for
+// UTF-8 content since that byte never occurs inside a multibyte sequence.
+func ReadLastLines(path string, n int) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	end := fi.Size()
+
+	var tail []byte
+	newlines := 0
+	for pos := end; pos > 0; {
+		size := min(reverseBlockSize, pos)
+		pos -= size
+		block := make([]byte, size)
+		if _, err := f.ReadAt(block, pos); err != nil && err != io.EOF {
+			return nil, err
+		}
+		tail = append(block, tail...)
+		newlines += bytes.Count(block, []byte{'\n'})
+		// n lines need n separators before them, plus possibly a trailing one
+		if newlines > n {
+			break
+		}
+	}
+
+	tail = bytes.TrimSuffix(tail, []byte{'\n'})
+	if len(tail) == 0 {
+		return nil, nil
+	}
+	lines := bytes.Split(tail, []byte{'\n'})
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = string(line)
+	}
+	return out, nil
+}
+
+// ProcessLines runs lines through the same filtering, multiline assembly and
+// enrichment as TailFile, sending the resulting entries on out. It blocks
+// while out is full and does not close it.
+func ProcessLines(path string, lines []string, out chan<- models.LogEntry, opts TailOptions) {
+	// The first line may well be the middle of a multiline entry
+	t := &tailer{path: path, opts: opts, out: out, skipPartial: true}
+	t.loadFields()
+	t.init()
+	for _, line := range lines {
+		t.handleLine([]byte(line))
+	}
+	t.flushBuffer()
+	t.releaseReordered(true, nil)
+}
+
+// ProcessFile runs the whole file at path, from the start, through the same
+// logic as TailFile and sends the resulting entries to out, returning at
+// EOF. out must be drained concurrently.
+func ProcessFile(path string, out chan<- models.LogEntry, opts TailOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	t := &tailer{path: path, opts: opts, out: out, file: f, lines: newLineReader(f, opts.lineDelimiter(), opts.MaxLineBytes)}
+	t.loadFields()
+	t.init()
+	for {
+		line, err := t.lines.next()
+		if err == io.EOF {
+			if rest := t.lines.rest(); len(rest) > 0 {
+				t.handleLine(rest)
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		t.handleLine(line)
+	}
+	t.flushBuffer()
+	t.releaseReordered(true, nil)
+	return nil
+}
@@ -0,0 +1,117 @@
+package forwarder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"katalog/internal/models"
+)
+
+func TestReadLastLines(t *testing.T) {
+	// Small blocks so the backward scan crosses several block boundaries
+	orig := reverseBlockSize
+	reverseBlockSize = 7
+	t.Cleanup(func() { reverseBlockSize = orig })
+
+	var many []string
+	for i := 1; i <= 50; i++ {
+		many = append(many, fmt.Sprintf("line %d", i))
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		n        int
+		expected []string
+	}{
+		{"Last Lines Of Long File", strings.Join(many, "\n") + "\n", 3, []string{"line 48", "line 49", "line 50"}},
+		{"File Smaller Than Window", "a\nb\n", 10, []string{"a", "b"}},
+		{"No Trailing Newline", "a\nb\nc", 2, []string{"b", "c"}},
+		{"Empty File", "", 5, nil},
+		{"Multibyte Content", "héllo wörld\n日本語のログ\nçà et là\n", 2, []string{"日本語のログ", "çà et là"}},
+		{"Blank Lines Are Kept", "a\n\nb\n", 3, []string{"a", "", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tail.log")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			lines, err := ReadLastLines(path, tt.n)
+			if err != nil {
+				t.Fatalf("ReadLastLines returned error: %v", err)
+			}
+			if !reflect.DeepEqual(lines, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, lines)
+			}
+		})
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	out := make(chan models.LogEntry, 10)
+	ProcessLines("/var/log/app.log", []string{
+		"2023-01-01 ERROR boom",
+		"\tat Main.java:1",
+		"2023-01-01 DEBUG noisy",
+		"2023-01-01 INFO done",
+	}, out, TailOptions{
+		GroupName:        "app",
+		Hostname:         "test-host",
+		ExcludeRegexes:   []*regexp.Regexp{regexp.MustCompile("DEBUG")},
+		MultilineRegexes: []*regexp.Regexp{regexp.MustCompile(`^\d{4}`)},
+	})
+	close(out)
+
+	var events []string
+	for e := range out {
+		if e.Source != "app.log" || e.SourceType != "app" {
+			t.Errorf("Unexpected enrichment: source=%s sourcetype=%s", e.Source, e.SourceType)
+		}
+		events = append(events, e.Event)
+	}
+	expected := []string{"2023-01-01 ERROR boom\n\tat Main.java:1", "2023-01-01 INFO done"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected %q, got %q", expected, events)
+	}
+}
+
+func TestProcessFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample.log")
+	// Starts with a continuation line and has no trailing newline
+	content := "\tat Orphan.java:1\n2023-01-01 ERROR boom\n\tat Main.java:1\n2023-01-01 DEBUG noisy\n2023-01-01 INFO done"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var excluded []string
+	out := make(chan models.LogEntry, 10)
+	err := ProcessFile(path, out, TailOptions{
+		ExcludeRegexes:   []*regexp.Regexp{regexp.MustCompile("DEBUG")},
+		MultilineRegexes: []*regexp.Regexp{regexp.MustCompile(`^\d{4}`)},
+		OnExclude:        func(msg string) { excluded = append(excluded, msg) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	var events []string
+	for e := range out {
+		events = append(events, e.Event)
+	}
+	// Reading from the start, the leading line is a real entry, not a partial one
+	expected := []string{"at Orphan.java:1", "2023-01-01 ERROR boom\n\tat Main.java:1", "2023-01-01 INFO done"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected %q, got %q", expected, events)
+	}
+	if !reflect.DeepEqual(excluded, []string{"2023-01-01 DEBUG noisy"}) {
+		t.Errorf("Unexpected excluded messages: %q", excluded)
+	}
+}
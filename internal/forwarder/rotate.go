@@ -0,0 +1,93 @@
+package forwarder
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"katalog/internal/metrics"
+)
+
+// rotatingFile appends to a file and rotates it once a write would take it
+// past maxSize: path.N-1 becomes path.N and so on down to path becoming
+// path.1, keeping at most maxBackups old files. Each Write is a whole batch
+// of entries, so rotation never splits one. The current file is renamed
+// while still open and only closed once its successor is, so if the new
+// file cannot be opened writes carry on to the renamed one and nothing is
+// lost.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewRotatingFile opens (or appends to) the file at path. maxBackups of 0
+// means 5.
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (io.WriteCloser, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file transport requires a path")
+	}
+	if maxBackups <= 0 {
+		maxBackups = 5
+	}
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		r.rotate()
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups and starts a new file. On failure it keeps
+// writing to the current one.
+func (r *rotatingFile) rotate() {
+	backup := func(i int) string { return fmt.Sprintf("%s.%d", r.path, i) }
+	os.Remove(backup(r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backup(i), backup(i+1)); err != nil && !os.IsNotExist(err) {
+			slog.Error("Error rotating", "path", backup(i), "error", err)
+		}
+	}
+	if err := os.Rename(r.path, backup(1)); err != nil {
+		slog.Error("Error rotating", "path", r.path, "error", err)
+		return
+	}
+	old := r.file
+	if err := r.open(); err != nil {
+		slog.Error("Error reopening after rotation", "path", r.path, "error", err)
+		// Keep appending to the renamed file rather than losing entries
+		r.file = old
+		return
+	}
+	old.Close()
+	metrics.OutputRotations.WithLabelValues(r.path).Inc()
+}
+
+func (r *rotatingFile) Close() error {
+	return r.file.Close()
+}
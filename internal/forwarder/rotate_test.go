@@ -0,0 +1,54 @@
+package forwarder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"katalog/internal/metrics"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	counter := metrics.OutputRotations.WithLabelValues(path)
+	before := counterValue(t, counter)
+
+	f, err := NewRotatingFile(path, 20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1. Seven 10-byte batches into files of at most 20 bytes
+	for _, c := range "abcdefg" {
+		if _, err := f.Write([]byte(strings.Repeat(string(c), 9) + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2. The newest batches are in path, older ones shifted down; the
+	// oldest beyond max_backups are gone
+	want := map[string]string{
+		path:        "ggggggggg\n",
+		path + ".1": "eeeeeeeee\nfffffffff\n",
+		path + ".2": "ccccccccc\nddddddddd\n",
+	}
+	for p, content := range want {
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("Expected %s to hold %q, got %q", filepath.Base(p), content, got)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected no third backup, got %v", err)
+	}
+	if got := counterValue(t, counter) - before; got != 3 {
+		t.Errorf("Expected 3 rotations, got %.0f", got)
+	}
+}
@@ -0,0 +1,177 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"katalog/internal/models"
+)
+
+// Serializer renders one entry, including its trailing newline, to w.
+// Serializers are independent of where the bytes end up (the transport).
+type Serializer interface {
+	Serialize(w io.Writer, entry models.LogEntry) error
+}
+
+// NewSerializer returns the serializer registered under name.
+func NewSerializer(name string) (Serializer, error) {
+	switch name {
+	case "", "json":
+		return JSONSerializer{}, nil
+	case "json_array":
+		// Framed into arrays by NewJSONArrayWriter
+		return JSONSerializer{}, nil
+	case "raw":
+		return RawSerializer{}, nil
+	case "logfmt":
+		return LogfmtSerializer{}, nil
+	case "cef":
+		return CEFSerializer{}, nil
+	case "rfc5424":
+		return RFC5424Serializer{}, nil
+	case "rfc3164":
+		return RFC3164Serializer{}, nil
+	case "loki":
+		return LokiSerializer{}, nil
+	case "hec":
+		return HECSerializer{}, nil
+	case "kafka":
+		return KafkaSerializer{}, nil
+	case "otlp":
+		return OTLPSerializer{}, nil
+	case "fluentd":
+		return FluentdSerializer{}, nil
+	case "datadog":
+		return DatadogSerializer{}, nil
+	}
+	return nil, fmt.Errorf("unknown serializer: %s", name)
+}
+
+// TargetSerializer renders the entries of the targets in ByTarget, keyed by
+// target name (the entry's SourceType), with their own serializer, and all
+// other entries with Default.
+type TargetSerializer struct {
+	Default  Serializer
+	ByTarget map[string]Serializer
+}
+
+func (s TargetSerializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	if ts, ok := s.ByTarget[entry.SourceType]; ok {
+		return ts.Serialize(w, entry)
+	}
+	return s.Default.Serialize(w, entry)
+}
+
+// JSONSerializer writes newline-delimited JSON.
+type JSONSerializer struct{}
+
+func (JSONSerializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// RawSerializer writes only the event text.
+type RawSerializer struct{}
+
+func (RawSerializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	_, err := io.WriteString(w, entry.Event+"\n")
+	return err
+}
+
+// LogfmtSerializer writes key=value pairs. Custom fields follow the
+// built-in keys in sorted order.
+type LogfmtSerializer struct{}
+
+func (LogfmtSerializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	var b strings.Builder
+	writePair := func(k, v string) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(v))
+	}
+	writePair("time", strconv.FormatInt(entry.Time, 10))
+	writePair("host", entry.Host)
+	writePair("source", entry.Source)
+	writePair("sourcetype", entry.SourceType)
+	writePair("event", entry.Event)
+	for _, k := range sortedKeys(entry.Fields) {
+		writePair(k, entry.Fields[k])
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// logfmtValue quotes v when it is empty or contains spaces, quotes, '=' or
+// control characters.
+func logfmtValue(v string) string {
+	if v == "" {
+		return `""`
+	}
+	if strings.ContainsAny(v, " \"=\t\r\n\\") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// CEFSerializer writes ArcSight Common Event Format lines. The target name
+// becomes the signature ID and the event goes into the msg extension.
+type CEFSerializer struct{}
+
+// cefMaxName is the longest Name header CEF allows, in bytes.
+const cefMaxName = 128
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtEscaper    = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+func (CEFSerializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	var b strings.Builder
+	name := entry.Event
+	if i := strings.IndexByte(name, '\n'); i >= 0 {
+		name = name[:i]
+	}
+	if len(name) > cefMaxName {
+		// Back off to a rune boundary so the name stays valid UTF-8
+		n := cefMaxName
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+		name = name[:n]
+	}
+	fmt.Fprintf(&b, "CEF:0|katalog|katalog|1.0|%s|%s|5|",
+		cefHeaderEscaper.Replace(entry.SourceType), cefHeaderEscaper.Replace(name))
+	fmt.Fprintf(&b, "rt=%d dvchost=%s fname=%s msg=%s",
+		entry.Timestamp().UnixMilli(),
+		cefExtEscaper.Replace(entry.Host),
+		cefExtEscaper.Replace(entry.Source),
+		cefExtEscaper.Replace(entry.Event))
+	for _, k := range sortedKeys(entry.Fields) {
+		fmt.Fprintf(&b, " %s=%s", k, cefExtEscaper.Replace(entry.Fields[k]))
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,125 @@
+package forwarder
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"katalog/internal/models"
+)
+
+func TestSerializers(t *testing.T) {
+	entry := models.LogEntry{
+		Time:       1672531200,
+		Host:       "web-1",
+		Source:     "app.log",
+		SourceType: "app",
+		Event:      `user "bob" logged in`,
+		Fields:     map[string]string{"env": "prod", "dc": "eu west"},
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"json", `{"time":1672531200,"host":"web-1","source":"app.log","sourcetype":"app","event":"user \"bob\" logged in","fields":{"dc":"eu west","env":"prod"}}` + "\n"},
+		{"raw", `user "bob" logged in` + "\n"},
+		{"logfmt", `time=1672531200 host=web-1 source=app.log sourcetype=app event="user \"bob\" logged in" dc="eu west" env=prod` + "\n"},
+		{"cef", `CEF:0|katalog|katalog|1.0|app|user "bob" logged in|5|rt=1672531200000 dvchost=web-1 fname=app.log msg=user "bob" logged in dc=eu west env=prod` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSerializer(tt.name)
+			if err != nil {
+				t.Fatalf("NewSerializer(%q) returned error: %v", tt.name, err)
+			}
+			var buf bytes.Buffer
+			if err := s.Serialize(&buf, entry); err != nil {
+				t.Fatalf("Serialize returned error: %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tt.expected, buf.String())
+			}
+		})
+	}
+
+	if _, err := NewSerializer("xml"); err == nil {
+		t.Error("Expected error for unknown serializer")
+	}
+}
+
+func TestCEFSerializerEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	err := CEFSerializer{}.Serialize(&buf, models.LogEntry{
+		SourceType: "a|b",
+		Event:      "k=v\nsecond line",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `CEF:0|katalog|katalog|1.0|a\|b|k=v|5|rt=0 dvchost= fname= msg=k\=v\nsecond line` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
+
+func TestCEFSerializerTruncatesName(t *testing.T) {
+	// 127 ASCII bytes, then 2-byte runes: byte 128 falls inside a rune
+	event := strings.Repeat("a", 127) + strings.Repeat("é", 10)
+	var buf bytes.Buffer
+	if err := (CEFSerializer{}).Serialize(&buf, models.LogEntry{SourceType: "app", Event: event}); err != nil {
+		t.Fatal(err)
+	}
+	name := strings.Split(buf.String(), "|")[5]
+	if !utf8.ValidString(name) {
+		t.Errorf("Expected a valid UTF-8 name, got %q", name)
+	}
+	if want := strings.Repeat("a", 127); name != want {
+		t.Errorf("Expected the name cut before the split rune, got %q", name)
+	}
+}
+
+func TestWriteLogsFileTransport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	if err := os.WriteFile(path, []byte("existing\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := OpenTransport("file", path)
+	if err != nil {
+		t.Fatalf("OpenTransport returned error: %v", err)
+	}
+
+	outCh := make(chan models.LogEntry, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		WriteLogs(outCh, dst, LogfmtSerializer{})
+	}()
+	outCh <- models.LogEntry{Time: 1, Host: "h", Source: "s", SourceType: "g", Event: "hello"}
+	close(outCh)
+	wg.Wait()
+	if err := dst.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file transport appends rather than truncating
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "existing\ntime=1 host=h source=s sourcetype=g event=hello\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(data))
+	}
+
+	if _, err := OpenTransport("file", ""); err == nil {
+		t.Error("Expected error for file transport without a path")
+	}
+}
@@ -0,0 +1,383 @@
+package forwarder
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"katalog/internal/metrics"
+)
+
+// Segment files rotate once they reach this size, so delivered data is
+// freed a segment at a time.
+var spoolSegmentSize int64 = 4 << 20
+
+// How long Close waits for a batch being delivered before giving up on it,
+// leaving it to be replayed on the next start.
+var spoolCloseTimeout = 5 * time.Second
+
+// SpoolConfig configures a disk spool in front of a transport. Name labels
+// its metrics. MaxBytes caps the undelivered data kept in Dir; when a batch
+// doesn't fit, the oldest segments are dropped with DropOldest, and
+// otherwise the write blocks until enough has been delivered.
+type SpoolConfig struct {
+	Name       string
+	Dir        string
+	MaxBytes   int64
+	DropOldest bool
+}
+
+// spoolSegment is one file of the spool, holding length-prefixed batches.
+type spoolSegment struct {
+	seq  uint64
+	size int64
+}
+
+// spool writes every batch to segment files on disk and returns; a
+// goroutine delivers them to the transport in order, removing each
+// segment once it has been sent in full. How far delivery got is kept in
+// a cursor file, so batches still on disk are sent after a restart. The
+// transport's own retries still apply: a batch it gives up on is gone.
+type spool struct {
+	dst io.WriteCloser
+	cfg SpoolConfig
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	segs    []spoolSegment // oldest first; the last one is written to
+	head    *os.File
+	readOff int64 // offset of the next batch in segs[0]
+	size    int64 // undelivered bytes on disk
+	closed  bool  // no more writes; deliver what is left
+	// delivering is set while a batch is with the transport, which can't be
+	// interrupted
+	delivering bool
+
+	stop chan struct{} // closed when Close stops waiting for delivery
+	done chan struct{} // closed when the delivery goroutine returns
+}
+
+// NewSpool returns a writer spooling batches for dst in cfg.Dir, picking up
+// whatever a previous run left undelivered there.
+func NewSpool(dst io.WriteCloser, cfg SpoolConfig) (io.WriteCloser, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool dir: %w", err)
+	}
+	s := &spool{dst: dst, cfg: cfg, stop: make(chan struct{}), done: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	// Always append to a fresh segment: the last one of a previous run may
+	// end in a batch cut short by a crash
+	if err := s.rotate(); err != nil {
+		return nil, err
+	}
+	s.updateMetrics()
+	go s.run()
+	return s, nil
+}
+
+// load picks up the segments and cursor left by a previous run.
+func (s *spool) load() error {
+	paths, err := filepath.Glob(filepath.Join(s.cfg.Dir, "*.seg"))
+	if err != nil {
+		return err
+	}
+	var curSeq uint64
+	var curOff int64
+	if b, err := os.ReadFile(s.cursorPath()); err == nil {
+		fmt.Sscanf(string(b), "%d %d", &curSeq, &curOff)
+	}
+	for _, path := range paths {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), ".seg"), 10, 64)
+		if err != nil {
+			continue
+		}
+		if seq < curSeq {
+			// Delivered in full, but not yet removed
+			os.Remove(path)
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		s.segs = append(s.segs, spoolSegment{seq: seq, size: fi.Size()})
+		s.size += fi.Size()
+	}
+	sort.Slice(s.segs, func(i, j int) bool { return s.segs[i].seq < s.segs[j].seq })
+	if len(s.segs) > 0 && s.segs[0].seq == curSeq {
+		s.readOff = min(curOff, s.segs[0].size)
+		s.size -= s.readOff
+	}
+	if s.size > 0 {
+		slog.Info("Spool has undelivered bytes from a previous run", "dir", s.cfg.Dir, "bytes", s.size)
+	}
+	return nil
+}
+
+func (s *spool) segPath(seq uint64) string {
+	return filepath.Join(s.cfg.Dir, fmt.Sprintf("%020d.seg", seq))
+}
+
+func (s *spool) cursorPath() string {
+	return filepath.Join(s.cfg.Dir, "cursor")
+}
+
+// rotate starts a new head segment. Called with mu held (or before the
+// spool is shared).
+func (s *spool) rotate() error {
+	seq := uint64(1)
+	if len(s.segs) > 0 {
+		seq = s.segs[len(s.segs)-1].seq + 1
+	}
+	f, err := os.OpenFile(s.segPath(seq), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+	if s.head != nil {
+		s.head.Close()
+	}
+	s.head = f
+	s.segs = append(s.segs, spoolSegment{seq: seq})
+	return nil
+}
+
+// Write spools p as one batch. It only fails when the batch could not be
+// written to disk, in which case nothing of it was kept.
+func (s *spool) Write(p []byte) (int, error) {
+	rec := int64(len(p)) + 4
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A batch larger than MaxBytes is still taken once the spool is empty
+	for !s.closed && s.size > 0 && s.size+rec > s.cfg.MaxBytes {
+		if s.cfg.DropOldest {
+			if err := s.dropOldest(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		s.cond.Wait()
+	}
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	last := &s.segs[len(s.segs)-1]
+	if last.size >= spoolSegmentSize {
+		if err := s.rotate(); err != nil {
+			return 0, err
+		}
+		last = &s.segs[len(s.segs)-1]
+	}
+	buf := make([]byte, rec)
+	binary.BigEndian.PutUint32(buf, uint32(len(p)))
+	copy(buf[4:], p)
+	if _, err := s.head.Write(buf); err != nil {
+		// Cut off whatever part of the batch made it to disk
+		s.head.Truncate(last.size)
+		s.head.Seek(last.size, io.SeekStart)
+		return 0, err
+	}
+	last.size += rec
+	s.size += rec
+	metrics.SpoolSpilledBytes.WithLabelValues(s.cfg.Name).Add(float64(len(p)))
+	s.updateMetrics()
+	s.cond.Broadcast()
+	return len(p), nil
+}
+
+// dropOldest discards the undelivered part of the oldest segment, first
+// starting a new head segment if the oldest is the only one. Called with
+// mu held.
+func (s *spool) dropOldest() error {
+	if len(s.segs) == 1 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	dropped := s.segs[0].size - s.readOff
+	os.Remove(s.segPath(s.segs[0].seq))
+	s.segs = s.segs[1:]
+	s.readOff = 0
+	s.size -= dropped
+	s.saveCursor()
+	metrics.SpoolDroppedBytes.WithLabelValues(s.cfg.Name).Add(float64(dropped))
+	s.updateMetrics()
+	return nil
+}
+
+// run delivers spooled batches to the transport until the spool is closed
+// and empty, or Close gives up waiting.
+func (s *spool) run() {
+	defer close(s.done)
+	delay := retryBase
+	for {
+		s.mu.Lock()
+		for s.size == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.size == 0 || s.stopped() {
+			s.mu.Unlock()
+			return
+		}
+		seg, off, isHead := s.segs[0], s.readOff, len(s.segs) == 1
+		s.mu.Unlock()
+
+		p, err := s.read(seg.seq, off, seg.size)
+		if err != nil {
+			if isHead {
+				slog.Warn("Error reading spool, retrying", "dir", s.cfg.Dir, "retry_in", delay, "error", err)
+				if !s.sleep(delay) {
+					return
+				}
+				delay = min(delay*2, retryMax)
+				continue
+			}
+			// The rest of an older segment is unreadable, e.g. a batch
+			// cut short by a crash: move on to the next one
+			slog.Warn("Skipping unreadable rest of spool segment", "dir", s.cfg.Dir, "segment", seg.seq, "error", err)
+			s.mu.Lock()
+			s.advance(seg, off, seg.size-off)
+			s.mu.Unlock()
+			continue
+		}
+		s.mu.Lock()
+		if s.stopped() {
+			s.mu.Unlock()
+			return
+		}
+		s.delivering = true
+		s.mu.Unlock()
+		_, err = s.dst.Write(p)
+		s.mu.Lock()
+		s.delivering = false
+		s.mu.Unlock()
+		if err != nil {
+			slog.Warn("Error delivering spooled batch, retrying", "dir", s.cfg.Dir, "retry_in", delay, "error", err)
+			if !s.sleep(delay) {
+				return
+			}
+			delay = min(delay*2, retryMax)
+			continue
+		}
+		delay = retryBase
+		s.mu.Lock()
+		s.advance(seg, off, int64(len(p))+4)
+		s.mu.Unlock()
+	}
+}
+
+// read returns the batch at off in segment seq, of which size bytes are
+// written.
+func (s *spool) read(seq uint64, off, size int64) ([]byte, error) {
+	f, err := os.Open(s.segPath(seq))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var hdr [4]byte
+	if _, err := f.ReadAt(hdr[:], off); err != nil {
+		return nil, err
+	}
+	n := int64(binary.BigEndian.Uint32(hdr[:]))
+	if off+4+n > size {
+		return nil, errors.New("truncated batch")
+	}
+	p := make([]byte, n)
+	if _, err := f.ReadAt(p, off+4); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// advance marks n bytes at off in seg as delivered, unless seg was dropped
+// in the meantime. Called with mu held.
+func (s *spool) advance(seg spoolSegment, off, n int64) {
+	if s.segs[0].seq != seg.seq || s.readOff != off {
+		return
+	}
+	s.readOff += n
+	s.size -= n
+	if len(s.segs) > 1 && s.readOff >= s.segs[0].size {
+		os.Remove(s.segPath(seg.seq))
+		s.segs = s.segs[1:]
+		s.readOff = 0
+	}
+	s.saveCursor()
+	s.updateMetrics()
+	s.cond.Broadcast()
+}
+
+// saveCursor records how far delivery got. Called with mu held.
+func (s *spool) saveCursor() {
+	cursor := fmt.Sprintf("%d %d\n", s.segs[0].seq, s.readOff)
+	if err := os.WriteFile(s.cursorPath(), []byte(cursor), 0644); err != nil {
+		slog.Error("Error saving spool cursor", "dir", s.cfg.Dir, "error", err)
+	}
+}
+
+func (s *spool) updateMetrics() {
+	metrics.SpoolBytes.WithLabelValues(s.cfg.Name).Set(float64(s.size))
+}
+
+// stopped reports whether Close has stopped waiting for delivery.
+func (s *spool) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits d unless Close stops waiting first.
+func (s *spool) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-s.stop:
+		return false
+	}
+}
+
+// Close delivers what is left for up to spoolCloseTimeout, then stops,
+// leaving undelivered batches on disk for the next start, and closes the
+// transport.
+func (s *spool) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	select {
+	case <-s.done:
+	case <-time.After(spoolCloseTimeout):
+		slog.Warn("Spool not delivered in time, leaving the rest for the next start", "dir", s.cfg.Dir, "timeout", spoolCloseTimeout, "bytes", s.undelivered())
+		close(s.stop)
+		s.mu.Lock()
+		busy := s.delivering
+		s.mu.Unlock()
+		if busy {
+			// Closing the transport under the delivery goroutine isn't safe
+			return s.head.Close()
+		}
+		<-s.done
+	}
+	s.head.Close()
+	return s.dst.Close()
+}
+
+func (s *spool) undelivered() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
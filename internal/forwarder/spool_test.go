@@ -0,0 +1,208 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// flakyTransport records delivered batches, failing every write while down.
+type flakyTransport struct {
+	mu      sync.Mutex
+	down    bool
+	batches []string
+}
+
+func (f *flakyTransport) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return 0, errors.New("collector unreachable")
+	}
+	f.batches = append(f.batches, string(p))
+	return len(p), nil
+}
+
+func (f *flakyTransport) Close() error { return nil }
+
+func (f *flakyTransport) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *flakyTransport) delivered() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.batches...)
+}
+
+// waitDelivered waits until dst has received n batches.
+func waitDelivered(t *testing.T, dst *flakyTransport, n int) []string {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		if got := dst.delivered(); len(got) >= n {
+			return got
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("Timed out waiting for %d batches, got %d", n, len(dst.delivered()))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// spooled returns the spool bytes gauge of output name.
+func spooled(t *testing.T, name string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.SpoolBytes.WithLabelValues(name).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestSpoolReplaysAfterRestart(t *testing.T) {
+	origBase, origTimeout, origSeg := retryBase, spoolCloseTimeout, spoolSegmentSize
+	retryBase, spoolCloseTimeout, spoolSegmentSize = 10*time.Millisecond, 100*time.Millisecond, 64
+	t.Cleanup(func() { retryBase, spoolCloseTimeout, spoolSegmentSize = origBase, origTimeout, origSeg })
+	dir := t.TempDir()
+
+	// 1. The collector is down: batches are accepted and kept on disk
+	down := &flakyTransport{down: true}
+	sp, err := NewSpool(down, SpoolConfig{Name: "replay", Dir: dir, MaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i := 0; i < 10; i++ {
+		batch := fmt.Sprintf("batch %d\n", i)
+		want = append(want, batch)
+		if _, err := sp.Write([]byte(batch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := spooled(t, "replay"); got != 10*(8+4) {
+		t.Errorf("Expected 120 spooled bytes, got %v", got)
+	}
+
+	// 2. Shut down while it is still down
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := down.delivered(); len(got) != 0 {
+		t.Fatalf("Expected nothing delivered while down, got %q", got)
+	}
+
+	// 3. The next start delivers everything, in order, exactly once
+	up := &flakyTransport{}
+	sp, err = NewSpool(up, SpoolConfig{Name: "replay", Dir: dir, MaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.Write([]byte("after restart\n")); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, "after restart\n")
+	got := waitDelivered(t, up, len(want))
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := spooled(t, "replay"); got != 0 {
+		t.Errorf("Expected an empty spool, got %v bytes", got)
+	}
+
+	// 4. Nothing is sent again after another restart
+	again := &flakyTransport{}
+	sp, err = NewSpool(again, SpoolConfig{Name: "replay", Dir: dir, MaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	sp.Close()
+	if got := again.delivered(); len(got) != 0 {
+		t.Errorf("Expected no redelivery, got %q", got)
+	}
+}
+
+func TestSpoolFullPolicies(t *testing.T) {
+	origBase, origTimeout, origSeg := retryBase, spoolCloseTimeout, spoolSegmentSize
+	retryBase, spoolCloseTimeout, spoolSegmentSize = 10*time.Millisecond, 100*time.Millisecond, 24
+	t.Cleanup(func() { retryBase, spoolCloseTimeout, spoolSegmentSize = origBase, origTimeout, origSeg })
+
+	t.Run("Drop Oldest", func(t *testing.T) {
+		dst := &flakyTransport{down: true}
+		sp, err := NewSpool(dst, SpoolConfig{Name: "drop", Dir: t.TempDir(), MaxBytes: 48, DropOldest: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sp.Close()
+
+		// 12-byte batches, two per segment and four fit: the first two
+		// segments make room for the last four batches
+		before := counterValue(t, metrics.SpoolDroppedBytes.WithLabelValues("drop"))
+		for i := 0; i < 8; i++ {
+			if _, err := sp.Write([]byte(fmt.Sprintf("batch %d\n", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if dropped := counterValue(t, metrics.SpoolDroppedBytes.WithLabelValues("drop")) - before; dropped != 48 {
+			t.Errorf("Expected 48 dropped bytes, got %v", dropped)
+		}
+
+		dst.setDown(false)
+		got := waitDelivered(t, dst, 4)
+		want := []string{"batch 4\n", "batch 5\n", "batch 6\n", "batch 7\n"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		dst := &flakyTransport{down: true}
+		sp, err := NewSpool(dst, SpoolConfig{Name: "block", Dir: t.TempDir(), MaxBytes: 24})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sp.Close()
+		for i := 0; i < 2; i++ {
+			if _, err := sp.Write([]byte(fmt.Sprintf("batch %d\n", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The third batch waits for room
+		written := make(chan struct{})
+		go func() {
+			sp.Write([]byte("batch 2\n"))
+			close(written)
+		}()
+		select {
+		case <-written:
+			t.Fatal("Expected the write to block while the spool is full")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		dst.setDown(false)
+		select {
+		case <-written:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for the blocked write")
+		}
+		got := waitDelivered(t, dst, 3)
+		if want := []string{"batch 0\n", "batch 1\n", "batch 2\n"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// StdinPath is the target path standing for katalog's standard input.
+const StdinPath = "-"
+
+// StdinSource is the source name of entries read from standard input.
+const StdinSource = "stdin"
+
+// streamLine is a line read from a stream, copied out of the read buffer.
+type streamLine struct {
+	data      []byte
+	truncated bool
+}
+
+// ReadStream runs every line read from r through the same filtering,
+// multiline assembly and enrichment as TailFile, with name as the source,
+// until r is exhausted or ctx is done; a last unterminated line is complete
+// at EOF. A stream can't be rotated, truncated or resumed, so none of that
+// applies. r is read in a goroutine of its own, as a blocked read can't be
+// interrupted: once ctx is done it is left to the process exit.
+func ReadStream(ctx context.Context, wg *sync.WaitGroup, name string, r io.Reader, out chan<- models.LogEntry, opts TailOptions) {
+	defer wg.Done()
+
+	t := newStreamTailer(ctx, name, out, opts)
+	lines := make(chan streamLine)
+	go readStreamLines(ctx, name, r, opts, lines)
+
+	// Fires when the multiline entry being assembled has been idle for
+	// MultilineTimeout
+	idle := time.NewTimer(time.Hour)
+	idle.Stop()
+	defer idle.Stop()
+	for {
+		if opts.MultilineTimeout > 0 && t.multilineBuffer.Len() > 0 {
+			idle.Reset(time.Until(t.bufferedAt.Add(opts.MultilineTimeout)))
+		}
+		select {
+		case line, ok := <-lines:
+			idle.Stop()
+			if !ok {
+				slog.Debug("Stream closed", "source", name)
+				t.flushBuffer()
+				t.releaseReordered(true, t.deadline)
+				return
+			}
+			t.refreshPatterns()
+			if !t.handle(line.data, line.truncated) || !t.releaseReordered(false, t.done) {
+				return
+			}
+		case <-idle.C:
+			t.flushIdleBuffer()
+		case <-ctx.Done():
+			slog.Debug("Shutting down collector", "source", name)
+			t.flushBuffer()
+			t.releaseReordered(true, t.deadline)
+			return
+		}
+	}
+}
+
+// newStreamTailer sets up a tailer for a source that isn't a file, named
+// name.
+func newStreamTailer(ctx context.Context, name string, out chan<- models.LogEntry, opts TailOptions) *tailer {
+	t := &tailer{path: name, opts: opts, out: out, done: ctx.Done()}
+	t.newProcessors()
+	t.loadFields()
+	t.init()
+	return t
+}
+
+// readStreamLines sends copies of the lines of r until it is exhausted or
+// ctx is done, then closes lines.
+func readStreamLines(ctx context.Context, name string, r io.Reader, opts TailOptions, lines chan<- streamLine) {
+	defer close(lines)
+	lr := newLineReader(r, opts.lineDelimiter(), opts.MaxLineBytes)
+	for {
+		line, err := lr.next()
+		if err != nil {
+			if err != io.EOF {
+				metrics.FileErrors.WithLabelValues(name, "read").Inc()
+				slog.Error("Error reading stream", "source", name, "error", err)
+			}
+			line = lr.rest()
+			if len(line) == 0 {
+				return
+			}
+		}
+		select {
+		case lines <- streamLine{data: bytes.Clone(line), truncated: lr.truncated}:
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
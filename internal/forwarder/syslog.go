@@ -0,0 +1,339 @@
+package forwarder
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+// Syslog facilities and severities by their conventional names.
+var (
+	syslogFacilities = map[string]int{
+		"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+		"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+		"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+		"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+	}
+	syslogSeverities = map[string]int{
+		"emerg": 0, "alert": 1, "crit": 2, "err": 3,
+		"warning": 4, "notice": 5, "info": 6, "debug": 7,
+	}
+)
+
+// DefaultSyslogPriority is local0.info.
+const DefaultSyslogPriority = 16*8 + 6
+
+// SyslogPriority returns the PRI value for a facility and severity name.
+// Empty names default to local0 and info.
+func SyslogPriority(facility, severity string) (int, error) {
+	if facility == "" {
+		facility = "local0"
+	}
+	if severity == "" {
+		severity = "info"
+	}
+	f, ok := syslogFacilities[facility]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility: %s", facility)
+	}
+	s, ok := syslogSeverities[severity]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog severity: %s", severity)
+	}
+	return f*8 + s, nil
+}
+
+// RFC5424Serializer writes RFC 5424 syslog messages. The target name is the
+// APP-NAME; the source path and custom fields go into a structured-data
+// element. For the syslog transports it frames each message with its length
+// (RFC 6587 octet counting) rather than a trailing newline, so multiline
+// events survive a TCP stream intact; elsewhere messages end in a newline
+// like the other serializers' entries.
+type RFC5424Serializer struct {
+	Priorities    map[string]int // PRI by target name; DefaultSyslogPriority otherwise
+	OctetCounting bool           // length-prefix messages instead of ending them in a newline
+}
+
+// sdID names katalog's structured-data element. 32473 is the private
+// enterprise number reserved for documentation (RFC 5612).
+const sdID = "katalog@32473"
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func (s RFC5424Serializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	pri, ok := s.Priorities[entry.SourceType]
+	if !ok {
+		pri = DefaultSyslogPriority
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s - - [%s source=\"%s\"",
+		pri,
+		time.Unix(entry.Time, 0).UTC().Format(time.RFC3339),
+		syslogHeaderField(entry.Host, 255),
+		syslogHeaderField(entry.SourceType, 48),
+		sdID,
+		sdValueEscaper.Replace(entry.Source))
+	for _, k := range sortedKeys(entry.Fields) {
+		fmt.Fprintf(&b, " %s=\"%s\"", sdParamName(k), sdValueEscaper.Replace(entry.Fields[k]))
+	}
+	b.WriteString("] ")
+	b.WriteString(entry.Event)
+	return writeSyslogMessage(w, b.String(), s.OctetCounting)
+}
+
+// writeSyslogMessage writes msg to w as an octet-counted frame ("LEN MSG"),
+// or followed by a newline.
+func writeSyslogMessage(w io.Writer, msg string, octetCounting bool) error {
+	var err error
+	if octetCounting {
+		_, err = fmt.Fprintf(w, "%d %s", len(msg), msg)
+	} else {
+		_, err = io.WriteString(w, msg+"\n")
+	}
+	return err
+}
+
+// syslogHeaderField renders v as a header field: printable ASCII without
+// spaces, at most max bytes, or "-" (the nil value) when empty.
+func syslogHeaderField(v string, max int) string {
+	if v == "" {
+		return "-"
+	}
+	v = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, v)
+	if len(v) > max {
+		v = v[:max]
+	}
+	return v
+}
+
+// sdParamName renders k as an SD-PARAM name, which excludes '=', ']', '"'
+// and spaces and is at most 32 bytes.
+func sdParamName(k string) string {
+	k = syslogHeaderField(k, 32)
+	return strings.Map(func(r rune) rune {
+		if r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+// syslogTransport keeps one TCP connection to a syslog collector, over TLS
+// when tlsConfig is set, dialing it on first use and again after a failure. A failed batch is retried on
+// a new connection with exponential backoff, holding up the writer
+// meanwhile, and dropped after MaxRetries. A frame cut short by a failure
+// is lost with its connection.
+type syslogTransport struct {
+	retrier
+	addr      string
+	tlsConfig *tls.Config // nil without TLS
+	conn      net.Conn
+}
+
+// NewSyslogTransport returns a transport to the syslog collector at addr
+// (host:port), connecting over TLS with tlsConfig unless it is nil. It does
+// not connect until the first Write.
+func NewSyslogTransport(addr string, tlsConfig *tls.Config) (io.WriteCloser, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("syslog transport requires a syslog_addr")
+	}
+	return &syslogTransport{retrier: newRetrier(10), addr: addr, tlsConfig: tlsConfig}, nil
+}
+
+func (s *syslogTransport) Write(p []byte) (int, error) {
+	if err := s.retry("syslog", func() error { return s.send(p) }); err != nil {
+		slog.Error("Dropping batch", "addr", s.addr, "bytes", len(p), "error", err)
+		metrics.OutputErrors.WithLabelValues("syslog", "dropped").Inc()
+		s.giveUp(frameEntries(p), err)
+	}
+	return len(p), nil
+}
+
+func (s *syslogTransport) send(p []byte) error {
+	if s.conn == nil {
+		dialer := &net.Dialer{Timeout: 10 * time.Second}
+		var conn net.Conn
+		var err error
+		if s.tlsConfig != nil {
+			conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, s.tlsConfig)
+		} else {
+			conn, err = dialer.Dial("tcp", s.addr)
+		}
+		if err != nil {
+			metrics.OutputErrors.WithLabelValues("syslog", "connect").Inc()
+			return err
+		}
+		s.conn = conn
+	}
+	if _, err := s.conn.Write(p); err != nil {
+		metrics.OutputErrors.WithLabelValues("syslog", "write").Inc()
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *syslogTransport) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// RFC3164Serializer writes BSD syslog messages (RFC 3164), with the target
+// name as the TAG. Messages are framed like RFC5424Serializer's.
+type RFC3164Serializer struct {
+	Priorities    map[string]int // PRI by target name; DefaultSyslogPriority otherwise
+	OctetCounting bool           // length-prefix messages instead of ending them in a newline
+}
+
+func (s RFC3164Serializer) Serialize(w io.Writer, entry models.LogEntry) error {
+	pri, ok := s.Priorities[entry.SourceType]
+	if !ok {
+		pri = DefaultSyslogPriority
+	}
+	msg := fmt.Sprintf("<%d>%s %s %s: %s",
+		pri,
+		time.Unix(entry.Time, 0).Format(time.Stamp),
+		syslogHeaderField(entry.Host, 255),
+		syslogTag(entry.SourceType),
+		entry.Event)
+	return writeSyslogMessage(w, msg, s.OctetCounting)
+}
+
+// syslogTag renders name as an RFC 3164 TAG: at most 32 alphanumeric
+// characters.
+func syslogTag(name string) string {
+	tag := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return '_'
+		}
+		return r
+	}, name)
+	if tag == "" {
+		return "katalog"
+	}
+	if len(tag) > 32 {
+		tag = tag[:32]
+	}
+	return tag
+}
+
+// udpSyslogTransport sends each octet-counted frame it is written as one
+// datagram, truncated to maxSize bytes so it is never fragmented. Delivery
+// is best-effort: UDP gives no backpressure, so send errors are counted and
+// the message is lost.
+type udpSyslogTransport struct {
+	addr    string
+	maxSize int
+	conn    net.Conn
+}
+
+// NewUDPSyslogTransport returns a transport to the syslog collector at addr
+// (host:port). maxSize caps each datagram; 0 means 1024.
+func NewUDPSyslogTransport(addr string, maxSize int) (io.WriteCloser, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("syslog_udp transport requires a syslog_addr")
+	}
+	if maxSize <= 0 {
+		maxSize = 1024
+	}
+	return &udpSyslogTransport{addr: addr, maxSize: maxSize}, nil
+}
+
+func (u *udpSyslogTransport) Write(p []byte) (int, error) {
+	rest := p
+	for len(rest) > 0 {
+		msg, next, err := nextFrame(rest)
+		if err != nil {
+			return len(p) - len(rest), err
+		}
+		rest = next
+		if len(msg) > u.maxSize {
+			msg = msg[:u.maxSize]
+			// Don't leave half a UTF-8 sequence at the end
+			for i := len(msg) - 1; i >= 0 && i > len(msg)-utf8.UTFMax; i-- {
+				if utf8.RuneStart(msg[i]) {
+					if !utf8.FullRune(msg[i:]) {
+						msg = msg[:i]
+					}
+					break
+				}
+			}
+		}
+		u.send(msg)
+	}
+	return len(p), nil
+}
+
+func (u *udpSyslogTransport) send(msg []byte) {
+	if u.conn == nil {
+		conn, err := net.Dial("udp", u.addr)
+		if err != nil {
+			metrics.OutputErrors.WithLabelValues("syslog_udp", "connect").Inc()
+			return
+		}
+		u.conn = conn
+	}
+	n, err := u.conn.Write(msg)
+	if err != nil {
+		metrics.OutputErrors.WithLabelValues("syslog_udp", "write").Inc()
+		u.conn.Close()
+		u.conn = nil
+		return
+	}
+	metrics.OutputBytesSent.WithLabelValues("syslog_udp").Add(float64(n))
+}
+
+// nextFrame splits the first octet-counted frame ("LEN MSG") off p.
+func nextFrame(p []byte) (msg, rest []byte, err error) {
+	sp := bytes.IndexByte(p, ' ')
+	if sp < 0 {
+		return nil, nil, fmt.Errorf("syslog_udp: unframed data")
+	}
+	n, err := strconv.Atoi(string(p[:sp]))
+	if err != nil || n < 0 || n > len(p)-sp-1 {
+		return nil, nil, fmt.Errorf("syslog_udp: invalid frame length %q", p[:sp])
+	}
+	return p[sp+1 : sp+1+n], p[sp+1+n:], nil
+}
+
+// frameEntries splits a batch of octet-counted frames into their messages,
+// or into lines when it isn't one, as written by a serializer other than
+// rfc5424 and rfc3164.
+func frameEntries(p []byte) [][]byte {
+	var entries [][]byte
+	for rest := p; len(rest) > 0; {
+		msg, next, err := nextFrame(rest)
+		if err != nil {
+			return batchEntries(p)
+		}
+		entries = append(entries, msg)
+		rest = next
+	}
+	return entries
+}
+
+func (u *udpSyslogTransport) Close() error {
+	if u.conn == nil {
+		return nil
+	}
+	return u.conn.Close()
+}
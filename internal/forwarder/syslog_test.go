@@ -0,0 +1,211 @@
+package forwarder
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+	"katalog/internal/models"
+)
+
+func TestSyslogPriority(t *testing.T) {
+	tests := []struct {
+		facility, severity string
+		want               int
+		wantErr            bool
+	}{
+		{"", "", DefaultSyslogPriority, false},
+		{"auth", "err", 4*8 + 3, false},
+		{"local7", "debug", 23*8 + 7, false},
+		{"local9", "", 0, true},
+		{"", "fatal", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := SyslogPriority(tt.facility, tt.severity)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("SyslogPriority(%q, %q) = %d, %v; want %d (error: %v)", tt.facility, tt.severity, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRFC5424Serializer(t *testing.T) {
+	s := RFC5424Serializer{Priorities: map[string]int{"auth": 4*8 + 3}, OctetCounting: true}
+	entry := models.LogEntry{
+		Time:       1700000000,
+		Host:       "web 1",
+		Source:     "/var/log/auth.log",
+		SourceType: "auth",
+		Event:      "first line\nsecond line",
+		Fields:     map[string]string{"env": "prod", "a=b": `say "hi"]`},
+	}
+	var buf bytes.Buffer
+	if err := s.Serialize(&buf, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	// 1. The frame is prefixed with the message's length
+	frame := buf.String()
+	n, msg, ok := strings.Cut(frame, " ")
+	if !ok {
+		t.Fatalf("Expected an octet-counted frame, got %q", frame)
+	}
+	if size, _ := strconv.Atoi(n); size != len(msg) {
+		t.Errorf("Expected length %d, got %s", len(msg), n)
+	}
+
+	// 2. Header, structured data and the multiline event as is
+	want := `<35>1 2023-11-14T22:13:20Z web_1 auth - - [katalog@32473 source="/var/log/auth.log" a_b="say \"hi\"\]" env="prod"] first line` + "\nsecond line"
+	if msg != want {
+		t.Errorf("Unexpected message:\n got %q\nwant %q", msg, want)
+	}
+
+	// 3. Unknown targets get local0.info
+	buf.Reset()
+	entry.SourceType = "other"
+	s.Serialize(&buf, entry)
+	if !strings.Contains(buf.String(), " <134>1 ") {
+		t.Errorf("Expected the default priority, got %q", buf.String())
+	}
+}
+
+func TestSyslogTransportReconnects(t *testing.T) {
+	orig := retryBase
+	retryBase = 10 * time.Millisecond
+	t.Cleanup(func() { retryBase = orig })
+
+	// 1. Reserve an address with nothing listening on it yet
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	before := counterValue(t, metrics.OutputErrors.WithLabelValues("syslog", "connect"))
+	tr, err := NewSyslogTransport(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := tr.Write([]byte("5 hello"))
+		written <- err
+	}()
+
+	// 2. The collector comes up while the transport is retrying
+	time.Sleep(50 * time.Millisecond)
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	got := make([]byte, len("5 hello"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(bufio.NewReader(conn), got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "5 hello" {
+		t.Errorf("Expected '5 hello', got %q", got)
+	}
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Errorf("Unexpected write error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the write to return")
+	}
+	if counterValue(t, metrics.OutputErrors.WithLabelValues("syslog", "connect")) <= before {
+		t.Error("Expected connection failures to be counted")
+	}
+}
+
+func TestRFC3164Serializer(t *testing.T) {
+	s := RFC3164Serializer{Priorities: map[string]int{"web-app": 3*8 + 4}, OctetCounting: true}
+	entry := models.LogEntry{Time: 1700000000, Host: "web1", SourceType: "web-app", Event: "GET / 200"}
+	var buf bytes.Buffer
+	if err := s.Serialize(&buf, entry); err != nil {
+		t.Fatal(err)
+	}
+	msg, rest, err := nextFrame(buf.Bytes())
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("Expected a single frame, got %q (%v)", buf.String(), err)
+	}
+	stamp := time.Unix(1700000000, 0).Format(time.Stamp)
+	if want := "<28>" + stamp + " web1 web_app: GET / 200"; string(msg) != want {
+		t.Errorf("Expected %q, got %q", want, msg)
+	}
+}
+
+func TestSyslogSerializers_Newline(t *testing.T) {
+	entry := models.LogEntry{Time: 1700000000, Host: "web1", SourceType: "app", Event: "one"}
+	for _, s := range []Serializer{RFC5424Serializer{}, RFC3164Serializer{}} {
+		var buf bytes.Buffer
+		for range 2 {
+			if err := s.Serialize(&buf, entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[1], "<134>") || !strings.HasSuffix(lines[0], " one") {
+			t.Errorf("%T: expected one message per line, got %q", s, buf.String())
+		}
+	}
+}
+
+func TestUDPSyslogTransport(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	before := counterValue(t, metrics.OutputBytesSent.WithLabelValues("syslog_udp"))
+	tr, err := NewUDPSyslogTransport(pc.LocalAddr().String(), 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	// 1. A batch of two frames, the second above max_datagram_size and
+	// ending in a multi-byte character cut by the limit
+	batch := "5 short13 long messagé"
+	if n, err := tr.Write([]byte(batch)); err != nil || n != len(batch) {
+		t.Fatalf("Write() = %d, %v", n, err)
+	}
+
+	// 2. One datagram per frame, truncated without breaking the character
+	buf := make([]byte, 64)
+	for _, want := range []string{"short", "long messag"} {
+		pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != want {
+			t.Errorf("Expected datagram %q, got %q", want, buf[:n])
+		}
+	}
+	if got := counterValue(t, metrics.OutputBytesSent.WithLabelValues("syslog_udp")) - before; got != 16 {
+		t.Errorf("Expected 16 bytes sent, got %.0f", got)
+	}
+
+	// 3. Unframed data is rejected
+	if _, err := tr.Write([]byte("no frame")); err == nil {
+		t.Error("Expected an error for unframed data")
+	}
+}
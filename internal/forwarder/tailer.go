@@ -1,13 +1,20 @@
 package forwarder
 
 import (
-	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"hash/crc32"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -16,148 +23,1127 @@ import (
 	"katalog/internal/models"
 )
 
+// What a tailer does with an entry when its output channel is full.
+const (
+	ChannelBlock      = "block"
+	ChannelDropNewest = "drop_newest"
+	ChannelDropOldest = "drop_oldest"
+)
+
 type TailOptions struct {
-	GroupName      string
-	Hostname       string
-	ExcludeRegex   *regexp.Regexp
-	MultilineRegex *regexp.Regexp
-	CustomFields   map[string]string
+	GroupName string
+	Hostname  string
+	// TimestampFormat is how entries encode their time in JSON, one of the
+	// models.Time* formats.
+	TimestampFormat string
+	// ExcludeRegexes drops messages matching any of them. IncludeRegexes,
+	// when set, then drops messages matching none of them.
+	ExcludeRegexes     []*regexp.Regexp
+	IncludeRegexes     []*regexp.Regexp
+	MultilineRegexes   []*regexp.Regexp
+	CustomFields       map[string]string
+	CollapseWhitespace bool
+	// Redactions are applied in order to every event before it becomes an
+	// entry, so nothing downstream sees what they replace.
+	Redactions []Redaction
+	// OnOpen, when set, is called once with the result of the initial open.
+	OnOpen func(err error)
+	// WaitForCreation waits for a file that doesn't exist yet instead of
+	// failing the open, then reads it from the start once it appears.
+	WaitForCreation bool
+	// PathFields are fields derived from this file's path, on top of
+	// CustomFields.
+	PathFields map[string]string
+	// XattrFields maps extended attribute names (e.g. "user.service_name")
+	// to field keys, read each time the file is opened. Linux only.
+	XattrFields map[string]string
+	// Processors run in order on every entry before it is sent
+	Processors []Processor
+	// FileProcessors build processors for this file, given its path, which
+	// run before Processors.
+	FileProcessors []func(path string) Processor
+	// ReorderWindow holds entries up to this long to emit them in timestamp
+	// order. It adds up to that much latency; 0 disables reordering.
+	ReorderWindow time.Duration
+	// DrainOnShutdown reads up to EOF before returning on cancellation,
+	// bounded by DrainTimeout (0 means no limit).
+	DrainOnShutdown bool
+	DrainTimeout    time.Duration
+	// ChannelFullPolicy is what happens to an entry when out is full:
+	// ChannelBlock (also when empty) waits for room, ChannelDropNewest drops
+	// the entry and ChannelDropOldest takes the oldest one off Evict, the
+	// receiving end of out, to make room. Neither drop policy ever waits.
+	ChannelFullPolicy string
+	Evict             <-chan models.LogEntry
+	// RateLimit caps emitted entries per second (0 means unlimited), allowing
+	// bursts of RateLimitBurst. Over the limit entries are dropped, or 1 in
+	// OverLimitSampleN is kept when OverLimitPolicy is "sample", or reading
+	// waits until the limit allows the next entry when it is "block".
+	RateLimit        float64
+	RateLimitBurst   int
+	OverLimitPolicy  string
+	OverLimitSampleN int
+	// SampleRate keeps each entry with this probability, checked after the
+	// exclude and include patterns, so a multiline entry is kept or dropped
+	// as a whole. 0 or 1 keeps everything. SampleSeed seeds the tailer's
+	// generator for reproducible sampling (0 picks a random seed).
+	SampleRate float64
+	SampleSeed uint64
+	// DedupWindowSize suppresses lines identical to one among roughly the
+	// last DedupWindowSize distinct lines of the file (0 disables it).
+	DedupWindowSize int
+	// FollowSymlink re-resolves path on every EOF and, when it is a symlink
+	// that now points elsewhere, finishes the old target before switching.
+	FollowSymlink bool
+	// KeepPartialEntry keeps continuation lines read before the first
+	// start line after a mid-file seek instead of discarding them.
+	KeepPartialEntry bool
+	// MaxLineBytes truncates longer lines to that many bytes, followed by
+	// TruncationMarker, skipping the rest of the line unread, so one giant
+	// line can't exhaust memory or stall the patterns (0 means no cap).
+	MaxLineBytes int
+	// TruncationMarker is appended to truncated lines ("" means
+	// DefaultTruncationMarker).
+	TruncationMarker string
+	// OnExclude, when set, is called with each message dropped by
+	// ExcludeRegexes or IncludeRegexes.
+	OnExclude func(msg string)
+	// MaxLinesPerCycle yields to other goroutines after this many lines read
+	// back to back, so one file's burst doesn't starve the others
+	// (0 means unlimited).
+	MaxLinesPerCycle int
+	// Live, when set, overrides ExcludeRegexes, IncludeRegexes,
+	// MultilineRegexes and CustomFields with its current Patterns, checked before every line.
+	Live *LivePatterns
+	// Checkpoint, when set, is where the tailer resumes on open (instead of
+	// EOF) and records how far it has handed lines to the writer.
+	Checkpoint *CheckpointStore
+	// ReadFromBeginning starts a file without a checkpoint at its start
+	// rather than at EOF.
+	ReadFromBeginning bool
+	// Once stops at the first EOF instead of following the file, emitting
+	// an unterminated last line and any entry still being assembled.
+	Once bool
+	// Wake, when set, signals that the file may have changed; the tailer
+	// waits on it at EOF instead of polling, re-checking every
+	// wakeFallback in case an event was missed.
+	Wake <-chan struct{}
+	// EOFPollInterval is how long the tailer sleeps at EOF, and between
+	// checks for a file to be created, without Wake (0 means
+	// defaultEOFPoll).
+	EOFPollInterval time.Duration
+	// LineDelimiter is the single byte that ends each line, e.g. "\r" or
+	// "\x00" ("" means "\n", where a CRLF ending counts as one).
+	LineDelimiter string
+	// PartialLineTimeout emits an unterminated last line as a complete one
+	// once it has waited this long at EOF for its newline (0 means wait
+	// forever). Whatever is appended to that line afterwards becomes a
+	// line of its own.
+	PartialLineTimeout time.Duration
+	// MultilineTimeout flushes a multiline entry once no line has been
+	// added to it for this long, instead of holding it until the next start
+	// line (0 means no timeout).
+	MultilineTimeout time.Duration
+	// MaxMultilineBytes flushes a multiline entry early, as one entry, before
+	// a line would take it past this size, so a start pattern that stops
+	// matching can't grow the buffer without bound (0 or less means no cap).
+	MaxMultilineBytes int
+}
+
+func (o TailOptions) lineDelimiter() byte {
+	if o.LineDelimiter == "" {
+		return '\n'
+	}
+	return o.LineDelimiter[0]
+}
+
+func (o TailOptions) eofPollInterval() time.Duration {
+	if o.EOFPollInterval > 0 {
+		return o.EOFPollInterval
+	}
+	return defaultEOFPoll
+}
+
+var whitespaceRun = regexp.MustCompile(`[ \t]+`)
+
+// collapseWhitespace squeezes every run of spaces/tabs in s into one space.
+func collapseWhitespace(s string) string {
+	return whitespaceRun.ReplaceAllString(s, " ")
+}
+
+// slowMatchThreshold is how long a single pattern match may take before
+// it is counted as slow.
+var slowMatchThreshold = 10 * time.Millisecond
+
+// How often a tailer with a Wake channel checks its file without an event.
+var wakeFallback = 5 * time.Second
+
+// DefaultTruncationMarker marks lines cut at MaxLineBytes.
+const DefaultTruncationMarker = "…[truncated]"
+
+// defaultEOFPoll is how often a tailer without Wake checks its file at EOF.
+const defaultEOFPoll = 200 * time.Millisecond
+
+// readLagLines is how many lines read back to back between read lag
+// updates; it is also updated at every EOF.
+const readLagLines = 1000
+
+// matchAny reports whether b matches at least one of the given patterns.
+func matchAny(patterns []*regexp.Regexp, b []byte) bool {
+	for _, re := range patterns {
+		if re.Match(b) {
+			return true
+		}
+	}
+	return false
 }
 
 func TailFile(ctx context.Context, wg *sync.WaitGroup, path string, out chan<- models.LogEntry, opts TailOptions) {
 	defer wg.Done()
 
 	file, err := os.Open(path)
+	if opts.WaitForCreation && errors.Is(err, fs.ErrNotExist) {
+		slog.Debug("Waiting for file to be created", "path", path)
+		if file, err = waitForCreation(ctx, path, opts.Wake, opts.eofPollInterval()); file == nil && err == nil {
+			return
+		}
+		// Everything in a file created while waiting is new
+		opts.ReadFromBeginning = true
+	}
+	if opts.OnOpen != nil {
+		opts.OnOpen(err)
+	}
 	if err != nil {
 		metrics.FileErrors.WithLabelValues(path, "open").Inc()
 		return
 	}
+	// The file handle is swapped on rotation, so close whichever is current
+	t := &tailer{path: path, opts: opts, out: out, file: file, done: ctx.Done()}
+	if opts.DrainOnShutdown {
+		// Don't abandon an in-flight line on cancel, but don't block past
+		// the drain's deadline either
+		var stop func() bool
+		t.drainDeadline, stop = drainDeadline(ctx, opts.DrainTimeout)
+		defer stop()
+		t.done = t.drainDeadline
+	}
+	defer func() { t.file.Close() }()
+	t.newProcessors()
 
-	var multilineBuffer strings.Builder
-
-	// Helper to flush multiline buffer
-	flushBuffer := func() {
-		if multilineBuffer.Len() == 0 {
-			return
-		}
-		msg := strings.TrimSpace(multilineBuffer.String())
-		multilineBuffer.Reset()
+	if t.fi, err = file.Stat(); err != nil {
+		return
+	}
+	if isGzip(path, file) {
+		t.loadFields()
+		t.init()
+		t.readGzip(ctx)
+		return
+	}
+	offset, resumed, err := t.startOffset()
+	if err != nil {
+		metrics.FileErrors.WithLabelValues(path, "seek").Inc()
+		return
+	}
+	// Starting mid-file may land inside a multiline entry; a checkpoint is
+	// always at an entry boundary
+	t.skipPartial = offset > 0 && !resumed
+	t.lines = newLineReader(file, opts.lineDelimiter(), opts.MaxLineBytes)
+	t.lines.pos = offset
+	t.checkHead()
+	defer metrics.ReadLag.DeleteLabelValues(path)
+	if opts.FollowSymlink {
+		t.target, _ = filepath.EvalSymlinks(path)
+	}
+	t.loadFields()
+	t.init()
+	t.run(ctx)
+}
 
-		if msg == "" {
-			return
-		}
-		if opts.ExcludeRegex != nil && opts.ExcludeRegex.MatchString(msg) {
-			return
+// waitForCreation polls every poll until path exists and returns it
+// opened, or a nil file once ctx is done.
+func waitForCreation(ctx context.Context, path string, wake <-chan struct{}, poll time.Duration) (*os.File, error) {
+	d := poll
+	if wake != nil {
+		d = wakeFallback
+	}
+	for {
+		timer := time.NewTimer(d)
+		select {
+		case <-wake:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil
 		}
-
-		out <- models.LogEntry{
-			Time:       time.Now().Unix(),
-			Host:       opts.Hostname,
-			Source:     filepath.Base(path),
-			SourceType: opts.GroupName,
-			Event:      msg,
-			Fields:     opts.CustomFields,
+		timer.Stop()
+		file, err := os.Open(path)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return file, err
 		}
-		metrics.LinesProcessed.WithLabelValues(path, opts.GroupName).Inc()
 	}
+}
 
-	// We manage file closing manually to support rotation
+// tailer holds the state of a single TailFile invocation.
+type tailer struct {
+	path string
+	opts TailOptions
+	out  chan<- models.LogEntry
 
-	if _, err := file.Seek(0, io.SeekEnd); err != nil {
-		metrics.FileErrors.WithLabelValues(path, "seek").Inc()
-		return
-	}
-	fi, err := file.Stat()
-	if err != nil {
-		file.Close()
-		return
+	file   *os.File
+	fi     os.FileInfo
+	target string // resolved symlink target, with FollowSymlink
+	lines  *lineReader
+	fields map[string]string
+
+	multilineBuffer bytes.Buffer
+	bufferStart     int64     // file offset of the buffered entry's first line
+	bufferedAt      time.Time // when the buffered entry last grew
+	live            *Patterns // last Patterns applied from opts.Live
+	// skipPartial discards multiline continuation lines until the first
+	// start line, when reading began somewhere other than the file start.
+	skipPartial bool
+	processors  []Processor // built for the current file, then opts.Processors
+	reorder     *reorderBuffer
+	limiter     *rateLimiter
+	sampler     *rand.Rand
+	dedup       *dedupFilter
+	sinceLag    int // lines read since the last read lag update
+	// headLen bytes at the start of the file hash to headSum, to notice a
+	// copytruncate that the file regrew from before the size check saw it.
+	headLen int
+	headSum uint32
+	// partialSince is when an unterminated last line was first seen at
+	// EOF, zero while there is none.
+	partialSince time.Time
+	// aborted is set once a send was given up, leaving read lines unsent,
+	// so no checkpoint may be recorded past them.
+	aborted bool
+	// journalCursor is the cursor of the journal entry read before the
+	// current one, which reading resumes after to get the current one again.
+	journalCursor string
+
+	// done aborts single-line sends (ctx.Done() while tailing). deadline
+	// aborts multiline flushes, which otherwise block; it is nil until a
+	// bounded shutdown drain starts.
+	done     <-chan struct{}
+	deadline <-chan struct{}
+	// drainDeadline is closed DrainTimeout after ctx is done, with
+	// DrainOnShutdown and a DrainTimeout; otherwise nil.
+	drainDeadline <-chan struct{}
+}
+
+// drainDeadline returns a channel closed timeout after ctx is done, or nil
+// without a timeout, and a func to stop it from ever closing.
+func drainDeadline(ctx context.Context, timeout time.Duration) (<-chan struct{}, func() bool) {
+	if timeout <= 0 {
+		return nil, func() bool { return false }
 	}
-	reader := bufio.NewReader(file)
+	ch := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(timeout, func() { close(ch) })
+	})
+	return ch, stop
+}
 
+func (t *tailer) run(ctx context.Context) {
+	burst := 0
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("Shutting down collector for: %s", path)
-			flushBuffer()
-			file.Close()
+			slog.Debug("Shutting down collector", "path", t.path)
+			if t.opts.DrainOnShutdown {
+				t.drain()
+			}
+			// Before the final flushes, which may be cut short: at worst
+			// their entries are read again on restart
+			t.saveCheckpoint()
+			t.flushBuffer()
+			t.releaseReordered(true, t.deadline)
 			return
 		default:
-			line, err := reader.ReadString('\n')
+			if !t.releaseReordered(false, t.done) {
+				return
+			}
+			t.refreshPatterns()
+			line, err := t.lines.next()
 			if err != nil {
 				if err == io.EOF {
-					// Check for rotation
-					if newFi, err := os.Stat(path); err == nil {
-						if !os.SameFile(fi, newFi) {
-							log.Printf("File rotation detected: %s", path)
-							flushBuffer() // Flush any partial/complete logs from old file
-							newFile, err := os.Open(path)
-							if err == nil {
-								file.Close()
-								file = newFile
-								fi = newFi
-								reader = bufio.NewReader(file)
-								continue
-							}
-						} else if newFi.Size() < fi.Size() {
-							// Handle truncation (inode same, but size decreased)
-							log.Printf("File truncation detected: %s", path)
-							multilineBuffer.Reset() // Discard partial buffer on truncation
-							if _, err := file.Seek(0, io.SeekStart); err != nil {
-								metrics.FileErrors.WithLabelValues(path, "seek_start").Inc()
-								log.Printf("Error seeking to start of file after truncation for %s: %v", path, err)
-								file.Close()
-								return
-							}
-							fi = newFi
-							reader = bufio.NewReader(file)
-							continue
-						}
+					if t.opts.Once {
+						t.finish()
+						return
+					}
+					burst = 0
+					if !t.flushPartial() {
+						return
 					}
-					// Update file info to current state for next comparison
-					if stat, err := file.Stat(); err == nil {
-						fi = stat
+					t.flushIdleBuffer()
+					t.saveCheckpoint()
+					switched, ok := t.checkRotation()
+					if !ok {
+						return
+					}
+					t.updateReadLag()
+					if !switched {
+						t.wait(ctx)
 					}
-					// Smaller sleep for better responsiveness
-					time.Sleep(200 * time.Millisecond)
 					continue
 				}
-				if err != io.EOF {
-					metrics.FileErrors.WithLabelValues(path, "read").Inc()
-				}
-				flushBuffer()
-				file.Close()
+				metrics.FileErrors.WithLabelValues(t.path, "read").Inc()
+				t.flushBuffer()
+				t.releaseReordered(true, t.deadline)
 				return
 			}
+			t.partialSince = time.Time{}
+			if !t.handleLine(line) {
+				return
+			}
+			if t.sinceLag++; t.sinceLag >= readLagLines {
+				t.updateReadLag()
+			}
+			burst++
+			if t.opts.MaxLinesPerCycle > 0 && burst >= t.opts.MaxLinesPerCycle {
+				// Yield mid-burst; the loop re-checks ctx before reading on
+				burst = 0
+				runtime.Gosched()
+			}
+		}
+	}
+}
 
-			// Multiline Logic
-			if opts.MultilineRegex != nil {
-				// Check if this line starts a new log entry
-				if opts.MultilineRegex.MatchString(line) {
-					flushBuffer()
+// finish runs at EOF in Once mode. Nothing more is coming: an unterminated
+// last line is complete, and so is a buffered entry.
+func (t *tailer) finish() {
+	if rest := t.lines.rest(); len(rest) > 0 && !t.handleLine(rest) {
+		return
+	}
+	t.flushBuffer()
+	t.releaseReordered(true, t.deadline)
+	t.saveCheckpoint()
+}
+
+// isGzip reports whether f is gzip-compressed, by extension or by its
+// magic bytes.
+func isGzip(path string, f *os.File) bool {
+	if strings.HasSuffix(path, ".gz") {
+		return true
+	}
+	var magic [2]byte
+	n, _ := f.ReadAt(magic[:], 0)
+	return n == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+// readGzip reads a compressed file once from start to end and returns: a
+// compressed file is an already rotated one, so nothing more will be
+// appended. With a checkpoint, a file read to the end is marked as done
+// and not read again.
+func (t *tailer) readGzip(ctx context.Context) {
+	id, size := fileID(t.fi), t.fi.Size()
+	if t.opts.Checkpoint != nil {
+		if done, ok := t.opts.Checkpoint.Get(t.path, id); ok && done >= size {
+			return
+		}
+	}
+	gz, err := gzip.NewReader(t.file)
+	if err != nil {
+		metrics.FileErrors.WithLabelValues(t.path, "gzip").Inc()
+		slog.Error("Error decompressing", "path", t.path, "error", err)
+		return
+	}
+	defer gz.Close()
+	t.lines = newLineReader(gz, t.opts.lineDelimiter(), t.opts.MaxLineBytes)
+	for ctx.Err() == nil {
+		line, err := t.lines.next()
+		if err == io.EOF {
+			if rest := t.lines.rest(); len(rest) > 0 && !t.handleLine(rest) {
+				return
+			}
+			t.flushBuffer()
+			t.releaseReordered(true, t.deadline)
+			if !t.aborted && t.opts.Checkpoint != nil {
+				t.opts.Checkpoint.Set(t.path, id, size)
+			}
+			return
+		}
+		if err != nil {
+			metrics.FileErrors.WithLabelValues(t.path, "gzip").Inc()
+			slog.Error("Error decompressing", "path", t.path, "error", err)
+			t.flushBuffer()
+			t.releaseReordered(true, t.deadline)
+			return
+		}
+		if !t.handleLine(line) {
+			return
+		}
+	}
+	// Shut down partway through
+	t.flushBuffer()
+	t.releaseReordered(true, t.deadline)
+}
+
+// startOffset seeks to where tailing begins: the saved checkpoint for this
+// file if there is one, or else EOF (the start with ReadFromBeginning). A
+// checkpoint past the end of the file (truncated while the agent was down)
+// restarts from the beginning.
+func (t *tailer) startOffset() (offset int64, resumed bool, err error) {
+	if t.opts.Checkpoint != nil {
+		if saved, ok := t.opts.Checkpoint.Get(t.path, fileID(t.fi)); ok {
+			if saved > t.fi.Size() {
+				slog.Warn("Checkpoint is past the end of the file, reading from the start", "path", t.path)
+				saved = 0
+			}
+			offset, err = t.file.Seek(saved, io.SeekStart)
+			return offset, true, err
+		}
+	}
+	whence := io.SeekEnd
+	if t.opts.ReadFromBeginning {
+		whence = io.SeekStart
+	}
+	offset, err = t.file.Seek(0, whence)
+	return offset, false, err
+}
+
+// saveCheckpoint records how far lines have been handed to the writer: up
+// to the start of a multiline entry still being assembled or of the first
+// entry read of those held for reordering, and not at all after a send was
+// abandoned.
+func (t *tailer) saveCheckpoint() {
+	if t.opts.Checkpoint == nil || t.aborted {
+		return
+	}
+	offset := t.lines.pos
+	if t.multilineBuffer.Len() > 0 {
+		offset = t.bufferStart
+	}
+	if t.reorder != nil {
+		if from, ok := t.reorder.resume(); ok {
+			offset = min(offset, from.offset)
+		}
+	}
+	t.opts.Checkpoint.Set(t.path, fileID(t.fi), offset)
+}
+
+// resumePoint returns where reading resumes to get the entry being emitted
+// again.
+func (t *tailer) resumePoint() resumePoint {
+	switch {
+	case t.multilineBuffer.Len() > 0:
+		return resumePoint{offset: t.bufferStart}
+	case t.lines != nil:
+		return resumePoint{offset: t.lines.pos - t.lines.last}
+	}
+	return resumePoint{cursor: t.journalCursor}
+}
+
+// updateReadLag records how far the read position trails the end of the
+// open file. The position is that of the last complete line, so a
+// buffered partial line counts as lag. Switching to a rotated file starts
+// over from that file's size.
+func (t *tailer) updateReadLag() {
+	t.sinceLag = 0
+	fi, err := t.file.Stat()
+	if err != nil {
+		return
+	}
+	metrics.ReadLag.WithLabelValues(t.path).Set(float64(max(fi.Size()-t.lines.pos, 0)))
+}
+
+// flushPartial runs at EOF. It handles an unterminated last line as
+// complete once it has waited PartialLineTimeout, flushing the multiline
+// entry it ends. It returns false once the tailer should stop.
+func (t *tailer) flushPartial() bool {
+	if t.opts.PartialLineTimeout <= 0 {
+		return true
+	}
+	if len(t.lines.partial) == 0 {
+		// Read in full, or dropped by a rotation or truncation
+		t.partialSince = time.Time{}
+		return true
+	}
+	if t.partialSince.IsZero() {
+		t.partialSince = time.Now()
+		return true
+	}
+	if time.Since(t.partialSince) < t.opts.PartialLineTimeout {
+		return true
+	}
+	t.partialSince = time.Time{}
+	if !t.handleLine(t.lines.rest()) {
+		return false
+	}
+	t.flushBuffer()
+	return true
+}
+
+// flushIdleBuffer runs at EOF. It flushes the multiline entry once nothing
+// has been added to it for MultilineTimeout.
+func (t *tailer) flushIdleBuffer() {
+	if t.opts.MultilineTimeout > 0 && t.multilineBuffer.Len() > 0 && time.Since(t.bufferedAt) >= t.opts.MultilineTimeout {
+		t.flushBuffer()
+	}
+}
+
+// wait pauses at EOF until there may be more to read.
+func (t *tailer) wait(ctx context.Context) {
+	if t.opts.Wake == nil {
+		time.Sleep(t.opts.eofPollInterval())
+		return
+	}
+	// Don't sleep through a pending partial line's or multiline entry's
+	// timeout, or past when a held entry is due
+	d := wakeFallback
+	if !t.partialSince.IsZero() {
+		d = min(d, time.Until(t.partialSince.Add(t.opts.PartialLineTimeout)))
+	}
+	if t.opts.MultilineTimeout > 0 && t.multilineBuffer.Len() > 0 {
+		d = min(d, time.Until(t.bufferedAt.Add(t.opts.MultilineTimeout)))
+	}
+	if t.reorder != nil {
+		if at, ok := t.reorder.due(); ok {
+			d = min(d, time.Until(at))
+		}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.opts.Wake:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// How much of the start of a file checkHead compares.
+const headCheckBytes = 256
+
+// checkHead reports whether the start of the open file changed since the
+// last call, meaning it was truncated and written again in between. The
+// compared prefix grows with the file up to headCheckBytes.
+func (t *tailer) checkHead() bool {
+	buf := make([]byte, headCheckBytes)
+	n, err := t.file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return false
+	}
+	if t.headLen > 0 && (n < t.headLen || crc32.ChecksumIEEE(buf[:t.headLen]) != t.headSum) {
+		return true
+	}
+	t.headLen, t.headSum = n, crc32.ChecksumIEEE(buf[:n])
+	return false
+}
+
+// checkRotation runs at EOF. It reports whether the reader was switched to a
+// rotated or truncated file, and ok=false when tailing cannot continue.
+func (t *tailer) checkRotation() (switched, ok bool) {
+	if t.opts.FollowSymlink {
+		if switched, ok := t.checkSymlink(); switched || !ok {
+			return switched, ok
+		}
+	}
+	if newFi, err := os.Stat(t.path); err == nil {
+		if !os.SameFile(t.fi, newFi) {
+			slog.Debug("File rotation detected", "path", t.path)
+			newFile, err := os.Open(t.path)
+			if err == nil {
+				// Lines may have landed in the old file after our last read
+				if !t.readToEOF() {
+					newFile.Close()
+					return false, false
 				}
-				multilineBuffer.WriteString(line)
-			} else {
-				// Single line mode
-				msg := strings.TrimSpace(line)
-				if opts.ExcludeRegex != nil && opts.ExcludeRegex.MatchString(msg) {
-					continue
+				t.flushBuffer() // Flush any partial/complete logs from old file
+				// Held offsets must be in the file checkpoints are saved for
+				if !t.releaseReordered(true, t.done) {
+					newFile.Close()
+					return false, false
 				}
+				t.file.Close()
+				t.file = newFile
+				t.fi = newFi
+				t.lines.reset(t.file)
+				t.skipPartial = false
+				t.headLen = 0
+				t.loadFields()
+				t.newProcessors()
+				return true, true
+			}
+		} else if newFi.Size() < t.fi.Size() || t.checkHead() {
+			// Handle truncation (inode same, but size decreased or the
+			// start of the file was rewritten, as with copytruncate)
+			slog.Debug("File truncation detected", "path", t.path)
+			t.multilineBuffer.Reset() // Discard partial buffer on truncation
+			if !t.releaseReordered(true, t.done) {
+				return false, false
+			}
+			if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+				metrics.FileErrors.WithLabelValues(t.path, "seek_start").Inc()
+				slog.Error("Error seeking to start of file after truncation", "path", t.path, "error", err)
+				return false, false
+			}
+			t.fi = newFi
+			t.lines.reset(t.file)
+			t.skipPartial = false
+			t.headLen = 0
+			return true, true
+		}
+	}
+	// Update file info to current state for next comparison
+	if stat, err := t.file.Stat(); err == nil {
+		t.fi = stat
+	}
+	return false, true
+}
 
-				select {
-				case out <- models.LogEntry{
-					Time:       time.Now().Unix(),
-					Host:       opts.Hostname,
-					Source:     filepath.Base(path),
-					SourceType: opts.GroupName,
-					Event:      msg,
-					Fields:     opts.CustomFields,
-				}:
-					metrics.LinesProcessed.WithLabelValues(path, opts.GroupName).Inc()
-				case <-ctx.Done():
-					file.Close()
-					return
-				}
+// checkSymlink switches to the new target of a repointed symlink, reading
+// whatever is left of the old target first so nothing is lost in between.
+func (t *tailer) checkSymlink() (switched, ok bool) {
+	target, err := filepath.EvalSymlinks(t.path)
+	if err != nil || target == t.target {
+		return false, true
+	}
+	newFile, err := os.Open(target)
+	if err != nil {
+		return false, true
+	}
+	slog.Debug("Symlink target changed", "path", t.path, "target", target)
+	if !t.readToEOF() {
+		newFile.Close()
+		return false, false
+	}
+	t.flushBuffer()
+	if !t.releaseReordered(true, t.done) {
+		newFile.Close()
+		return false, false
+	}
+	newFi, err := newFile.Stat()
+	if err != nil {
+		newFile.Close()
+		return false, true
+	}
+	t.file.Close()
+	t.file = newFile
+	t.fi = newFi
+	t.target = target
+	t.lines.reset(t.file)
+	t.skipPartial = false
+	t.loadFields()
+	t.newProcessors()
+	return true, true
+}
+
+// readToEOF handles every line left in the current file, including an
+// unterminated last one, for when nothing more will be read from it. It
+// returns false once the tailer should stop.
+func (t *tailer) readToEOF() bool {
+	for {
+		line, err := t.lines.next()
+		if err != nil {
+			if rest := t.lines.rest(); len(rest) > 0 {
+				return t.handleLine(rest)
+			}
+			return true
+		}
+		if !t.handleLine(line) {
+			return false
+		}
+	}
+}
+
+// matches is matchAny guarded for the read loop: it only looks at the first
+// MaxLineBytes of each line of b, which is an assembled entry in multiline
+// mode, and counts matches slower than slowMatchThreshold.
+func (t *tailer) matches(kind string, b []byte, patterns ...*regexp.Regexp) bool {
+	b = capLines(b, t.opts.MaxLineBytes)
+	start := time.Now()
+	ok := matchAny(patterns, b)
+	if time.Since(start) > slowMatchThreshold {
+		metrics.SlowMatches.WithLabelValues(t.path, kind).Inc()
+	}
+	return ok
+}
+
+// capLines returns b with each of its lines cut to n bytes, copying only
+// when one is longer. n <= 0 means no cap.
+func capLines(b []byte, n int) []byte {
+	if n <= 0 || len(b) <= n {
+		return b
+	}
+	lines := bytes.Split(b, []byte{'\n'})
+	long := false
+	for i, line := range lines {
+		if len(line) > n {
+			lines[i] = line[:n]
+			long = true
+		}
+	}
+	if !long {
+		return b
+	}
+	return bytes.Join(lines, []byte{'\n'})
+}
+
+// init sets up the optional per-file stages selected by the options.
+func (t *tailer) init() {
+	if t.opts.ReorderWindow > 0 {
+		t.reorder = newReorderBuffer(t.opts.ReorderWindow)
+	}
+	if t.opts.RateLimit > 0 {
+		t.limiter = newRateLimiter(t.opts)
+	}
+	if t.opts.DedupWindowSize > 0 {
+		t.dedup = newDedupFilter(t.opts.DedupWindowSize)
+	}
+	if t.opts.SampleRate > 0 && t.opts.SampleRate < 1 {
+		seed := t.opts.SampleSeed
+		if seed == 0 {
+			seed = rand.Uint64()
+		}
+		t.sampler = rand.New(rand.NewPCG(seed, seed))
+	}
+}
+
+// refreshPatterns applies the current live Patterns, if they changed since
+// the last line. A partly assembled multiline entry is flushed first when
+// multiline mode is switched off.
+func (t *tailer) refreshPatterns() {
+	if t.opts.Live == nil {
+		return
+	}
+	p := t.opts.Live.Load()
+	if p == t.live {
+		return
+	}
+	t.live = p
+	if len(p.Multiline) == 0 {
+		t.flushBuffer()
+	}
+	t.opts.ExcludeRegexes = p.Exclude
+	t.opts.IncludeRegexes = p.Include
+	t.opts.MultilineRegexes = p.Multiline
+	t.opts.CustomFields = p.Fields
+	t.loadFields()
+}
+
+// loadFields resolves the fields attached to entries from the currently
+// open file: the static CustomFields, then PathFields, then any configured
+// xattrs, each taking precedence over the last on key conflicts.
+func (t *tailer) loadFields() {
+	t.fields = t.opts.CustomFields
+	var xattrs map[string]string
+	if len(t.opts.XattrFields) > 0 {
+		var err error
+		xattrs, err = readXattrs(t.path, t.opts.XattrFields)
+		if err != nil {
+			metrics.FileErrors.WithLabelValues(t.path, "xattr").Inc()
+			slog.Warn("Error reading xattrs", "path", t.path, "error", err)
+		}
+	}
+	if len(t.opts.PathFields) == 0 && len(xattrs) == 0 {
+		return
+	}
+	fields := make(map[string]string, len(t.opts.CustomFields)+len(t.opts.PathFields)+len(xattrs))
+	maps.Copy(fields, t.opts.CustomFields)
+	maps.Copy(fields, t.opts.PathFields)
+	maps.Copy(fields, xattrs)
+	t.fields = fields
+}
+
+// newProcessors sets up the processors of the file being read: those
+// FileProcessors build for it, then the shared ones. It runs again when the
+// tailer switches to another file, so per-file state such as a detected
+// format starts over.
+func (t *tailer) newProcessors() {
+	t.processors = t.opts.Processors
+	if len(t.opts.FileProcessors) == 0 {
+		return
+	}
+	processors := make([]Processor, 0, len(t.opts.FileProcessors)+len(t.opts.Processors))
+	for _, newProcessor := range t.opts.FileProcessors {
+		processors = append(processors, newProcessor(t.path))
+	}
+	t.processors = append(processors, t.opts.Processors...)
+}
+
+// handleLine feeds one raw line just read through multiline assembly or
+// straight to the output. line is only valid for the duration of the call;
+// it is copied to a string only once it becomes an entry. It returns false
+// once the tailer should stop.
+func (t *tailer) handleLine(line []byte) bool {
+	return t.handle(line, t.lines != nil && t.lines.truncated)
+}
+
+// handle is handleLine for a line that may not come from t.lines;
+// truncated says whether it was cut at MaxLineBytes.
+func (t *tailer) handle(line []byte, truncated bool) bool {
+	line = t.trimDelimiter(line)
+	if truncated {
+		metrics.TruncatedLines.WithLabelValues(t.path, t.opts.GroupName).Inc()
+		marker := t.opts.TruncationMarker
+		if marker == "" {
+			marker = DefaultTruncationMarker
+		}
+		// Copy rather than write past the line into the read buffer
+		line = append(line[:len(line):len(line)], marker...)
+	}
+	// Multiline Logic
+	if len(t.opts.MultilineRegexes) > 0 {
+		// Check if this line starts a new log entry (any pattern may match)
+		start := t.matches("multiline", line, t.opts.MultilineRegexes...)
+		if t.skipPartial && !t.opts.KeepPartialEntry {
+			if !start {
+				// Tail of an entry that began before we started reading
+				return true
+			}
+			t.skipPartial = false
+		}
+		if start {
+			t.flushBuffer()
+		} else if n := t.opts.MaxMultilineBytes; n > 0 && t.multilineBuffer.Len() > 0 && t.multilineBuffer.Len()+len(line) > n {
+			metrics.MultilineForcedFlushes.WithLabelValues(t.path, t.opts.GroupName).Inc()
+			t.flushBuffer()
+		}
+		if t.multilineBuffer.Len() == 0 && t.lines != nil {
+			t.bufferStart = t.lines.pos - t.lines.last
+		}
+		// Lines are joined with '\n' whatever their delimiter
+		t.multilineBuffer.Write(line)
+		t.multilineBuffer.WriteByte('\n')
+		if t.opts.MultilineTimeout > 0 {
+			t.bufferedAt = time.Now()
+		}
+		return true
+	}
+
+	// Single line mode
+	msg := bytes.TrimSpace(line)
+	if t.excluded(msg) {
+		return true
+	}
+	return t.emit(string(msg), t.done)
+}
+
+// trimDelimiter strips the delimiter ending line, if any, and with the
+// default delimiter the '\r' of a CRLF ending too.
+func (t *tailer) trimDelimiter(line []byte) []byte {
+	delim := t.opts.lineDelimiter()
+	line = bytes.TrimSuffix(line, []byte{delim})
+	if delim == '\n' {
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+	}
+	return line
+}
+
+// excluded reports whether msg matches an exclude pattern or misses all
+// include patterns.
+func (t *tailer) excluded(msg []byte) bool {
+	drop := len(t.opts.ExcludeRegexes) > 0 && t.matches("exclude", msg, t.opts.ExcludeRegexes...)
+	if !drop && len(t.opts.IncludeRegexes) > 0 {
+		drop = !t.matches("include", msg, t.opts.IncludeRegexes...)
+	}
+	if !drop {
+		return false
+	}
+	if t.opts.OnExclude != nil {
+		t.opts.OnExclude(string(msg))
+	}
+	return true
+}
+
+// flushBuffer emits the assembled multiline entry, if any.
+func (t *tailer) flushBuffer() {
+	if t.multilineBuffer.Len() == 0 {
+		return
+	}
+	msg := bytes.TrimSpace(t.multilineBuffer.Bytes())
+	defer t.multilineBuffer.Reset()
+
+	if len(msg) == 0 {
+		return
+	}
+	if t.excluded(msg) {
+		return
+	}
+	t.emit(string(msg), t.deadline)
+}
+
+// emit builds the entry for msg, runs the processors and sends the result.
+// It returns false only when the send was aborted.
+func (t *tailer) emit(msg string, abort <-chan struct{}) bool {
+	if t.sampler != nil && t.sampler.Float64() >= t.opts.SampleRate {
+		metrics.SampledDropped.WithLabelValues(t.path, t.opts.GroupName).Inc()
+		return true
+	}
+	if t.dedup != nil {
+		dup := t.dedup.seen(msg)
+		metrics.DedupFillRatio.WithLabelValues(t.path, t.opts.GroupName).Set(t.dedup.fillRatio())
+		if dup {
+			metrics.DedupSuppressed.WithLabelValues(t.path, t.opts.GroupName).Inc()
+			return true
+		}
+	}
+	for _, r := range t.opts.Redactions {
+		msg = r.Pattern.ReplaceAllString(msg, r.Replacement)
+	}
+	entry := t.newEntry(msg)
+	for _, p := range t.processors {
+		if !p(&entry) {
+			return true
+		}
+	}
+	if t.limiter != nil {
+		if t.limiter.policy == OverLimitBlock {
+			if !t.throttle(abort) {
+				return false
 			}
+		} else if !t.limiter.admit(&entry, t.path, t.opts.GroupName, time.Now()) {
+			return true
+		}
+	}
+	if t.reorder != nil && t.reorder.add(entry, time.Now(), t.resumePoint()) {
+		return t.releaseReordered(false, abort)
+	}
+	return t.send(entry, abort)
+}
+
+// throttle waits until the rate limit allows another entry, counting the
+// time spent waiting. It returns false when abort fires first.
+func (t *tailer) throttle(abort <-chan struct{}) bool {
+	d := t.limiter.bucket.reserve(time.Now())
+	if d <= 0 {
+		return true
+	}
+	start := time.Now()
+	defer func() {
+		metrics.RateLimitedSeconds.WithLabelValues(t.path, t.opts.GroupName).Add(time.Since(start).Seconds())
+	}()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-abort:
+		t.aborted = true
+		return false
+	}
+}
+
+// releaseReordered sends the reorder-buffered entries that are due, or all
+// of them when all is set.
+func (t *tailer) releaseReordered(all bool, abort <-chan struct{}) bool {
+	if t.reorder == nil {
+		return true
+	}
+	now := time.Now()
+	for {
+		entry, ok := t.reorder.next(now, all)
+		if !ok {
+			return true
+		}
+		if !t.send(entry, abort) {
+			return false
 		}
 	}
 }
+
+// newEntry builds an entry from a trimmed, already filtered message.
+func (t *tailer) newEntry(msg string) models.LogEntry {
+	if t.opts.CollapseWhitespace {
+		msg = collapseWhitespace(msg)
+	}
+	now := time.Now()
+	return models.LogEntry{
+		Time:       now.Unix(),
+		TimeNano:   now.UnixNano(),
+		TimeFormat: t.opts.TimestampFormat,
+		Host:       t.opts.Hostname,
+		Source:     filepath.Base(t.path),
+		SourceType: t.opts.GroupName,
+		Event:      msg,
+		Fields:     t.fields,
+	}
+}
+
+// send delivers entry unless abort fires first. A nil abort blocks. With a
+// drop ChannelFullPolicy it never waits, and reports success whether or not
+// entry made it.
+func (t *tailer) send(entry models.LogEntry, abort <-chan struct{}) bool {
+	if t.opts.ChannelFullPolicy == ChannelDropNewest || t.opts.ChannelFullPolicy == ChannelDropOldest {
+		t.trySend(entry)
+		return true
+	}
+	select {
+	case t.out <- entry:
+		t.sent(entry)
+		return true
+	case <-abort:
+		t.aborted = true
+		return false
+	}
+}
+
+// trySend sends entry if out has room, first evicting the oldest entry
+// with ChannelDropOldest, and drops it otherwise.
+func (t *tailer) trySend(entry models.LogEntry) {
+	select {
+	case t.out <- entry:
+		t.sent(entry)
+		return
+	default:
+	}
+	if t.opts.ChannelFullPolicy == ChannelDropOldest && t.opts.Evict != nil {
+		select {
+		case old := <-t.opts.Evict:
+			metrics.ChannelDropped.WithLabelValues(old.SourceType).Inc()
+		default:
+		}
+		// Another tailer may have taken the room in the meantime
+		select {
+		case t.out <- entry:
+			t.sent(entry)
+			return
+		default:
+		}
+	}
+	metrics.ChannelDropped.WithLabelValues(t.opts.GroupName).Inc()
+}
+
+func (t *tailer) sent(entry models.LogEntry) {
+	metrics.LinesProcessed.WithLabelValues(t.path, t.opts.GroupName).Inc()
+	metrics.BytesProcessed.WithLabelValues(t.path, t.opts.GroupName).Add(float64(len(entry.Event)))
+}
+
+// drain reads everything currently readable up to EOF so a planned shutdown
+// doesn't stop mid-file. It gives up DrainTimeout after the shutdown began
+// (0 means no limit).
+func (t *tailer) drain() {
+	t.done, t.deadline = t.drainDeadline, t.drainDeadline
+	expired := func() bool {
+		select {
+		case <-t.drainDeadline:
+			return true
+		default:
+			return false
+		}
+	}
+
+	for !expired() {
+		line, err := t.lines.next()
+		if err != nil {
+			if err != io.EOF {
+				metrics.FileErrors.WithLabelValues(t.path, "read").Inc()
+			}
+			// Nothing more is coming: an unterminated last line is complete
+			if rest := t.lines.rest(); len(rest) > 0 {
+				t.handleLine(rest)
+			}
+			break
+		}
+		if !t.handleLine(line) {
+			break
+		}
+	}
+	// Flush while the deadline still applies; the caller's flush is then a no-op
+	t.flushBuffer()
+	t.releaseReordered(true, t.deadline)
+	if expired() {
+		slog.Warn("Shutdown drain timed out", "path", t.path)
+	}
+}
@@ -1,16 +1,23 @@
 package forwarder
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"katalog/internal/metrics"
 	"katalog/internal/models"
+
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestTailFile(t *testing.T) {
@@ -246,9 +253,9 @@ func TestTailFileExclusion(t *testing.T) {
 	// 4. Start tailing
 	wg.Add(1)
 	go TailFile(ctx, &wg, tmpfile.Name(), outCh, TailOptions{
-		GroupName:    "exclude-group",
-		Hostname:     "test-host",
-		ExcludeRegex: re,
+		GroupName:      "exclude-group",
+		Hostname:       "test-host",
+		ExcludeRegexes: []*regexp.Regexp{re},
 	})
 
 	time.Sleep(100 * time.Millisecond)
@@ -291,6 +298,187 @@ func TestTailFileExclusion(t *testing.T) {
 	wg.Wait()
 }
 
+func TestTailerIncludeExclude(t *testing.T) {
+	lines := []string{
+		"INFO: started\n",
+		"ERROR: disk full\n",
+		"ERROR: debug dump follows\n",
+		"WARN: slow request\n",
+	}
+	tests := []struct {
+		name      string
+		exclude   []string
+		include   []string
+		multiline string
+		maxLine   int
+		want      []string
+	}{
+		{
+			name:    "Exclude Only",
+			exclude: []string{"debug"},
+			want:    []string{"INFO: started", "ERROR: disk full", "WARN: slow request"},
+		},
+		{
+			name:    "Include Only",
+			include: []string{"^(ERROR|WARN)"},
+			want:    []string{"ERROR: disk full", "ERROR: debug dump follows", "WARN: slow request"},
+		},
+		{
+			name:    "Include And Exclude",
+			exclude: []string{"debug"},
+			include: []string{"^(ERROR|WARN)"},
+			want:    []string{"ERROR: disk full", "WARN: slow request"},
+		},
+		{
+			name:    "Any Of Several",
+			exclude: []string{"^INFO", "debug"},
+			include: []string{"disk", "dump", "slow"},
+			want:    []string{"ERROR: disk full", "WARN: slow request"},
+		},
+		{
+			// The patterns see the assembled entry, not its lines
+			name:      "Multiline",
+			exclude:   []string{"disk"},
+			include:   []string{"started", "slow"},
+			multiline: "^(INFO|ERROR)",
+			want:      []string{"INFO: started", "ERROR: debug dump follows\nWARN: slow request"},
+		},
+		{
+			// max_line_bytes caps each line of the entry, not the entry
+			name:      "Multiline Past Line Cap",
+			exclude:   []string{"slow"},
+			multiline: "^(INFO|ERROR)",
+			maxLine:   30,
+			want:      []string{"INFO: started", "ERROR: disk full"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outCh := make(chan models.LogEntry, 10)
+			opts := TailOptions{MaxLineBytes: tt.maxLine}
+			for _, pattern := range tt.exclude {
+				opts.ExcludeRegexes = append(opts.ExcludeRegexes, regexp.MustCompile(pattern))
+			}
+			for _, pattern := range tt.include {
+				opts.IncludeRegexes = append(opts.IncludeRegexes, regexp.MustCompile(pattern))
+			}
+			if tt.multiline != "" {
+				opts.MultilineRegexes = []*regexp.Regexp{regexp.MustCompile(tt.multiline)}
+			}
+			dropped := 0
+			opts.OnExclude = func(string) { dropped++ }
+			tl := &tailer{path: "/var/log/filter.log", out: outCh, opts: opts}
+
+			for _, line := range lines {
+				tl.handleLine([]byte(line))
+			}
+			tl.flushBuffer()
+			close(outCh)
+
+			var got []string
+			for e := range outCh {
+				got = append(got, e.Event)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+			if tt.multiline == "" && dropped != len(lines)-len(tt.want) {
+				t.Errorf("Expected %d dropped lines reported, got %d", len(lines)-len(tt.want), dropped)
+			}
+		})
+	}
+}
+
+func TestTailerSampleRate(t *testing.T) {
+	// sample runs 1000 lines through a tailer keeping about a quarter
+	sample := func(seed uint64) []string {
+		outCh := make(chan models.LogEntry, 1000)
+		tl := &tailer{path: "/var/log/sampled.log", out: outCh, opts: TailOptions{
+			GroupName:  "sampled",
+			SampleRate: 0.25,
+			SampleSeed: seed,
+		}}
+		tl.init()
+		for i := 0; i < 1000; i++ {
+			tl.handleLine([]byte(fmt.Sprintf("line %d\n", i)))
+		}
+		close(outCh)
+		var kept []string
+		for e := range outCh {
+			kept = append(kept, e.Event)
+		}
+		return kept
+	}
+
+	before := counterValue(t, metrics.SampledDropped.WithLabelValues("/var/log/sampled.log", "sampled"))
+	kept := sample(42)
+	if len(kept) < 200 || len(kept) > 300 {
+		t.Errorf("Expected about 250 of 1000 lines kept, got %d", len(kept))
+	}
+	dropped := counterValue(t, metrics.SampledDropped.WithLabelValues("/var/log/sampled.log", "sampled")) - before
+	if int(dropped) != 1000-len(kept) {
+		t.Errorf("Expected %d sampled out lines counted, got %v", 1000-len(kept), dropped)
+	}
+
+	// The same seed picks the same lines
+	if again := sample(42); !reflect.DeepEqual(kept, again) {
+		t.Error("Expected the same seed to keep the same lines")
+	}
+}
+
+// TestTailerChannelFullPolicy verifies that the drop policies never wait on a
+// full channel and count what they drop.
+func TestTailerChannelFullPolicy(t *testing.T) {
+	// fill sends five lines through a channel with room for two, which
+	// nothing reads
+	fill := func(policy, group string) []string {
+		outCh := make(chan models.LogEntry, 2)
+		tl := &tailer{path: "/var/log/full.log", out: outCh, opts: TailOptions{
+			GroupName:         group,
+			ChannelFullPolicy: policy,
+			Evict:             outCh,
+		}}
+		tl.init()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 5; i++ {
+				tl.handleLine([]byte(fmt.Sprintf("line %d\n", i)))
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %s not to block on a full channel", policy)
+		}
+		close(outCh)
+		var kept []string
+		for e := range outCh {
+			kept = append(kept, e.Event)
+		}
+		return kept
+	}
+
+	for _, tt := range []struct {
+		policy string
+		want   []string
+	}{
+		{ChannelDropNewest, []string{"line 0", "line 1"}},
+		{ChannelDropOldest, []string{"line 3", "line 4"}},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			before := counterValue(t, metrics.ChannelDropped.WithLabelValues(tt.policy))
+			if got := fill(tt.policy, tt.policy); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+			if dropped := counterValue(t, metrics.ChannelDropped.WithLabelValues(tt.policy)) - before; dropped != 3 {
+				t.Errorf("Expected 3 dropped lines, got %v", dropped)
+			}
+		})
+	}
+}
+
 func TestTailFileMultiline(t *testing.T) {
 	// 1. Create temp file
 	tmpfile, err := os.CreateTemp("", "multiline-*.log")
@@ -314,9 +502,9 @@ func TestTailFileMultiline(t *testing.T) {
 	// 4. Start tailing
 	wg.Add(1)
 	go TailFile(ctx, &wg, tmpfile.Name(), outCh, TailOptions{
-		GroupName:      "multi-group",
-		Hostname:       "test-host",
-		MultilineRegex: multiRe,
+		GroupName:        "multi-group",
+		Hostname:         "test-host",
+		MultilineRegexes: []*regexp.Regexp{multiRe},
 	})
 
 	time.Sleep(100 * time.Millisecond)
@@ -431,3 +619,1452 @@ func TestTailFileEnrichment(t *testing.T) {
 	cancel()
 	wg.Wait()
 }
+
+func TestTailFileCollapseWhitespace(t *testing.T) {
+	// 1. Create temp file
+	tmpfile, err := os.CreateTemp("", "collapse-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	// 2. Setup context
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+
+	// 3. Start tailing with whitespace collapsing enabled
+	wg.Add(1)
+	go TailFile(ctx, &wg, tmpfile.Name(), outCh, TailOptions{
+		GroupName:          "collapse-group",
+		Hostname:           "test-host",
+		CollapseWhitespace: true,
+	})
+
+	time.Sleep(100 * time.Millisecond)
+
+	// 4. Write a line with runs of spaces and tabs
+	if _, err := tmpfile.WriteString("  GET   /index.html\t\t200    12ms  \n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 5. Verify the event was compacted
+	select {
+	case e := <-outCh:
+		if e.Event != "GET /index.html 200 12ms" {
+			t.Errorf("Expected 'GET /index.html 200 12ms', got '%s'", e.Event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for collapsed log")
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestTailFileMultilineAnyPattern(t *testing.T) {
+	// 1. Create temp file
+	tmpfile, err := os.CreateTemp("", "multiline-any-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	// 2. Setup context
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+
+	// 3. An entry starts with either a date or a bare log level
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`^\d{4}-\d{2}-\d{2}`),
+		regexp.MustCompile(`^(INFO|WARN|ERROR)\b`),
+	}
+
+	// 4. Start tailing
+	wg.Add(1)
+	go TailFile(ctx, &wg, tmpfile.Name(), outCh, TailOptions{
+		GroupName:        "multi-any-group",
+		Hostname:         "test-host",
+		MultilineRegexes: patterns,
+	})
+
+	time.Sleep(100 * time.Millisecond)
+
+	// 5. Write mixed-format logs
+	lines := []string{
+		"2023-01-01 10:00:00 boot",
+		"ERROR worker crashed",
+		"\tat worker.run(Worker.java:42)",
+		"INFO recovered",
+	}
+	for _, l := range lines {
+		if _, err := tmpfile.WriteString(l + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 6. Verify entries are split on either marker
+	expected := []string{
+		"2023-01-01 10:00:00 boot",
+		"ERROR worker crashed\n\tat worker.run(Worker.java:42)",
+	}
+	for _, exp := range expected {
+		select {
+		case e := <-outCh:
+			if e.Event != exp {
+				t.Errorf("Expected '%s', got '%s'", exp, e.Event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for '%s'", exp)
+		}
+	}
+
+	// The last entry is flushed on shutdown
+	cancel()
+	select {
+	case e := <-outCh:
+		if e.Event != "INFO recovered" {
+			t.Errorf("Expected 'INFO recovered', got '%s'", e.Event)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("Timeout waiting for final entry")
+	}
+
+	wg.Wait()
+}
+
+func TestTailFileDrainOnShutdown(t *testing.T) {
+	// 1. Create temp file
+	tmpfile, err := os.CreateTemp("", "drain-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	// 2. Setup context and an unbuffered channel so the tailer stalls on the first send
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry)
+
+	// 3. Start tailing in drain mode
+	wg.Add(1)
+	go TailFile(ctx, &wg, tmpfile.Name(), outCh, TailOptions{
+		GroupName:       "drain-group",
+		Hostname:        "test-host",
+		DrainOnShutdown: true,
+		DrainTimeout:    2 * time.Second,
+	})
+
+	time.Sleep(100 * time.Millisecond)
+
+	// 4. Write a backlog, then cancel before anything has been consumed
+	var expected []string
+	for i := 1; i <= 5; i++ {
+		line := fmt.Sprintf("backlog line %d", i)
+		expected = append(expected, line)
+		if _, err := tmpfile.WriteString(line + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(300 * time.Millisecond)
+	cancel()
+
+	// 5. Every line up to EOF must still be delivered, in order
+	for _, exp := range expected {
+		select {
+		case e := <-outCh:
+			if e.Event != exp {
+				t.Errorf("Expected '%s', got '%s'", exp, e.Event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for '%s' during drain", exp)
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestTailFileDrainOnShutdownBlocked(t *testing.T) {
+	// 1. A file with a backlog, and a channel nobody reads
+	path := filepath.Join(t.TempDir(), "blocked.log")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go TailFile(ctx, &wg, path, make(chan models.LogEntry), TailOptions{
+		GroupName:         "drain-group",
+		ReadFromBeginning: true,
+		DrainOnShutdown:   true,
+		DrainTimeout:      200 * time.Millisecond,
+	})
+
+	// 2. The tailer, stuck sending the first line, still gives up once the
+	// drain's deadline has passed since the cancellation
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Tailer blocked on a full channel ignored the drain timeout")
+	}
+}
+
+func TestTailFileFollowSymlink(t *testing.T) {
+	// 1. Setup two dated files and a "current" symlink to the first
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "app-2024-01-01.log")
+	newPath := filepath.Join(dir, "app-2024-01-02.log")
+	link := filepath.Join(dir, "current")
+	for _, p := range []string{oldPath, newPath} {
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(oldPath, link); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+
+	wg.Add(1)
+	go TailFile(ctx, &wg, link, outCh, TailOptions{FollowSymlink: true})
+	time.Sleep(100 * time.Millisecond)
+
+	appendLine := func(path, line string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(line + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 2. Write to the old target, then repoint the link mid-stream and
+	// write to both: the old target's tail must not be lost
+	appendLine(oldPath, "old-1")
+	tmpLink := link + ".tmp"
+	if err := os.Symlink(newPath, tmpLink); err != nil {
+		t.Fatal(err)
+	}
+	appendLine(oldPath, "old-2")
+	if err := os.Rename(tmpLink, link); err != nil {
+		t.Fatal(err)
+	}
+	appendLine(newPath, "new-1")
+
+	// 3. Verify everything arrives in order
+	for _, want := range []string{"old-1", "old-2", "new-1"} {
+		select {
+		case entry := <-outCh:
+			if entry.Event != want {
+				t.Errorf("Expected '%s', got '%s'", want, entry.Event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for: %s", want)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestTailFileMultilineStartsMidEntry(t *testing.T) {
+	// 1. Create a file whose last entry is still being written
+	tmpfile, err := os.CreateTemp("", "multiline-mid-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+	if _, err := tmpfile.WriteString("2023-01-01 10:00:00 ERROR Crash\njava.lang.Exception: Boom\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+
+	// 2. Start tailing, which seeks into the middle of that entry
+	wg.Add(1)
+	go TailFile(ctx, &wg, tmpfile.Name(), outCh, TailOptions{
+		MultilineRegexes: []*regexp.Regexp{regexp.MustCompile(`^\d{4}-\d{2}-\d{2}`)},
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	// 3. The rest of the stack trace arrives, then new entries
+	if _, err := tmpfile.WriteString("\tat com.example.Main.main(Main.java:10)\n2023-01-01 10:00:01 INFO Next\n2023-01-01 10:00:02 INFO Last\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 4. The orphaned continuation line is discarded, not glued onto "Next"
+	select {
+	case e := <-outCh:
+		if e.Event != "2023-01-01 10:00:01 INFO Next" {
+			t.Errorf("Expected the first whole entry, got '%s'", e.Event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for first entry")
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestTailerMatchesGuard(t *testing.T) {
+	tl := &tailer{path: "/var/log/guard.log", opts: TailOptions{MaxLineBytes: 16}}
+	re := regexp.MustCompile(`ERROR$`)
+	long := []byte(strings.Repeat("a", 1000) + "ERROR")
+
+	// Only the first MaxLineBytes are matched against
+	if tl.matches("exclude", long, re) {
+		t.Error("Expected the match to be limited to the first 16 bytes")
+	}
+	if !tl.matches("exclude", []byte("short ERROR"), re) {
+		t.Error("Expected lines under the cap to match normally")
+	}
+
+	// In multiline mode the cap applies to each line of the entry
+	if tl.matches("exclude", []byte("start\n"+strings.Repeat("a", 1000)+"ERROR"), regexp.MustCompile(`ERROR`)) {
+		t.Error("Expected each line of a multiline entry to be cut to 16 bytes")
+	}
+
+	// Every match counts as slow with a negative threshold
+	defer func(d time.Duration) { slowMatchThreshold = d }(slowMatchThreshold)
+	slowMatchThreshold = -1
+	tl.matches("multiline", []byte("x"), re)
+	var m dto.Metric
+	if err := metrics.SlowMatches.WithLabelValues(tl.path, "multiline").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetCounter().GetValue() != 1 {
+		t.Errorf("Expected 1 slow match, got %v", m.GetCounter().GetValue())
+	}
+}
+
+func TestTailFileRotationToSmallerFile(t *testing.T) {
+	// 1. Start from a large file so the read offset is far past anything
+	// the replacement file will hold (logrotate "create" mode)
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(logPath, []byte(strings.Repeat("old history line\n", 4096)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+
+	wg.Add(1)
+	go TailFile(ctx, &wg, logPath, outCh, TailOptions{})
+	time.Sleep(100 * time.Millisecond)
+
+	// 2. Rotate to a new, empty file with a different inode
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// 3. Write once before the rotation is noticed and once after; both
+	// must be read from the start of the new file
+	if _, err := f.WriteString("New 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if _, err := f.WriteString("New 2\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"New 1", "New 2"} {
+		select {
+		case e := <-outCh:
+			if e.Event != want {
+				t.Errorf("Expected '%s', got '%s'", want, e.Event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for '%s'", want)
+		}
+	}
+
+	// 4. Rotate again, this time letting the tailer open the new file while
+	// it is still empty, then write to it
+	if err := os.Rename(logPath, logPath+".2"); err != nil {
+		t.Fatal(err)
+	}
+	f2, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+	time.Sleep(500 * time.Millisecond)
+	if _, err := f2.WriteString("New 3\n"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-outCh:
+		if e.Event != "New 3" {
+			t.Errorf("Expected 'New 3', got '%s'", e.Event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for 'New 3'")
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestTailerRotationDrainsOldFile(t *testing.T) {
+	// 1. A tailer that has read the old file up to its EOF
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	f, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("Line 1\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outCh := make(chan models.LogEntry, 10)
+	tl := &tailer{path: logPath, out: outCh, file: file, lines: newLineReader(file, '\n', 0)}
+	defer func() { tl.file.Close() }()
+	if tl.fi, err = file.Stat(); err != nil {
+		t.Fatal(err)
+	}
+	if !tl.readToEOF() {
+		t.Fatal("Expected reading the first line to succeed")
+	}
+
+	// 2. More lines land in the old file after that EOF, right before it is
+	// rotated away and the new file gets lines of its own
+	if _, err := f.WriteString("Line 2\nLine 3\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(logPath, []byte("Line 4\nLine 5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 3. The rotation check finishes the old file before switching
+	switched, ok := tl.checkRotation()
+	if !switched || !ok {
+		t.Fatalf("Expected a switch to the new file, got switched=%v ok=%v", switched, ok)
+	}
+	if !tl.readToEOF() {
+		t.Fatal("Expected reading the new file to succeed")
+	}
+
+	// 4. Every line arrives exactly once, in order
+	close(outCh)
+	var got []string
+	for e := range outCh {
+		got = append(got, e.Event)
+	}
+	want := []string{"Line 1", "Line 2", "Line 3", "Line 4", "Line 5"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestTailerCopyTruncate(t *testing.T) {
+	// 1. A tailer that has read the file up to its EOF
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(logPath, []byte("old 1\nold 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outCh := make(chan models.LogEntry, 10)
+	tl := &tailer{path: logPath, out: outCh, file: file, lines: newLineReader(file, '\n', 0)}
+	defer func() { tl.file.Close() }()
+	if tl.fi, err = file.Stat(); err != nil {
+		t.Fatal(err)
+	}
+	tl.checkHead()
+	if !tl.readToEOF() {
+		t.Fatal("Expected reading the file to succeed")
+	}
+	if switched, ok := tl.checkRotation(); switched || !ok {
+		t.Fatalf("Expected no switch yet, got switched=%v ok=%v", switched, ok)
+	}
+
+	// 2. copytruncate empties the file in place, and the app writes more
+	// than it held before the next check
+	if err := os.WriteFile(logPath, []byte("new 1\nnew 2\nnew 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 3. The changed start of the file gives it away despite the larger
+	// size, and reading starts over
+	switched, ok := tl.checkRotation()
+	if !switched || !ok {
+		t.Fatalf("Expected the truncation to be detected, got switched=%v ok=%v", switched, ok)
+	}
+	if !tl.readToEOF() {
+		t.Fatal("Expected reading the rewritten file to succeed")
+	}
+	close(outCh)
+	var got []string
+	for e := range outCh {
+		got = append(got, e.Event)
+	}
+	want := []string{"old 1", "old 2", "new 1", "new 2", "new 3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestTailerRedaction(t *testing.T) {
+	email := Redaction{regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.]+`), "<email>"}
+	card := Redaction{regexp.MustCompile(`\b(?:\d{4}[ -]?){3}(\d{4})\b`), "****-$1"}
+	digits := Redaction{regexp.MustCompile(`\d+`), "#"}
+
+	t.Run("Never Reaches The Output", func(t *testing.T) {
+		outCh := make(chan models.LogEntry, 10)
+		tl := &tailer{path: "/tmp/app.log", out: outCh, opts: TailOptions{
+			Redactions:       []Redaction{email, card},
+			MultilineRegexes: []*regexp.Regexp{regexp.MustCompile(`^\S`)},
+			// A field extractor runs after redaction and only sees its result
+			Processors: []Processor{ExtractFields(regexp.MustCompile(`user=(?P<user>\S+)`), false)},
+		}}
+		for _, line := range []string{
+			"login user=jane.doe@example.com\n",
+			"payment failed\n",
+			"  card 4111 1111 1111 1234 declined for jane.doe@example.com\n",
+			"done\n",
+		} {
+			tl.handleLine([]byte(line))
+		}
+		tl.flushBuffer()
+		close(outCh)
+
+		var events []string
+		for e := range outCh {
+			for _, secret := range []string{"jane.doe", "4111"} {
+				if strings.Contains(e.Event, secret) || strings.Contains(fmt.Sprint(e.Fields), secret) {
+					t.Errorf("Secret %q reached the output: %q %v", secret, e.Event, e.Fields)
+				}
+			}
+			events = append(events, e.Event)
+		}
+		want := []string{
+			"login user=<email>",
+			"payment failed\n  card ****-1234 declined for <email>",
+			"done",
+		}
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("Expected %q, got %q", want, events)
+		}
+	})
+
+	t.Run("List Order", func(t *testing.T) {
+		// The card rule keeps the last four digits only when it runs first
+		for _, tt := range []struct {
+			redactions []Redaction
+			want       string
+		}{
+			{[]Redaction{card, digits}, "card ****-# ok"},
+			{[]Redaction{digits, card}, "card # # # # ok"},
+		} {
+			outCh := make(chan models.LogEntry, 1)
+			tl := &tailer{path: "/tmp/app.log", out: outCh, opts: TailOptions{Redactions: tt.redactions}}
+			tl.handleLine([]byte("card 4111 1111 1111 1234 ok\n"))
+			if got := (<-outCh).Event; got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		}
+	})
+}
+
+func TestTailFileMaxLinesPerCycle(t *testing.T) {
+	// 1. Two files tailed at once, each with a small per-cycle cap
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "busy.log"), filepath.Join(dir, "quiet.log")}
+	for _, p := range paths {
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+	for _, p := range paths {
+		wg.Add(1)
+		go TailFile(ctx, &wg, p, outCh, TailOptions{MaxLinesPerCycle: 10})
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// 2. A burst on one file, a single line on the other
+	var burst strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&burst, "burst %d\n", i)
+	}
+	if err := os.WriteFile(paths[0], []byte(burst.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	quiet, err := os.OpenFile(paths[1], os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer quiet.Close()
+	if _, err := quiet.WriteString("quiet line\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 3. Yielding mid-burst loses nothing: every burst line arrives in
+	// order, along with the quiet file's line
+	next, quietSeen := 0, false
+	for next < 2000 || !quietSeen {
+		select {
+		case e := <-outCh:
+			if e.Event == "quiet line" {
+				quietSeen = true
+				continue
+			}
+			if want := fmt.Sprintf("burst %d", next); e.Event != want {
+				t.Fatalf("Expected '%s', got '%s'", want, e.Event)
+			}
+			next++
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout after %d burst lines (quiet seen: %v)", next, quietSeen)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestTailFileLineWrittenInParts(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "parts-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+	wg.Add(1)
+	go TailFile(ctx, &wg, tmpfile.Name(), outCh, TailOptions{})
+	time.Sleep(100 * time.Millisecond)
+
+	// The writer flushes half a line, pauses past a poll, then finishes it
+	if _, err := tmpfile.WriteString("first half, "); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, err := tmpfile.WriteString("second half\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-outCh:
+		if e.Event != "first half, second half" {
+			t.Errorf("Expected the whole line, got '%s'", e.Event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the line")
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestTailFilePartialLineTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  TailOptions
+		write string
+		want  string
+	}{
+		{
+			name:  "Single Line",
+			write: "no newline yet",
+			want:  "no newline yet",
+		},
+		{
+			name:  "Multiline",
+			opts:  TailOptions{MultilineRegexes: []*regexp.Regexp{regexp.MustCompile(`^ERROR`)}},
+			write: "ERROR boom\n  at main.go:12\n  at main.go:3",
+			want:  "ERROR boom\n  at main.go:12\n  at main.go:3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "partial.log")
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var wg sync.WaitGroup
+			outCh := make(chan models.LogEntry, 10)
+			opts := tt.opts
+			opts.PartialLineTimeout = 300 * time.Millisecond
+			wg.Add(1)
+			go TailFile(ctx, &wg, path, outCh, opts)
+			time.Sleep(100 * time.Millisecond)
+
+			// 1. The writer goes idle without finishing its last line
+			if _, err := f.WriteString(tt.write); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case e := <-outCh:
+				t.Fatalf("Expected nothing before the timeout, got '%s'", e.Event)
+			case <-time.After(150 * time.Millisecond):
+			}
+
+			// 2. After the timeout it is emitted as it stands
+			select {
+			case e := <-outCh:
+				if e.Event != tt.want {
+					t.Errorf("Expected '%s', got '%s'", tt.want, e.Event)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("Timeout waiting for the partial line")
+			}
+
+			// 3. What follows is read as new lines
+			if _, err := f.WriteString("\nERROR next\n"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.WriteString("ERROR last\n"); err != nil {
+				t.Fatal(err)
+			}
+			for {
+				select {
+				case e := <-outCh:
+					if e.Event == "" {
+						// Single line mode: the newline ending the emitted line
+						continue
+					}
+					if e.Event != "ERROR next" {
+						t.Errorf("Expected 'ERROR next', got '%s'", e.Event)
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatal("Timeout waiting for the next line")
+				}
+				break
+			}
+
+			cancel()
+			wg.Wait()
+		})
+	}
+}
+
+func TestTailFileMultilineTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+	wg.Add(1)
+	go TailFile(ctx, &wg, path, outCh, TailOptions{
+		MultilineRegexes: []*regexp.Regexp{regexp.MustCompile(`^ERROR`)},
+		MultilineTimeout: 300 * time.Millisecond,
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	// 1. The last stack trace for a while: no start line follows it
+	if _, err := f.WriteString("ERROR crash\n  at main.go:12\n"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-outCh:
+		t.Fatalf("Expected nothing before the timeout, got '%s'", e.Event)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	// 2. It is flushed once the timeout passes
+	select {
+	case e := <-outCh:
+		if e.Event != "ERROR crash\n  at main.go:12" {
+			t.Errorf("Expected the whole stack trace, got '%s'", e.Event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the stack trace")
+	}
+
+	// 3. Later entries follow it
+	if _, err := f.WriteString("ERROR again\nERROR last\n"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-outCh:
+		if e.Event != "ERROR again" {
+			t.Errorf("Expected 'ERROR again', got '%s'", e.Event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for 'ERROR again'")
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestTailerMaxMultilineBytes(t *testing.T) {
+	outCh := make(chan models.LogEntry, 10)
+	tl := &tailer{path: "/var/log/capped.log", out: outCh, opts: TailOptions{
+		GroupName:         "capped",
+		MultilineRegexes:  []*regexp.Regexp{regexp.MustCompile(`^START`)},
+		MaxMultilineBytes: 50,
+	}}
+
+	// 1. A start line followed by continuation lines that never end: 10
+	// bytes each, so five fit under the cap
+	lines := []string{"START 000\n"}
+	for i := 1; i < 12; i++ {
+		lines = append(lines, fmt.Sprintf("cont  %03d\n", i))
+	}
+	for _, line := range lines {
+		if !tl.handleLine([]byte(line)) {
+			t.Fatal("Expected handleLine to succeed")
+		}
+	}
+
+	// 2. Each full buffer was flushed as one entry at the boundary, the
+	// rest is still being assembled
+	close(outCh)
+	var got []string
+	for e := range outCh {
+		got = append(got, e.Event)
+	}
+	want := []string{
+		strings.TrimSpace(strings.Join(lines[0:5], "")),
+		strings.TrimSpace(strings.Join(lines[5:10], "")),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if tl.multilineBuffer.String() != strings.Join(lines[10:], "") {
+		t.Errorf("Expected the last two lines to be buffered, got %q", tl.multilineBuffer.String())
+	}
+
+	var m dto.Metric
+	if err := metrics.MultilineForcedFlushes.WithLabelValues(tl.path, "capped").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetCounter().GetValue() != 2 {
+		t.Errorf("Expected 2 forced flushes, got %v", m.GetCounter().GetValue())
+	}
+}
+
+func TestTailerBytesProcessed(t *testing.T) {
+	outCh := make(chan models.LogEntry, 10)
+	tl := &tailer{path: "/var/log/bytes.log", out: outCh, opts: TailOptions{
+		GroupName:        "bytes",
+		MultilineRegexes: []*regexp.Regexp{regexp.MustCompile(`^START`)},
+	}}
+
+	// A multiline entry counts the bytes of the whole assembled event, as
+	// sent: trimmed, with the newlines between its lines
+	for _, line := range []string{"START one\n", "  two\n", "  three  \n"} {
+		tl.handleLine([]byte(line))
+	}
+	tl.flushBuffer()
+
+	e := <-outCh
+	var m dto.Metric
+	if err := metrics.BytesProcessed.WithLabelValues(tl.path, "bytes").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.GetCounter().GetValue(), float64(len("START one\n  two\n  three")); got != want || float64(len(e.Event)) != want {
+		t.Errorf("Expected %v bytes for %q, got %v", want, e.Event, got)
+	}
+}
+
+func TestTailerReadLag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lag.log")
+	if err := os.WriteFile(path, []byte("first line\nsecond line\nthird"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tl := &tailer{path: path, file: file, lines: newLineReader(file, '\n', 0)}
+
+	lag := func() float64 {
+		t.Helper()
+		var m dto.Metric
+		if err := metrics.ReadLag.WithLabelValues(path).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetGauge().GetValue()
+	}
+
+	// Nothing read yet: the whole file is backlog
+	tl.updateReadLag()
+	if got := lag(); got != 28 {
+		t.Errorf("Expected a lag of 28 bytes, got %v", got)
+	}
+
+	// Past both complete lines, only the unterminated one is left
+	for i := 0; i < 2; i++ {
+		if _, err := tl.lines.next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tl.updateReadLag()
+	if got := lag(); got != 5 {
+		t.Errorf("Expected a lag of 5 bytes, got %v", got)
+	}
+}
+
+func TestTailFileLivePatterns(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "live-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	// 1. Start with DEBUG lines excluded
+	live := NewLivePatterns(Patterns{
+		Exclude: []*regexp.Regexp{regexp.MustCompile(`^DEBUG`)},
+		Fields:  map[string]string{"env": "old"},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+	wg.Add(1)
+	go TailFile(ctx, &wg, tmpfile.Name(), outCh, TailOptions{Live: live})
+	time.Sleep(100 * time.Millisecond)
+
+	expect := func(event, env string) {
+		t.Helper()
+		select {
+		case e := <-outCh:
+			if e.Event != event || e.Fields["env"] != env {
+				t.Fatalf("Expected '%s' with env=%s, got '%s' with %v", event, env, e.Event, e.Fields)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for '%s'", event)
+		}
+	}
+
+	if _, err := tmpfile.WriteString("DEBUG one\nINFO two\n"); err != nil {
+		t.Fatal(err)
+	}
+	expect("INFO two", "old")
+
+	// 2. Swap in new patterns without restarting the tailer
+	live.Store(Patterns{
+		Exclude: []*regexp.Regexp{regexp.MustCompile(`^INFO`)},
+		Fields:  map[string]string{"env": "new"},
+	})
+	if _, err := tmpfile.WriteString("INFO three\nDEBUG four\n"); err != nil {
+		t.Fatal(err)
+	}
+	expect("DEBUG four", "new")
+
+	cancel()
+	wg.Wait()
+}
+
+func TestTailFileCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte("before start\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenCheckpointStore(filepath.Join(dir, "checkpoints.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendLine := func(line string) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(line + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tail := func() (chan models.LogEntry, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		outCh := make(chan models.LogEntry, 10)
+		wg.Add(1)
+		go TailFile(ctx, &wg, path, outCh, TailOptions{Checkpoint: store})
+		time.Sleep(100 * time.Millisecond)
+		return outCh, func() { cancel(); wg.Wait() }
+	}
+	expect := func(outCh chan models.LogEntry, want string) {
+		t.Helper()
+		select {
+		case e := <-outCh:
+			if e.Event != want {
+				t.Fatalf("Expected '%s', got '%s'", want, e.Event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for '%s'", want)
+		}
+	}
+
+	// 1. Without a checkpoint the tailer starts at EOF
+	outCh, stop := tail()
+	appendLine("first run")
+	expect(outCh, "first run")
+	stop()
+
+	// 2. Lines written while stopped are picked up on restart
+	appendLine("while down")
+	outCh, stop = tail()
+	expect(outCh, "while down")
+	stop()
+
+	// 3. A checkpoint past the end (truncated while down) starts over
+	if err := os.WriteFile(path, []byte("after truncate\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outCh, stop = tail()
+	defer stop()
+	expect(outCh, "after truncate")
+}
+
+func TestTailerCheckpointReorder(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\nfour\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenCheckpointStore(filepath.Join(dir, "checkpoints.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outCh := make(chan models.LogEntry, 10)
+	tl := &tailer{path: path, out: outCh, fi: fi, lines: newLineReader(f, '\n', 0), opts: TailOptions{ReorderWindow: time.Hour, Checkpoint: store}}
+	tl.init()
+	read := func(n int) {
+		t.Helper()
+		for range n {
+			line, err := tl.lines.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tl.handleLine(line)
+		}
+	}
+	checkpoint := func(want int64) {
+		t.Helper()
+		tl.saveCheckpoint()
+		if got, ok := store.Get(path, fileID(fi)); !ok || got != want {
+			t.Errorf("Expected checkpoint %d, got %d (saved: %v)", want, got, ok)
+		}
+	}
+
+	// 1. While every entry read is held, the checkpoint stays at the first
+	read(3)
+	checkpoint(0)
+
+	// 2. Once those are handed over it moves to the first one still held
+	tl.releaseReordered(true, nil)
+	read(1)
+	checkpoint(14)
+	tl.releaseReordered(true, nil)
+	checkpoint(19)
+}
+
+func TestTailFileReadFromBeginning(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte("old 1\nold 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenCheckpointStore(filepath.Join(dir, "checkpoints.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func() []string {
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		outCh := make(chan models.LogEntry, 10)
+		wg.Add(1)
+		go TailFile(ctx, &wg, path, outCh, TailOptions{ReadFromBeginning: true, Checkpoint: store})
+		time.Sleep(300 * time.Millisecond)
+		cancel()
+		wg.Wait()
+		close(outCh)
+		var events []string
+		for e := range outCh {
+			events = append(events, e.Event)
+		}
+		return events
+	}
+
+	// 1. The existing content is read once from the start
+	if got := run(); !reflect.DeepEqual(got, []string{"old 1", "old 2"}) {
+		t.Errorf("Expected the whole file, got %v", got)
+	}
+
+	// 2. On the next open the checkpoint wins over read_from_beginning
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("new\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(); !reflect.DeepEqual(got, []string{"new"}) {
+		t.Errorf("Expected only the new line, got %v", got)
+	}
+}
+
+func TestTailFileGzip(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("rotated 1\nrotated 2\nunterminated"))
+	gz.Close()
+
+	// 1. Detected by extension and, without one, by magic bytes
+	for _, name := range []string{"app.log.1.gz", "app.log.2"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		outCh := make(chan models.LogEntry, 10)
+		wg.Add(1)
+		go TailFile(context.Background(), &wg, path, outCh, TailOptions{})
+
+		// 2. The file is read once, then the tailer exits on its own
+		done := make(chan struct{})
+		go func() { wg.Wait(); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected the tailer of %s to exit after reading it", name)
+		}
+		close(outCh)
+		var events []string
+		for e := range outCh {
+			events = append(events, e.Event)
+		}
+		if want := []string{"rotated 1", "rotated 2", "unterminated"}; !reflect.DeepEqual(events, want) {
+			t.Errorf("%s: expected %v, got %v", name, want, events)
+		}
+	}
+
+	// 3. A corrupt file is counted as a gzip error
+	path := filepath.Join(dir, "broken.gz")
+	if err := os.WriteFile(path, buf.Bytes()[:len(buf.Bytes())/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	TailFile(context.Background(), &wg, path, make(chan models.LogEntry, 10), TailOptions{})
+	var m dto.Metric
+	if err := metrics.FileErrors.WithLabelValues(path, "gzip").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetCounter().GetValue() != 1 {
+		t.Errorf("Expected 1 gzip error, got %v", m.GetCounter().GetValue())
+	}
+}
+
+func TestTailFileWake(t *testing.T) {
+	defer func(d time.Duration) { wakeFallback = d }(wakeFallback)
+	wakeFallback = time.Hour
+
+	tmpfile, err := os.CreateTemp("", "wake-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+	wake := make(chan struct{}, 1)
+	wg.Add(1)
+	go TailFile(ctx, &wg, tmpfile.Name(), outCh, TailOptions{Wake: wake})
+	time.Sleep(100 * time.Millisecond)
+
+	// 1. Without a wake-up the tailer doesn't look at the file again
+	if _, err := tmpfile.WriteString("woken\n"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-outCh:
+		t.Fatalf("Expected no read before the wake-up, got '%s'", e.Event)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// 2. A wake-up reads what was written
+	wake <- struct{}{}
+	select {
+	case e := <-outCh:
+		if e.Event != "woken" {
+			t.Errorf("Expected 'woken', got '%s'", e.Event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the line after the wake-up")
+	}
+
+	// 3. Cancelling interrupts the wait
+	cancel()
+	select {
+	case <-waitGroupDone(&wg):
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the tailer to stop while waiting")
+	}
+}
+
+func TestTailFileWakeReorder(t *testing.T) {
+	defer func(d time.Duration) { wakeFallback = d }(wakeFallback)
+	wakeFallback = time.Hour
+
+	path := filepath.Join(t.TempDir(), "reorder.log")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+	wake := make(chan struct{}, 1)
+	wg.Add(1)
+	go TailFile(ctx, &wg, path, outCh, TailOptions{Wake: wake, ReorderWindow: 200 * time.Millisecond})
+	time.Sleep(100 * time.Millisecond)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("held\n"); err != nil {
+		t.Fatal(err)
+	}
+	wake <- struct{}{}
+
+	// The held entry is released once the window passes, with no further
+	// wake-up
+	select {
+	case e := <-outCh:
+		if e.Event != "held" {
+			t.Errorf("Expected 'held', got '%s'", e.Event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the reordered entry")
+	}
+	cancel()
+	wg.Wait()
+}
+
+func waitGroupDone(wg *sync.WaitGroup) <-chan struct{} {
+	ch := make(chan struct{})
+	go func() { wg.Wait(); close(ch) }()
+	return ch
+}
+
+func TestTailFileFileProcessors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.csv")
+	if err := os.WriteFile(path, []byte("ip,status\n10.0.0.1,200\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+	wg.Add(1)
+	TailFile(context.Background(), &wg, path, outCh, TailOptions{
+		ReadFromBeginning: true,
+		Once:              true,
+		FileProcessors: []func(string) Processor{func(p string) Processor {
+			paths = append(paths, p)
+			return ParseCSV(CSVOptions{HeaderFile: p})
+		}},
+		// Runs after the file's processors, so sees the parsed fields
+		Processors: []Processor{DropFields([]string{"ip"})},
+	})
+	close(outCh)
+
+	var entries []models.LogEntry
+	for e := range outCh {
+		entries = append(entries, e)
+	}
+	if !reflect.DeepEqual(paths, []string{path}) {
+		t.Errorf("Expected the processor to be built once for %s, got %v", path, paths)
+	}
+	if len(entries) != 1 || !reflect.DeepEqual(entries[0].Fields, map[string]string{"status": "200"}) {
+		t.Errorf("Expected one row with its status, got %+v", entries)
+	}
+}
+
+func TestTailFileRotationRebuildsFileProcessors(t *testing.T) {
+	// 1. A JSON file, parsed with a format detected per file
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte(`{"message":"one","n":"1"}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+	wg.Add(1)
+	go TailFile(ctx, &wg, path, outCh, TailOptions{
+		ReadFromBeginning: true,
+		FileProcessors: []func(string) Processor{func(p string) Processor {
+			return ParseAuto(p, "", false)
+		}},
+	})
+	receive := func() models.LogEntry {
+		t.Helper()
+		select {
+		case e := <-outCh:
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for an entry")
+		}
+		return models.LogEntry{}
+	}
+	if e := receive(); e.Event != "one" || e.Fields["n"] != "1" {
+		t.Errorf("Expected the JSON line parsed, got '%s' with %v", e.Event, e.Fields)
+	}
+
+	// 2. The file rotated to one in logfmt gets its own format
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("msg=two n=2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if e := receive(); e.Event != "msg=two n=2" || e.Fields["n"] != "2" {
+		t.Errorf("Expected the logfmt line parsed, got '%s' with %v", e.Event, e.Fields)
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestTailFileLineDelimiters(t *testing.T) {
+	multiline := []*regexp.Regexp{regexp.MustCompile(`^\d{4}`)}
+	tests := []struct {
+		name     string
+		content  string
+		opts     TailOptions
+		expected []string
+	}{
+		{"CRLF", "one\r\ntwo\r\n", TailOptions{}, []string{"one", "two"}},
+		{"CRLF Multiline", "2023 ERROR boom\r\n\tat Main.java:1\r\n2023 INFO done\r\n", TailOptions{MultilineRegexes: multiline},
+			[]string{"2023 ERROR boom\n\tat Main.java:1", "2023 INFO done"}},
+		{"Carriage Return", "one\rtwo\r", TailOptions{LineDelimiter: "\r"}, []string{"one", "two"}},
+		{"Null Byte", "one\x00two\nlines\x00last", TailOptions{LineDelimiter: "\x00"}, []string{"one", "two\nlines", "last"}},
+		{"Null Byte Multiline", "2023 boom\x00\tat Main.java:1\x002023 done\x00", TailOptions{LineDelimiter: "\x00", MultilineRegexes: multiline},
+			[]string{"2023 boom\n\tat Main.java:1", "2023 done"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			out := make(chan models.LogEntry, 10)
+			if err := ProcessFile(path, out, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			close(out)
+			var events []string
+			for e := range out {
+				events = append(events, e.Event)
+			}
+			if !reflect.DeepEqual(events, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, events)
+			}
+		})
+	}
+}
+
+func TestTailFileMaxLineBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blob.log")
+	blob := strings.Repeat("B", 1<<20)
+	if err := os.WriteFile(path, []byte("before\n"+blob+"\nafter\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	truncated := metrics.TruncatedLines.WithLabelValues(path, "blobs")
+	before := counterValue(t, truncated)
+
+	out := make(chan models.LogEntry, 10)
+	if err := ProcessFile(path, out, TailOptions{GroupName: "blobs", MaxLineBytes: 16, TruncationMarker: " [cut]"}); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	var events []string
+	for e := range out {
+		events = append(events, e.Event)
+	}
+	if want := []string{"before", "BBBBBBBBBBBBBBBB [cut]", "after"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("Expected %q, got %.60q", want, events)
+	}
+	if got := counterValue(t, truncated) - before; got != 1 {
+		t.Errorf("Expected 1 truncated line, got %.0f", got)
+	}
+}
@@ -0,0 +1,184 @@
+package forwarder
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"katalog/internal/metrics"
+)
+
+// writePEM writes one PEM block of type typ to a file in dir.
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newClientCert issues a client certificate for commonName from a new CA,
+// returning the CA and the paths of the certificate and its key.
+func newClientCert(t *testing.T, dir, commonName string) (*x509.Certificate, string, string) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, writePEM(t, dir, "client.crt", "CERTIFICATE", der), writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+}
+
+func TestHTTPTransportTLS(t *testing.T) {
+	orig := retryBase
+	retryBase = time.Millisecond
+	t.Cleanup(func() { retryBase = orig })
+
+	// 1. A collector requiring a client certificate from its own CA
+	dir := t.TempDir()
+	clientCA, certFile, keyFile := newClientCert(t, dir, "katalog")
+	var mu sync.Mutex
+	var clients []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		clients = append(clients, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCA)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := writePEM(t, dir, "server-ca.crt", "CERTIFICATE", srv.Certificate().Raw)
+
+	tests := []struct {
+		name      string
+		opts      TLSOptions
+		delivered bool
+	}{
+		{name: "Unknown Server", opts: TLSOptions{CertFile: certFile, KeyFile: keyFile}},
+		{name: "No Client Certificate", opts: TLSOptions{CAFile: caFile}},
+		{name: "Mutual TLS", opts: TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, delivered: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 2. The batch only gets through when the server is verified and
+			// the client certificate presented
+			cfg, err := NewTLSConfig(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr, err := NewHTTPTransport(HTTPOutputConfig{URL: srv.URL, MaxRetries: 1, TLS: cfg})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tr.Close()
+			mu.Lock()
+			clients = nil
+			mu.Unlock()
+			before := counterValue(t, metrics.HTTPOutputDropped)
+			if _, err := tr.Write([]byte("line\n")); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			dropped := counterValue(t, metrics.HTTPOutputDropped) - before
+			if tt.delivered {
+				if dropped != 0 || len(clients) != 1 || clients[0] != "katalog" {
+					t.Errorf("Expected one request from the client certificate, got %q (%.0f dropped)", clients, dropped)
+				}
+			} else if dropped != 1 || len(clients) != 0 {
+				t.Errorf("Expected the batch dropped without a request, got %q (%.0f dropped)", clients, dropped)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := newClientCert(t, dir, "katalog")
+	notPEM := filepath.Join(dir, "not.pem")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0o600)
+
+	tests := []struct {
+		name    string
+		opts    TLSOptions
+		wantErr bool
+	}{
+		{name: "Defaults", opts: TLSOptions{ServerName: "collector"}},
+		{name: "Client Certificate", opts: TLSOptions{CertFile: certFile, KeyFile: keyFile}},
+		{name: "Key Mismatch", opts: TLSOptions{CertFile: certFile, KeyFile: certFile}, wantErr: true},
+		{name: "Missing CA", opts: TLSOptions{CAFile: filepath.Join(dir, "missing.crt")}, wantErr: true},
+		{name: "Empty CA", opts: TLSOptions{CAFile: notPEM}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := NewTLSConfig(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.ServerName != tt.opts.ServerName {
+				t.Errorf("Expected server name %q, got %q", tt.opts.ServerName, cfg.ServerName)
+			}
+			if want := tt.opts.CertFile != ""; (len(cfg.Certificates) == 1) != want {
+				t.Errorf("Expected a client certificate: %v, got %d", want, len(cfg.Certificates))
+			}
+		})
+	}
+}
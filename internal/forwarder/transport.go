@@ -0,0 +1,28 @@
+package forwarder
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// OpenTransport opens the destination entries are written to. The caller
+// closes it once the writer has returned; closing stdout is a no-op.
+func OpenTransport(kind, path string) (io.WriteCloser, error) {
+	switch kind {
+	case "", "stdout":
+		return nopCloser{os.Stdout}, nil
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("file transport requires a path")
+		}
+		return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	}
+	return nil, fmt.Errorf("unknown transport: %s", kind)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -1,24 +1,115 @@
 package forwarder
 
 import (
-	"bufio"
-	"encoding/json"
-	"log" // Added for error logging
-	"os"
+	"bytes"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
 	"time"
 
 	"katalog/internal/models"
 )
 
-func WriteLogs(out <-chan models.LogEntry, format string) {
-	// Use a buffered writer to reduce syscalls
-	w := bufio.NewWriter(os.Stdout)
-	defer w.Flush()
+const (
+	// Defaults for entries without a BufferPolicy: write out every 4KB or
+	// 500ms, whichever comes first.
+	defaultBufferBytes   = 4096
+	defaultFlushInterval = 500 * time.Millisecond
+)
+
+// BufferPolicy controls how long a group's entries are held before being
+// written to the shared sink. BatchSize flushes after that many entries
+// (0 means by size, every 4KB) and FlushInterval bounds how long the oldest
+// entry waits (0 means 500ms). MaxBatchBytes, if set, also caps the size
+// of each write: a batch is written out before an entry would push it past
+// the cap, so only a single oversized entry can ever exceed it.
+type BufferPolicy struct {
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxBatchBytes int
+}
+
+// groupBuffer holds the serialized entries of one group until they are due.
+type groupBuffer struct {
+	policy BufferPolicy
+	buf    bytes.Buffer
+	count  int
+	since  time.Time // arrival of the oldest buffered entry
+}
+
+func (g *groupBuffer) full() bool {
+	if g.policy.MaxBatchBytes > 0 && g.buf.Len() >= g.policy.MaxBatchBytes {
+		return true
+	}
+	if g.policy.BatchSize > 0 {
+		return g.count >= g.policy.BatchSize
+	}
+	return g.buf.Len() >= defaultBufferBytes
+}
+
+// due reports whether the oldest entry would exceed the flush interval
+// before the next tick.
+func (g *groupBuffer) due(now time.Time, tick time.Duration) bool {
+	return g.count > 0 && now.Sub(g.since) >= g.interval()-tick
+}
+
+func (g *groupBuffer) interval() time.Duration {
+	if g.policy.FlushInterval > 0 {
+		return g.policy.FlushInterval
+	}
+	return defaultFlushInterval
+}
+
+// WriteLogs serializes every entry received on out to dst until out is closed.
+func WriteLogs(out <-chan models.LogEntry, dst io.Writer, serializer Serializer) {
+	WriteLogsBuffered(out, dst, serializer, nil)
+}
 
-	encoder := json.NewEncoder(w)
+// WriteLogsBuffered is WriteLogs with per-group buffering: entries are
+// first collected per SourceType (the target name) under that group's
+// policy, then written to the shared dst, so latency-critical targets can
+// flush on every entry while others batch. Groups without a policy share
+// the default buffer, whose policy is the one under "". The buffers are
+// checked as often as the shortest flush interval requires, so long
+// intervals also mean fewer wakeups.
+func WriteLogsBuffered(out <-chan models.LogEntry, dst io.Writer, serializer Serializer, policies map[string]BufferPolicy) {
+	def := &groupBuffer{policy: policies[""]}
+	groups := make(map[string]*groupBuffer, len(policies))
+	all := []*groupBuffer{def}
+	tick := def.interval()
+	for _, name := range slices.Sorted(maps.Keys(policies)) {
+		if name == "" {
+			continue
+		}
+		g := &groupBuffer{policy: policies[name]}
+		groups[name] = g
+		all = append(all, g)
+		tick = min(tick, g.interval())
+	}
+
+	flush := func(g *groupBuffer) {
+		if g.buf.Len() == 0 {
+			return
+		}
+		if _, err := dst.Write(g.buf.Bytes()); err != nil {
+			slog.Error("Error flushing writer buffer", "error", err)
+		}
+		g.buf.Reset()
+		g.count = 0
+	}
+	// flushBefore writes out the first n buffered bytes, keeping the entry
+	// serialized after them as the start of the next batch.
+	flushBefore := func(g *groupBuffer, n int) {
+		if _, err := dst.Write(g.buf.Next(n)); err != nil {
+			slog.Error("Error flushing writer buffer", "error", err)
+		}
+		g.count = 0
+		g.since = time.Now()
+	}
 
-	// Ticker to flush buffer periodically if low traffic
-	flushTicker := time.NewTicker(500 * time.Millisecond)
+	// Ticker to flush buffers periodically if low traffic
+	flushTicker := time.NewTicker(tick)
 	defer flushTicker.Stop()
 
 	for {
@@ -26,24 +117,68 @@ func WriteLogs(out <-chan models.LogEntry, format string) {
 		case entry, ok := <-out:
 			if !ok {
 				// Channel closed, flush anything remaining and return
-				_ = w.Flush() // Attempt to flush, ignore error on shutdown
+				for _, g := range all {
+					flush(g)
+				}
 				return
 			}
-			if format == "raw" {
-				if _, err := w.WriteString(entry.Event + "\n"); err != nil {
-					// Log the error, but continue trying to write next logs
-					log.Printf("Error writing raw log to stdout: %v", err)
-				}
-			} else {
-				if err := encoder.Encode(entry); err != nil {
-					// Log the error, but continue trying to write next logs
-					log.Printf("Error encoding JSON log to stdout: %v", err)
-				}
+			g := groups[entry.SourceType]
+			if g == nil {
+				g = def
+			}
+			if g.count == 0 {
+				g.since = time.Now()
+			}
+			mark := g.buf.Len()
+			if err := serializer.Serialize(&g.buf, entry); err != nil {
+				// Log the error, but continue trying to write next logs
+				slog.Error("Error writing log entry", "error", err)
+				continue
+			}
+			if limit := g.policy.MaxBatchBytes; limit > 0 && mark > 0 && g.buf.Len() > limit {
+				flushBefore(g, mark)
 			}
-		case <-flushTicker.C:
-			if err := w.Flush(); err != nil {
-				log.Printf("Error flushing writer buffer: %v", err)
+			g.count++
+			if g.full() {
+				flush(g)
+			}
+		case now := <-flushTicker.C:
+			for _, g := range all {
+				if g.due(now, tick) {
+					flush(g)
+				}
 			}
 		}
 	}
 }
+
+// jsonArrayWriter turns every batch of newline-delimited JSON written to it
+// into a single JSON array on its own line.
+type jsonArrayWriter struct {
+	io.WriteCloser
+}
+
+// NewJSONArrayWriter wraps dst for the json_array format: WriteLogs writes
+// each flushed batch in one call, so each flush becomes one `[...]` array.
+// As arrays are closed within the write that opens them, the output stays
+// valid JSON whenever the process stops, and WriteLogs flushes a partial
+// batch as a shorter array when its channel is closed.
+func NewJSONArrayWriter(dst io.WriteCloser) io.WriteCloser {
+	return jsonArrayWriter{dst}
+}
+
+func (w jsonArrayWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	lines := bytes.Split(bytes.TrimSuffix(p, []byte("\n")), []byte("\n"))
+	var b bytes.Buffer
+	b.Grow(len(p) + 2)
+	b.WriteByte('[')
+	b.Write(bytes.Join(lines, []byte(",")))
+	b.WriteString("]\n")
+	if _, err := w.WriteCloser.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
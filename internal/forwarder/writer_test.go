@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"io"
 	"os"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"katalog/internal/models"
 )
@@ -32,7 +34,7 @@ func TestWriteLogs(t *testing.T) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		WriteLogs(outCh, "json")
+		WriteLogs(outCh, os.Stdout, JSONSerializer{})
 	}()
 
 	// 4. Send data and close
@@ -81,7 +83,7 @@ func TestWriteLogsRaw(t *testing.T) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		WriteLogs(outCh, "raw")
+		WriteLogs(outCh, os.Stdout, RawSerializer{})
 	}()
 
 	// 4. Send data and close
@@ -102,3 +104,158 @@ func TestWriteLogsRaw(t *testing.T) {
 		t.Errorf("Expected 'raw message\\n', got '%s'", buf.String())
 	}
 }
+
+// lockedBuffer is a bytes.Buffer safe to read while the writer writes to it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWriteLogsBufferedPerGroup(t *testing.T) {
+	sink := &lockedBuffer{}
+	outCh := make(chan models.LogEntry)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		WriteLogsBuffered(outCh, sink, RawSerializer{}, map[string]BufferPolicy{
+			"alerts": {BatchSize: 1},
+			"bulk":   {BatchSize: 3, FlushInterval: time.Minute},
+		})
+	}()
+
+	// 1. A latency-critical entry is written straight through
+	outCh <- models.LogEntry{SourceType: "alerts", Event: "page"}
+	waitFor := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for sink.String() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %q, got %q", want, sink.String())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("page\n")
+
+	// 2. Bulk entries wait for a full batch, without holding up alerts
+	outCh <- models.LogEntry{SourceType: "bulk", Event: "b1"}
+	outCh <- models.LogEntry{SourceType: "bulk", Event: "b2"}
+	outCh <- models.LogEntry{SourceType: "alerts", Event: "page2"}
+	waitFor("page\npage2\n")
+	outCh <- models.LogEntry{SourceType: "bulk", Event: "b3"}
+	waitFor("page\npage2\nb1\nb2\nb3\n")
+
+	// 3. Partial batches are flushed on close
+	outCh <- models.LogEntry{SourceType: "bulk", Event: "b4"}
+	close(outCh)
+	<-done
+	if got := sink.String(); got != "page\npage2\nb1\nb2\nb3\nb4\n" {
+		t.Errorf("Expected the partial batch on close, got %q", got)
+	}
+}
+
+func TestWriteLogsBufferedDefaultPolicy(t *testing.T) {
+	sink := &lockedBuffer{}
+	outCh := make(chan models.LogEntry)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		WriteLogsBuffered(outCh, sink, RawSerializer{}, map[string]BufferPolicy{
+			"": {FlushInterval: 20 * time.Millisecond},
+		})
+	}()
+	defer func() { close(outCh); <-done }()
+
+	// Entries of groups without a policy follow the one under "", well
+	// before the 500ms default
+	outCh <- models.LogEntry{SourceType: "app", Event: "quick"}
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for sink.String() != "quick\n" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the entry within the default flush interval, got %q", sink.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// writeRecorder keeps each Write separately.
+type writeRecorder struct {
+	writes []string
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestWriteLogsBufferedMaxBatchBytes(t *testing.T) {
+	sink := &writeRecorder{}
+	outCh := make(chan models.LogEntry, 10)
+
+	// 1. 100-byte entries (with newline) against a 250-byte cap and a count
+	// limit that is never reached
+	event := strings.Repeat("x", 99)
+	for i := 0; i < 5; i++ {
+		outCh <- models.LogEntry{SourceType: "bulk", Event: event}
+	}
+	close(outCh)
+	WriteLogsBuffered(outCh, sink, RawSerializer{}, map[string]BufferPolicy{
+		"bulk": {BatchSize: 100, FlushInterval: time.Minute, MaxBatchBytes: 250},
+	})
+
+	// 2. Batches are cut on bytes: two entries each, never over the cap
+	want := []int{200, 200, 100}
+	if len(sink.writes) != len(want) {
+		t.Fatalf("Expected %d writes, got %d", len(want), len(sink.writes))
+	}
+	for i, w := range sink.writes {
+		if len(w) != want[i] {
+			t.Errorf("Write %d: expected %d bytes, got %d", i, want[i], len(w))
+		}
+	}
+}
+
+func TestWriteLogsJSONArray(t *testing.T) {
+	var buf bytes.Buffer
+	dst := NewJSONArrayWriter(nopCloser{&buf})
+
+	// 1. Two full batches of two, then one entry left when the channel
+	// closes mid-batch
+	outCh := make(chan models.LogEntry, 10)
+	for i := 0; i < 5; i++ {
+		outCh <- models.LogEntry{Time: 1700000000, SourceType: "app", Event: "event " + string(rune('a'+i))}
+	}
+	close(outCh)
+	WriteLogsBuffered(outCh, dst, JSONSerializer{}, map[string]BufferPolicy{"app": {BatchSize: 2}})
+
+	// 2. Every line is a JSON array, holding all the entries in order
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 arrays, got %q", buf.String())
+	}
+	var events []string
+	for _, line := range lines {
+		var batch []models.LogEntry
+		if err := json.Unmarshal([]byte(line), &batch); err != nil {
+			t.Fatalf("Expected a JSON array, got %q: %v", line, err)
+		}
+		for _, e := range batch {
+			events = append(events, e.Event)
+		}
+	}
+	if got := strings.Join(events, ","); got != "event a,event b,event c,event d,event e" {
+		t.Errorf("Expected all 5 entries in order, got %s", got)
+	}
+}
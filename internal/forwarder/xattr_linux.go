@@ -0,0 +1,33 @@
+//go:build linux
+
+package forwarder
+
+import (
+	"errors"
+	"syscall"
+)
+
+// readXattrs reads the extended attributes named by the keys of names and
+// returns them keyed by the mapped field name. Missing attributes are skipped.
+func readXattrs(path string, names map[string]string) (map[string]string, error) {
+	fields := make(map[string]string, len(names))
+	buf := make([]byte, 256)
+	for attr, field := range names {
+		for {
+			n, err := syscall.Getxattr(path, attr, buf)
+			if errors.Is(err, syscall.ERANGE) {
+				buf = make([]byte, len(buf)*2)
+				continue
+			}
+			if errors.Is(err, syscall.ENODATA) {
+				break
+			}
+			if err != nil {
+				return fields, err
+			}
+			fields[field] = string(buf[:n])
+			break
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,81 @@
+//go:build linux
+
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"katalog/internal/models"
+)
+
+func TestTailFileXattrFields(t *testing.T) {
+	// 1. Create a file and stamp an xattr on it
+	logPath := filepath.Join(t.TempDir(), "xattr.log")
+	f, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := syscall.Setxattr(logPath, "user.service_name", []byte("billing"), 0); err != nil {
+		if errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EPERM) {
+			t.Skipf("Filesystem does not support user xattrs: %v", err)
+		}
+		t.Fatal(err)
+	}
+
+	// 2. Setup context
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	outCh := make(chan models.LogEntry, 10)
+
+	// 3. Start tailing, mapping the xattr to a field; a missing xattr is ignored
+	static := map[string]string{"env": "prod"}
+	wg.Add(1)
+	go TailFile(ctx, &wg, logPath, outCh, TailOptions{
+		GroupName:    "xattr-group",
+		Hostname:     "test-host",
+		CustomFields: static,
+		XattrFields: map[string]string{
+			"user.service_name": "service",
+			"user.missing":      "missing",
+		},
+	})
+
+	time.Sleep(100 * time.Millisecond)
+
+	// 4. Write log
+	if _, err := f.WriteString("charged card\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 5. Verify fields
+	select {
+	case e := <-outCh:
+		if e.Fields["service"] != "billing" {
+			t.Errorf("Expected service='billing', got '%s'", e.Fields["service"])
+		}
+		if e.Fields["env"] != "prod" {
+			t.Errorf("Expected env='prod', got '%s'", e.Fields["env"])
+		}
+		if _, ok := e.Fields["missing"]; ok {
+			t.Error("Expected missing xattr to be skipped")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for log")
+	}
+	if _, ok := static["service"]; ok {
+		t.Error("xattr fields must not be written into the shared CustomFields map")
+	}
+
+	cancel()
+	wg.Wait()
+}
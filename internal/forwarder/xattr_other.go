@@ -0,0 +1,8 @@
+//go:build !linux
+
+package forwarder
+
+// readXattrs is a no-op where extended attributes aren't supported.
+func readXattrs(path string, names map[string]string) (map[string]string, error) {
+	return nil, nil
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Family is the JSON form of one metric family.
+type Family struct {
+	Name    string   `json:"name"`
+	Help    string   `json:"help,omitempty"`
+	Type    string   `json:"type"`
+	Metrics []Sample `json:"metrics"`
+}
+
+// Sample is one labelled series. Counters, gauges and untyped metrics set
+// Value; summaries and histograms set Count and Sum, plus their quantiles
+// or cumulative buckets keyed by the bound.
+type Sample struct {
+	Labels    map[string]string  `json:"labels,omitempty"`
+	Value     *float64           `json:"value,omitempty"`
+	Count     *uint64            `json:"count,omitempty"`
+	Sum       *float64           `json:"sum,omitempty"`
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+	Buckets   map[string]uint64  `json:"buckets,omitempty"`
+}
+
+// Snapshot gathers g and converts the result to its JSON form.
+func Snapshot(g prometheus.Gatherer) ([]Family, error) {
+	mfs, err := g.Gather()
+	families := make([]Family, 0, len(mfs))
+	for _, mf := range mfs {
+		f := Family{
+			Name:    mf.GetName(),
+			Help:    mf.GetHelp(),
+			Type:    strings.ToLower(mf.GetType().String()),
+			Metrics: make([]Sample, 0, len(mf.GetMetric())),
+		}
+		for _, m := range mf.GetMetric() {
+			f.Metrics = append(f.Metrics, sample(m))
+		}
+		families = append(families, f)
+	}
+	return families, err
+}
+
+func sample(m *dto.Metric) Sample {
+	var s Sample
+	if len(m.GetLabel()) > 0 {
+		s.Labels = make(map[string]string, len(m.GetLabel()))
+		for _, l := range m.GetLabel() {
+			s.Labels[l.GetName()] = l.GetValue()
+		}
+	}
+	switch {
+	case m.Counter != nil:
+		s.Value = m.Counter.Value
+	case m.Gauge != nil:
+		s.Value = m.Gauge.Value
+	case m.Untyped != nil:
+		s.Value = m.Untyped.Value
+	case m.Summary != nil:
+		s.Count, s.Sum = m.Summary.SampleCount, m.Summary.SampleSum
+		s.Quantiles = make(map[string]float64, len(m.Summary.GetQuantile()))
+		for _, q := range m.Summary.GetQuantile() {
+			s.Quantiles[formatBound(q.GetQuantile())] = q.GetValue()
+		}
+	case m.Histogram != nil:
+		s.Count, s.Sum = m.Histogram.SampleCount, m.Histogram.SampleSum
+		s.Buckets = make(map[string]uint64, len(m.Histogram.GetBucket()))
+		for _, b := range m.Histogram.GetBucket() {
+			s.Buckets[formatBound(b.GetUpperBound())] = b.GetCumulativeCount()
+		}
+	}
+	return s
+}
+
+func formatBound(f float64) string {
+	b, _ := json.Marshal(f)
+	return string(b)
+}
+
+// JSONHandler serves a read-only JSON snapshot of the metrics in g, for
+// tooling that would rather not parse the Prometheus text format.
+func JSONHandler(g prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		families, err := Snapshot(g)
+		if err != nil && len(families) == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(families)
+	})
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestJSONHandler(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_lines_total", Help: "Lines"}, []string{"path"})
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_latency_seconds", Buckets: []float64{0.5, 1}})
+	reg.MustRegister(c, h)
+	c.WithLabelValues("/var/log/app.log").Add(3)
+	h.Observe(0.25)
+
+	rec := httptest.NewRecorder()
+	JSONHandler(reg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	var families []Family
+	if err := json.Unmarshal(rec.Body.Bytes(), &families); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	byName := map[string]Family{}
+	for _, f := range families {
+		byName[f.Name] = f
+	}
+
+	counter := byName["test_lines_total"]
+	if counter.Type != "counter" || len(counter.Metrics) != 1 {
+		t.Fatalf("Unexpected counter family: %+v", counter)
+	}
+	if m := counter.Metrics[0]; m.Labels["path"] != "/var/log/app.log" || m.Value == nil || *m.Value != 3 {
+		t.Errorf("Unexpected counter sample: %+v", m)
+	}
+
+	hist := byName["test_latency_seconds"]
+	if hist.Type != "histogram" || len(hist.Metrics) != 1 {
+		t.Fatalf("Unexpected histogram family: %+v", hist)
+	}
+	if m := hist.Metrics[0]; m.Count == nil || *m.Count != 1 || m.Buckets["0.5"] != 1 {
+		t.Errorf("Unexpected histogram sample: %+v", m)
+	}
+
+	rec = httptest.NewRecorder()
+	JSONHandler(reg).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics.json", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rec.Code)
+	}
+}
@@ -1,6 +1,9 @@
 package metrics
 
 import (
+	"errors"
+	"runtime"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -12,6 +15,13 @@ var (
 		},
 		[]string{"path", "group"},
 	)
+	BytesProcessed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_processed_bytes_total",
+			Help: "Total number of event bytes processed per file: the length of each sent event, after trimming and multiline assembly",
+		},
+		[]string{"path", "group"},
+	)
 	FileErrors = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "katalog_file_errors_total",
@@ -19,8 +29,274 @@ var (
 		},
 		[]string{"path", "error_type"},
 	)
+	RateLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_rate_limited_lines_total",
+			Help: "Total number of lines over a target's rate limit, by action taken (dropped or sampled)",
+		},
+		[]string{"path", "group", "action"},
+	)
+	RateLimitedSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_rate_limited_seconds_total",
+			Help: "Total time tailers spent waiting for a target's rate limit with over_limit_policy block",
+		},
+		[]string{"path", "group"},
+	)
+	SampledDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_sampled_dropped_total",
+			Help: "Total number of entries dropped by a target's sample_rate",
+		},
+		[]string{"path", "group"},
+	)
+	DedupSuppressed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_dedup_suppressed_lines_total",
+			Help: "Total number of lines suppressed as already seen by the dedup filter",
+		},
+		[]string{"path", "group"},
+	)
+	DedupFillRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "katalog_dedup_filter_fill_ratio",
+			Help: "Fraction of bits set in the current generation of the dedup bloom filter",
+		},
+		[]string{"path", "group"},
+	)
+	SlowMatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_slow_regex_matches_total",
+			Help: "Total number of exclude/multiline pattern matches that took longer than 10ms",
+		},
+		[]string{"path", "pattern"},
+	)
+	MultilineForcedFlushes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_multiline_forced_flushes_total",
+			Help: "Total number of multiline entries flushed early because they reached max_multiline_bytes",
+		},
+		[]string{"path", "group"},
+	)
+	TruncatedLines = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_truncated_lines_total",
+			Help: "Total number of lines truncated because they exceeded max_line_bytes",
+		},
+		[]string{"path", "group"},
+	)
+	TrackedFiles = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "katalog_tracked_files",
+			Help: "Number of files currently being tailed per target, as of the last discovery",
+		},
+		[]string{"target"},
+	)
+	ReadLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "katalog_read_lag_bytes",
+			Help: "Bytes between the read position and the end of the file being tailed, updated at EOF and every 1000 lines; starts over from the new file's size on rotation",
+		},
+		[]string{"path"},
+	)
+	LocalCopyDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "katalog_local_copy_dropped_total",
+			Help: "Total number of entries dropped because the local copy's buffer was full",
+		},
+	)
+	CheckpointErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "katalog_checkpoint_errors_total",
+			Help: "Total number of checkpoint saves that failed after their retries",
+		},
+	)
+	TraceIDMissing = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_trace_id_missing_total",
+			Help: "Total number of entries where trace_id_pattern found no trace ID",
+		},
+		[]string{"group"},
+	)
+	ParseErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_parse_errors_total",
+			Help: "Total number of events that failed to parse with their target's parser (format json) or timestamp_pattern (format timestamp) and were forwarded unparsed",
+		},
+		[]string{"group", "format"},
+	)
+	Goroutines = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "katalog_goroutines",
+			Help: "Number of goroutines currently running, sampled at scrape time",
+		},
+		func() float64 { return float64(runtime.NumGoroutine()) },
+	)
+	HTTPOutputErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_http_output_errors_total",
+			Help: "Total number of failed POSTs by the http output, by response status (or \"error\" when no response was received)",
+		},
+		[]string{"status"},
+	)
+	HTTPOutputDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "katalog_http_output_dropped_batches_total",
+			Help: "Total number of batches the http output dropped after exhausting its retries",
+		},
+	)
+	OutputErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_output_errors_total",
+			Help: "Total number of failures of the network outputs, by transport and reason (connect, write, dropped, or an HTTP status)",
+		},
+		[]string{"transport", "reason"},
+	)
+	OutputBytesSent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_output_sent_bytes_total",
+			Help: "Total number of bytes sent by the best-effort network outputs, by transport",
+		},
+		[]string{"transport"},
+	)
+	Retries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_retries_total",
+			Help: "Total number of retries of failed batches by the network outputs, by sink and outcome (success, error, or canceled when a shutdown cut the wait short)",
+		},
+		[]string{"sink", "outcome"},
+	)
+	DeadLettered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_dead_lettered_total",
+			Help: "Total number of entries an output gave up on and appended to its dead_letter_path",
+		},
+		[]string{"output"},
+	)
+	CompressionBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_compression_bytes_total",
+			Help: "Total number of bytes of the request bodies the http outputs compressed, by transport, before (raw) and after (compressed) compression",
+		},
+		[]string{"transport", "stage"},
+	)
+	LokiDroppedLines = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_loki_dropped_lines_total",
+			Help: "Total number of lines the loki output dropped after failed pushes, by stream",
+		},
+		[]string{"stream"},
+	)
+	HECEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_hec_events_total",
+			Help: "Total number of events sent to Splunk HEC, by status (acknowledged or failed)",
+		},
+		[]string{"status"},
+	)
+	OTLPLogRecords = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_otlp_log_records_total",
+			Help: "Total number of log records sent to an OTLP collector, by status (exported or failed)",
+		},
+		[]string{"status"},
+	)
+	FluentdEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_fluentd_events_total",
+			Help: "Total number of events forwarded to Fluentd, by status (sent, acked or failed)",
+		},
+		[]string{"status"},
+	)
+	DatadogLogs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_datadog_logs_total",
+			Help: "Total number of logs sent to the Datadog intake, by status (accepted or rejected)",
+		},
+		[]string{"status"},
+	)
+	KafkaMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_kafka_messages_total",
+			Help: "Total number of messages the kafka output produced or failed to produce, by topic and status",
+		},
+		[]string{"topic", "status"},
+	)
+	OutputRotations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_output_rotations_total",
+			Help: "Total number of times the file output rotated its file, by path",
+		},
+		[]string{"path"},
+	)
+	SpoolBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "katalog_spool_bytes",
+			Help: "Bytes spooled to disk by an output and not yet delivered",
+		},
+		[]string{"output"},
+	)
+	SpoolSpilledBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_spool_spilled_bytes_total",
+			Help: "Total bytes an output wrote to its disk spool",
+		},
+		[]string{"output"},
+	)
+	SpoolDroppedBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_spool_dropped_bytes_total",
+			Help: "Total undelivered bytes an output's full spool dropped with spool_full_policy drop_oldest",
+		},
+		[]string{"output"},
+	)
+	OutputDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_output_dropped_total",
+			Help: "Total number of entries an output with queue_full_policy drop discarded because its queue was full",
+		},
+		[]string{"output"},
+	)
+	ChannelDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_channel_dropped_lines_total",
+			Help: "Total number of entries dropped by channel_full_policy drop_newest or drop_oldest because the agent's channel was full",
+		},
+		[]string{"group"},
+	)
+	DiskFull = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "katalog_disk_full",
+			Help: "1 while the output destination is out of space, 0 otherwise",
+		},
+	)
 )
 
 func Init() {
-	prometheus.MustRegister(LinesProcessed, FileErrors)
+	prometheus.MustRegister(LinesProcessed, BytesProcessed, FileErrors, RateLimited, RateLimitedSeconds, SampledDropped, DedupSuppressed, DedupFillRatio, SlowMatches, MultilineForcedFlushes, TruncatedLines, TrackedFiles, ReadLag, LocalCopyDropped, CheckpointErrors, TraceIDMissing, ParseErrors, Goroutines, HTTPOutputErrors, HTTPOutputDropped, OutputErrors, OutputBytesSent, Retries, DeadLettered, CompressionBytes, LokiDroppedLines, HECEvents, OTLPLogRecords, FluentdEvents, DatadogLogs, KafkaMessages, OutputRotations, OutputDropped, ChannelDropped, SpoolBytes, SpoolSpilledBytes, SpoolDroppedBytes, DiskFull)
+}
+
+// Derived returns the counter behind a derived_metrics entry, labelled by
+// group, registering it on first use.
+func Derived(name, help string) (*prometheus.CounterVec, error) {
+	if help == "" {
+		help = "Lines matching the derived metric's pattern"
+	}
+	c := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "katalog_derived_" + name,
+			Help: help,
+		},
+		[]string{"group"},
+	)
+	if err := prometheus.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(*prometheus.CounterVec); ok {
+				return existing, nil
+			}
+		}
+		return nil, err
+	}
+	return c, nil
 }
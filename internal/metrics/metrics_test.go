@@ -0,0 +1,20 @@
+package metrics
+
+import "testing"
+
+func TestDerived(t *testing.T) {
+	c, err := Derived("test_5xx_total", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.WithLabelValues("web").Inc()
+
+	// Asking again (e.g. on reload) returns the registered counter
+	again, err := Derived("test_5xx_total", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != c {
+		t.Error("Expected the already registered counter to be reused")
+	}
+}
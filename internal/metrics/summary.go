@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GroupSummary is what one group did since the previous summary.
+type GroupSummary struct {
+	Group  string
+	Lines  float64
+	Bytes  float64
+	Errors float64
+}
+
+// Summarizer turns the per-file line, byte and error counters into
+// per-group deltas between calls to Next. It only reads the registry, so
+// it never touches the tailers' hot path.
+type Summarizer struct {
+	g      prometheus.Gatherer
+	prev   map[string]float64 // last value per family and path
+	groups map[string]string  // path -> group, learned from the line counters
+}
+
+func NewSummarizer(g prometheus.Gatherer) *Summarizer {
+	return &Summarizer{g: g, prev: make(map[string]float64), groups: make(map[string]string)}
+}
+
+// Next returns the deltas since the last call (or since startup), one per
+// group seen so far, sorted by group. Errors on files that never produced a
+// line can't be attributed to a group and are reported under "-".
+func (s *Summarizer) Next() ([]GroupSummary, error) {
+	families, err := Snapshot(s.g)
+	if err != nil {
+		return nil, err
+	}
+	byGroup := make(map[string]*GroupSummary)
+	for _, group := range s.groups {
+		byGroup[group] = &GroupSummary{Group: group}
+	}
+	add := func(group string) *GroupSummary {
+		if byGroup[group] == nil {
+			byGroup[group] = &GroupSummary{Group: group}
+		}
+		return byGroup[group]
+	}
+	// Line and byte counters come first so errors can be attributed
+	slices.SortFunc(families, func(a, b Family) int { return order(a.Name) - order(b.Name) })
+	for _, f := range families {
+		if order(f.Name) == 0 {
+			continue
+		}
+		for _, m := range f.Metrics {
+			if m.Value == nil {
+				continue
+			}
+			path := m.Labels["path"]
+			key := f.Name + "\xff" + path + "\xff" + m.Labels["error_type"]
+			delta := *m.Value - s.prev[key]
+			s.prev[key] = *m.Value
+			switch f.Name {
+			case "katalog_processed_lines_total":
+				s.groups[path] = m.Labels["group"]
+				add(m.Labels["group"]).Lines += delta
+			case "katalog_processed_bytes_total":
+				add(m.Labels["group"]).Bytes += delta
+			case "katalog_file_errors_total":
+				group, ok := s.groups[path]
+				if !ok {
+					group = "-"
+				}
+				add(group).Errors += delta
+			}
+		}
+	}
+	summaries := make([]GroupSummary, 0, len(byGroup))
+	for _, gs := range byGroup {
+		summaries = append(summaries, *gs)
+	}
+	slices.SortFunc(summaries, func(a, b GroupSummary) int { return cmp.Compare(a.Group, b.Group) })
+	return summaries, nil
+}
+
+// order ranks the families Next reads; 0 means ignored.
+func order(name string) int {
+	switch name {
+	case "katalog_processed_lines_total":
+		return 1
+	case "katalog_processed_bytes_total":
+		return 2
+	case "katalog_file_errors_total":
+		return 3
+	}
+	return 0
+}
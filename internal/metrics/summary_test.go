@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSummarizer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	lines := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "katalog_processed_lines_total"}, []string{"path", "group"})
+	bytes := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "katalog_processed_bytes_total"}, []string{"path", "group"})
+	errs := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "katalog_file_errors_total"}, []string{"path", "error_type"})
+	reg.MustRegister(lines, bytes, errs)
+	s := NewSummarizer(reg)
+
+	// 1. Two files in one group, one in another, plus an unattributable error
+	lines.WithLabelValues("/a.log", "app").Add(3)
+	lines.WithLabelValues("/b.log", "app").Add(2)
+	bytes.WithLabelValues("/a.log", "app").Add(300)
+	lines.WithLabelValues("/c.log", "web").Add(1)
+	errs.WithLabelValues("/a.log", "read").Inc()
+	errs.WithLabelValues("/missing.log", "open").Inc()
+
+	got, err := s.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GroupSummary{
+		{Group: "-", Errors: 1},
+		{Group: "app", Lines: 5, Bytes: 300, Errors: 1},
+		{Group: "web", Lines: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], got[i])
+		}
+	}
+
+	// 2. The next summary only covers what happened since
+	lines.WithLabelValues("/a.log", "app").Add(4)
+	got, err = s.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, gs := range got {
+		switch gs.Group {
+		case "app":
+			if gs.Lines != 4 || gs.Bytes != 0 || gs.Errors != 0 {
+				t.Errorf("Expected only 4 new lines for app, got %+v", gs)
+			}
+		case "web":
+			if gs.Lines != 0 {
+				t.Errorf("Expected an idle web group, got %+v", gs)
+			}
+		}
+	}
+}
@@ -1,10 +1,94 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// How LogEntry encodes its time in JSON.
+const (
+	TimeUnix    = "unix"    // unix seconds (default)
+	TimeUnixMs  = "unix_ms" // unix milliseconds
+	TimeRFC3339 = "rfc3339" // RFC 3339 string in UTC, with fractional seconds
+)
+
 type LogEntry struct {
-	Time       int64             `json:"time"`
+	// Time is when the entry was read, in unix seconds. TimeNano is the same
+	// instant in nanoseconds, zero when only the seconds are known.
+	Time     int64 `json:"time"`
+	TimeNano int64 `json:"-"`
+	// TimeFormat is how JSON encodes the time: TimeUnix (also when empty),
+	// TimeUnixMs or TimeRFC3339.
+	TimeFormat string            `json:"-"`
 	Host       string            `json:"host"`
 	Source     string            `json:"source"`
 	SourceType string            `json:"sourcetype"`
 	Event      string            `json:"event"`
 	Fields     map[string]string `json:"fields,omitempty"`
 }
+
+// Timestamp returns the time of the entry, as precisely as it is known.
+func (e LogEntry) Timestamp() time.Time {
+	if e.TimeNano != 0 {
+		return time.Unix(0, e.TimeNano)
+	}
+	return time.Unix(e.Time, 0)
+}
+
+// entryFields has the fields of LogEntry without its JSON methods.
+type entryFields LogEntry
+
+// MarshalJSON writes time in the entry's TimeFormat.
+func (e LogEntry) MarshalJSON() ([]byte, error) {
+	var t any = e.Time
+	switch e.TimeFormat {
+	case TimeUnixMs:
+		t = e.Timestamp().UnixMilli()
+	case TimeRFC3339:
+		t = e.Timestamp().UTC().Format(time.RFC3339Nano)
+	}
+	return json.Marshal(struct {
+		Time any `json:"time"`
+		entryFields
+	}{t, entryFields(e)})
+}
+
+// UnmarshalJSON reads time as written by MarshalJSON. A string is parsed as
+// RFC 3339; a number is unix seconds, or milliseconds when e.TimeFormat is
+// TimeUnixMs.
+func (e *LogEntry) UnmarshalJSON(b []byte) error {
+	v := struct {
+		Time json.RawMessage `json:"time"`
+		*entryFields
+	}{entryFields: (*entryFields)(e)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if len(v.Time) == 0 || string(v.Time) == "null" {
+		return nil
+	}
+	if v.Time[0] == '"' {
+		var s string
+		if err := json.Unmarshal(v.Time, &s); err != nil {
+			return err
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("invalid time: %w", err)
+		}
+		e.Time, e.TimeNano = t.Unix(), t.UnixNano()
+		return nil
+	}
+	var n int64
+	if err := json.Unmarshal(v.Time, &n); err != nil {
+		return err
+	}
+	if e.TimeFormat == TimeUnixMs {
+		t := time.UnixMilli(n)
+		e.Time, e.TimeNano = t.Unix(), t.UnixNano()
+		return nil
+	}
+	e.Time, e.TimeNano = n, 0
+	return nil
+}
@@ -3,7 +3,7 @@ package models
 import (
 	"encoding/json"
 	"reflect"
-	// "strings" // Removed unused import
+	"strings"
 	"testing"
 	"time"
 )
@@ -70,3 +70,46 @@ Expected: %s
 Got: %s`, string(expectedEntryJSON), string(jsonDataWithoutFields))
 	}
 }
+
+func TestLogEntry_JSONTimeFormats(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.UTC)
+	tests := []struct {
+		format   string
+		wantJSON string
+		want     time.Time // the time read back, at the format's precision
+	}{
+		{"", `"time":1709296245,`, ts.Truncate(time.Second)},
+		{TimeUnix, `"time":1709296245,`, ts.Truncate(time.Second)},
+		{TimeUnixMs, `"time":1709296245123,`, ts.Truncate(time.Millisecond)},
+		{TimeRFC3339, `"time":"2024-03-01T12:30:45.123456789Z",`, ts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			entry := LogEntry{
+				Time:       ts.Unix(),
+				TimeNano:   ts.UnixNano(),
+				TimeFormat: tt.format,
+				Host:       "test-host",
+				Event:      "event",
+			}
+			b, err := json.Marshal(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(b), "{"+tt.wantJSON) {
+				t.Errorf("Expected JSON starting with {%s, got %s", tt.wantJSON, b)
+			}
+
+			decoded := LogEntry{TimeFormat: tt.format}
+			if err := json.Unmarshal(b, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if got := decoded.Timestamp(); !got.Equal(tt.want) {
+				t.Errorf("Expected time %v, got %v", tt.want, got)
+			}
+			if decoded.Time != ts.Unix() || decoded.Host != entry.Host || decoded.Event != entry.Event {
+				t.Errorf("Expected %+v back, got %+v", entry, decoded)
+			}
+		})
+	}
+}
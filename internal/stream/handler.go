@@ -0,0 +1,77 @@
+package stream
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Handler serves the live feed over WebSocket. The optional "group" and
+// "source" query parameters filter entries server side. Browsers may only
+// connect from the server's own origin or one of allowedOrigins (e.g.
+// "https://ui.example.com"), so that other sites can't read the feed.
+func (h *Hub) Handler(allowedOrigins []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !originAllowed(r, allowedOrigins) {
+			slog.Warn("Rejecting stream client from another origin", "remote_addr", r.RemoteAddr, "origin", r.Header.Get("Origin"))
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		conn, err := upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		sub := h.Subscribe(Filter{
+			Group:  r.URL.Query().Get("group"),
+			Source: r.URL.Query().Get("source"),
+		})
+		defer h.Unsubscribe(sub)
+
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			conn.readUntilClose()
+		}()
+
+		for {
+			select {
+			case entry, ok := <-sub.C:
+				if !ok {
+					slog.Warn("Dropping slow stream client", "remote_addr", r.RemoteAddr)
+					return
+				}
+				payload, err := json.Marshal(entry)
+				if err != nil {
+					continue
+				}
+				if err := conn.WriteText(payload); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	})
+}
+
+// originAllowed reports whether the Origin of r, set by browsers, is the
+// server's own or one of allowed. Clients other than browsers send none.
+func originAllowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"katalog/internal/models"
+)
+
+// dialStream performs a client handshake against the test server.
+func dialStream(t *testing.T, srv *httptest.Server, query string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	req := "GET /stream" + query + " HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", resp.StatusCode)
+	}
+	// Value from the RFC 6455 example handshake
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected Sec-WebSocket-Accept: %s", got)
+	}
+	return conn, br
+}
+
+// readTextFrame reads one unmasked server frame.
+func readTextFrame(t *testing.T, conn net.Conn, br *bufio.Reader) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		t.Fatalf("Failed to read frame header: %v", err)
+	}
+	if hdr[0] != 0x81 {
+		t.Fatalf("Expected final text frame, got header 0x%x", hdr[0])
+	}
+	n := uint64(hdr[1] & 0x7F)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatalf("Failed to read frame payload: %v", err)
+	}
+	return payload
+}
+
+func TestHandlerStreamsFilteredEntries(t *testing.T) {
+	hub := NewHub(10)
+	srv := httptest.NewServer(hub.Handler(nil))
+	defer srv.Close()
+
+	conn, br := dialStream(t, srv, "?group=web")
+	defer conn.Close()
+
+	// Wait for the handler to register the subscription
+	deadline := time.Now().Add(time.Second)
+	for hub.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	hub.Publish(models.LogEntry{SourceType: "db", Event: "filtered out"})
+	hub.Publish(models.LogEntry{SourceType: "web", Event: "GET /health"})
+
+	var entry models.LogEntry
+	if err := json.Unmarshal(readTextFrame(t, conn, br), &entry); err != nil {
+		t.Fatalf("Failed to decode streamed entry: %v", err)
+	}
+	if entry.Event != "GET /health" {
+		t.Errorf("Expected 'GET /health', got '%s'", entry.Event)
+	}
+
+	// Closing the client unsubscribes it
+	conn.Close()
+	deadline = time.Now().Add(time.Second)
+	for hub.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if hub.Len() != 0 {
+		t.Error("Expected subscriber to be removed after the client disconnected")
+	}
+}
+
+func TestHandlerRejectsPlainHTTP(t *testing.T) {
+	hub := NewHub(10)
+	srv := httptest.NewServer(hub.Handler(nil))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a non-websocket request, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerChecksOrigin(t *testing.T) {
+	hub := NewHub(10)
+	srv := httptest.NewServer(hub.Handler([]string{"https://ui.example.com"}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		origin string
+		want   int
+	}{
+		{name: "No Origin", want: http.StatusSwitchingProtocols},
+		{name: "Same Origin", origin: srv.URL, want: http.StatusSwitchingProtocols},
+		{name: "Allowed Origin", origin: "https://ui.example.com", want: http.StatusSwitchingProtocols},
+		{name: "Other Origin", origin: "https://evil.example.com", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/stream", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+			req.Header.Set("Sec-WebSocket-Version", "13")
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestWriteTextTimesOut(t *testing.T) {
+	orig := writeTimeout
+	writeTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { writeTimeout = orig })
+
+	// A client that never reads: net.Pipe has no buffer, so the write
+	// blocks until the deadline
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &wsConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}
+	done := make(chan error, 1)
+	go func() { done <- conn.WriteText([]byte("stalled")) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected the write to a stalled client to fail")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Write to a stalled client never returned")
+	}
+}
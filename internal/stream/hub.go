@@ -0,0 +1,99 @@
+package stream
+
+import (
+	"sync"
+
+	"katalog/internal/models"
+)
+
+// Filter restricts a subscription to entries from one group and/or source.
+// Empty fields match everything.
+type Filter struct {
+	Group  string
+	Source string
+}
+
+func (f Filter) match(entry models.LogEntry) bool {
+	if f.Group != "" && f.Group != entry.SourceType {
+		return false
+	}
+	if f.Source != "" && f.Source != entry.Source {
+		return false
+	}
+	return true
+}
+
+// Subscriber receives the entries matching its filter on C. C is closed
+// when the subscriber is removed, either explicitly or for falling behind.
+type Subscriber struct {
+	C      <-chan models.LogEntry
+	ch     chan models.LogEntry
+	filter Filter
+}
+
+// Hub fans the live entry feed out to subscribers. Each subscriber has a
+// bounded buffer; one that can't keep up is dropped rather than blocking
+// the publisher or other subscribers.
+type Hub struct {
+	mu         sync.RWMutex
+	subs       map[*Subscriber]struct{}
+	bufferSize int
+}
+
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = 256
+	}
+	return &Hub{
+		subs:       make(map[*Subscriber]struct{}),
+		bufferSize: bufferSize,
+	}
+}
+
+func (h *Hub) Subscribe(filter Filter) *Subscriber {
+	ch := make(chan models.LogEntry, h.bufferSize)
+	sub := &Subscriber{C: ch, ch: ch, filter: filter}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe removes sub and closes its channel. It is safe to call more
+// than once, including after the hub dropped the subscriber itself.
+func (h *Hub) Unsubscribe(sub *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Publish hands entry to every matching subscriber without blocking.
+func (h *Hub) Publish(entry models.LogEntry) {
+	h.mu.RLock()
+	var slow []*Subscriber
+	for sub := range h.subs {
+		if !sub.filter.match(entry) {
+			continue
+		}
+		select {
+		case sub.ch <- entry:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, sub := range slow {
+		h.Unsubscribe(sub)
+	}
+}
+
+// Len returns the number of connected subscribers.
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs)
+}
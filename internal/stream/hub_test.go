@@ -0,0 +1,78 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"katalog/internal/models"
+)
+
+func TestHubFilter(t *testing.T) {
+	hub := NewHub(10)
+	all := hub.Subscribe(Filter{})
+	web := hub.Subscribe(Filter{Group: "web"})
+	defer hub.Unsubscribe(all)
+	defer hub.Unsubscribe(web)
+
+	hub.Publish(models.LogEntry{SourceType: "web", Event: "GET /"})
+	hub.Publish(models.LogEntry{SourceType: "db", Event: "slow query"})
+
+	if len(all.C) != 2 {
+		t.Errorf("Expected unfiltered subscriber to get 2 entries, got %d", len(all.C))
+	}
+	if len(web.C) != 1 {
+		t.Fatalf("Expected group subscriber to get 1 entry, got %d", len(web.C))
+	}
+	if e := <-web.C; e.Event != "GET /" {
+		t.Errorf("Expected 'GET /', got '%s'", e.Event)
+	}
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	hub := NewHub(2)
+	slow := hub.Subscribe(Filter{})
+	fast := hub.Subscribe(Filter{})
+
+	// Keep draining the fast subscriber while the slow one never reads
+	received := make(chan int)
+	go func() {
+		n := 0
+		for range fast.C {
+			n++
+		}
+		received <- n
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			hub.Publish(models.LogEntry{Event: "burst"})
+			time.Sleep(5 * time.Millisecond)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a slow subscriber")
+	}
+
+	// The slow subscriber got its buffer's worth and was then closed
+	n := 0
+	for range slow.C {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("Expected slow subscriber to keep 2 buffered entries, got %d", n)
+	}
+	if hub.Len() != 1 {
+		t.Errorf("Expected 1 remaining subscriber, got %d", hub.Len())
+	}
+
+	hub.Unsubscribe(fast)
+	if n := <-received; n != 5 {
+		t.Errorf("Expected fast subscriber to receive all 5 entries, got %d", n)
+	}
+	hub.Unsubscribe(slow) // Safe after the hub already dropped it
+}
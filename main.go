@@ -3,7 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,7 +12,9 @@ import (
 	"katalog/internal/agent"
 	"katalog/internal/config"
 	"katalog/internal/metrics"
+	"katalog/internal/stream"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 )
@@ -21,6 +23,27 @@ func init() {
 	metrics.Init()
 }
 
+// setupLogging makes the agent's own logs go to stderr at the level and in
+// the format given by --log-level and --log-format.
+func setupLogging(cmd *cobra.Command, args []string) error {
+	levelName, _ := cmd.Flags().GetString("log-level")
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(levelName)); err != nil {
+		return fmt.Errorf("invalid --log-level: %s", levelName)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	format, _ := cmd.Flags().GetString("log-format")
+	switch format {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("invalid --log-format: %s (expected text or json)", format)
+	}
+	return nil
+}
+
 func runForwarder(cmd *cobra.Command, args []string) error {
 	configPath, _ := cmd.Flags().GetString("config")
 	// 1. Setup Context with Signal Handling
@@ -32,6 +55,13 @@ func runForwarder(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	color, _ := cmd.Flags().GetString("color")
+	if color != "" {
+		cfg.Color = color
+	}
+	if once, _ := cmd.Flags().GetBool("once"); once {
+		cfg.Mode = config.ModeOnce
+	}
 	if _, err := cfg.Validate(); err != nil {
 		return fmt.Errorf("invalid configuration: %w", err)
 	}
@@ -41,36 +71,98 @@ func runForwarder(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("could not get hostname: %w", err)
 	}
 
+	// Initialize the agent
+	ag, err := agent.New(&cfg, hostname)
+	if err != nil {
+		return fmt.Errorf("failed to initialize agent: %w", err)
+	}
+
 	// Start Metrics Server
 	metricsAddr, _ := cmd.Flags().GetString("metrics-addr")
 	if metricsAddr != "" {
 		go func() {
 			http.Handle("/metrics", promhttp.Handler())
-			log.Printf("Metrics server listening on %s", metricsAddr)
-			log.Printf("Error starting metrics server: %v", http.ListenAndServe(metricsAddr, nil))
+			http.Handle("/metrics.json", metrics.JSONHandler(prometheus.DefaultGatherer))
+			http.Handle("/healthz", ag.HealthHandler())
+			http.Handle("/readyz", ag.ReadyHandler())
+			slog.Info("Metrics server listening", "addr", metricsAddr)
+			slog.Error("Error starting metrics server", "error", http.ListenAndServe(metricsAddr, nil))
 		}()
 	}
 
-	// Initialize and run the agent
-	ag, err := agent.New(&cfg, hostname)
-	if err != nil {
-		return fmt.Errorf("failed to initialize agent: %w", err)
+	// The live WebSocket feed exposes every entry, so it is only served,
+	// and only tapped from the pipeline, when asked for
+	streamAddr, _ := cmd.Flags().GetString("stream-addr")
+	if streamAddr != "" {
+		origins, _ := cmd.Flags().GetStringSlice("stream-allowed-origins")
+		hub := stream.NewHub(0)
+		ag.AddTap(hub.Publish)
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/stream", hub.Handler(origins))
+			slog.Info("Stream server listening", "addr", streamAddr)
+			slog.Error("Error starting stream server", "error", http.ListenAndServe(streamAddr, mux))
+		}()
 	}
+
+	// SIGHUP re-reads the config and applies it without a restart
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reloadConfig(ctx, ag, configPath, color)
+			}
+		}
+	}()
+
 	ag.Run(ctx)
 	return nil
 }
 
+func reloadConfig(ctx context.Context, ag *agent.Agent, configPath, color string) {
+	cfg, err := config.Load(configPath)
+	if err == nil && color != "" {
+		cfg.Color = color
+	}
+	if err == nil {
+		_, err = cfg.Validate()
+	}
+	if err == nil {
+		err = ag.Reload(ctx, &cfg)
+	}
+	if err != nil {
+		slog.Error("Config reload failed, keeping the current config", "error", err)
+	}
+}
+
 func main() {
 	var rootCmd = &cobra.Command{
 		Use:   "katalog",
 		Short: "A lightweight, concurrent log forwarding agent.",
 		Long: `Katalog is a lightweight, concurrent log forwarding agent written in Go.
 It monitors multiple log files defined by glob patterns, enriches the log lines with metadata, and outputs them as JSON to stdout.`,
-		RunE: runForwarder,
+		PersistentPreRunE: setupLogging,
+		RunE:              runForwarder,
 	}
 
 	rootCmd.PersistentFlags().String("config", "config.yaml", "path to config file")
+	rootCmd.PersistentFlags().String("color", "", "pretty/colored JSON on stdout: auto (only on a terminal), always or never (default from config, else auto)")
 	rootCmd.PersistentFlags().String("metrics-addr", ":8080", "address to bind metrics server (e.g. :8080)")
+	rootCmd.PersistentFlags().String("log-level", "info", "minimum level of the agent's own logs: debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-format", "text", "format of the agent's own logs on stderr: text or json")
+
+	rootCmd.Flags().String("stream-addr", "", "address to serve the live WebSocket feed on at /stream (e.g. 127.0.0.1:8081); off when empty")
+	rootCmd.Flags().StringSlice("stream-allowed-origins", nil, "origins besides the stream server's own that browsers may connect to /stream from (e.g. https://ui.example.com)")
+	rootCmd.Flags().Bool("once", false, "read every matched file from the start to EOF, then exit (same as mode: once)")
+
+	rootCmd.AddCommand(newQueryCmd())
+	rootCmd.AddCommand(newTestPatternsCmd())
+	rootCmd.AddCommand(newValidateCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		// Cobra prints the error, so we just need to exit.
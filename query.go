@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"katalog/internal/agent"
+	"katalog/internal/config"
+	"katalog/internal/forwarder"
+	"katalog/internal/models"
+
+	"github.com/spf13/cobra"
+)
+
+// runQuery prints the last N lines of a file, run through the same
+// filtering and enrichment as the target that would tail it. A missing
+// config file is fine: lines are then emitted without target settings.
+func runQuery(cmd *cobra.Command, args []string) error {
+	path := args[0]
+	configPath, _ := cmd.Flags().GetString("config")
+	tailLines, _ := cmd.Flags().GetInt("tail-lines")
+
+	var cfg config.Config
+	loaded, err := config.Load(configPath)
+	switch {
+	case err == nil:
+		cfg = loaded
+		if _, err := cfg.Validate(); err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if color, _ := cmd.Flags().GetString("color"); color != "" {
+		cfg.Color = color
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("could not get hostname: %w", err)
+	}
+	opts, ok, err := agent.TargetOptions(&cfg, hostname, path)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		opts = forwarder.TailOptions{Hostname: hostname}
+	}
+	serializer, err := forwarder.StdoutSerializer(cfg.ResolvedOutput().Serializer, cfg.Color)
+	if err != nil {
+		return err
+	}
+
+	lines, err := forwarder.ReadLastLines(path, tailLines)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// Every line yields at most one entry, so this never blocks
+	out := make(chan models.LogEntry, len(lines))
+	forwarder.ProcessLines(path, lines, out, opts)
+	close(out)
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for entry := range out {
+		if err := serializer.Serialize(w, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newQueryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "query <file>",
+		Short: "Print the last lines of a file through the enrichment pipeline.",
+		Long: `Query reads the last --tail-lines lines of a file by seeking backwards from its end,
+applies the filters, multiline rules and fields of the target matching the file, and prints the result.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runQuery,
+	}
+	cmd.Flags().Int("tail-lines", 10, "number of lines to read from the end of the file")
+	return cmd
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"katalog/internal/forwarder"
+	"katalog/internal/models"
+
+	"github.com/spf13/cobra"
+)
+
+// runTestPatterns runs a sample file from the start through the real
+// multiline, exclude and include logic and prints the entries it produces, with
+// their boundaries marked, followed by how many were excluded.
+func runTestPatterns(cmd *cobra.Command, args []string) error {
+	path, _ := cmd.Flags().GetString("file")
+	multiline, _ := cmd.Flags().GetStringArray("multiline")
+	exclude, _ := cmd.Flags().GetStringArray("exclude")
+	include, _ := cmd.Flags().GetStringArray("include")
+
+	var opts forwarder.TailOptions
+	for _, pattern := range multiline {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid multiline pattern: %w", err)
+		}
+		opts.MultilineRegexes = append(opts.MultilineRegexes, re)
+	}
+	for _, pattern := range exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid exclude pattern: %w", err)
+		}
+		opts.ExcludeRegexes = append(opts.ExcludeRegexes, re)
+	}
+	for _, pattern := range include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid include pattern: %w", err)
+		}
+		opts.IncludeRegexes = append(opts.IncludeRegexes, re)
+	}
+	excluded := 0
+	opts.OnExclude = func(string) { excluded++ }
+
+	out := make(chan models.LogEntry, 100)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(out)
+		errCh <- forwarder.ProcessFile(path, out, opts)
+	}()
+
+	w := bufio.NewWriter(cmd.OutOrStdout())
+	defer w.Flush()
+	entries := 0
+	for entry := range out {
+		entries++
+		fmt.Fprintf(w, "--- entry %d (%d lines) ---\n%s\n", entries, strings.Count(entry.Event, "\n")+1, entry.Event)
+	}
+	if err := <-errCh; err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	fmt.Fprintf(w, "--- %d entries, %d excluded ---\n", entries, excluded)
+	return nil
+}
+
+func newTestPatternsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test-patterns --file <sample> [--multiline <regex>]... [--exclude <regex>]... [--include <regex>]...",
+		Short: "Show how multiline, exclude and include patterns split a sample file.",
+		Long: `Test-patterns reads a sample file from the start through the same multiline, exclude and include
+logic the agent uses, and prints each resulting entry with its boundaries, plus the number of excluded entries.`,
+		Args: cobra.NoArgs,
+		RunE: runTestPatterns,
+	}
+	cmd.Flags().String("file", "", "sample log file to read")
+	cmd.Flags().StringArray("multiline", nil, "multiline start pattern (repeatable; a line matching any starts an entry)")
+	cmd.Flags().StringArray("exclude", nil, "exclude pattern (repeatable; an entry matching any is excluded)")
+	cmd.Flags().StringArray("include", nil, "include pattern (repeatable; an entry matching none is excluded)")
+	_ = cmd.MarkFlagRequired("file")
+	return cmd
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"katalog/internal/agent"
+	"katalog/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+// runValidate checks the config as the agent would at startup, without
+// opening outputs or tailing anything, and reports how many files each
+// target matches right now.
+func runValidate(cmd *cobra.Command, args []string) error {
+	configPath, _ := cmd.Flags().GetString("config")
+	allowEmpty, _ := cmd.Flags().GetBool("allow-empty")
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if _, err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	counts, err := agent.Check(&cfg)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	w := cmd.OutOrStdout()
+	var empty []string
+	for i, target := range cfg.Targets {
+		fmt.Fprintf(w, "target '%s': %d file(s)\n", target.Name, counts[i])
+		if counts[i] == 0 {
+			empty = append(empty, target.Name)
+		}
+	}
+	if len(empty) > 0 && !allowEmpty {
+		return fmt.Errorf("targets matching no files: %s", strings.Join(empty, ", "))
+	}
+	fmt.Fprintf(w, "%s is valid\n", configPath)
+	return nil
+}
+
+func newValidateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the config without starting the agent.",
+		Long: `Validate loads and validates the config, compiles every target's patterns the same way the agent does
+at startup, and prints how many files each target currently matches. It exits non-zero on any error,
+including a target matching no files unless --allow-empty is set. Outputs are not opened.`,
+		Args: cobra.NoArgs,
+		RunE: runValidate,
+	}
+	cmd.Flags().Bool("allow-empty", false, "don't fail on targets that currently match no files")
+	return cmd
+}